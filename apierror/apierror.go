@@ -0,0 +1,104 @@
+// Package apierror is the error returned by the fleet, docker, etcd and consul packages when a
+// request fails, so callers can handle failures of every backend with one policy. Backend
+// specific errors such as etcd.ErrKeyNotFound are wrapped, so errors.Is still matches them.
+//
+//	var apiErr *apierror.Error
+//	if errors.As(err, &apiErr) && apiErr.Retryable() {
+//		...
+//	}
+package apierror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Services reported in Error's Service
+const (
+	Fleet      = "fleet"
+	Docker     = "docker"
+	Etcd       = "etcd"
+	Consul     = "consul"
+	Journal    = "journal"
+	Registry   = "registry"
+	Kubernetes = "kubernetes"
+	Vault      = "vault"
+	CAdvisor   = "cadvisor"
+)
+
+// ErrClosed is the error of requests made with a client that was closed or shut down, and of
+// requests in flight that its shutdown cancelled
+var ErrClosed = errors.New("Client is closed")
+
+// Error is a failed request to a backend
+type Error struct {
+	// Service is the backend, such as Etcd
+	Service string
+	// Operation is the request's method and path, such as GET /v2/keys/services
+	Operation string
+	// StatusCode is the HTTP status of the response, 0 when no response was received
+	StatusCode int
+	// Code is the backend's own error code when it has one, such as etcd's errorCode
+	Code string
+	// Message is the backend's description of the error
+	Message string
+	// Err is the underlying error, the backend's error type or a transport error
+	Err error
+}
+
+// FromResponse returns an error for a failed response, err is the backend's own error if any
+func FromResponse(service string, response *http.Response, code, message string, err error) *Error {
+	return &Error{
+		Service:    service,
+		Operation:  operation(response.Request),
+		StatusCode: response.StatusCode,
+		Code:       code,
+		Message:    message,
+		Err:        err,
+	}
+}
+
+// Transport wraps the error of a request that didn't get a response, nil stays nil. The
+// operation is the request's method and path.
+func Transport(service, operation string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Service: service, Operation: operation, Message: err.Error(), Err: err}
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s %s: %v", e.Service, e.Operation, e.Err)
+	}
+	return fmt.Sprintf("%s %s: %d: %s", e.Service, e.Operation, e.StatusCode, e.Message)
+}
+
+// Unwrap returns the underlying error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Retryable reports whether the request may succeed if it is tried again: transport failures
+// other than cancellation and closed clients, timeouts, rate limiting and server errors
+func (e *Error) Retryable() bool {
+	if e.StatusCode == 0 {
+		return !errors.Is(e.Err, context.Canceled) && !errors.Is(e.Err, ErrClosed)
+	}
+	return e.StatusCode == http.StatusRequestTimeout || e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// Retryable reports whether err is an *Error that is retryable
+func Retryable(err error) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.Retryable()
+}
+
+func operation(request *http.Request) string {
+	if request == nil {
+		return ""
+	}
+	return request.Method + " " + request.URL.Path
+}
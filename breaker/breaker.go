@@ -0,0 +1,212 @@
+// Package breaker stops the fleet, docker, etcd and consul clients sending requests to a backend
+// that is failing or too slow, so retries against a down member or agent fail fast instead of
+// tying up every goroutine that needs it:
+//
+//	client.SetCircuitBreaker(breaker.New(breaker.Settings{}))
+//
+// Each host has its own circuit. A closed circuit lets requests through and opens when too many
+// of the requests in its window failed or were slow. An open circuit fails requests with ErrOpen
+// until OpenFor has passed, then half opens to let a few probe requests through: the circuit
+// closes again if they succeed and reopens if one fails. Clients failing over between several
+// members or agents move on to the next one when a circuit is open.
+package breaker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrOpen is returned instead of sending a request to a host whose circuit is open
+var ErrOpen = errors.New("Circuit breaker is open")
+
+// States of a circuit
+const (
+	Closed   = "closed"
+	Open     = "open"
+	HalfOpen = "half-open"
+)
+
+// Breaker is what the clients consult around each request
+type Breaker interface {
+	// Allow returns an error if a request to endpoint of host must not be sent
+	Allow(host, endpoint string) error
+	// Done reports the outcome of a request Allow let through, statusCode is 0 when no response
+	// was received
+	Done(host, endpoint string, statusCode int, err error, duration time.Duration)
+}
+
+// Settings configure a Circuits, zero fields take the defaults given
+type Settings struct {
+	// Window is how long outcomes are counted for before the counts start over, 10s
+	Window time.Duration
+	// MinRequests is how many requests a window needs before the circuit can open, 20
+	MinRequests int
+	// FailureRate is the share of failed requests in a window that opens the circuit, 0.5.
+	// Requests fail with transport errors other than cancellation, 429 or 5xx responses.
+	FailureRate float64
+	// SlowDuration is how long a request takes to count as slow, 0 doesn't count slow requests
+	SlowDuration time.Duration
+	// SlowRate is the share of slow requests in a window that opens the circuit, 0.5
+	SlowRate float64
+	// OpenFor is how long the circuit stays open before probing, 30s
+	OpenFor time.Duration
+	// Probes is how many requests may be in flight while half open, 1
+	Probes int
+	// OnStateChange is called with a host's circuit's new state, without any lock held
+	OnStateChange func(host, state string)
+}
+
+// Circuits is a Breaker with a circuit for each host, safe for concurrent use
+type Circuits struct {
+	settings Settings
+	mutex    sync.Mutex
+	circuits map[string]*circuit
+}
+
+type circuit struct {
+	state       string
+	windowStart time.Time
+	requests    int
+	failures    int
+	slow        int
+	openedAt    time.Time
+	probes      int
+}
+
+// New returns a breaker configured by settings
+func New(settings Settings) *Circuits {
+	if settings.Window <= 0 {
+		settings.Window = 10 * time.Second
+	}
+	if settings.MinRequests <= 0 {
+		settings.MinRequests = 20
+	}
+	if settings.FailureRate <= 0 {
+		settings.FailureRate = 0.5
+	}
+	if settings.SlowRate <= 0 {
+		settings.SlowRate = 0.5
+	}
+	if settings.OpenFor <= 0 {
+		settings.OpenFor = 30 * time.Second
+	}
+	if settings.Probes <= 0 {
+		settings.Probes = 1
+	}
+	return &Circuits{settings: settings, circuits: map[string]*circuit{}}
+}
+
+// State returns the state of host's circuit
+func (circuits *Circuits) State(host string) string {
+	circuits.mutex.Lock()
+	defer circuits.mutex.Unlock()
+	return circuits.circuitLocked(host, time.Now()).state
+}
+
+// Allow returns ErrOpen if host's circuit is open, or half open with all its probes in flight
+func (circuits *Circuits) Allow(host, endpoint string) error {
+	circuits.mutex.Lock()
+	now := time.Now()
+	circuit := circuits.circuitLocked(host, now)
+	changed := false
+	if circuit.state == Open && now.Sub(circuit.openedAt) >= circuits.settings.OpenFor {
+		circuit.state = HalfOpen
+		circuit.probes = 0
+		changed = true
+	}
+
+	var err error
+	switch {
+	case circuit.state == Open:
+		err = ErrOpen
+	case circuit.state == HalfOpen && circuit.probes >= circuits.settings.Probes:
+		err = ErrOpen
+	case circuit.state == HalfOpen:
+		circuit.probes++
+	}
+	circuits.mutex.Unlock()
+
+	if changed {
+		circuits.notify(host, HalfOpen)
+	}
+	return err
+}
+
+// Done counts the outcome of a request towards host's circuit
+func (circuits *Circuits) Done(host, endpoint string, statusCode int, err error, duration time.Duration) {
+	if errors.Is(err, context.Canceled) {
+		circuits.mutex.Lock()
+		if circuit := circuits.circuits[host]; circuit != nil && circuit.state == HalfOpen {
+			circuit.probes--
+		}
+		circuits.mutex.Unlock()
+		return
+	}
+	failed := err != nil || statusCode == http.StatusTooManyRequests || statusCode >= 500
+	slow := circuits.settings.SlowDuration > 0 && duration >= circuits.settings.SlowDuration
+
+	circuits.mutex.Lock()
+	now := time.Now()
+	circuit := circuits.circuitLocked(host, now)
+	state := circuit.state
+	switch circuit.state {
+	case HalfOpen:
+		if failed || slow {
+			circuit.open(now)
+		} else {
+			circuit.reset(Closed, now)
+		}
+	case Closed:
+		circuit.requests++
+		if failed {
+			circuit.failures++
+		}
+		if slow {
+			circuit.slow++
+		}
+		if circuit.requests >= circuits.settings.MinRequests &&
+			(float64(circuit.failures)/float64(circuit.requests) >= circuits.settings.FailureRate ||
+				float64(circuit.slow)/float64(circuit.requests) >= circuits.settings.SlowRate) {
+			circuit.open(now)
+		}
+	}
+	changed := circuit.state != state
+	state = circuit.state
+	circuits.mutex.Unlock()
+
+	if changed {
+		circuits.notify(host, state)
+	}
+}
+
+// circuitLocked returns host's circuit, starting a new window if the current one has ended
+func (circuits *Circuits) circuitLocked(host string, now time.Time) *circuit {
+	c, ok := circuits.circuits[host]
+	if !ok {
+		c = &circuit{}
+		c.reset(Closed, now)
+		circuits.circuits[host] = c
+	}
+	if c.state == Closed && now.Sub(c.windowStart) >= circuits.settings.Window {
+		c.reset(Closed, now)
+	}
+	return c
+}
+
+func (circuits *Circuits) notify(host, state string) {
+	if circuits.settings.OnStateChange != nil {
+		circuits.settings.OnStateChange(host, state)
+	}
+}
+
+func (c *circuit) open(now time.Time) {
+	c.reset(Open, now)
+	c.openedAt = now
+}
+
+func (c *circuit) reset(state string, now time.Time) {
+	*c = circuit{state: state, windowStart: now}
+}
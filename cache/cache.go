@@ -0,0 +1,240 @@
+// Package cache caches the responses of read endpoints of the fleet, docker, etcd and consul
+// clients, so reconcilers listing the same machines, services or images many times in a burst
+// make one request:
+//
+//	responses := cache.New().
+//		SetTTL("machines", 10*time.Second, time.Minute).
+//		SetTTL("catalog/services", 5*time.Second, 0).
+//		Invalidates("agent/service/register", "catalog/services")
+//	fleet.SetCache(responses)
+//
+// Only endpoints given a TTL are cached, named as in metrics and traces. A cached response is
+// served until it is TTL old, and then for up to its stale period more while a request in the
+// background refreshes it. Writes drop the cached responses of endpoints sharing their first
+// path segment, such as a DELETE of an image dropping the images/json listing, and of the
+// endpoints given to Invalidates. Blocking and watch requests, with a wait or index parameter,
+// are never cached.
+package cache
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cache is what the clients send requests through
+type Cache interface {
+	// Do returns a response to request, to an endpoint named endpoint, from the cache or by
+	// calling send
+	Do(request *http.Request, endpoint string, send func(*http.Request) (*http.Response, error)) (*http.Response, error)
+}
+
+// Responses is a Cache of successful responses in memory, safe for concurrent use
+type Responses struct {
+	mutex       sync.Mutex
+	policies    map[string]policy
+	invalidates map[string][]string
+	entries     map[string]*entry
+}
+
+type policy struct {
+	ttl   time.Duration
+	stale time.Duration
+}
+
+type entry struct {
+	endpoint   string
+	statusCode int
+	header     http.Header
+	body       []byte
+	fetched    time.Time
+	refreshing bool
+}
+
+// New returns an empty cache that caches no endpoint until given TTLs
+func New() *Responses {
+	return &Responses{
+		policies:    map[string]policy{},
+		invalidates: map[string][]string{},
+		entries:     map[string]*entry{},
+	}
+}
+
+// SetTTL caches endpoint's responses for ttl, then serves them for up to stale more while
+// refreshing them, returning the cache
+func (responses *Responses) SetTTL(endpoint string, ttl, stale time.Duration) *Responses {
+	responses.mutex.Lock()
+	defer responses.mutex.Unlock()
+	responses.policies[endpoint] = policy{ttl: ttl, stale: stale}
+	return responses
+}
+
+// Invalidates makes writes to the write endpoint drop the cached responses of the read
+// endpoints, returning the cache
+func (responses *Responses) Invalidates(write string, reads ...string) *Responses {
+	responses.mutex.Lock()
+	defer responses.mutex.Unlock()
+	responses.invalidates[write] = append(responses.invalidates[write], reads...)
+	return responses
+}
+
+// Invalidate drops the cached responses of the endpoints
+func (responses *Responses) Invalidate(endpoints ...string) {
+	responses.mutex.Lock()
+	defer responses.mutex.Unlock()
+	responses.invalidateLocked(func(endpoint string) bool {
+		for _, invalid := range endpoints {
+			if endpoint == invalid {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// Clear drops every cached response
+func (responses *Responses) Clear() {
+	responses.mutex.Lock()
+	defer responses.mutex.Unlock()
+	responses.entries = map[string]*entry{}
+}
+
+// Do returns a cached response to GET requests of endpoints with a TTL, and drops the cached
+// responses a write makes out of date
+func (responses *Responses) Do(request *http.Request, endpoint string, send func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	if request.Method != http.MethodGet && request.Method != http.MethodHead {
+		response, err := send(request)
+		responses.written(endpoint)
+		return response, err
+	}
+
+	query := request.URL.Query()
+	responses.mutex.Lock()
+	policy, cached := responses.policies[endpoint]
+	if !cached || request.Method != http.MethodGet || query.Get("wait") != "" || query.Get("index") != "" {
+		responses.mutex.Unlock()
+		return send(request)
+	}
+
+	key := cacheKey(request)
+	now := time.Now()
+	if e, ok := responses.entries[key]; ok {
+		age := now.Sub(e.fetched)
+		if age < policy.ttl {
+			response := e.response(request)
+			responses.mutex.Unlock()
+			return response, nil
+		}
+		if age < policy.ttl+policy.stale {
+			if !e.refreshing {
+				e.refreshing = true
+				go responses.refresh(request.Clone(context.Background()), endpoint, key, send)
+			}
+			response := e.response(request)
+			responses.mutex.Unlock()
+			return response, nil
+		}
+	}
+	responses.mutex.Unlock()
+
+	return responses.fetch(request, endpoint, key, send)
+}
+
+// fetch sends request and caches the response if it succeeded
+func (responses *Responses) fetch(request *http.Request, endpoint, key string, send func(*http.Request) (*http.Response, error)) (*http.Response, error) {
+	response, err := send(request)
+	if err != nil || response.StatusCode != http.StatusOK {
+		return response, err
+	}
+	body, err := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	response.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	responses.mutex.Lock()
+	responses.entries[key] = &entry{
+		endpoint:   endpoint,
+		statusCode: response.StatusCode,
+		header:     response.Header.Clone(),
+		body:       body,
+		fetched:    time.Now(),
+	}
+	responses.mutex.Unlock()
+	return response, nil
+}
+
+// refresh fetches a stale response again in the background
+func (responses *Responses) refresh(request *http.Request, endpoint, key string, send func(*http.Request) (*http.Response, error)) {
+	response, err := responses.fetch(request, endpoint, key, send)
+	if err == nil {
+		response.Body.Close()
+	}
+
+	responses.mutex.Lock()
+	defer responses.mutex.Unlock()
+	if e, ok := responses.entries[key]; ok {
+		e.refreshing = false
+	}
+}
+
+// written drops the cached responses a write to endpoint makes out of date
+func (responses *Responses) written(endpoint string) {
+	responses.mutex.Lock()
+	defer responses.mutex.Unlock()
+
+	related := responses.invalidates[endpoint]
+	segment := firstSegment(endpoint)
+	responses.invalidateLocked(func(cached string) bool {
+		if firstSegment(cached) == segment {
+			return true
+		}
+		for _, read := range related {
+			if cached == read {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func (responses *Responses) invalidateLocked(matches func(endpoint string) bool) {
+	for key, e := range responses.entries {
+		if matches(e.endpoint) {
+			delete(responses.entries, key)
+		}
+	}
+}
+
+// response returns a copy of the cached response for request
+func (e *entry) response(request *http.Request) *http.Response {
+	return &http.Response{
+		Status:        http.StatusText(e.statusCode),
+		StatusCode:    e.statusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        e.header.Clone(),
+		Body:          ioutil.NopCloser(bytes.NewReader(e.body)),
+		ContentLength: int64(len(e.body)),
+		Request:       request,
+	}
+}
+
+// cacheKey identifies a request's response, including the credentials it was made with
+func cacheKey(request *http.Request) string {
+	return strings.Join([]string{
+		request.URL.String(),
+		request.Header.Get("Authorization"),
+		request.Header.Get("X-Consul-Token"),
+	}, "\n")
+}
+
+func firstSegment(endpoint string) string {
+	return strings.SplitN(endpoint, "/", 2)[0]
+}
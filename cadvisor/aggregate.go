@@ -0,0 +1,110 @@
+package cadvisor
+
+import (
+	"context"
+
+	"github.com/rarmstrong73/go-utils/fanout"
+)
+
+// Usage is the usage of one or more containers
+type Usage struct {
+	// Containers is how many containers the usage is of
+	Containers int
+	// CPUCores is the average number of cores used between the first and last samples
+	CPUCores float64
+	// MemoryBytes is the working set in the last sample
+	MemoryBytes uint64
+	// FilesystemBytes is the filesystem usage in the last sample
+	FilesystemBytes uint64
+	// RxBytesPerSecond and TxBytesPerSecond are the average network throughput between the
+	// first and last samples
+	RxBytesPerSecond float64
+	TxBytesPerSecond float64
+}
+
+// Add returns the sum of two usages
+func (usage Usage) Add(other Usage) Usage {
+	return Usage{
+		Containers:       usage.Containers + other.Containers,
+		CPUCores:         usage.CPUCores + other.CPUCores,
+		MemoryBytes:      usage.MemoryBytes + other.MemoryBytes,
+		FilesystemBytes:  usage.FilesystemBytes + other.FilesystemBytes,
+		RxBytesPerSecond: usage.RxBytesPerSecond + other.RxBytesPerSecond,
+		TxBytesPerSecond: usage.TxBytesPerSecond + other.TxBytesPerSecond,
+	}
+}
+
+// Usage returns the container's usage over its samples. Rates are 0 with fewer than two samples.
+func (info ContainerInfo) Usage() Usage {
+	usage := Usage{Containers: 1}
+	if len(info.Stats) == 0 {
+		return usage
+	}
+	first, last := info.Stats[0], info.Stats[len(info.Stats)-1]
+	usage.MemoryBytes = last.Memory.WorkingSet
+	for _, fs := range last.Filesystem {
+		usage.FilesystemBytes += fs.Usage
+	}
+
+	seconds := last.Timestamp.Sub(first.Timestamp).Seconds()
+	if seconds <= 0 {
+		return usage
+	}
+	usage.CPUCores = float64(counterDelta(first.CPU.Usage.Total, last.CPU.Usage.Total)) / 1e9 / seconds
+	usage.RxBytesPerSecond = float64(counterDelta(first.Network.RxBytes, last.Network.RxBytes)) / seconds
+	usage.TxBytesPerSecond = float64(counterDelta(first.Network.TxBytes, last.Network.TxBytes)) / seconds
+	return usage
+}
+
+// HostContainers are the docker containers on a host
+type HostContainers struct {
+	Host       string
+	Containers []ContainerInfo
+}
+
+// Collect gets the docker containers on every host at once, see fanout.Run. It returns the
+// containers of the hosts that succeeded, in the order the hosts were given, along with a
+// *fanout.Error listing the hosts that failed.
+func Collect(ctx context.Context, hosts []string, query Query, options fanout.Options) ([]HostContainers, error) {
+	results, err := fanout.Run(ctx, hosts, options, func(ctx context.Context, host string) (interface{}, error) {
+		return GetDockerContainers(host, query)
+	})
+	collected := []HostContainers{}
+	for _, result := range results {
+		if result.Err == nil {
+			collected = append(collected, HostContainers{Host: result.Host, Containers: result.Value.([]ContainerInfo)})
+		}
+	}
+	return collected, err
+}
+
+// Aggregate sums the usage of the containers on each host by the key each container is given,
+// such as ByImage
+func Aggregate(hosts []HostContainers, key func(host string, container ContainerInfo) string) map[string]Usage {
+	usages := map[string]Usage{}
+	for _, host := range hosts {
+		for _, container := range host.Containers {
+			name := key(host.Host, container)
+			usages[name] = usages[name].Add(container.Usage())
+		}
+	}
+	return usages
+}
+
+// ByImage is a key for Aggregate summing the usage of containers of the same image
+func ByImage(host string, container ContainerInfo) string {
+	return container.Spec.Image
+}
+
+// ByHost is a key for Aggregate summing the usage of the containers on each host
+func ByHost(host string, container ContainerInfo) string {
+	return host
+}
+
+// counterDelta returns how much a counter grew, 0 if it was reset in between
+func counterDelta(first, last uint64) uint64 {
+	if last < first {
+		return 0
+	}
+	return last - first
+}
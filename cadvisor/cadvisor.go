@@ -0,0 +1,320 @@
+// Package cadvisor reads container metrics from the cAdvisor running on each host, keeping a
+// history of samples the docker stats endpoint doesn't:
+//
+//	containers, err := cadvisor.GetDockerContainers(machine.PrimaryIP, cadvisor.Query{Samples: 60})
+//	for _, container := range containers {
+//		usage := container.Usage()
+//		...
+//	}
+//
+// Collect and Aggregate sum the usage of containers across hosts, such as by image.
+package cadvisor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rarmstrong73/go-utils/apierror"
+	"github.com/rarmstrong73/go-utils/internal/httpclient"
+	"github.com/rarmstrong73/go-utils/logging"
+)
+
+var port = 8080
+
+var httpClient = httpclient.New(httpclient.Options{Service: apierror.CAdvisor, Endpoint: endpointName})
+
+// logger is where the package logs, set with SetLogger
+var logger = &logging.Swappable{}
+
+// SetLogger sets where the package logs, nil turns logging off
+func SetLogger(l logging.Logger) {
+	logger.Set(l)
+}
+
+// Shutdown stops the package: new requests fail with apierror.ErrClosed and requests in flight
+// are given until ctx is done to finish before they are cancelled. It returns ctx's error if
+// requests had to be cancelled.
+func Shutdown(ctx context.Context) error {
+	return httpClient.Shutdown(ctx)
+}
+
+// Close stops the package like Shutdown, cancelling requests in flight straight away
+func Close() error {
+	return httpClient.Close()
+}
+
+// Query selects the samples returned with containers
+type Query struct {
+	// Samples is how many of the latest samples to return, cAdvisor's default of 60 when 0
+	Samples int
+	// Start and End only select samples taken between them when not zero
+	Start time.Time
+	End   time.Time
+}
+
+// ContainerInfo is a container and its samples
+type ContainerInfo struct {
+	// Name is the container's cgroup, such as /docker/3f4e...
+	Name string `json:"name"`
+	// Aliases are the container's other names, its docker name and ID for docker containers
+	Aliases   []string         `json:"aliases"`
+	Namespace string           `json:"namespace"`
+	Spec      ContainerSpec    `json:"spec"`
+	Stats     []ContainerStats `json:"stats"`
+}
+
+// ContainerSpec describes a container and its limits
+type ContainerSpec struct {
+	CreationTime time.Time         `json:"creation_time"`
+	Labels       map[string]string `json:"labels"`
+	Image        string            `json:"image"`
+	HasCPU       bool              `json:"has_cpu"`
+	CPU          CPUSpec           `json:"cpu"`
+	HasMemory    bool              `json:"has_memory"`
+	Memory       MemorySpec        `json:"memory"`
+	HasNetwork   bool              `json:"has_network"`
+	HasFS        bool              `json:"has_filesystem"`
+}
+
+// CPUSpec is a container's CPU limits
+type CPUSpec struct {
+	// Limit is the container's relative CPU shares
+	Limit    uint64 `json:"limit"`
+	MaxLimit uint64 `json:"max_limit"`
+	Mask     string `json:"mask"`
+	// Quota and Period limit the container to Quota/Period cores when Quota is set
+	Quota  uint64 `json:"quota"`
+	Period uint64 `json:"period"`
+}
+
+// MemorySpec is a container's memory limits, in bytes
+type MemorySpec struct {
+	Limit       uint64 `json:"limit"`
+	Reservation uint64 `json:"reservation"`
+	SwapLimit   uint64 `json:"swap_limit"`
+}
+
+// ContainerStats is a sample of a container's usage
+type ContainerStats struct {
+	Timestamp  time.Time      `json:"timestamp"`
+	CPU        CPUStats       `json:"cpu"`
+	Memory     MemoryStats    `json:"memory"`
+	Network    NetworkStats   `json:"network"`
+	Filesystem []FsStats      `json:"filesystem"`
+	DiskIO     DiskIOStats    `json:"diskio"`
+	Processes  ProcessStats   `json:"processes"`
+	TaskStats  map[string]int `json:"task_stats"`
+}
+
+// CPUStats is a container's cumulative CPU usage
+type CPUStats struct {
+	Usage struct {
+		// Total, User and System are nanoseconds of CPU time used since the container started
+		Total  uint64   `json:"total"`
+		PerCPU []uint64 `json:"per_cpu_usage"`
+		User   uint64   `json:"user"`
+		System uint64   `json:"system"`
+	} `json:"usage"`
+	// LoadAverage is the container's smoothed number of runnable threads, times 1000
+	LoadAverage int32 `json:"load_average"`
+}
+
+// MemoryStats is a container's memory usage, in bytes
+type MemoryStats struct {
+	Usage    uint64 `json:"usage"`
+	MaxUsage uint64 `json:"max_usage"`
+	Cache    uint64 `json:"cache"`
+	RSS      uint64 `json:"rss"`
+	Swap     uint64 `json:"swap"`
+	// WorkingSet is the memory the container can't give up, what the kernel's OOM killer
+	// goes by
+	WorkingSet uint64 `json:"working_set"`
+	Failcnt    uint64 `json:"failcnt"`
+}
+
+// NetworkStats is a container's cumulative network usage
+type NetworkStats struct {
+	InterfaceStats
+	Interfaces []InterfaceStats `json:"interfaces"`
+}
+
+// InterfaceStats is the cumulative usage of a network interface
+type InterfaceStats struct {
+	Name      string `json:"name"`
+	RxBytes   uint64 `json:"rx_bytes"`
+	RxPackets uint64 `json:"rx_packets"`
+	RxErrors  uint64 `json:"rx_errors"`
+	RxDropped uint64 `json:"rx_dropped"`
+	TxBytes   uint64 `json:"tx_bytes"`
+	TxPackets uint64 `json:"tx_packets"`
+	TxErrors  uint64 `json:"tx_errors"`
+	TxDropped uint64 `json:"tx_dropped"`
+}
+
+// FsStats is a container's usage of a filesystem, in bytes
+type FsStats struct {
+	Device     string `json:"device"`
+	Type       string `json:"type"`
+	Limit      uint64 `json:"capacity"`
+	Usage      uint64 `json:"usage"`
+	BaseUsage  uint64 `json:"base_usage"`
+	Available  uint64 `json:"available"`
+	Inodes     uint64 `json:"inodes"`
+	InodesFree uint64 `json:"inodes_free"`
+}
+
+// DiskIOStats is a container's cumulative block IO, by device
+type DiskIOStats struct {
+	IoServiceBytes []PerDiskStats `json:"io_service_bytes"`
+	IoServiced     []PerDiskStats `json:"io_serviced"`
+}
+
+// PerDiskStats is a counter of a block device, such as Read and Write bytes in Stats
+type PerDiskStats struct {
+	Device string            `json:"device"`
+	Major  uint64            `json:"major"`
+	Minor  uint64            `json:"minor"`
+	Stats  map[string]uint64 `json:"stats"`
+}
+
+// ProcessStats counts a container's processes
+type ProcessStats struct {
+	ProcessCount   uint64 `json:"process_count"`
+	FdCount        uint64 `json:"fd_count"`
+	ThreadsCurrent uint64 `json:"threads_current"`
+}
+
+// MachineInfo describes a host
+type MachineInfo struct {
+	NumCores       int    `json:"num_cores"`
+	CPUFrequency   uint64 `json:"cpu_frequency_khz"`
+	MemoryCapacity uint64 `json:"memory_capacity"`
+	MachineID      string `json:"machine_id"`
+	SystemUUID     string `json:"system_uuid"`
+	BootID         string `json:"boot_id"`
+	Filesystems    []struct {
+		Device   string `json:"device"`
+		Type     string `json:"type"`
+		Capacity uint64 `json:"capacity"`
+	} `json:"filesystems"`
+}
+
+// DockerName returns the docker name of a docker container, its first alias not its ID
+func (info ContainerInfo) DockerName() string {
+	id := info.Name[strings.LastIndex(info.Name, "/")+1:]
+	for _, alias := range info.Aliases {
+		if alias != id {
+			return alias
+		}
+	}
+	return id
+}
+
+// GetMachine returns the description of a host
+func GetMachine(host string) (MachineInfo, error) {
+	var machine MachineInfo
+	err := send(host, "/api/v1.3/machine", nil, &machine)
+	return machine, err
+}
+
+// GetDockerContainers returns the docker containers on a host with their samples, sorted by name
+func GetDockerContainers(host string, query Query) ([]ContainerInfo, error) {
+	var containers map[string]ContainerInfo
+	if err := send(host, "/api/v1.3/docker/", &query, &containers); err != nil {
+		return nil, err
+	}
+	infos := []ContainerInfo{}
+	for _, container := range containers {
+		infos = append(infos, container)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos, nil
+}
+
+// GetDockerContainer returns a docker container on a host, by name or ID, with its samples
+func GetDockerContainer(host, name string, query Query) (ContainerInfo, error) {
+	var containers map[string]ContainerInfo
+	if err := send(host, "/api/v1.3/docker/"+name, &query, &containers); err != nil {
+		return ContainerInfo{}, err
+	}
+	for _, container := range containers {
+		return container, nil
+	}
+	return ContainerInfo{}, fmt.Errorf("No docker container %s on %s", name, host)
+}
+
+// GetContainer returns a cgroup container on a host, such as / for the whole machine or
+// /system.slice/fleet.service, with its samples
+func GetContainer(host, name string, query Query) (ContainerInfo, error) {
+	var container ContainerInfo
+	err := send(host, "/api/v1.3/containers/"+strings.TrimPrefix(name, "/"), &query, &container)
+	return container, err
+}
+
+// MarshalJSON encodes the query as cAdvisor's ContainerInfoRequest
+func (query Query) MarshalJSON() ([]byte, error) {
+	request := map[string]interface{}{}
+	if query.Samples > 0 {
+		request["num_stats"] = query.Samples
+	}
+	if !query.Start.IsZero() {
+		request["start"] = query.Start
+	}
+	if !query.End.IsZero() {
+		request["end"] = query.End
+	}
+	return json.Marshal(request)
+}
+
+// baseURL returns the URL of cAdvisor on host, which listens on the default port unless host has
+// one
+func baseURL(host string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return "http://" + host
+	}
+	return fmt.Sprintf("http://%s:%d", host, port)
+}
+
+// endpointName names a request path for observations, such as docker or machine
+func endpointName(path string) string {
+	segments := strings.Split(strings.TrimPrefix(path, "/api/"), "/")
+	if len(segments) < 2 {
+		return segments[0]
+	}
+	return segments[1]
+}
+
+// ============================================================================
+// ============================= HTTP UTILS ===================================
+// ============================================================================
+
+// send asks a host's cAdvisor for path, with the query in the body when it isn't nil, decoding
+// the response into result
+func send(host, path string, query *Query, result interface{}) error {
+	request := httpclient.Request{Method: http.MethodGet, URL: baseURL(host), Path: path}
+	if query != nil {
+		var err error
+		request.Method = http.MethodPost
+		if request, err = request.JSONBody(query); err != nil {
+			return err
+		}
+	}
+	response, err := httpClient.Do(context.Background(), request)
+	if err != nil {
+		return apierror.Transport(apierror.CAdvisor, request.Operation(), err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(response.Body)
+		return apierror.FromResponse(apierror.CAdvisor, response, "", strings.TrimSpace(string(body)), nil)
+	}
+	logger.Debug("Read cadvisor metrics", "host", host, "path", path)
+	return httpclient.DecodeJSON(response, result)
+}
@@ -0,0 +1,127 @@
+// Package cluster puts the fleet, docker, etcd and consul APIs behind interfaces that stay the
+// same across their versions, so callers don't change when the cluster is upgraded. The
+// constructors ask each backend which API it serves and return the implementation for it:
+//
+//	store, err := cluster.NewKVStore(etcdHosts...)
+//	if err != nil {
+//		...
+//	}
+//	engine, err := cluster.NewContainerEngine(machine.PrimaryIP)
+//	units, err := cluster.NewUnitManager(fleetHost)
+//	services := cluster.NewServiceDiscovery(consulClient)
+//
+// Supporting a new API version means adding an implementation here rather than changing every
+// caller.
+package cluster
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rarmstrong73/go-utils/docker"
+	"github.com/rarmstrong73/go-utils/fleet"
+)
+
+// ErrNotFound is returned by KVStore.Get for keys without a value
+var ErrNotFound = errors.New("Key not found")
+
+// UnitManager schedules systemd units on the machines of a cluster
+type UnitManager interface {
+	// APIVersion is the version of the API the manager talks, such as v1
+	APIVersion() string
+	Units() ([]fleet.Unit, error)
+	UnitStates() ([]fleet.UnitState, error)
+	Machines() ([]fleet.Machine, error)
+	// CreateUnit creates a unit with the given options in the desired state, one of
+	// fleet.Launched, fleet.Loaded or fleet.Inactive
+	CreateUnit(name, desiredState string, options []fleet.Option) error
+	SetDesiredState(name, desiredState string) error
+	DestroyUnit(name string) error
+}
+
+// ContainerEngine runs the containers of a machine
+type ContainerEngine interface {
+	// APIVersion is the version of the API the engine talks, such as 1.41
+	APIVersion() string
+	Ping() error
+	Containers(all bool) ([]docker.Container, error)
+	RemoveContainer(nameOrID string, removeVolumes, force bool) error
+	Images(all bool) ([]docker.Image, error)
+	// PullImage pulls an image such as nginx:1.25 from its registry, returning once the image is
+	// on the machine or with the registry's error when it can't be pulled
+	PullImage(image string) error
+	RemoveImage(image string, force bool) error
+	// StreamEvents calls handle with the engine's events until ctx is done or the stream fails,
+	// see docker.StreamEvents. Events always have a Type, Action and Actor.
+	StreamEvents(ctx context.Context, since time.Time, filters map[string][]string, handle func(docker.Event)) error
+}
+
+// KVStore keeps values under slash separated keys
+type KVStore interface {
+	// APIVersion is the version of the API the store talks, such as v3
+	APIVersion() string
+	// Get returns the value of key, ErrNotFound when it has none
+	Get(key string) (string, error)
+	Put(key, value string) error
+	// Delete deletes key, deleting a key that doesn't exist isn't an error
+	Delete(key string) error
+	// List returns the values of the keys under prefix, keyed by their full key
+	List(prefix string) (map[string]string, error)
+	// DeletePrefix deletes every key under prefix
+	DeletePrefix(prefix string) error
+}
+
+// ServiceDiscovery registers the instances of services and finds them
+type ServiceDiscovery interface {
+	// APIVersion is the version of the API the discovery talks, such as v1
+	APIVersion() string
+	Register(instance Instance) error
+	Deregister(id string) error
+	// Instances returns the instances of the named service, only the healthy ones when
+	// healthyOnly is set
+	Instances(name string, healthyOnly bool) ([]Instance, error)
+}
+
+// Instance is an instance of a service
+type Instance struct {
+	ID      string
+	Name    string
+	Address string
+	Port    int
+	Tags    []string
+	Meta    map[string]string
+	// Healthy reports whether the instance's checks were all passing, Instances sets it
+	Healthy bool
+}
+
+// compareVersions compares dotted versions such as 1.41 and 1.9 number by number, returning
+// -1, 0 or 1. Missing numbers count as 0 and whatever follows a number, such as -rc1, is ignored.
+func compareVersions(a, b string) int {
+	aParts := strings.Split(a, ".")
+	bParts := strings.Split(b, ".")
+	for i := 0; i < len(aParts) || i < len(bParts); i++ {
+		aNumber, bNumber := versionNumber(aParts, i), versionNumber(bParts, i)
+		if aNumber < bNumber {
+			return -1
+		}
+		if aNumber > bNumber {
+			return 1
+		}
+	}
+	return 0
+}
+
+func versionNumber(parts []string, i int) int {
+	if i >= len(parts) {
+		return 0
+	}
+	digits := strings.TrimLeft(parts[i], "v")
+	if end := strings.IndexFunc(digits, func(r rune) bool { return r < '0' || r > '9' }); end >= 0 {
+		digits = digits[:end]
+	}
+	number, _ := strconv.Atoi(digits)
+	return number
+}
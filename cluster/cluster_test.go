@@ -0,0 +1,90 @@
+package cluster_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rarmstrong73/go-utils/cluster"
+	"github.com/rarmstrong73/go-utils/docker/dockertest"
+	"github.com/rarmstrong73/go-utils/etcd/etcdtest"
+)
+
+// newEtcd3Server fakes an etcd 3 member whose v2 store holds v2Keys, or has the v2 API turned off
+// when v2Keys is nil
+func newEtcd3Server(v2Keys []string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/version":
+			fmt.Fprint(w, `{"etcdserver":"3.5.9","etcdcluster":"3.5.0"}`)
+		case strings.HasPrefix(r.URL.Path, "/v2/keys") && v2Keys != nil:
+			nodes := []string{}
+			for _, key := range v2Keys {
+				nodes = append(nodes, fmt.Sprintf(`{"key":%q,"value":"1"}`, key))
+			}
+			fmt.Fprintf(w, `{"action":"get","node":{"dir":true,"nodes":[%s]}}`, strings.Join(nodes, ","))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestNewKVStoreSelectsAPIVersion(t *testing.T) {
+	etcd2 := etcdtest.NewServer()
+	defer etcd2.Close()
+	migrating := newEtcd3Server([]string{"/app"})
+	defer migrating.Close()
+	migrated := newEtcd3Server([]string{})
+	defer migrated.Close()
+	v2Off := newEtcd3Server(nil)
+	defer v2Off.Close()
+
+	tests := []struct {
+		name string
+		host string
+		want string
+	}{
+		{"etcd 2", etcd2.Host(), "v2"},
+		{"etcd 3 with v2 keys", strings.TrimPrefix(migrating.URL, "http://"), "v2"},
+		{"etcd 3 with an empty v2 store", strings.TrimPrefix(migrated.URL, "http://"), "v3"},
+		{"etcd 3 without the v2 API", strings.TrimPrefix(v2Off.URL, "http://"), "v3"},
+	}
+	for _, test := range tests {
+		store, err := cluster.NewKVStore(test.host)
+		if err != nil {
+			t.Fatalf("%s: NewKVStore: %v", test.name, err)
+		}
+		if store.APIVersion() != test.want {
+			t.Errorf("%s: APIVersion = %s, want %s", test.name, store.APIVersion(), test.want)
+		}
+	}
+}
+
+func TestNewKVStoreVersion(t *testing.T) {
+	store, err := cluster.NewKVStoreVersion("v3", "127.0.0.1")
+	if err != nil || store.APIVersion() != "v3" {
+		t.Errorf("NewKVStoreVersion(v3) = %v, %v", store, err)
+	}
+	if _, err := cluster.NewKVStoreVersion("v4", "127.0.0.1"); err == nil {
+		t.Error("NewKVStoreVersion(v4) succeeded")
+	}
+}
+
+func TestPullImageFailsWithRegistryError(t *testing.T) {
+	server := dockertest.NewServer()
+	defer server.Close()
+	server.FailPull("nginx:nope", "manifest for nginx:nope not found")
+
+	engine, err := cluster.NewContainerEngine(server.Host())
+	if err != nil {
+		t.Fatalf("NewContainerEngine: %v", err)
+	}
+	if err := engine.PullImage("nginx:1.25"); err != nil {
+		t.Errorf("PullImage(nginx:1.25): %v", err)
+	}
+	if err := engine.PullImage("nginx:nope"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("PullImage(nginx:nope) error = %v, want the registry's", err)
+	}
+}
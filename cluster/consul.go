@@ -0,0 +1,67 @@
+package cluster
+
+import (
+	consul "github.com/rarmstrong73/go-utils/consul/health"
+)
+
+// NewServiceDiscovery returns the ServiceDiscovery registering instances with the agent client
+// talks to and finding them in its datacenter
+func NewServiceDiscovery(client *consul.Client) ServiceDiscovery {
+	// v1 is the only version of the consul API
+	return consulV1{client: client}
+}
+
+// consulV1 registers and finds instances through the v1 consul API
+type consulV1 struct {
+	client *consul.Client
+}
+
+func (discovery consulV1) APIVersion() string {
+	return "v1"
+}
+
+func (discovery consulV1) Register(instance Instance) error {
+	return discovery.client.AgentRegisterService(consul.AgentServiceRegistration{
+		ID:      instance.ID,
+		Name:    instance.Name,
+		Tags:    instance.Tags,
+		Meta:    instance.Meta,
+		Address: instance.Address,
+		Port:    instance.Port,
+	})
+}
+
+func (discovery consulV1) Deregister(id string) error {
+	return discovery.client.AgentDeregisterService(id)
+}
+
+func (discovery consulV1) Instances(name string, healthyOnly bool) ([]Instance, error) {
+	entries, _, err := discovery.client.HealthService(name, "", healthyOnly, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	instances := make([]Instance, 0, len(entries))
+	for _, entry := range entries {
+		instance := Instance{
+			ID:      entry.Service.ID,
+			Name:    entry.Service.Service,
+			Address: entry.Service.Address,
+			Port:    entry.Service.Port,
+			Tags:    entry.Service.Tags,
+			Meta:    entry.Service.Meta,
+			Healthy: true,
+		}
+		// Services registered without an address are reached on their node's
+		if instance.Address == "" {
+			instance.Address = entry.Node.Address
+		}
+		for _, check := range entry.Checks {
+			if check.Status != consul.HealthPassing {
+				instance.Healthy = false
+			}
+		}
+		instances = append(instances, instance)
+	}
+	return instances, nil
+}
@@ -0,0 +1,117 @@
+package cluster
+
+import (
+	"context"
+	"time"
+
+	"github.com/rarmstrong73/go-utils/docker"
+)
+
+// typedEventsAPIVersion is the docker API version events got their Type, Action and Actor in,
+// along with the type filter
+const typedEventsAPIVersion = "1.22"
+
+// imageActions are the actions of the events about images of daemons before API 1.22, whose
+// other events are about containers
+var imageActions = map[string]bool{"pull": true, "push": true, "tag": true, "untag": true, "delete": true, "import": true, "load": true, "save": true}
+
+// NewContainerEngine returns the ContainerEngine for the docker daemon on host, picked by the
+// newest API version the daemon serves
+func NewContainerEngine(host string) (ContainerEngine, error) {
+	version, err := docker.GetVersion(host)
+	if err != nil {
+		return nil, err
+	}
+	engine := dockerEngine{host: host, apiVersion: version.APIVersion}
+	if compareVersions(version.APIVersion, typedEventsAPIVersion) < 0 {
+		return legacyDockerEngine{engine}, nil
+	}
+	return engine, nil
+}
+
+// dockerEngine runs containers through the docker API from version 1.22
+type dockerEngine struct {
+	host       string
+	apiVersion string
+}
+
+func (engine dockerEngine) APIVersion() string {
+	return engine.apiVersion
+}
+
+func (engine dockerEngine) Ping() error {
+	return docker.Ping(engine.host)
+}
+
+func (engine dockerEngine) Containers(all bool) ([]docker.Container, error) {
+	return docker.ListContainers(engine.host, all)
+}
+
+func (engine dockerEngine) RemoveContainer(nameOrID string, removeVolumes, force bool) error {
+	return docker.RemoveContainer(engine.host, nameOrID, removeVolumes, force)
+}
+
+func (engine dockerEngine) Images(all bool) ([]docker.Image, error) {
+	return docker.ListImages(engine.host, all)
+}
+
+func (engine dockerEngine) PullImage(image string) error {
+	return docker.CreateImage(engine.host, image, "", "", "")
+}
+
+func (engine dockerEngine) RemoveImage(image string, force bool) error {
+	return docker.RemoveImage(engine.host, image, force, false)
+}
+
+func (engine dockerEngine) StreamEvents(ctx context.Context, since time.Time, filters map[string][]string, handle func(docker.Event)) error {
+	return docker.StreamEvents(ctx, engine.host, since, filters, handle)
+}
+
+// legacyDockerEngine runs containers through the docker API before version 1.22, whose events
+// only have a status, ID and image and can't be filtered by type
+type legacyDockerEngine struct {
+	dockerEngine
+}
+
+func (engine legacyDockerEngine) StreamEvents(ctx context.Context, since time.Time, filters map[string][]string, handle func(docker.Event)) error {
+	types := filters["type"]
+	daemonFilters := map[string][]string{}
+	for name, values := range filters {
+		if name != "type" {
+			daemonFilters[name] = values
+		}
+	}
+
+	return docker.StreamEvents(ctx, engine.host, since, daemonFilters, func(event docker.Event) {
+		if event.Type == "" {
+			event = typedEvent(event)
+		}
+		if len(types) > 0 && !contains(types, event.Type) {
+			return
+		}
+		handle(event)
+	})
+}
+
+// typedEvent fills in the Type, Action and Actor of an event from a daemon before API 1.22
+func typedEvent(event docker.Event) docker.Event {
+	event.Type = "container"
+	if imageActions[event.Status] {
+		event.Type = "image"
+	}
+	event.Action = event.Status
+	event.Actor = docker.EventActor{ID: event.ID, Attributes: map[string]string{}}
+	if event.From != "" {
+		event.Actor.Attributes["image"] = event.From
+	}
+	return event
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
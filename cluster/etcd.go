@@ -0,0 +1,110 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/rarmstrong73/go-utils/apierror"
+	"github.com/rarmstrong73/go-utils/etcd"
+)
+
+// NewKVStore returns the KVStore for the etcd cluster with members on the given hosts. Keys
+// written through the v2 API can't be read through the v3 API, so it talks v2 to etcd 2 clusters
+// and to etcd 3 clusters whose v2 store still holds keys, and v3 once the keys have been migrated
+// and the v2 store emptied or turned off. NewKVStoreVersion picks the API instead.
+func NewKVStore(hosts ...string) (KVStore, error) {
+	v2 := etcd.NewClient(hosts...)
+	version, err := v2.GetVersion()
+	if err != nil {
+		return nil, err
+	}
+	if compareVersions(version.Cluster, "3") < 0 {
+		return etcdStore{client: v2, apiVersion: "v2"}, nil
+	}
+	hasKeys, err := hasV2Keys(v2)
+	if err != nil {
+		return nil, err
+	}
+	if hasKeys {
+		return etcdStore{client: v2, apiVersion: "v2"}, nil
+	}
+	return etcdStore{client: etcd.NewV3Client(hosts...), apiVersion: "v3"}, nil
+}
+
+// NewKVStoreVersion returns the KVStore talking the given API version, v2 or v3, to the etcd
+// cluster with members on the given hosts
+func NewKVStoreVersion(apiVersion string, hosts ...string) (KVStore, error) {
+	switch apiVersion {
+	case "v2":
+		return etcdStore{client: etcd.NewClient(hosts...), apiVersion: apiVersion}, nil
+	case "v3":
+		return etcdStore{client: etcd.NewV3Client(hosts...), apiVersion: apiVersion}, nil
+	}
+	return nil, fmt.Errorf("Unsupported etcd API version %q", apiVersion)
+}
+
+// hasV2Keys reports whether the v2 store of the cluster holds any keys, which it doesn't when
+// the v2 API is turned off
+func hasV2Keys(client *etcd.Client) (bool, error) {
+	root, err := client.GetKey("/")
+	var apiErr *apierror.Error
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return len(root.Nodes) > 0, nil
+}
+
+// etcdStore keeps values in etcd through a v2 or v3 client, which map keys onto their API
+// the same way
+type etcdStore struct {
+	client     *etcd.Client
+	apiVersion string
+}
+
+func (store etcdStore) APIVersion() string {
+	return store.apiVersion
+}
+
+func (store etcdStore) Get(key string) (string, error) {
+	node, err := store.client.GetKey(key)
+	if errors.Is(err, etcd.ErrKeyNotFound) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	if node.Dir {
+		return "", ErrNotFound
+	}
+	return node.Value, nil
+}
+
+func (store etcdStore) Put(key, value string) error {
+	_, err := store.client.SetKey(key, value)
+	return err
+}
+
+func (store etcdStore) Delete(key string) error {
+	err := store.client.DeleteKey(key)
+	if errors.Is(err, etcd.ErrKeyNotFound) {
+		return nil
+	}
+	return err
+}
+
+func (store etcdStore) List(prefix string) (map[string]string, error) {
+	values, err := store.client.GetValues(prefix)
+	if errors.Is(err, etcd.ErrKeyNotFound) {
+		return map[string]string{}, nil
+	}
+	return values, err
+}
+
+func (store etcdStore) DeletePrefix(prefix string) error {
+	_, err := store.client.DeletePrefix(prefix, etcd.DeleteOptions{})
+	return err
+}
@@ -0,0 +1,57 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/rarmstrong73/go-utils/apierror"
+	"github.com/rarmstrong73/go-utils/fleet"
+)
+
+// NewUnitManager returns the UnitManager for the fleet API on host, after checking that fleet
+// serves an API version the package supports
+func NewUnitManager(host string) (UnitManager, error) {
+	// v1 is the only version of the fleet API, older fleets have no API
+	if _, err := fleet.ListMachines(host); err != nil {
+		var apiErr *apierror.Error
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("Fleet on %s doesn't serve a supported API", host)
+		}
+		return nil, err
+	}
+	return fleetV1{host: host}, nil
+}
+
+// fleetV1 manages units through the v1 fleet API
+type fleetV1 struct {
+	host string
+}
+
+func (manager fleetV1) APIVersion() string {
+	return "v1"
+}
+
+func (manager fleetV1) Units() ([]fleet.Unit, error) {
+	return fleet.ListUnits(manager.host)
+}
+
+func (manager fleetV1) UnitStates() ([]fleet.UnitState, error) {
+	return fleet.ListUnitStates(manager.host)
+}
+
+func (manager fleetV1) Machines() ([]fleet.Machine, error) {
+	return fleet.ListMachines(manager.host)
+}
+
+func (manager fleetV1) CreateUnit(name, desiredState string, options []fleet.Option) error {
+	return fleet.CreateUnit(manager.host, name, desiredState, options)
+}
+
+func (manager fleetV1) SetDesiredState(name, desiredState string) error {
+	return fleet.Unit{Name: name}.ModifyDesiredState(manager.host, desiredState)
+}
+
+func (manager fleetV1) DestroyUnit(name string) error {
+	return fleet.Unit{Name: name}.Destroy(manager.host)
+}
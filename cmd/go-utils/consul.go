@@ -0,0 +1,178 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	consul "github.com/rarmstrong73/go-utils/consul/health"
+)
+
+func init() {
+	commands = append(commands,
+		command{[]string{"consul", "health"}, "[-tag tag] [-passing] <service>", consulHealth},
+		command{[]string{"consul", "checks"}, "[-state state]", consulChecks},
+		command{[]string{"consul", "services"}, "", consulServices},
+		command{[]string{"consul", "members"}, "[-wan]", consulMembers},
+		command{[]string{"consul", "kv", "get"}, "<key>", consulKVGet},
+		command{[]string{"consul", "kv", "ls"}, "<prefix>", consulKVList},
+	)
+}
+
+func consulHealth(env *environment, args []string) error {
+	flags := flag.NewFlagSet("consul health", flag.ExitOnError)
+	tag := flags.String("tag", "", "only list instances with this tag")
+	passing := flags.Bool("passing", false, "only list instances whose checks are all passing")
+	args, err := parseFlags(flags, args, 1)
+	if err != nil {
+		return err
+	}
+	client, err := env.config.ConsulClient()
+	if err != nil {
+		return err
+	}
+
+	entries, _, err := client.HealthService(args[0], *tag, *passing, nil)
+	if err != nil {
+		return err
+	}
+	rows := [][]string{}
+	for _, entry := range entries {
+		address := entry.Service.Address
+		if address == "" {
+			address = entry.Node.Address
+		}
+		rows = append(rows, []string{
+			entry.Service.ID,
+			entry.Node.Node,
+			fmt.Sprintf("%s:%d", address, entry.Service.Port),
+			aggregateStatus(entry.Checks),
+			strings.Join(entry.Service.Tags, ","),
+		})
+	}
+	return env.print(entries, []string{"SERVICE", "NODE", "ADDRESS", "STATUS", "TAGS"}, rows)
+}
+
+func consulChecks(env *environment, args []string) error {
+	flags := flag.NewFlagSet("consul checks", flag.ExitOnError)
+	state := flags.String("state", consul.HealthAny, "only list checks in this state")
+	if _, err := parseFlags(flags, args, 0); err != nil {
+		return err
+	}
+	client, err := env.config.ConsulClient()
+	if err != nil {
+		return err
+	}
+
+	checks, _, err := client.HealthState(*state, nil)
+	if err != nil {
+		return err
+	}
+	rows := [][]string{}
+	for _, check := range checks {
+		rows = append(rows, []string{check.Node, check.CheckID, check.ServiceName, check.Status})
+	}
+	return env.print(checks, []string{"NODE", "CHECK", "SERVICE", "STATUS"}, rows)
+}
+
+func consulServices(env *environment, args []string) error {
+	if _, err := parseFlags(flag.NewFlagSet("consul services", flag.ExitOnError), args, 0); err != nil {
+		return err
+	}
+	client, err := env.config.ConsulClient()
+	if err != nil {
+		return err
+	}
+
+	services, _, err := client.CatalogServices(nil)
+	if err != nil {
+		return err
+	}
+	names := []string{}
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	rows := [][]string{}
+	for _, name := range names {
+		rows = append(rows, []string{name, strings.Join(services[name], ",")})
+	}
+	return env.print(services, []string{"SERVICE", "TAGS"}, rows)
+}
+
+func consulMembers(env *environment, args []string) error {
+	flags := flag.NewFlagSet("consul members", flag.ExitOnError)
+	wan := flags.Bool("wan", false, "list the servers in every datacenter")
+	if _, err := parseFlags(flags, args, 0); err != nil {
+		return err
+	}
+	client, err := env.config.ConsulClient()
+	if err != nil {
+		return err
+	}
+
+	members, err := client.AgentMembers(*wan)
+	if err != nil {
+		return err
+	}
+	rows := [][]string{}
+	for _, member := range members {
+		rows = append(rows, []string{member.Name, fmt.Sprintf("%s:%d", member.Addr, member.Port), strconv.Itoa(member.Status), member.Tags["role"]})
+	}
+	return env.print(members, []string{"NODE", "ADDRESS", "STATUS", "ROLE"}, rows)
+}
+
+func consulKVGet(env *environment, args []string) error {
+	args, err := parseFlags(flag.NewFlagSet("consul kv get", flag.ExitOnError), args, 1)
+	if err != nil {
+		return err
+	}
+	client, err := env.config.ConsulClient()
+	if err != nil {
+		return err
+	}
+
+	pair, _, err := client.KVGet(args[0], nil)
+	if err != nil {
+		return err
+	}
+	row := []string{pair.Key, string(pair.Value), strconv.FormatUint(pair.ModifyIndex, 10)}
+	return env.print(pair, []string{"KEY", "VALUE", "MODIFIED"}, [][]string{row})
+}
+
+func consulKVList(env *environment, args []string) error {
+	args, err := parseFlags(flag.NewFlagSet("consul kv ls", flag.ExitOnError), args, 1)
+	if err != nil {
+		return err
+	}
+	client, err := env.config.ConsulClient()
+	if err != nil {
+		return err
+	}
+
+	pairs, _, err := client.KVList(args[0], nil)
+	if err != nil {
+		return err
+	}
+	rows := [][]string{}
+	for _, pair := range pairs {
+		rows = append(rows, []string{pair.Key, string(pair.Value), strconv.FormatUint(pair.ModifyIndex, 10)})
+	}
+	return env.print(pairs, []string{"KEY", "VALUE", "MODIFIED"}, rows)
+}
+
+// aggregateStatus returns the worst status of the checks
+func aggregateStatus(checks []consul.HealthNode) string {
+	status := consul.HealthPassing
+	for _, check := range checks {
+		switch {
+		case check.Status == consul.HealthCritical:
+			return consul.HealthCritical
+		case check.Status == consul.HealthWarning:
+			status = consul.HealthWarning
+		}
+	}
+	return status
+}
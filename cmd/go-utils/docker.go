@@ -0,0 +1,119 @@
+package main
+
+import (
+	"flag"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rarmstrong73/go-utils/docker"
+)
+
+func init() {
+	commands = append(commands,
+		command{[]string{"docker", "containers", "ls"}, "[-host host] [-all]", dockerContainersList},
+		command{[]string{"docker", "containers", "rm"}, "[-host host] [-v] [-force] <container>", dockerContainersRemove},
+		command{[]string{"docker", "images", "ls"}, "[-host host] [-all]", dockerImagesList},
+		command{[]string{"docker", "images", "rm"}, "[-host host] [-force] <image>", dockerImagesRemove},
+	)
+}
+
+// dockerFlags returns a command's flags with -host defaulting to the configured docker host
+func dockerFlags(env *environment, name string) (*flag.FlagSet, *string) {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	host := flags.String("host", env.config.Docker.Host, "docker daemon host")
+	return flags, host
+}
+
+// checkDockerHost checks that host is configured and can be passed to the docker functions
+func checkDockerHost(env *environment, host string) error {
+	if err := require(host, "docker host"); err != nil {
+		return err
+	}
+	config := env.config
+	config.Docker.Host = host
+	_, err := config.DockerHost()
+	return err
+}
+
+func dockerContainersList(env *environment, args []string) error {
+	flags, host := dockerFlags(env, "docker containers ls")
+	all := flags.Bool("all", false, "include stopped containers")
+	if _, err := parseFlags(flags, args, 0); err != nil {
+		return err
+	}
+	if err := checkDockerHost(env, *host); err != nil {
+		return err
+	}
+
+	containers, err := docker.ListContainers(*host, *all)
+	if err != nil {
+		return err
+	}
+	rows := [][]string{}
+	for _, container := range containers {
+		id := container.ID
+		if len(id) > 12 {
+			id = id[:12]
+		}
+		names := []string{}
+		for _, name := range container.Names {
+			names = append(names, strings.TrimPrefix(name, "/"))
+		}
+		rows = append(rows, []string{id, container.Image, container.Status, strings.Join(names, ",")})
+	}
+	return env.print(containers, []string{"CONTAINER", "IMAGE", "STATUS", "NAMES"}, rows)
+}
+
+func dockerContainersRemove(env *environment, args []string) error {
+	flags, host := dockerFlags(env, "docker containers rm")
+	volumes := flags.Bool("v", false, "remove the container's volumes")
+	force := flags.Bool("force", false, "kill the container if it is running")
+	args, err := parseFlags(flags, args, 1)
+	if err != nil {
+		return err
+	}
+	if err := checkDockerHost(env, *host); err != nil {
+		return err
+	}
+	return docker.RemoveContainer(*host, args[0], *volumes, *force)
+}
+
+func dockerImagesList(env *environment, args []string) error {
+	flags, host := dockerFlags(env, "docker images ls")
+	all := flags.Bool("all", false, "include intermediate images")
+	if _, err := parseFlags(flags, args, 0); err != nil {
+		return err
+	}
+	if err := checkDockerHost(env, *host); err != nil {
+		return err
+	}
+
+	images, err := docker.ListImages(*host, *all)
+	if err != nil {
+		return err
+	}
+	rows := [][]string{}
+	for _, image := range images {
+		id := strings.TrimPrefix(image.ID, "sha256:")
+		if len(id) > 12 {
+			id = id[:12]
+		}
+		created := time.Unix(image.Created, 0).UTC().Format(time.RFC3339)
+		rows = append(rows, []string{id, strings.Join(image.RepoTags, ","), created, strconv.FormatInt(image.Size, 10)})
+	}
+	return env.print(images, []string{"IMAGE", "TAGS", "CREATED", "SIZE"}, rows)
+}
+
+func dockerImagesRemove(env *environment, args []string) error {
+	flags, host := dockerFlags(env, "docker images rm")
+	force := flags.Bool("force", false, "remove the image even if it is tagged in several repositories")
+	args, err := parseFlags(flags, args, 1)
+	if err != nil {
+		return err
+	}
+	if err := checkDockerHost(env, *host); err != nil {
+		return err
+	}
+	return docker.RemoveImage(*host, args[0], *force, false)
+}
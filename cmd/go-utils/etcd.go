@@ -0,0 +1,92 @@
+package main
+
+import (
+	"flag"
+	"sort"
+	"strconv"
+)
+
+func init() {
+	commands = append(commands,
+		command{[]string{"etcd", "get"}, "<key>", etcdGet},
+		command{[]string{"etcd", "ls"}, "<prefix>", etcdList},
+		command{[]string{"etcd", "put"}, "[-ttl seconds] <key> <value>", etcdPut},
+		command{[]string{"etcd", "rm"}, "<key>", etcdRemove},
+	)
+}
+
+func etcdGet(env *environment, args []string) error {
+	args, err := parseFlags(flag.NewFlagSet("etcd get", flag.ExitOnError), args, 1)
+	if err != nil {
+		return err
+	}
+	client, err := env.config.EtcdClient()
+	if err != nil {
+		return err
+	}
+
+	node, err := client.GetKey(args[0])
+	if err != nil {
+		return err
+	}
+	row := []string{node.Key, node.Value, strconv.FormatInt(node.ModifiedIndex, 10)}
+	return env.print(node, []string{"KEY", "VALUE", "MODIFIED"}, [][]string{row})
+}
+
+func etcdList(env *environment, args []string) error {
+	args, err := parseFlags(flag.NewFlagSet("etcd ls", flag.ExitOnError), args, 1)
+	if err != nil {
+		return err
+	}
+	client, err := env.config.EtcdClient()
+	if err != nil {
+		return err
+	}
+
+	values, err := client.GetValues(args[0])
+	if err != nil {
+		return err
+	}
+	keys := []string{}
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	rows := [][]string{}
+	for _, key := range keys {
+		rows = append(rows, []string{key, values[key]})
+	}
+	return env.print(values, []string{"KEY", "VALUE"}, rows)
+}
+
+func etcdPut(env *environment, args []string) error {
+	flags := flag.NewFlagSet("etcd put", flag.ExitOnError)
+	ttl := flags.Int("ttl", 0, "seconds until the key expires, 0 for never")
+	args, err := parseFlags(flags, args, 2)
+	if err != nil {
+		return err
+	}
+	client, err := env.config.EtcdClient()
+	if err != nil {
+		return err
+	}
+
+	if *ttl > 0 {
+		_, err = client.SetWithTTL(args[0], args[1], *ttl)
+	} else {
+		_, err = client.Set(args[0], args[1])
+	}
+	return err
+}
+
+func etcdRemove(env *environment, args []string) error {
+	args, err := parseFlags(flag.NewFlagSet("etcd rm", flag.ExitOnError), args, 1)
+	if err != nil {
+		return err
+	}
+	client, err := env.config.EtcdClient()
+	if err != nil {
+		return err
+	}
+	return client.DeleteKey(args[0])
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"flag"
+
+	"github.com/rarmstrong73/go-utils/fleet"
+)
+
+func init() {
+	commands = append(commands,
+		command{[]string{"fleet", "units", "list"}, "[-host host]", fleetUnitsList},
+		command{[]string{"fleet", "units", "get"}, "[-host host] <unit>", fleetUnitsGet},
+		command{[]string{"fleet", "states", "list"}, "[-host host] [-machine id]", fleetStatesList},
+		command{[]string{"fleet", "machines", "list"}, "[-host host]", fleetMachinesList},
+	)
+}
+
+// fleetFlags returns a command's flags with -host defaulting to the configured fleet host
+func fleetFlags(env *environment, name string) (*flag.FlagSet, *string) {
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	host := flags.String("host", env.config.Fleet.Host, "fleet API host")
+	return flags, host
+}
+
+// fleetClient returns a client for the fleet API on host, over the configured scheme
+func fleetClient(env *environment, host string) (*fleet.Client, error) {
+	if err := require(host, "fleet host"); err != nil {
+		return nil, err
+	}
+	config := env.config
+	config.Fleet.Host = host
+	return config.FleetClient()
+}
+
+func fleetUnitsList(env *environment, args []string) error {
+	flags, host := fleetFlags(env, "fleet units list")
+	if _, err := parseFlags(flags, args, 0); err != nil {
+		return err
+	}
+	client, err := fleetClient(env, *host)
+	if err != nil {
+		return err
+	}
+
+	units, err := client.ListUnits()
+	if err != nil {
+		return err
+	}
+	rows := [][]string{}
+	for _, unit := range units {
+		rows = append(rows, []string{unit.Name, unit.DesiredState, unit.CurrentState})
+	}
+	return env.print(units, []string{"UNIT", "DESIRED", "CURRENT"}, rows)
+}
+
+func fleetUnitsGet(env *environment, args []string) error {
+	flags, host := fleetFlags(env, "fleet units get")
+	args, err := parseFlags(flags, args, 1)
+	if err != nil {
+		return err
+	}
+	client, err := fleetClient(env, *host)
+	if err != nil {
+		return err
+	}
+
+	unit, err := client.GetUnit(args[0])
+	if err != nil {
+		return err
+	}
+	rows := [][]string{}
+	for _, option := range unit.Options {
+		rows = append(rows, []string{option.Section, option.Name, option.Value})
+	}
+	return env.print(unit, []string{"SECTION", "NAME", "VALUE"}, rows)
+}
+
+func fleetStatesList(env *environment, args []string) error {
+	flags, host := fleetFlags(env, "fleet states list")
+	machine := flags.String("machine", "", "only list the states of units on this machine ID")
+	if _, err := parseFlags(flags, args, 0); err != nil {
+		return err
+	}
+	client, err := fleetClient(env, *host)
+	if err != nil {
+		return err
+	}
+
+	var states []fleet.UnitState
+	if *machine != "" {
+		states, err = client.GetUnitStatesByMachineID(*machine)
+	} else {
+		states, err = client.ListUnitStates()
+	}
+	if err != nil {
+		return err
+	}
+	rows := [][]string{}
+	for _, state := range states {
+		rows = append(rows, []string{state.Name, state.MachineID, state.SystemdLoadState, state.SystemdActiveState, state.SystemdSubState})
+	}
+	return env.print(states, []string{"UNIT", "MACHINE", "LOAD", "ACTIVE", "SUB"}, rows)
+}
+
+func fleetMachinesList(env *environment, args []string) error {
+	flags, host := fleetFlags(env, "fleet machines list")
+	if _, err := parseFlags(flags, args, 0); err != nil {
+		return err
+	}
+	client, err := fleetClient(env, *host)
+	if err != nil {
+		return err
+	}
+
+	machines, err := client.ListMachines()
+	if err != nil {
+		return err
+	}
+	rows := [][]string{}
+	for _, machine := range machines {
+		rows = append(rows, []string{machine.ID, machine.PrimaryIP, formatMap(machine.Metadata)})
+	}
+	return env.print(machines, []string{"MACHINE", "IP", "METADATA"}, rows)
+}
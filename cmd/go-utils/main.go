@@ -0,0 +1,144 @@
+// Command go-utils runs the library's fleet, docker, etcd and consul operations from the command
+// line, so operators debugging a cluster use the same code paths as the services. Backends are
+// configured like the config package does, from a config file given with -config and from the
+// environment variables of the backends' own tools.
+//
+//	go-utils [-config file] [-o table|json|yaml] <command> [flags] [args]
+//
+// Run go-utils without a command to list the commands.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/rarmstrong73/go-utils/config"
+	"github.com/rarmstrong73/go-utils/internal/marshal"
+)
+
+// command is a subcommand such as `fleet units list`
+type command struct {
+	path  []string
+	usage string
+	run   func(env *environment, args []string) error
+}
+
+var commands []command
+
+// environment is what commands run with
+type environment struct {
+	config config.Config
+	format string
+	out    io.Writer
+}
+
+func main() {
+	flags := flag.NewFlagSet("go-utils", flag.ExitOnError)
+	configPath := flags.String("config", "", "config file, YAML or TOML, see the config package")
+	format := flags.String("o", "table", "output format, table, json or yaml")
+	flags.Usage = func() { usage(flags) }
+	flags.Parse(os.Args[1:])
+
+	if *format != "table" && *format != marshal.JSON && *format != marshal.YAML {
+		fmt.Fprintf(os.Stderr, "Unknown output format %q\n", *format)
+		os.Exit(2)
+	}
+
+	cmd, args, ok := findCommand(flags.Args())
+	if !ok {
+		usage(flags)
+		os.Exit(2)
+	}
+
+	loaded, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	env := &environment{config: loaded, format: *format, out: os.Stdout}
+	if err := cmd.run(env, args); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// findCommand returns the command named by the start of args and the rest of args
+func findCommand(args []string) (command, []string, bool) {
+	for _, cmd := range commands {
+		if len(args) < len(cmd.path) {
+			continue
+		}
+		matches := true
+		for i, word := range cmd.path {
+			matches = matches && args[i] == word
+		}
+		if matches {
+			return cmd, args[len(cmd.path):], true
+		}
+	}
+	return command{}, nil, false
+}
+
+func usage(flags *flag.FlagSet) {
+	fmt.Fprintln(os.Stderr, "Usage: go-utils [-config file] [-o table|json|yaml] <command> [flags] [args]")
+	flags.PrintDefaults()
+	fmt.Fprintln(os.Stderr, "\nCommands:")
+
+	lines := []string{}
+	for _, cmd := range commands {
+		lines = append(lines, fmt.Sprintf("  %s %s", strings.Join(cmd.path, " "), cmd.usage))
+	}
+	sort.Strings(lines)
+	fmt.Fprintln(os.Stderr, strings.Join(lines, "\n"))
+}
+
+// parseFlags parses a command's flags, failing unless exactly count arguments remain, or at
+// least -count when count is negative
+func parseFlags(flags *flag.FlagSet, args []string, count int) ([]string, error) {
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	remaining := flags.Args()
+	if (count >= 0 && len(remaining) != count) || (count < 0 && len(remaining) < -count) {
+		return nil, fmt.Errorf("Wrong number of arguments for %s", flags.Name())
+	}
+	return remaining, nil
+}
+
+// print writes value as JSON or YAML, or as a table of rows under header
+func (env *environment) print(value interface{}, header []string, rows [][]string) error {
+	if env.format != "table" {
+		return marshal.Write(env.out, env.format, value)
+	}
+
+	writer := tabwriter.NewWriter(env.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(writer, strings.Join(row, "\t"))
+	}
+	return writer.Flush()
+}
+
+// require returns an error naming what is missing when value is empty
+func require(value, name string) error {
+	if value == "" {
+		return fmt.Errorf("No %s configured, see go-utils -h", name)
+	}
+	return nil
+}
+
+// formatMap formats a map as sorted key=value pairs
+func formatMap(values map[string]string) string {
+	pairs := []string{}
+	for key, value := range values {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
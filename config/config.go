@@ -0,0 +1,312 @@
+// Package config builds the fleet, docker, etcd and consul clients of a service from the
+// environment variables the backends' own command line tools use and from a config file, so
+// services embedding several clients share one way of setting them up.
+//
+// A config file has a section per backend, written either as YAML or as TOML depending on the
+// file's extension:
+//
+//	fleet:
+//	  host: 10.0.0.1
+//	etcd:
+//	  endpoints: [10.0.0.1:2379, 10.0.0.2:2379]
+//	  v3: true
+//	consul:
+//	  address: 127.0.0.1:8500
+//	  token: secret
+//
+// Only this subset of YAML and TOML is supported: sections of scalar settings and lists of
+// scalars, with # comments. Environment variables override the file.
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	consul "github.com/rarmstrong73/go-utils/consul/health"
+	"github.com/rarmstrong73/go-utils/etcd"
+	"github.com/rarmstrong73/go-utils/fleet"
+)
+
+// Config is the settings of every backend, a backend whose address is empty isn't configured
+type Config struct {
+	Fleet  FleetConfig
+	Docker DockerConfig
+	Etcd   EtcdConfig
+	Consul ConsulConfig
+}
+
+// FleetConfig is the settings of a fleet client
+type FleetConfig struct {
+	// Host is the fleet API's host, with its port when it isn't the default, read from
+	// FLEETCTL_ENDPOINT
+	Host string
+	// Scheme is http or https, from FLEETCTL_ENDPOINT's scheme
+	Scheme string
+}
+
+// DockerConfig is the settings of the docker package
+type DockerConfig struct {
+	// Host is the docker daemon host passed to the docker functions, with its port when it isn't
+	// the default, read from DOCKER_HOST
+	Host string
+}
+
+// EtcdConfig is the settings of an etcd client
+type EtcdConfig struct {
+	// Endpoints are the members' host:ports, read from ETCDCTL_ENDPOINTS
+	Endpoints []string
+	// V3 selects the v3 API, read from ETCDCTL_API=3
+	V3 bool
+	// Namespace keeps every key under a prefix, see etcd.Client's Namespace
+	Namespace string
+}
+
+// ConsulConfig is the settings of a consul client, read from the CONSUL_ environment variables
+type ConsulConfig struct {
+	// Address is the agent's host:port, from CONSUL_HTTP_ADDR
+	Address string
+	// Scheme is http or https, from CONSUL_HTTP_ADDR's scheme or CONSUL_HTTP_SSL
+	Scheme string
+	// Token is the ACL token, from CONSUL_HTTP_TOKEN
+	Token      string
+	Datacenter string
+	// CAFile, CertFile and KeyFile are from CONSUL_CACERT, CONSUL_CLIENT_CERT and CONSUL_CLIENT_KEY
+	CAFile   string
+	CertFile string
+	KeyFile  string
+	// ServerName is from CONSUL_TLS_SERVER_NAME
+	ServerName string
+	// InsecureSkipVerify is from CONSUL_HTTP_SSL_VERIFY=false
+	InsecureSkipVerify bool
+}
+
+// Load reads the config file at path, if path isn't empty, and then applies the environment
+func Load(path string) (Config, error) {
+	var config Config
+	if path != "" {
+		var err error
+		config, err = LoadFile(path)
+		if err != nil {
+			return Config{}, err
+		}
+	}
+	config.ApplyEnv()
+	return config, nil
+}
+
+// LoadFile reads the config file at path, which is parsed as TOML when it ends in .toml and as
+// YAML otherwise
+func LoadFile(path string) (Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+
+	var settings map[string]map[string][]string
+	if filepath.Ext(path) == ".toml" {
+		settings, err = parseTOML(string(data))
+	} else {
+		settings, err = parseYAML(string(data))
+	}
+	if err != nil {
+		return Config{}, fmt.Errorf("Failed to parse %s: %v", path, err)
+	}
+
+	var config Config
+	if err := config.set(settings); err != nil {
+		return Config{}, fmt.Errorf("Failed to load %s: %v", path, err)
+	}
+	return config, nil
+}
+
+// ApplyEnv overrides the config with the environment variables that are set
+func (config *Config) ApplyEnv() {
+	if endpoint := os.Getenv("FLEETCTL_ENDPOINT"); endpoint != "" {
+		// fleetctl takes a list of endpoints, the client talks to the first
+		endpoint = strings.TrimSpace(strings.Split(endpoint, ",")[0])
+		config.Fleet.Host = stripScheme(endpoint)
+		if strings.HasPrefix(endpoint, "https://") {
+			config.Fleet.Scheme = "https"
+		} else if strings.HasPrefix(endpoint, "http://") {
+			config.Fleet.Scheme = "http"
+		}
+	}
+	if host := os.Getenv("DOCKER_HOST"); host != "" {
+		config.Docker.Host = host
+		if !strings.HasPrefix(host, "unix://") {
+			config.Docker.Host = stripScheme(host)
+		}
+	}
+
+	if endpoints := os.Getenv("ETCDCTL_ENDPOINTS"); endpoints != "" {
+		config.Etcd.Endpoints = nil
+		for _, endpoint := range strings.Split(endpoints, ",") {
+			if endpoint = strings.TrimSpace(endpoint); endpoint != "" {
+				config.Etcd.Endpoints = append(config.Etcd.Endpoints, stripScheme(endpoint))
+			}
+		}
+	}
+	if api := os.Getenv("ETCDCTL_API"); api != "" {
+		config.Etcd.V3 = api == "3"
+	}
+
+	if address := os.Getenv("CONSUL_HTTP_ADDR"); address != "" {
+		config.Consul.Address = stripScheme(address)
+		if strings.HasPrefix(address, "https://") {
+			config.Consul.Scheme = "https"
+		} else if strings.HasPrefix(address, "http://") {
+			config.Consul.Scheme = "http"
+		}
+	}
+	if ssl, err := strconv.ParseBool(os.Getenv("CONSUL_HTTP_SSL")); err == nil {
+		config.Consul.Scheme = "http"
+		if ssl {
+			config.Consul.Scheme = "https"
+		}
+	}
+	if verify, err := strconv.ParseBool(os.Getenv("CONSUL_HTTP_SSL_VERIFY")); err == nil {
+		config.Consul.InsecureSkipVerify = !verify
+	}
+	setFromEnv(&config.Consul.Token, "CONSUL_HTTP_TOKEN")
+	setFromEnv(&config.Consul.CAFile, "CONSUL_CACERT")
+	setFromEnv(&config.Consul.CertFile, "CONSUL_CLIENT_CERT")
+	setFromEnv(&config.Consul.KeyFile, "CONSUL_CLIENT_KEY")
+	setFromEnv(&config.Consul.ServerName, "CONSUL_TLS_SERVER_NAME")
+}
+
+// FleetClient returns a client for the configured fleet API
+func (config Config) FleetClient() (*fleet.Client, error) {
+	if config.Fleet.Host == "" {
+		return nil, fmt.Errorf("No fleet host configured")
+	}
+	return fleet.NewClient(fleet.Config{Host: config.Fleet.Host, Scheme: config.Fleet.Scheme}), nil
+}
+
+// DockerHost returns the configured docker daemon's host to pass to the docker functions, which
+// talk to daemons over TCP only
+func (config Config) DockerHost() (string, error) {
+	host := config.Docker.Host
+	if host == "" {
+		return "", fmt.Errorf("No docker host configured")
+	}
+	if strings.HasPrefix(host, "unix://") {
+		return "", fmt.Errorf("Docker host %s is a unix socket, the docker package needs a TCP host", host)
+	}
+	return host, nil
+}
+
+// EtcdClient returns a client for the configured etcd cluster
+func (config Config) EtcdClient() (*etcd.Client, error) {
+	if len(config.Etcd.Endpoints) == 0 {
+		return nil, fmt.Errorf("No etcd endpoints configured")
+	}
+
+	client := etcd.NewClient(config.Etcd.Endpoints...)
+	if config.Etcd.V3 {
+		client = etcd.NewV3Client(config.Etcd.Endpoints...)
+	}
+	if config.Etcd.Namespace != "" {
+		client = client.Namespace(config.Etcd.Namespace)
+	}
+	return client, nil
+}
+
+// ConsulClient returns a client for the configured consul agent, connecting over https when
+// the scheme is https or any TLS file is configured
+func (config Config) ConsulClient() (*consul.Client, error) {
+	settings := config.Consul
+	if settings.Address == "" {
+		return nil, fmt.Errorf("No consul address configured")
+	}
+
+	client := consul.NewClient(consul.Config{
+		Address:    settings.Address,
+		Scheme:     settings.Scheme,
+		Token:      settings.Token,
+		Datacenter: settings.Datacenter,
+	})
+	if settings.Scheme == "https" || settings.CAFile != "" || settings.CertFile != "" || settings.KeyFile != "" {
+		err := client.SetTLSConfig(consul.TLSConfig{
+			CAFile:             settings.CAFile,
+			CertFile:           settings.CertFile,
+			KeyFile:            settings.KeyFile,
+			ServerName:         settings.ServerName,
+			InsecureSkipVerify: settings.InsecureSkipVerify,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+// set applies the settings read from a file
+func (config *Config) set(settings map[string]map[string][]string) error {
+	fields := map[string]map[string]interface{}{
+		"fleet":  {"host": &config.Fleet.Host, "scheme": &config.Fleet.Scheme},
+		"docker": {"host": &config.Docker.Host},
+		"etcd": {
+			"endpoints": &config.Etcd.Endpoints,
+			"v3":        &config.Etcd.V3,
+			"namespace": &config.Etcd.Namespace,
+		},
+		"consul": {
+			"address":              &config.Consul.Address,
+			"scheme":               &config.Consul.Scheme,
+			"token":                &config.Consul.Token,
+			"datacenter":           &config.Consul.Datacenter,
+			"ca_file":              &config.Consul.CAFile,
+			"cert_file":            &config.Consul.CertFile,
+			"key_file":             &config.Consul.KeyFile,
+			"server_name":          &config.Consul.ServerName,
+			"insecure_skip_verify": &config.Consul.InsecureSkipVerify,
+		},
+	}
+
+	for section, values := range settings {
+		for key, value := range values {
+			field, ok := fields[section][key]
+			if !ok {
+				return fmt.Errorf("Unknown setting %s.%s", section, key)
+			}
+
+			switch field := field.(type) {
+			case *[]string:
+				*field = value
+			case *string:
+				if len(value) != 1 {
+					return fmt.Errorf("%s.%s must be a single value", section, key)
+				}
+				*field = value[0]
+			case *bool:
+				if len(value) != 1 {
+					return fmt.Errorf("%s.%s must be a single value", section, key)
+				}
+				parsed, err := strconv.ParseBool(value[0])
+				if err != nil {
+					return fmt.Errorf("%s.%s must be true or false, got %q", section, key, value[0])
+				}
+				*field = parsed
+			}
+		}
+	}
+	return nil
+}
+
+func setFromEnv(field *string, name string) {
+	if value := os.Getenv(name); value != "" {
+		*field = value
+	}
+}
+
+// stripScheme removes a scheme such as http:// or tcp:// from an address
+func stripScheme(address string) string {
+	if i := strings.Index(address, "://"); i >= 0 {
+		address = address[i+3:]
+	}
+	return strings.TrimSuffix(address, "/")
+}
@@ -0,0 +1,55 @@
+package config_test
+
+import (
+	"testing"
+
+	"github.com/rarmstrong73/go-utils/config"
+	"github.com/rarmstrong73/go-utils/fleet"
+	"github.com/rarmstrong73/go-utils/fleet/fleettest"
+)
+
+func TestApplyEnvKeepsPorts(t *testing.T) {
+	t.Setenv("DOCKER_HOST", "tcp://10.0.0.1:2376")
+	t.Setenv("FLEETCTL_ENDPOINT", "https://10.0.0.2:8443,https://10.0.0.3:8443")
+
+	var loaded config.Config
+	loaded.ApplyEnv()
+	if loaded.Docker.Host != "10.0.0.1:2376" {
+		t.Errorf("Docker.Host = %q, want 10.0.0.1:2376", loaded.Docker.Host)
+	}
+	if loaded.Fleet.Host != "10.0.0.2:8443" || loaded.Fleet.Scheme != "https" {
+		t.Errorf("Fleet = %+v, want https 10.0.0.2:8443", loaded.Fleet)
+	}
+	host, err := loaded.DockerHost()
+	if err != nil || host != "10.0.0.1:2376" {
+		t.Errorf("DockerHost = %q, %v", host, err)
+	}
+}
+
+func TestDockerHostRejectsUnixSockets(t *testing.T) {
+	t.Setenv("DOCKER_HOST", "unix:///var/run/docker.sock")
+
+	var loaded config.Config
+	loaded.ApplyEnv()
+	if _, err := loaded.DockerHost(); err == nil {
+		t.Error("DockerHost succeeded for a unix socket")
+	}
+}
+
+func TestFleetClientUsesEndpointPort(t *testing.T) {
+	server := fleettest.NewServer()
+	defer server.Close()
+	server.AddMachine(fleet.Machine{ID: "m1", PrimaryIP: "10.0.0.1"})
+	t.Setenv("FLEETCTL_ENDPOINT", "http://"+server.Host())
+
+	var loaded config.Config
+	loaded.ApplyEnv()
+	client, err := loaded.FleetClient()
+	if err != nil {
+		t.Fatalf("FleetClient: %v", err)
+	}
+	machines, err := client.ListMachines()
+	if err != nil || len(machines) != 1 {
+		t.Errorf("ListMachines = %v, %v", machines, err)
+	}
+}
@@ -0,0 +1,181 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseYAML reads top level keys as sections holding `key: value` settings, where a value is a
+// scalar, a [flow, list] or a block list of `- item` lines
+func parseYAML(data string) (map[string]map[string][]string, error) {
+	settings := map[string]map[string][]string{}
+	section, listKey := "", ""
+
+	for number, line := range strings.Split(data, "\n") {
+		line = stripComment(line)
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || trimmed == "---" {
+			continue
+		}
+		indented := line[0] == ' ' || line[0] == '\t'
+
+		if !indented {
+			if !strings.HasSuffix(trimmed, ":") {
+				return nil, fmt.Errorf("line %d: expected a section such as etcd:", number+1)
+			}
+			section, listKey = strings.TrimSuffix(trimmed, ":"), ""
+			if settings[section] == nil {
+				settings[section] = map[string][]string{}
+			}
+			continue
+		}
+		if section == "" {
+			return nil, fmt.Errorf("line %d: setting outside of a section", number+1)
+		}
+
+		if strings.HasPrefix(trimmed, "- ") || trimmed == "-" {
+			if listKey == "" {
+				return nil, fmt.Errorf("line %d: list item without a key", number+1)
+			}
+			item, err := parseScalar(strings.TrimSpace(strings.TrimPrefix(trimmed, "-")))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", number+1, err)
+			}
+			settings[section][listKey] = append(settings[section][listKey], item)
+			continue
+		}
+
+		parts := strings.SplitN(trimmed, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("line %d: expected key: value", number+1)
+		}
+		key, raw := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if raw == "" {
+			// The value is a block list on the following lines
+			listKey = key
+			settings[section][key] = []string{}
+			continue
+		}
+		listKey = ""
+		value, err := parseValue(raw)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", number+1, err)
+		}
+		settings[section][key] = value
+	}
+	return settings, nil
+}
+
+// parseTOML reads [section] tables holding `key = value` settings, where a value is a scalar or
+// a [list]
+func parseTOML(data string) (map[string]map[string][]string, error) {
+	settings := map[string]map[string][]string{}
+	section := ""
+
+	for number, line := range strings.Split(data, "\n") {
+		trimmed := strings.TrimSpace(stripComment(line))
+		if trimmed == "" {
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, "[") && strings.HasSuffix(trimmed, "]") {
+			section = strings.TrimSpace(trimmed[1 : len(trimmed)-1])
+			if settings[section] == nil {
+				settings[section] = map[string][]string{}
+			}
+			continue
+		}
+		if section == "" {
+			return nil, fmt.Errorf("line %d: setting outside of a section", number+1)
+		}
+
+		parts := strings.SplitN(trimmed, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("line %d: expected key = value", number+1)
+		}
+		value, err := parseValue(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", number+1, err)
+		}
+		settings[section][strings.TrimSpace(parts[0])] = value
+	}
+	return settings, nil
+}
+
+// parseValue parses a scalar or a [list] of scalars
+func parseValue(raw string) ([]string, error) {
+	if !strings.HasPrefix(raw, "[") {
+		value, err := parseScalar(raw)
+		return []string{value}, err
+	}
+	if !strings.HasSuffix(raw, "]") {
+		return nil, fmt.Errorf("unterminated list %s", raw)
+	}
+
+	values := []string{}
+	for _, item := range splitList(raw[1 : len(raw)-1]) {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		value, err := parseScalar(item)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// parseScalar unquotes a quoted string, other scalars are taken as they are
+func parseScalar(raw string) (string, error) {
+	if strings.HasPrefix(raw, "'") {
+		if len(raw) < 2 || !strings.HasSuffix(raw, "'") {
+			return "", fmt.Errorf("unterminated string %s", raw)
+		}
+		return strings.Replace(raw[1:len(raw)-1], "''", "'", -1), nil
+	}
+	if strings.HasPrefix(raw, `"`) {
+		value, err := strconv.Unquote(raw)
+		if err != nil {
+			return "", fmt.Errorf("bad string %s", raw)
+		}
+		return value, nil
+	}
+	return raw, nil
+}
+
+// splitList splits the inside of a list on the commas that aren't inside quotes
+func splitList(raw string) []string {
+	var items []string
+	var quote rune
+	start := 0
+	for i, char := range raw {
+		switch {
+		case quote != 0 && char == quote:
+			quote = 0
+		case quote == 0 && (char == '"' || char == '\''):
+			quote = char
+		case quote == 0 && char == ',':
+			items = append(items, raw[start:i])
+			start = i + 1
+		}
+	}
+	return append(items, raw[start:])
+}
+
+// stripComment removes a # comment that isn't inside quotes
+func stripComment(line string) string {
+	var quote rune
+	for i, char := range line {
+		switch {
+		case quote != 0 && char == quote:
+			quote = 0
+		case quote == 0 && (char == '"' || char == '\''):
+			quote = char
+		case quote == 0 && char == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
@@ -0,0 +1,783 @@
+// Package consultest provides an in-memory fake of a single consul agent behind an httptest
+// server, so code using the consul package can be tested without a real cluster. It supports
+// the KV store including check-and-set and locking, sessions, service and check registration,
+// TTL check updates, the health and catalog endpoints and blocking queries. The fake has a
+// single node named by NodeName in the datacenter named by Datacenter.
+package consultest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	consul "github.com/rarmstrong73/go-utils/consul/health"
+)
+
+var apiVersion = "v1"
+
+// NodeName is the name of the fake agent's node
+var NodeName = "consultest"
+
+// Datacenter is the name of the fake agent's datacenter
+var Datacenter = "dc1"
+
+// maxWait caps how long a blocking query waits, whatever it asks for
+var maxWait = 10 * time.Second
+
+const serfHealth = "serfHealth"
+
+// Server is a fake consul agent
+type Server struct {
+	*httptest.Server
+
+	mutex    sync.Mutex
+	index    uint64
+	kv       map[string]*consul.KVPair
+	services map[string]*consul.AgentService
+	checks   map[string]*consul.HealthNode
+	sessions map[string]*consul.SessionEntry
+	changed  chan struct{}
+}
+
+// NewServer starts a fake consul agent with an empty KV store and no services
+func NewServer() *Server {
+	server := &Server{
+		index:    1,
+		kv:       map[string]*consul.KVPair{},
+		services: map[string]*consul.AgentService{},
+		checks:   map[string]*consul.HealthNode{},
+		sessions: map[string]*consul.SessionEntry{},
+		changed:  make(chan struct{}),
+	}
+	server.checks[serfHealth] = &consul.HealthNode{
+		Node:        NodeName,
+		CheckID:     serfHealth,
+		Name:        "Serf Health Status",
+		Status:      consul.HealthPassing,
+		CreateIndex: 1,
+		ModifyIndex: 1,
+	}
+	server.Server = httptest.NewServer(http.HandlerFunc(server.handle))
+	return server
+}
+
+// Address returns the host:port the server listens on, to be used as consul.Config's Address
+func (server *Server) Address() string {
+	return strings.TrimPrefix(server.URL, "http://")
+}
+
+// Client returns a client for the server
+func (server *Server) Client() *consul.Client {
+	return consul.NewClient(consul.Config{Address: server.Address()})
+}
+
+// Index returns the server's current raft index, which every write increments
+func (server *Server) Index() uint64 {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return server.index
+}
+
+// SetCheckStatus sets the status of a registered check as if the agent had run it, returning
+// false if there is no such check
+func (server *Server) SetCheckStatus(checkID, status, output string) bool {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	check, ok := server.checks[checkID]
+	if !ok {
+		return false
+	}
+	check.Status = status
+	check.Output = output
+	server.commitLocked()
+	check.ModifyIndex = int64(server.index)
+	return true
+}
+
+// commitLocked moves the index on and wakes blocking queries
+func (server *Server) commitLocked() {
+	server.index++
+	close(server.changed)
+	server.changed = make(chan struct{})
+}
+
+func (server *Server) handle(w http.ResponseWriter, r *http.Request) {
+	prefix := "/" + apiVersion
+	if !strings.HasPrefix(r.URL.Path, prefix+"/") {
+		http.NotFound(w, r)
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, prefix)
+	query := r.URL.Query()
+
+	if r.Method == http.MethodGet {
+		server.block(r, query)
+	}
+
+	switch {
+	case strings.HasPrefix(path, "/kv/"):
+		server.handleKV(w, r, strings.TrimPrefix(path, "/kv/"), query)
+	case strings.HasPrefix(path, "/session/"):
+		server.handleSession(w, r, strings.TrimPrefix(path, "/session/"))
+	case strings.HasPrefix(path, "/agent/"):
+		server.handleAgent(w, r, strings.TrimPrefix(path, "/agent/"), query)
+	case strings.HasPrefix(path, "/health/"):
+		server.handleHealth(w, strings.TrimPrefix(path, "/health/"), query)
+	case strings.HasPrefix(path, "/catalog/"):
+		server.handleCatalog(w, strings.TrimPrefix(path, "/catalog/"))
+	case path == "/status/leader":
+		server.write(w, http.StatusOK, server.Address())
+	case path == "/status/peers":
+		server.write(w, http.StatusOK, []string{server.Address()})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// block waits for the index to pass the query's index, if it has one, for its wait time to run
+// out or for the client to give up
+func (server *Server) block(r *http.Request, query url.Values) {
+	waitIndex, err := strconv.ParseUint(query.Get("index"), 10, 64)
+	if err != nil || waitIndex == 0 {
+		return
+	}
+	wait := maxWait
+	if requested, err := time.ParseDuration(query.Get("wait")); err == nil && requested < wait {
+		wait = requested
+	}
+
+	timeout := time.After(wait)
+	for {
+		server.mutex.Lock()
+		index, changed := server.index, server.changed
+		server.mutex.Unlock()
+		if index > waitIndex {
+			return
+		}
+		select {
+		case <-changed:
+		case <-timeout:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// ============================================================================
+// ================================== KV ======================================
+// ============================================================================
+
+func (server *Server) handleKV(w http.ResponseWriter, r *http.Request, key string, query url.Values) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	switch r.Method {
+	case http.MethodGet:
+		server.getKVLocked(w, key, query)
+	case http.MethodPut:
+		value, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			server.writeErrorLocked(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		server.putKVLocked(w, key, value, query)
+	case http.MethodDelete:
+		server.deleteKVLocked(w, key, query)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (server *Server) getKVLocked(w http.ResponseWriter, key string, query url.Values) {
+	_, recurse := query["recurse"]
+	_, keysOnly := query["keys"]
+
+	if !recurse && !keysOnly {
+		pair, ok := server.kv[key]
+		if !ok {
+			server.writeErrorLocked(w, http.StatusNotFound, "")
+			return
+		}
+		server.writeLocked(w, http.StatusOK, []consul.KVPair{*pair})
+		return
+	}
+
+	keys := []string{}
+	for existing := range server.kv {
+		if strings.HasPrefix(existing, key) {
+			keys = append(keys, existing)
+		}
+	}
+	sort.Strings(keys)
+	if len(keys) == 0 {
+		server.writeErrorLocked(w, http.StatusNotFound, "")
+		return
+	}
+
+	if keysOnly {
+		separator := query.Get("separator")
+		names := []string{}
+		seen := map[string]bool{}
+		for _, existing := range keys {
+			name := existing
+			if separator != "" {
+				if i := strings.Index(existing[len(key):], separator); i >= 0 {
+					name = existing[:len(key)+i+len(separator)]
+				}
+			}
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+		server.writeLocked(w, http.StatusOK, names)
+		return
+	}
+
+	pairs := []consul.KVPair{}
+	for _, existing := range keys {
+		pairs = append(pairs, *server.kv[existing])
+	}
+	server.writeLocked(w, http.StatusOK, pairs)
+}
+
+func (server *Server) putKVLocked(w http.ResponseWriter, key string, value []byte, query url.Values) {
+	existing, exists := server.kv[key]
+
+	if cas := query.Get("cas"); cas != "" {
+		index, err := strconv.ParseUint(cas, 10, 64)
+		if err != nil {
+			server.writeErrorLocked(w, http.StatusBadRequest, "Invalid cas index")
+			return
+		}
+		if (index == 0 && exists) || (index != 0 && (!exists || existing.ModifyIndex != index)) {
+			server.writeLocked(w, http.StatusOK, false)
+			return
+		}
+	}
+
+	session := ""
+	if exists {
+		session = existing.Session
+	}
+	if acquire := query.Get("acquire"); acquire != "" {
+		if _, ok := server.sessions[acquire]; !ok {
+			server.writeErrorLocked(w, http.StatusInternalServerError, "invalid session \""+acquire+"\"")
+			return
+		}
+		if session != "" && session != acquire {
+			server.writeLocked(w, http.StatusOK, false)
+			return
+		}
+		session = acquire
+	}
+	if release := query.Get("release"); release != "" {
+		if session != release {
+			server.writeLocked(w, http.StatusOK, false)
+			return
+		}
+		session = ""
+	}
+
+	flags, _ := strconv.ParseUint(query.Get("flags"), 10, 64)
+	server.commitLocked()
+	pair := &consul.KVPair{
+		Key:         key,
+		CreateIndex: server.index,
+		ModifyIndex: server.index,
+		Flags:       flags,
+		Value:       value,
+		Session:     session,
+	}
+	if exists {
+		pair.CreateIndex = existing.CreateIndex
+		pair.LockIndex = existing.LockIndex
+	}
+	if query.Get("acquire") != "" && (!exists || existing.Session == "") {
+		pair.LockIndex++
+	}
+	server.kv[key] = pair
+	server.writeLocked(w, http.StatusOK, true)
+}
+
+func (server *Server) deleteKVLocked(w http.ResponseWriter, key string, query url.Values) {
+	if cas := query.Get("cas"); cas != "" {
+		index, err := strconv.ParseUint(cas, 10, 64)
+		if err != nil {
+			server.writeErrorLocked(w, http.StatusBadRequest, "Invalid cas index")
+			return
+		}
+		existing, exists := server.kv[key]
+		if !exists || existing.ModifyIndex != index {
+			server.writeLocked(w, http.StatusOK, false)
+			return
+		}
+	}
+
+	server.commitLocked()
+	if _, recurse := query["recurse"]; recurse {
+		for existing := range server.kv {
+			if strings.HasPrefix(existing, key) {
+				delete(server.kv, existing)
+			}
+		}
+	} else {
+		delete(server.kv, key)
+	}
+	server.writeLocked(w, http.StatusOK, true)
+}
+
+// ============================================================================
+// =============================== SESSIONS ===================================
+// ============================================================================
+
+func (server *Server) handleSession(w http.ResponseWriter, r *http.Request, path string) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	operation, id := path, ""
+	if i := strings.Index(path, "/"); i >= 0 {
+		operation, id = path[:i], path[i+1:]
+	}
+
+	switch operation {
+	case "create":
+		var session consul.SessionEntry
+		if body, _ := ioutil.ReadAll(r.Body); len(body) > 0 {
+			if err := json.Unmarshal(body, &session); err != nil {
+				server.writeErrorLocked(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+		server.commitLocked()
+		session.ID = newID()
+		if session.Node == "" {
+			session.Node = NodeName
+		}
+		if session.Behavior == "" {
+			session.Behavior = consul.SessionBehaviorRelease
+		}
+		session.CreateIndex = server.index
+		session.ModifyIndex = server.index
+		server.sessions[session.ID] = &session
+		server.writeLocked(w, http.StatusOK, map[string]string{"ID": session.ID})
+	case "destroy":
+		server.destroySessionLocked(id)
+		server.writeLocked(w, http.StatusOK, true)
+	case "renew":
+		session, ok := server.sessions[id]
+		if !ok {
+			server.writeErrorLocked(w, http.StatusNotFound, "Session id '"+id+"' not found")
+			return
+		}
+		server.writeLocked(w, http.StatusOK, []consul.SessionEntry{*session})
+	case "info":
+		sessions := []consul.SessionEntry{}
+		if session, ok := server.sessions[id]; ok {
+			sessions = append(sessions, *session)
+		}
+		server.writeLocked(w, http.StatusOK, sessions)
+	case "list", "node":
+		sessions := []consul.SessionEntry{}
+		for _, session := range server.sessions {
+			if operation == "list" || session.Node == id {
+				sessions = append(sessions, *session)
+			}
+		}
+		sort.Slice(sessions, func(i, j int) bool { return sessions[i].CreateIndex < sessions[j].CreateIndex })
+		server.writeLocked(w, http.StatusOK, sessions)
+	default:
+		server.writeErrorLocked(w, http.StatusNotFound, "")
+	}
+}
+
+// destroySessionLocked invalidates the session, releasing or deleting the keys it holds
+func (server *Server) destroySessionLocked(id string) {
+	session, ok := server.sessions[id]
+	if !ok {
+		return
+	}
+	server.commitLocked()
+	delete(server.sessions, id)
+	for key, pair := range server.kv {
+		if pair.Session != id {
+			continue
+		}
+		if session.Behavior == consul.SessionBehaviorDelete {
+			delete(server.kv, key)
+			continue
+		}
+		pair.Session = ""
+		pair.ModifyIndex = server.index
+	}
+}
+
+// ============================================================================
+// ================================ AGENT =====================================
+// ============================================================================
+
+func (server *Server) handleAgent(w http.ResponseWriter, r *http.Request, path string, query url.Values) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	switch {
+	case path == "services":
+		services := map[string]consul.AgentService{}
+		for id, service := range server.services {
+			services[id] = *service
+		}
+		server.writeLocked(w, http.StatusOK, services)
+	case path == "checks":
+		checks := map[string]consul.HealthNode{}
+		for id, check := range server.checks {
+			if id != serfHealth {
+				checks[id] = *check
+			}
+		}
+		server.writeLocked(w, http.StatusOK, checks)
+	case path == "service/register":
+		var registration consul.AgentServiceRegistration
+		if !server.decodeLocked(w, r, &registration) {
+			return
+		}
+		server.registerServiceLocked(registration)
+		w.WriteHeader(http.StatusOK)
+	case strings.HasPrefix(path, "service/deregister/"):
+		id := strings.TrimPrefix(path, "service/deregister/")
+		if _, ok := server.services[id]; !ok {
+			server.writeErrorLocked(w, http.StatusNotFound, "Unknown service ID \""+id+"\"")
+			return
+		}
+		server.commitLocked()
+		delete(server.services, id)
+		for checkID, check := range server.checks {
+			if check.ServiceID == id {
+				delete(server.checks, checkID)
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+	case path == "check/register":
+		var registration consul.AgentCheckRegistration
+		if !server.decodeLocked(w, r, &registration) {
+			return
+		}
+		id := registration.ID
+		if id == "" {
+			id = registration.CheckID
+		}
+		if id == "" {
+			id = registration.Name
+		}
+		server.commitLocked()
+		server.registerCheckLocked(id, registration.ServiceID, registration.AgentServiceCheck)
+		w.WriteHeader(http.StatusOK)
+	case strings.HasPrefix(path, "check/deregister/"):
+		id := strings.TrimPrefix(path, "check/deregister/")
+		server.commitLocked()
+		delete(server.checks, id)
+		w.WriteHeader(http.StatusOK)
+	case strings.HasPrefix(path, "check/pass/"), strings.HasPrefix(path, "check/warn/"), strings.HasPrefix(path, "check/fail/"):
+		parts := strings.SplitN(path, "/", 3)
+		check, ok := server.checks[parts[2]]
+		if !ok {
+			server.writeErrorLocked(w, http.StatusNotFound, "Unknown check ID \""+parts[2]+"\"")
+			return
+		}
+		status := map[string]string{"pass": consul.HealthPassing, "warn": consul.HealthWarning, "fail": consul.HealthCritical}[parts[1]]
+		server.commitLocked()
+		check.Status = status
+		check.Output = query.Get("note")
+		check.ModifyIndex = int64(server.index)
+		w.WriteHeader(http.StatusOK)
+	default:
+		server.writeErrorLocked(w, http.StatusNotFound, "")
+	}
+}
+
+func (server *Server) registerServiceLocked(registration consul.AgentServiceRegistration) {
+	server.commitLocked()
+	id := registration.ID
+	if id == "" {
+		id = registration.Name
+	}
+
+	service := &consul.AgentService{
+		ID:                id,
+		Service:           registration.Name,
+		Tags:              registration.Tags,
+		Meta:              registration.Meta,
+		Address:           registration.Address,
+		Port:              registration.Port,
+		EnableTagOverride: registration.EnableTagOverride,
+		CreateIndex:       server.index,
+		ModifyIndex:       server.index,
+	}
+	if existing, ok := server.services[id]; ok {
+		service.CreateIndex = existing.CreateIndex
+	}
+	server.services[id] = service
+
+	checks := registration.Checks
+	if registration.Check != nil {
+		checks = append([]consul.AgentServiceCheck{*registration.Check}, checks...)
+	}
+	for i, check := range checks {
+		checkID := check.CheckID
+		if checkID == "" {
+			checkID = "service:" + id
+			if len(checks) > 1 {
+				checkID += ":" + strconv.Itoa(i+1)
+			}
+		}
+		server.registerCheckLocked(checkID, id, check)
+	}
+}
+
+// registerCheckLocked adds a check, which starts out critical unless it asks for another status
+func (server *Server) registerCheckLocked(id, serviceID string, definition consul.AgentServiceCheck) {
+	check := &consul.HealthNode{
+		Node:        NodeName,
+		CheckID:     id,
+		Name:        definition.Name,
+		Status:      definition.Status,
+		Notes:       definition.Notes,
+		ServiceID:   serviceID,
+		CreateIndex: int64(server.index),
+		ModifyIndex: int64(server.index),
+	}
+	if check.Name == "" {
+		check.Name = id
+	}
+	if check.Status == "" {
+		check.Status = consul.HealthCritical
+	}
+	if service, ok := server.services[serviceID]; ok {
+		check.ServiceName = service.Service
+		check.ServiceTags = service.Tags
+	}
+	server.checks[id] = check
+}
+
+// ============================================================================
+// ================================ HEALTH ====================================
+// ============================================================================
+
+func (server *Server) handleHealth(w http.ResponseWriter, path string, query url.Values) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	switch {
+	case strings.HasPrefix(path, "service/"):
+		name := strings.TrimPrefix(path, "service/")
+		_, passingOnly := query["passing"]
+		entries := []consul.ServiceEntry{}
+		for _, service := range server.sortedServicesLocked() {
+			if service.Service != name || !hasTags(service.Tags, query["tag"]) {
+				continue
+			}
+			entry := consul.ServiceEntry{Node: server.nodeLocked(), Service: *service, Checks: []consul.HealthNode{}}
+			passing := true
+			for _, check := range server.sortedChecksLocked() {
+				if check.ServiceID == "" || check.ServiceID == service.ID {
+					entry.Checks = append(entry.Checks, *check)
+					passing = passing && check.Status == consul.HealthPassing
+				}
+			}
+			if passing || !passingOnly {
+				entries = append(entries, entry)
+			}
+		}
+		server.writeLocked(w, http.StatusOK, entries)
+	case strings.HasPrefix(path, "checks/"):
+		name := strings.TrimPrefix(path, "checks/")
+		server.writeChecksLocked(w, func(check *consul.HealthNode) bool { return check.ServiceName == name })
+	case strings.HasPrefix(path, "node/"):
+		node := strings.TrimPrefix(path, "node/")
+		server.writeChecksLocked(w, func(check *consul.HealthNode) bool { return check.Node == node })
+	case strings.HasPrefix(path, "state/"):
+		state := strings.TrimPrefix(path, "state/")
+		server.writeChecksLocked(w, func(check *consul.HealthNode) bool {
+			return state == consul.HealthAny || check.Status == state
+		})
+	default:
+		server.writeErrorLocked(w, http.StatusNotFound, "")
+	}
+}
+
+func (server *Server) writeChecksLocked(w http.ResponseWriter, matches func(check *consul.HealthNode) bool) {
+	checks := []consul.HealthNode{}
+	for _, check := range server.sortedChecksLocked() {
+		if matches(check) {
+			checks = append(checks, *check)
+		}
+	}
+	server.writeLocked(w, http.StatusOK, checks)
+}
+
+// ============================================================================
+// ================================ CATALOG ===================================
+// ============================================================================
+
+func (server *Server) handleCatalog(w http.ResponseWriter, path string) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	switch {
+	case path == "datacenters":
+		server.writeLocked(w, http.StatusOK, []string{Datacenter})
+	case path == "nodes":
+		server.writeLocked(w, http.StatusOK, []consul.Node{server.nodeLocked()})
+	case strings.HasPrefix(path, "node/"):
+		if strings.TrimPrefix(path, "node/") != NodeName {
+			server.writeLocked(w, http.StatusOK, nil)
+			return
+		}
+		services := map[string]consul.AgentService{}
+		for id, service := range server.services {
+			services[id] = *service
+		}
+		server.writeLocked(w, http.StatusOK, consul.CatalogNode{Node: server.nodeLocked(), Services: services})
+	case path == "services":
+		services := map[string][]string{}
+		for _, service := range server.services {
+			tags := services[service.Service]
+			if tags == nil {
+				tags = []string{}
+			}
+			for _, tag := range service.Tags {
+				if !hasTags(tags, []string{tag}) {
+					tags = append(tags, tag)
+				}
+			}
+			services[service.Service] = tags
+		}
+		server.writeLocked(w, http.StatusOK, services)
+	case strings.HasPrefix(path, "service/"):
+		name := strings.TrimPrefix(path, "service/")
+		node := server.nodeLocked()
+		services := []consul.CatalogService{}
+		for _, service := range server.sortedServicesLocked() {
+			if service.Service != name {
+				continue
+			}
+			services = append(services, consul.CatalogService{
+				Node:           node.Node,
+				Address:        node.Address,
+				Datacenter:     node.Datacenter,
+				ServiceID:      service.ID,
+				ServiceName:    service.Service,
+				ServiceAddress: service.Address,
+				ServiceTags:    service.Tags,
+				ServiceMeta:    service.Meta,
+				ServicePort:    service.Port,
+				CreateIndex:    service.CreateIndex,
+				ModifyIndex:    service.ModifyIndex,
+			})
+		}
+		server.writeLocked(w, http.StatusOK, services)
+	default:
+		server.writeErrorLocked(w, http.StatusNotFound, "")
+	}
+}
+
+func (server *Server) nodeLocked() consul.Node {
+	return consul.Node{
+		Node:            NodeName,
+		Address:         "127.0.0.1",
+		Datacenter:      Datacenter,
+		TaggedAddresses: map[string]string{"lan": "127.0.0.1", "wan": "127.0.0.1"},
+		Meta:            map[string]string{},
+		CreateIndex:     1,
+		ModifyIndex:     1,
+	}
+}
+
+func (server *Server) sortedServicesLocked() []*consul.AgentService {
+	services := make([]*consul.AgentService, 0, len(server.services))
+	for _, service := range server.services {
+		services = append(services, service)
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].ID < services[j].ID })
+	return services
+}
+
+func (server *Server) sortedChecksLocked() []*consul.HealthNode {
+	checks := make([]*consul.HealthNode, 0, len(server.checks))
+	for _, check := range server.checks {
+		checks = append(checks, check)
+	}
+	sort.Slice(checks, func(i, j int) bool { return checks[i].CheckID < checks[j].CheckID })
+	return checks
+}
+
+func hasTags(tags, wanted []string) bool {
+	for _, tag := range wanted {
+		found := false
+		for _, existing := range tags {
+			found = found || existing == tag
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func newID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	encoded := hex.EncodeToString(id)
+	return encoded[0:8] + "-" + encoded[8:12] + "-" + encoded[12:16] + "-" + encoded[16:20] + "-" + encoded[20:]
+}
+
+// ============================================================================
+// ============================= HTTP UTILS ===================================
+// ============================================================================
+
+func (server *Server) decodeLocked(w http.ResponseWriter, r *http.Request, value interface{}) bool {
+	body, err := ioutil.ReadAll(r.Body)
+	if err == nil {
+		err = json.Unmarshal(body, value)
+	}
+	if err != nil {
+		server.writeErrorLocked(w, http.StatusBadRequest, "Request decode failed: "+err.Error())
+		return false
+	}
+	return true
+}
+
+func (server *Server) write(w http.ResponseWriter, status int, value interface{}) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.writeLocked(w, status, value)
+}
+
+func (server *Server) writeLocked(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	server.writeHeadersLocked(w)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func (server *Server) writeErrorLocked(w http.ResponseWriter, status int, message string) {
+	server.writeHeadersLocked(w)
+	w.WriteHeader(status)
+	w.Write([]byte(message))
+}
+
+func (server *Server) writeHeadersLocked(w http.ResponseWriter) {
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(server.index, 10))
+	w.Header().Set("X-Consul-KnownLeader", "true")
+	w.Header().Set("X-Consul-LastContact", "0")
+}
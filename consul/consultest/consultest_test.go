@@ -0,0 +1,139 @@
+package consultest_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rarmstrong73/go-utils/consul/consultest"
+	consul "github.com/rarmstrong73/go-utils/consul/health"
+)
+
+func TestBlockingQueries(t *testing.T) {
+	agent := consultest.NewServer()
+	defer agent.Close()
+	client := agent.Client()
+	if err := client.KVPut(consul.KVPair{Key: "a", Value: []byte("1")}); err != nil {
+		t.Fatalf("KVPut: %v", err)
+	}
+	_, meta, err := client.KVGet("a", nil)
+	if err != nil || meta.LastIndex != agent.Index() {
+		t.Fatalf("KVGet = %+v, %v, want the index %d", meta, err, agent.Index())
+	}
+
+	time.AfterFunc(50*time.Millisecond, func() {
+		client.KVPut(consul.KVPair{Key: "a", Value: []byte("2")})
+	})
+	started := time.Now()
+	pair, next, err := client.KVGet("a", &consul.QueryOptions{WaitIndex: meta.LastIndex, WaitTime: 5 * time.Second})
+	if err != nil {
+		t.Fatalf("KVGet: %v", err)
+	}
+	if string(pair.Value) != "2" || next.LastIndex <= meta.LastIndex {
+		t.Errorf("KVGet = %s at %d, want 2 after %d", pair.Value, next.LastIndex, meta.LastIndex)
+	}
+	if elapsed := time.Since(started); elapsed < 40*time.Millisecond || elapsed > 4*time.Second {
+		t.Errorf("the query returned after %v, want it blocked until the write", elapsed)
+	}
+
+	started = time.Now()
+	if _, _, err := client.KVGet("a", &consul.QueryOptions{WaitIndex: next.LastIndex, WaitTime: 100 * time.Millisecond}); err != nil {
+		t.Fatalf("KVGet: %v", err)
+	}
+	if elapsed := time.Since(started); elapsed < 90*time.Millisecond {
+		t.Errorf("the query returned after %v, want it to wait out its wait time", elapsed)
+	}
+}
+
+func TestCheckAndSet(t *testing.T) {
+	agent := consultest.NewServer()
+	defer agent.Close()
+	client := agent.Client()
+
+	if set, err := client.KVCAS(consul.KVPair{Key: "a", Value: []byte("1")}); err != nil || !set {
+		t.Fatalf("KVCAS creating a = %t, %v", set, err)
+	}
+	if set, err := client.KVCAS(consul.KVPair{Key: "a", Value: []byte("2")}); err != nil || set {
+		t.Errorf("KVCAS creating a again = %t, %v, want false", set, err)
+	}
+	pair, _, err := client.KVGet("a", nil)
+	if err != nil {
+		t.Fatalf("KVGet: %v", err)
+	}
+	if set, err := client.KVCAS(consul.KVPair{Key: "a", Value: []byte("2"), ModifyIndex: pair.ModifyIndex - 1}); err != nil || set {
+		t.Errorf("KVCAS at a stale index = %t, %v, want false", set, err)
+	}
+	if set, err := client.KVCAS(consul.KVPair{Key: "a", Value: []byte("2"), ModifyIndex: pair.ModifyIndex}); err != nil || !set {
+		t.Errorf("KVCAS at the current index = %t, %v, want true", set, err)
+	}
+}
+
+func TestSessionsHoldLocks(t *testing.T) {
+	agent := consultest.NewServer()
+	defer agent.Close()
+	client := agent.Client()
+	first, err := client.SessionCreate(consul.SessionEntry{Name: "first"})
+	if err != nil {
+		t.Fatalf("SessionCreate: %v", err)
+	}
+	second, err := client.SessionCreate(consul.SessionEntry{Name: "second"})
+	if err != nil {
+		t.Fatalf("SessionCreate: %v", err)
+	}
+
+	if acquired, err := client.KVAcquire(consul.KVPair{Key: "leader", Session: first}); err != nil || !acquired {
+		t.Fatalf("KVAcquire(first) = %t, %v", acquired, err)
+	}
+	if acquired, err := client.KVAcquire(consul.KVPair{Key: "leader", Session: second}); err != nil || acquired {
+		t.Errorf("KVAcquire(second) = %t, %v, want false while first holds the key", acquired, err)
+	}
+
+	if err := client.SessionDestroy(first); err != nil {
+		t.Fatalf("SessionDestroy: %v", err)
+	}
+	pair, _, err := client.KVGet("leader", nil)
+	if err != nil || pair.Session != "" {
+		t.Errorf("KVGet = %+v, %v, want the key released with its session", pair, err)
+	}
+	if _, err := client.SessionRenew(first); !errors.Is(err, consul.ErrSessionNotFound) {
+		t.Errorf("SessionRenew = %v, want %v", err, consul.ErrSessionNotFound)
+	}
+	if acquired, err := client.KVAcquire(consul.KVPair{Key: "leader", Session: second}); err != nil || !acquired {
+		t.Errorf("KVAcquire(second) = %t, %v, want true once released", acquired, err)
+	}
+}
+
+func TestTTLChecks(t *testing.T) {
+	agent := consultest.NewServer()
+	defer agent.Close()
+	client := agent.Client()
+	err := client.AgentRegisterService(consul.AgentServiceRegistration{
+		ID:    "web-1",
+		Name:  "web",
+		Check: &consul.AgentServiceCheck{TTL: "10s"},
+	})
+	if err != nil {
+		t.Fatalf("AgentRegisterService: %v", err)
+	}
+
+	passing := func() int {
+		entries, _, err := client.HealthService("web", "", true, nil)
+		if err != nil {
+			t.Fatalf("HealthService: %v", err)
+		}
+		return len(entries)
+	}
+	if n := passing(); n != 0 {
+		t.Errorf("%d passing instances, want none before the check passes", n)
+	}
+	if err := client.PassTTL("service:web-1", "ok"); err != nil {
+		t.Fatalf("PassTTL: %v", err)
+	}
+	if n := passing(); n != 1 {
+		t.Errorf("%d passing instances, want web-1", n)
+	}
+	agent.SetCheckStatus("service:web-1", consul.HealthCritical, "timed out")
+	if n := passing(); n != 0 {
+		t.Errorf("%d passing instances, want none once the check is critical", n)
+	}
+}
@@ -0,0 +1,241 @@
+package consul
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+)
+
+// AgentServiceRegistration describes a service to register with the local agent
+type AgentServiceRegistration struct {
+	ID                string              `json:"ID,omitempty"`
+	Name              string              `json:"Name"`
+	Tags              []string            `json:"Tags,omitempty"`
+	Meta              map[string]string   `json:"Meta,omitempty"`
+	Address           string              `json:"Address,omitempty"`
+	Port              int                 `json:"Port,omitempty"`
+	EnableTagOverride bool                `json:"EnableTagOverride,omitempty"`
+	Check             *AgentServiceCheck  `json:"Check,omitempty"`
+	Checks            []AgentServiceCheck `json:"Checks,omitempty"`
+}
+
+// AgentServiceCheck describes a check. Exactly one of HTTP, TCP, TTL or Args should be set;
+// HTTP, TCP and Args checks also need an Interval. Durations are strings such as "10s".
+type AgentServiceCheck struct {
+	CheckID  string              `json:"CheckID,omitempty"`
+	Name     string              `json:"Name,omitempty"`
+	Notes    string              `json:"Notes,omitempty"`
+	Status   string              `json:"Status,omitempty"`
+	Interval string              `json:"Interval,omitempty"`
+	Timeout  string              `json:"Timeout,omitempty"`
+	TTL      string              `json:"TTL,omitempty"`
+	HTTP     string              `json:"HTTP,omitempty"`
+	Method   string              `json:"Method,omitempty"`
+	Header   map[string][]string `json:"Header,omitempty"`
+	TCP      string              `json:"TCP,omitempty"`
+	// Args is the command and arguments of a script check
+	Args []string `json:"Args,omitempty"`
+	// DeregisterCriticalServiceAfter deregisters the service once the check has been critical this long
+	DeregisterCriticalServiceAfter string `json:"DeregisterCriticalServiceAfter,omitempty"`
+}
+
+// AgentRegisterService registers the service and its checks with the local agent, replacing any
+// existing registration with the same ID
+func (client *Client) AgentRegisterService(registration AgentServiceRegistration) error {
+	return client.AgentRegisterServiceContext(client.context(), registration)
+}
+
+// AgentRegisterServiceContext is AgentRegisterService with ctx cancelling its requests
+func (client *Client) AgentRegisterServiceContext(ctx context.Context, registration AgentServiceRegistration) error {
+	return client.putJSON(ctx, "/agent/service/register", nil, registration)
+}
+
+// AgentDeregisterService removes the service and its checks from the local agent
+func (client *Client) AgentDeregisterService(id string) error {
+	return client.AgentDeregisterServiceContext(client.context(), id)
+}
+
+// AgentDeregisterServiceContext is AgentDeregisterService with ctx cancelling its requests
+func (client *Client) AgentDeregisterServiceContext(ctx context.Context, id string) error {
+	return client.putJSON(ctx, "/agent/service/deregister/"+url.PathEscape(id), nil, nil)
+}
+
+// AgentServices returns the services registered with the local agent, keyed by ID
+func (client *Client) AgentServices() (map[string]AgentService, error) {
+	return client.AgentServicesContext(client.context())
+}
+
+// AgentServicesContext is AgentServices with ctx cancelling its requests
+func (client *Client) AgentServicesContext(ctx context.Context) (map[string]AgentService, error) {
+	services := map[string]AgentService{}
+	err := client.getJSON(ctx, "/agent/services", nil, &services)
+	return services, err
+}
+
+// AgentCheckRegistration describes a standalone check to register with the local agent,
+// optionally attached to one of its services
+type AgentCheckRegistration struct {
+	ID        string `json:"ID,omitempty"`
+	ServiceID string `json:"ServiceID,omitempty"`
+	AgentServiceCheck
+}
+
+// AgentRegisterCheck registers the check with the local agent
+func (client *Client) AgentRegisterCheck(registration AgentCheckRegistration) error {
+	return client.AgentRegisterCheckContext(client.context(), registration)
+}
+
+// AgentRegisterCheckContext is AgentRegisterCheck with ctx cancelling its requests
+func (client *Client) AgentRegisterCheckContext(ctx context.Context, registration AgentCheckRegistration) error {
+	return client.putJSON(ctx, "/agent/check/register", nil, registration)
+}
+
+// AgentDeregisterCheck removes the check from the local agent
+func (client *Client) AgentDeregisterCheck(id string) error {
+	return client.AgentDeregisterCheckContext(client.context(), id)
+}
+
+// AgentDeregisterCheckContext is AgentDeregisterCheck with ctx cancelling its requests
+func (client *Client) AgentDeregisterCheckContext(ctx context.Context, id string) error {
+	return client.putJSON(ctx, "/agent/check/deregister/"+url.PathEscape(id), nil, nil)
+}
+
+// AgentChecks returns the checks registered with the local agent, keyed by ID
+func (client *Client) AgentChecks() (map[string]HealthNode, error) {
+	return client.AgentChecksContext(client.context())
+}
+
+// AgentChecksContext is AgentChecks with ctx cancelling its requests
+func (client *Client) AgentChecksContext(ctx context.Context) (map[string]HealthNode, error) {
+	checks := map[string]HealthNode{}
+	err := client.getJSON(ctx, "/agent/checks", nil, &checks)
+	return checks, err
+}
+
+// PassTTL marks the TTL check as passing and resets its TTL, note is stored as the check's output
+func (client *Client) PassTTL(checkID, note string) error {
+	return client.PassTTLContext(client.context(), checkID, note)
+}
+
+// PassTTLContext is PassTTL with ctx cancelling its requests
+func (client *Client) PassTTLContext(ctx context.Context, checkID, note string) error {
+	return client.updateTTL(ctx, "pass", checkID, note)
+}
+
+// WarnTTL marks the TTL check as warning and resets its TTL, note is stored as the check's output
+func (client *Client) WarnTTL(checkID, note string) error {
+	return client.WarnTTLContext(client.context(), checkID, note)
+}
+
+// WarnTTLContext is WarnTTL with ctx cancelling its requests
+func (client *Client) WarnTTLContext(ctx context.Context, checkID, note string) error {
+	return client.updateTTL(ctx, "warn", checkID, note)
+}
+
+// FailTTL marks the TTL check as critical and resets its TTL, note is stored as the check's output
+func (client *Client) FailTTL(checkID, note string) error {
+	return client.FailTTLContext(client.context(), checkID, note)
+}
+
+// FailTTLContext is FailTTL with ctx cancelling its requests
+func (client *Client) FailTTLContext(ctx context.Context, checkID, note string) error {
+	return client.updateTTL(ctx, "fail", checkID, note)
+}
+
+func (client *Client) updateTTL(ctx context.Context, status, checkID, note string) error {
+	query := url.Values{}
+	if note != "" {
+		query.Set("note", note)
+	}
+	return client.putJSON(ctx, "/agent/check/"+status+"/"+url.PathEscape(checkID), query, nil)
+}
+
+// EnableNodeMaintenance puts the agent's node into maintenance mode, marking it critical so that
+// its services are left out of queries. reason is shown on the maintenance check.
+func (client *Client) EnableNodeMaintenance(reason string) error {
+	return client.EnableNodeMaintenanceContext(client.context(), reason)
+}
+
+// EnableNodeMaintenanceContext is EnableNodeMaintenance with ctx cancelling its requests
+func (client *Client) EnableNodeMaintenanceContext(ctx context.Context, reason string) error {
+	return client.putJSON(ctx, "/agent/maintenance", maintenanceQuery(true, reason), nil)
+}
+
+// DisableNodeMaintenance takes the agent's node out of maintenance mode
+func (client *Client) DisableNodeMaintenance() error {
+	return client.DisableNodeMaintenanceContext(client.context())
+}
+
+// DisableNodeMaintenanceContext is DisableNodeMaintenance with ctx cancelling its requests
+func (client *Client) DisableNodeMaintenanceContext(ctx context.Context) error {
+	return client.putJSON(ctx, "/agent/maintenance", maintenanceQuery(false, ""), nil)
+}
+
+// EnableServiceMaintenance puts the service into maintenance mode, see EnableNodeMaintenance
+func (client *Client) EnableServiceMaintenance(serviceID, reason string) error {
+	return client.EnableServiceMaintenanceContext(client.context(), serviceID, reason)
+}
+
+// EnableServiceMaintenanceContext is EnableServiceMaintenance with ctx cancelling its requests
+func (client *Client) EnableServiceMaintenanceContext(ctx context.Context, serviceID, reason string) error {
+	return client.putJSON(ctx, "/agent/service/maintenance/"+url.PathEscape(serviceID), maintenanceQuery(true, reason), nil)
+}
+
+// DisableServiceMaintenance takes the service out of maintenance mode
+func (client *Client) DisableServiceMaintenance(serviceID string) error {
+	return client.DisableServiceMaintenanceContext(client.context(), serviceID)
+}
+
+// DisableServiceMaintenanceContext is DisableServiceMaintenance with ctx cancelling its requests
+func (client *Client) DisableServiceMaintenanceContext(ctx context.Context, serviceID string) error {
+	return client.putJSON(ctx, "/agent/service/maintenance/"+url.PathEscape(serviceID), maintenanceQuery(false, ""), nil)
+}
+
+func maintenanceQuery(enable bool, reason string) url.Values {
+	query := url.Values{"enable": {strconv.FormatBool(enable)}}
+	if reason != "" {
+		query.Set("reason", reason)
+	}
+	return query
+}
+
+// Gossip member statuses
+const (
+	MemberNone    = 0
+	MemberAlive   = 1
+	MemberLeaving = 2
+	MemberLeft    = 3
+	MemberFailed  = 4
+)
+
+// AgentMember is a member of the gossip pool as seen by the local agent
+type AgentMember struct {
+	Name        string            `json:"Name"`
+	Addr        string            `json:"Addr"`
+	Port        uint16            `json:"Port"`
+	Tags        map[string]string `json:"Tags"`
+	Status      int               `json:"Status"`
+	ProtocolMin uint8             `json:"ProtocolMin"`
+	ProtocolMax uint8             `json:"ProtocolMax"`
+	ProtocolCur uint8             `json:"ProtocolCur"`
+	DelegateMin uint8             `json:"DelegateMin"`
+	DelegateMax uint8             `json:"DelegateMax"`
+	DelegateCur uint8             `json:"DelegateCur"`
+}
+
+// AgentMembers returns the members of the LAN gossip pool, or of the WAN pool of servers if wan is set
+func (client *Client) AgentMembers(wan bool) ([]AgentMember, error) {
+	return client.AgentMembersContext(client.context(), wan)
+}
+
+// AgentMembersContext is AgentMembers with ctx cancelling its requests
+func (client *Client) AgentMembersContext(ctx context.Context, wan bool) ([]AgentMember, error) {
+	query := url.Values{}
+	if wan {
+		query.Set("wan", "1")
+	}
+
+	members := []AgentMember{}
+	err := client.getJSON(ctx, "/agent/members", query, &members)
+	return members, err
+}
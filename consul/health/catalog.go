@@ -0,0 +1,126 @@
+package consul
+
+import (
+	"context"
+	"errors"
+	"net/url"
+)
+
+// ErrNodeNotFound is returned when looking up a node that isn't registered
+var ErrNodeNotFound = errors.New("Node not found")
+
+// Node is a node registered in the catalog
+type Node struct {
+	ID              string            `json:"ID"`
+	Node            string            `json:"Node"`
+	Address         string            `json:"Address"`
+	Datacenter      string            `json:"Datacenter"`
+	TaggedAddresses map[string]string `json:"TaggedAddresses"`
+	Meta            map[string]string `json:"Meta"`
+	CreateIndex     uint64            `json:"CreateIndex"`
+	ModifyIndex     uint64            `json:"ModifyIndex"`
+}
+
+// CatalogService is an instance of a service along with the node it is registered on
+type CatalogService struct {
+	ID                       string            `json:"ID"`
+	Node                     string            `json:"Node"`
+	Address                  string            `json:"Address"`
+	Datacenter               string            `json:"Datacenter"`
+	TaggedAddresses          map[string]string `json:"TaggedAddresses"`
+	NodeMeta                 map[string]string `json:"NodeMeta"`
+	ServiceID                string            `json:"ServiceID"`
+	ServiceName              string            `json:"ServiceName"`
+	ServiceAddress           string            `json:"ServiceAddress"`
+	ServiceTags              []string          `json:"ServiceTags"`
+	ServiceMeta              map[string]string `json:"ServiceMeta"`
+	ServicePort              int               `json:"ServicePort"`
+	ServiceEnableTagOverride bool              `json:"ServiceEnableTagOverride"`
+	CreateIndex              uint64            `json:"CreateIndex"`
+	ModifyIndex              uint64            `json:"ModifyIndex"`
+}
+
+// AgentService is a service registered on a node
+type AgentService struct {
+	ID                string            `json:"ID"`
+	Service           string            `json:"Service"`
+	Tags              []string          `json:"Tags"`
+	Meta              map[string]string `json:"Meta"`
+	Address           string            `json:"Address"`
+	Port              int               `json:"Port"`
+	EnableTagOverride bool              `json:"EnableTagOverride"`
+	CreateIndex       uint64            `json:"CreateIndex"`
+	ModifyIndex       uint64            `json:"ModifyIndex"`
+}
+
+// CatalogNode is a node along with the services registered on it
+type CatalogNode struct {
+	Node     Node                    `json:"Node"`
+	Services map[string]AgentService `json:"Services"`
+}
+
+// CatalogServices returns the name of every service in the datacenter along with its tags
+func (client *Client) CatalogServices(options *QueryOptions) (map[string][]string, QueryMeta, error) {
+	return client.CatalogServicesContext(client.context(), options)
+}
+
+// CatalogServicesContext is CatalogServices with ctx cancelling its requests
+func (client *Client) CatalogServicesContext(ctx context.Context, options *QueryOptions) (map[string][]string, QueryMeta, error) {
+	services := map[string][]string{}
+	meta, err := client.query(ctx, "/catalog/services", nil, options, &services)
+	return services, meta, err
+}
+
+// CatalogService returns every instance of the named service
+func (client *Client) CatalogService(name string, options *QueryOptions) ([]CatalogService, QueryMeta, error) {
+	return client.CatalogServiceContext(client.context(), name, options)
+}
+
+// CatalogServiceContext is CatalogService with ctx cancelling its requests
+func (client *Client) CatalogServiceContext(ctx context.Context, name string, options *QueryOptions) ([]CatalogService, QueryMeta, error) {
+	services := []CatalogService{}
+	meta, err := client.query(ctx, "/catalog/service/"+url.PathEscape(name), nil, options, &services)
+	return services, meta, err
+}
+
+// CatalogNodes returns every node in the datacenter
+func (client *Client) CatalogNodes(options *QueryOptions) ([]Node, QueryMeta, error) {
+	return client.CatalogNodesContext(client.context(), options)
+}
+
+// CatalogNodesContext is CatalogNodes with ctx cancelling its requests
+func (client *Client) CatalogNodesContext(ctx context.Context, options *QueryOptions) ([]Node, QueryMeta, error) {
+	nodes := []Node{}
+	meta, err := client.query(ctx, "/catalog/nodes", nil, options, &nodes)
+	return nodes, meta, err
+}
+
+// CatalogNode returns the named node and its services, ErrNodeNotFound is returned if it isn't registered
+func (client *Client) CatalogNode(name string, options *QueryOptions) (CatalogNode, QueryMeta, error) {
+	return client.CatalogNodeContext(client.context(), name, options)
+}
+
+// CatalogNodeContext is CatalogNode with ctx cancelling its requests
+func (client *Client) CatalogNodeContext(ctx context.Context, name string, options *QueryOptions) (CatalogNode, QueryMeta, error) {
+	var node *CatalogNode
+	meta, err := client.query(ctx, "/catalog/node/"+url.PathEscape(name), nil, options, &node)
+	if err != nil {
+		return CatalogNode{}, meta, err
+	}
+	if node == nil {
+		return CatalogNode{}, meta, ErrNodeNotFound
+	}
+	return *node, meta, nil
+}
+
+// Datacenters returns every known datacenter
+func (client *Client) Datacenters() ([]string, error) {
+	return client.DatacentersContext(client.context())
+}
+
+// DatacentersContext is Datacenters with ctx cancelling its requests
+func (client *Client) DatacentersContext(ctx context.Context) ([]string, error) {
+	datacenters := []string{}
+	err := client.getJSON(ctx, "/catalog/datacenters", nil, &datacenters)
+	return datacenters, err
+}
@@ -0,0 +1,431 @@
+package consul
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rarmstrong73/go-utils/apierror"
+	"github.com/rarmstrong73/go-utils/breaker"
+	"github.com/rarmstrong73/go-utils/cache"
+	"github.com/rarmstrong73/go-utils/internal/httpclient"
+	"github.com/rarmstrong73/go-utils/logging"
+	"github.com/rarmstrong73/go-utils/proxy"
+	"github.com/rarmstrong73/go-utils/ratelimit"
+	"github.com/rarmstrong73/go-utils/retry"
+	"github.com/rarmstrong73/go-utils/timeouts"
+)
+
+var httpsPort = 8501
+
+// DefaultRetryPolicy is the retry policy of new clients, trying each agent once when a request
+// can't reach the current one. Whatever the policy, only reads fail over after reaching an agent,
+// other requests such as events, sessions and check-and-set writes could be applied twice.
+var DefaultRetryPolicy = retry.Policy{
+	InitialBackoff: 100 * time.Millisecond,
+	MaxBackoff:     2 * time.Second,
+	RetryOn:        retry.TransportErrors,
+}
+
+// Config configures a Client, only Address is required
+type Config struct {
+	// Address is the host of the agent, with the default port for the scheme added if it has none
+	Address string
+	// FallbackAddresses are more agents to fail over to, in order, when a request to the current
+	// agent fails to connect
+	FallbackAddresses []string
+	// Scheme is http or https, defaulting to http
+	Scheme string
+	// Token is the ACL token sent with every request
+	Token string
+	// TokenInQuery sends the token as the token query parameter instead of the X-Consul-Token
+	// header, for proxies that strip unknown headers. The query parameter can end up in access logs.
+	TokenInQuery bool
+	// Datacenter is the datacenter requests are made against, defaulting to the agent's own
+	Datacenter string
+}
+
+// httpOptions are the options of every client's HTTP client
+var httpOptions = httpclient.Options{
+	Service: apierror.Consul,
+	Endpoint: func(path string) string {
+		return endpointName(strings.TrimPrefix(path, "/"+apiVersion))
+	},
+}
+
+// Client is a connection to a consul agent
+type Client struct {
+	config     Config
+	httpClient *httpclient.Client
+	ctx        context.Context
+	agents     *agentRotation
+	retry      retry.Policy
+	metrics    Metrics
+	logger     logging.Logger
+}
+
+// agentRotation tracks the agents and which of them requests go to, shared by derived clients
+type agentRotation struct {
+	mutex     sync.Mutex
+	addresses []string
+	current   int
+}
+
+// NewClient returns a client for the agent described by config
+func NewClient(config Config) *Client {
+	if config.Scheme == "" {
+		config.Scheme = "http"
+	}
+	return &Client{
+		config:     config,
+		httpClient: httpclient.New(httpOptions),
+		agents:     &agentRotation{addresses: append([]string{config.Address}, config.FallbackAddresses...)},
+		retry:      DefaultRetryPolicy,
+		metrics:    noopMetrics{},
+		logger:     logging.Nop,
+	}
+}
+
+// WithContext returns a copy of the client whose methods without a context make their requests
+// with ctx. To cut a single call such as a blocking query short, pass ctx to the method's
+// Context variant instead, which doesn't copy the client.
+func (client *Client) WithContext(ctx context.Context) *Client {
+	derived := *client
+	derived.ctx = ctx
+	return &derived
+}
+
+// SetLogger sets where the client logs, nil turns logging off. Clients derived from the client
+// afterwards log to the same place.
+func (client *Client) SetLogger(logger logging.Logger) {
+	client.logger = logging.OrNop(logger)
+}
+
+// SetRateLimiter limits the rate at which the client sends requests, nil removes the limit.
+// Clients derived from the client share the limiter.
+func (client *Client) SetRateLimiter(limiter ratelimit.Limiter) {
+	client.httpClient.Configure(func(options *httpclient.Options) {
+		options.Limiter = limiter
+	})
+}
+
+// SetCircuitBreaker stops the client sending requests to agents whose circuit is open, failing
+// over to the next agent, nil removes the breaker. Clients derived from the client share the
+// breaker.
+func (client *Client) SetCircuitBreaker(b breaker.Breaker) {
+	client.httpClient.Configure(func(options *httpclient.Options) {
+		options.Breaker = b
+	})
+}
+
+// SetCache serves the client's reads from responses when it has them, nil turns caching off.
+// Clients derived from the client share the cache.
+func (client *Client) SetCache(responses cache.Cache) {
+	client.httpClient.Configure(func(options *httpclient.Options) {
+		options.Cache = responses
+	})
+}
+
+// SetTimeouts sets the client's connect, read and operation timeouts, nil goes back to the
+// defaults of the timeouts package. Blocking queries only have the connect timeout. Clients
+// derived from the client share the timeouts.
+func (client *Client) SetTimeouts(t *timeouts.Timeouts) {
+	client.httpClient.Configure(func(options *httpclient.Options) {
+		options.Timeouts = t
+	})
+}
+
+// SetProxy sets the proxy the client's requests go through, nil goes back to the default of the
+// proxy package. Clients derived from the client share the proxy.
+func (client *Client) SetProxy(p proxy.Func) {
+	client.httpClient.Configure(func(options *httpclient.Options) {
+		options.Proxy = p
+	})
+}
+
+// SetRetryPolicy sets how the client retries failed requests, each retry going to the next
+// agent. A MaxAttempts of 0 tries each agent once. Clients derived from the client afterwards
+// share the policy.
+func (client *Client) SetRetryPolicy(policy retry.Policy) {
+	client.retry = policy
+}
+
+// Shutdown stops the client and the clients derived from it: new requests fail with
+// apierror.ErrClosed, watches, blocking queries and sessions stop straight away and requests in
+// flight are given until ctx is done to finish before they are cancelled. It returns ctx's error
+// if requests had to be cancelled.
+func (client *Client) Shutdown(ctx context.Context) error {
+	return client.httpClient.Shutdown(ctx)
+}
+
+// Close stops the client like Shutdown, cancelling requests in flight straight away
+func (client *Client) Close() error {
+	return client.httpClient.Close()
+}
+
+// context returns the context requests are made with
+func (client *Client) context() context.Context {
+	if client.ctx == nil {
+		return context.Background()
+	}
+	return client.ctx
+}
+
+// WithToken returns a copy of the client that sends token instead of the client's own ACL token
+func (client *Client) WithToken(token string) *Client {
+	derived := *client
+	derived.config.Token = token
+	return &derived
+}
+
+// WithDatacenter returns a copy of the client that makes requests against datacenter instead of
+// the client's own, reads can also override the datacenter with QueryOptions
+func (client *Client) WithDatacenter(datacenter string) *Client {
+	derived := *client
+	derived.config.Datacenter = datacenter
+	return &derived
+}
+
+// Datacenter returns the datacenter the client makes requests against, empty for the agent's own
+func (client *Client) Datacenter() string {
+	return client.config.Datacenter
+}
+
+// SetAddresses replaces the addresses of the agents, the first preferred and the rest fallbacks,
+// such as with ones found by the discovery package. Requests keep going to the current agent
+// while it is still one of them. Clients derived from the client share the addresses, and
+// SetAddresses without any is ignored.
+func (client *Client) SetAddresses(addresses ...string) {
+	if len(addresses) == 0 {
+		return
+	}
+	client.agents.mutex.Lock()
+	defer client.agents.mutex.Unlock()
+	current := client.agents.addresses[client.agents.current%len(client.agents.addresses)]
+	client.agents.addresses = append([]string{}, addresses...)
+	client.agents.current = 0
+	for agent, address := range addresses {
+		if address == current {
+			client.agents.current = agent
+		}
+	}
+}
+
+// addresses returns the addresses of the agents in order of preference
+func (client *Client) addresses() []string {
+	client.agents.mutex.Lock()
+	defer client.agents.mutex.Unlock()
+	return client.agents.addresses
+}
+
+// currentAgent returns the index of the agent requests currently go to
+func (client *Client) currentAgent() int {
+	client.agents.mutex.Lock()
+	defer client.agents.mutex.Unlock()
+	return client.agents.current
+}
+
+// address returns the agent's address with the default port for the scheme added if it has none
+func (client *Client) address(agent int) string {
+	addresses := client.addresses()
+	address := addresses[agent%len(addresses)]
+	if _, _, err := net.SplitHostPort(address); err == nil {
+		return address
+	}
+	defaultPort := port
+	if client.config.Scheme == "https" {
+		defaultPort = httpsPort
+	}
+	return net.JoinHostPort(address, strconv.Itoa(defaultPort))
+}
+
+// getJSON decodes the response to a GET of the API path into result
+func (client *Client) getJSON(ctx context.Context, path string, query url.Values, result interface{}) error {
+	_, err := client.query(ctx, path, query, nil, result)
+	return err
+}
+
+// putJSON sends body encoded as JSON in a PUT to the API path, a nil body sends an empty request
+func (client *Client) putJSON(ctx context.Context, path string, query url.Values, body interface{}) error {
+	return client.writeJSON(ctx, path, query, body, nil)
+}
+
+// writeJSON sends body encoded as JSON in a PUT to the API path and decodes the response into
+// result unless it is nil
+func (client *Client) writeJSON(ctx context.Context, path string, query url.Values, body, result interface{}) error {
+	return client.sendJSON(ctx, http.MethodPut, path, query, body, result)
+}
+
+// sendJSON sends body encoded as JSON to the API path and decodes the response into result
+// unless it is nil
+func (client *Client) sendJSON(ctx context.Context, method, path string, query url.Values, body, result interface{}) error {
+	var bodyBytes []byte
+	if body != nil {
+		var err error
+		bodyBytes, err = json.Marshal(body)
+		if err != nil {
+			return err
+		}
+	}
+
+	response, err := client.doHTTPResponse(ctx, method, path, query, nil, bodyBytes)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if err := checkResponse(response); err != nil || result == nil {
+		return err
+	}
+
+	return httpclient.DecodeJSON(response, result)
+}
+
+// checkResponse returns an *apierror.Error wrapping an *Error with the body of a response with a
+// non 2xx status
+func checkResponse(response *http.Response) error {
+	return httpclient.CheckStatus(response, func(statusCode int, body []byte) error {
+		message := strings.TrimSpace(string(body))
+		return apierror.FromResponse(apierror.Consul, response, "", message, &Error{StatusCode: statusCode, Message: message})
+	})
+}
+
+// Agent returns the address of the agent requests currently go to
+func (client *Client) Agent() string {
+	return client.address(client.currentAgent())
+}
+
+// ============================================================================
+// ============================= HTTP UTILS ===================================
+// ============================================================================
+
+func (client *Client) httpGetResponse(ctx context.Context, path string, query url.Values) (*http.Response, error) {
+	return client.doHTTPResponse(ctx, http.MethodGet, path, query, nil, nil)
+}
+
+func (client *Client) httpPutResponse(ctx context.Context, path string, query url.Values, body []byte) (*http.Response, error) {
+	return client.doHTTPResponse(ctx, http.MethodPut, path, query, nil, body)
+}
+
+func (client *Client) httpDeleteResponse(ctx context.Context, path string, query url.Values) (*http.Response, error) {
+	return client.doHTTPResponse(ctx, http.MethodDelete, path, query, nil, nil)
+}
+
+// doHTTPResponse sends a request for the given API path to the current agent, see newRequest.
+// When the agent can't be reached the request is retried against the other agents in turn as
+// the client's retry policy allows, and the first agent that answers becomes the current one.
+// Requests other than reads are only retried when they never reached an agent.
+func (client *Client) doHTTPResponse(ctx context.Context, method, path string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	start := client.currentAgent()
+	agents := len(client.addresses())
+	policy := client.retry
+	if policy.MaxAttempts == 0 {
+		policy.MaxAttempts = agents
+	}
+	if method != http.MethodGet {
+		configured := client.retry
+		policy.RetryOn = func(response *http.Response, err error) bool {
+			return retry.Unsent(response, err) && configured.Retryable(response, err)
+		}
+	}
+
+	if query.Get("index") != "" {
+		ctx = httpclient.Streaming(ctx)
+	}
+	ctx, release := client.httpClient.Deadline(ctx)
+
+	agent := start
+	response, err := httpclient.Retry(ctx, policy, func(attempt int) (*http.Response, error) {
+		agent = (start + attempt) % agents
+		request, err := client.newRequest(ctx, method, path, query, header, bytes.NewReader(body), agent)
+		if err != nil {
+			return nil, err
+		}
+		return client.do(request)
+	})
+	if err == nil && agent != start {
+		client.logger.Info("Failed over to another consul agent", "from", client.address(start), "to", client.address(agent))
+		client.agents.mutex.Lock()
+		client.agents.current = agent
+		client.agents.mutex.Unlock()
+	}
+	return httpclient.ReleaseOnClose(response, release), err
+}
+
+// do sends the request and reports it to the client's metrics
+func (client *Client) do(request *http.Request) (*http.Response, error) {
+	started := time.Now()
+	response, err := client.httpClient.Send(request)
+	info := RequestInfo{
+		Method:   request.Method,
+		Endpoint: httpOptions.Endpoint(request.URL.Path),
+		Agent:    request.URL.Host,
+		Err:      err,
+		Duration: time.Since(started),
+		Blocking: request.URL.Query().Get("index") != "",
+	}
+	if response != nil {
+		info.StatusCode = response.StatusCode
+	}
+	client.metrics.RequestDone(info)
+	return response, apierror.Transport(apierror.Consul, request.Method+" "+request.URL.Path, err)
+}
+
+// newRequest builds a request for the given API path to the agent at index agent, adding the client's datacenter to the
+// query and its token to the headers unless they are already set. The token is moved to the
+// query when the client is configured with TokenInQuery.
+func (client *Client) newRequest(ctx context.Context, method, path string, query url.Values, header http.Header, body io.Reader, agent int) (*http.Request, error) {
+	if client.config.Scheme != "http" && client.config.Scheme != "https" {
+		return nil, fmt.Errorf("Unsupported scheme %q", client.config.Scheme)
+	}
+
+	query = cloneValues(query)
+	header = cloneHeader(header)
+	if client.config.Datacenter != "" && query.Get("dc") == "" {
+		query.Set("dc", client.config.Datacenter)
+	}
+
+	token := header.Get("X-Consul-Token")
+	if token == "" {
+		token = client.config.Token
+	}
+	header.Del("X-Consul-Token")
+	if token != "" && client.config.TokenInQuery {
+		query.Set("token", token)
+	} else if token != "" {
+		header.Set("X-Consul-Token", token)
+	}
+
+	return client.httpClient.NewRequest(ctx, httpclient.Request{
+		Method:     method,
+		URL:        fmt.Sprintf("%s://%s/%s", client.config.Scheme, client.address(agent), apiVersion),
+		Path:       path,
+		Query:      query,
+		Header:     header,
+		BodyReader: body,
+	})
+}
+
+func cloneValues(values url.Values) url.Values {
+	cloned := url.Values{}
+	for name, value := range values {
+		cloned[name] = append([]string{}, value...)
+	}
+	return cloned
+}
+
+func cloneHeader(header http.Header) http.Header {
+	if header == nil {
+		return http.Header{}
+	}
+	return header.Clone()
+}
@@ -0,0 +1,175 @@
+package consul_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rarmstrong73/go-utils/consul/consultest"
+	consul "github.com/rarmstrong73/go-utils/consul/health"
+	"github.com/rarmstrong73/go-utils/timeouts"
+)
+
+// newDroppingAgent fakes an agent that reads every request and then drops the connection
+// without answering, counting the requests it dropped
+func newDroppingAgent(t *testing.T) (*httptest.Server, func() int) {
+	var mutex sync.Mutex
+	dropped := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		dropped++
+		mutex.Unlock()
+		connection, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("Hijack: %v", err)
+			return
+		}
+		connection.Close()
+	}))
+	return server, func() int {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return dropped
+	}
+}
+
+func TestFailOverOnlyReadsThatReachedAnAgent(t *testing.T) {
+	agent := consultest.NewServer()
+	defer agent.Close()
+	dropping, dropped := newDroppingAgent(t)
+	defer dropping.Close()
+	if err := agent.Client().KVPut(consul.KVPair{Key: "existing", Value: []byte("1")}); err != nil {
+		t.Fatalf("KVPut: %v", err)
+	}
+
+	client := consul.NewClient(consul.Config{
+		Address:           strings.TrimPrefix(dropping.URL, "http://"),
+		FallbackAddresses: []string{agent.Address()},
+	})
+	if _, err := client.KVCAS(consul.KVPair{Key: "created", Value: []byte("1")}); err == nil {
+		t.Error("KVCAS failed over after reaching an agent")
+	}
+	if dropped() != 1 {
+		t.Errorf("dropping agent saw %d requests, want 1", dropped())
+	}
+	if _, _, err := agent.Client().KVGet("created", nil); !errors.Is(err, consul.ErrKeyNotFound) {
+		t.Errorf("KVGet(created) error = %v, want ErrKeyNotFound", err)
+	}
+
+	pair, _, err := client.KVGet("existing", nil)
+	if err != nil || string(pair.Value) != "1" {
+		t.Errorf("KVGet(existing) = %+v, %v, want it read from the fallback agent", pair, err)
+	}
+}
+
+func TestFailOverWritesThatNeverReachedAnAgent(t *testing.T) {
+	agent := consultest.NewServer()
+	defer agent.Close()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refused := listener.Addr().String()
+	listener.Close()
+
+	client := consul.NewClient(consul.Config{Address: refused, FallbackAddresses: []string{agent.Address()}})
+	if set, err := client.KVCAS(consul.KVPair{Key: "created", Value: []byte("1")}); err != nil || !set {
+		t.Fatalf("KVCAS = %t, %v", set, err)
+	}
+	if _, _, err := agent.Client().KVGet("created", nil); err != nil {
+		t.Errorf("KVGet(created): %v", err)
+	}
+}
+
+func TestContextVariantsCancelBlockingQueries(t *testing.T) {
+	agent := consultest.NewServer()
+	defer agent.Close()
+	client := agent.Client()
+	if err := client.KVPut(consul.KVPair{Key: "a", Value: []byte("1")}); err != nil {
+		t.Fatalf("KVPut: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	_, _, err := client.KVGetContext(ctx, "a", &consul.QueryOptions{WaitIndex: agent.Index(), WaitTime: time.Minute})
+	if !errors.Is(err, context.DeadlineExceeded) || time.Since(started) > 5*time.Second {
+		t.Errorf("KVGetContext = %v after %s, want it cut short by its context", err, time.Since(started))
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	watch := client.WatchKeyContext(ctx, "a")
+	if event := <-watch.Events(); event.Value == nil {
+		t.Fatalf("first event = %+v, want the key", event)
+	}
+	cancel()
+	select {
+	case _, open := <-watch.Events():
+		if open {
+			t.Error("watch delivered an event after its context was cancelled")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("watch kept running after its context was cancelled")
+	}
+
+	if _, _, err := client.KVGet("a", nil); err != nil {
+		t.Errorf("KVGet after the cancellations: %v", err)
+	}
+}
+
+// TestSettersWhileRequesting changes a client's configuration while it and a client derived from
+// it send requests, which go test -race reports if the setters aren't safe for it
+func TestSettersWhileRequesting(t *testing.T) {
+	agent := consultest.NewServer()
+	defer agent.Close()
+	client := agent.Client()
+	derived := client.WithToken("token")
+	if err := client.KVPut(consul.KVPair{Key: "a", Value: []byte("1")}); err != nil {
+		t.Fatalf("KVPut: %v", err)
+	}
+
+	var wait sync.WaitGroup
+	for _, requester := range []*consul.Client{client, derived, client, derived} {
+		wait.Add(1)
+		go func(requester *consul.Client) {
+			defer wait.Done()
+			for j := 0; j < 20; j++ {
+				if _, _, err := requester.KVGet("a", nil); err != nil {
+					t.Errorf("KVGet: %v", err)
+					return
+				}
+			}
+		}(requester)
+	}
+	for j := 0; j < 20; j++ {
+		client.SetTimeouts(&timeouts.Timeouts{Connect: time.Second, Read: time.Duration(j+1) * time.Second})
+		client.SetRateLimiter(nil)
+		client.SetCache(nil)
+	}
+	wait.Wait()
+}
+
+func TestSettersApplyToDerivedClients(t *testing.T) {
+	agent := consultest.NewServer()
+	defer agent.Close()
+	client := agent.Client()
+	derived := client.WithToken("token")
+
+	client.SetProxy(func(*http.Request) (*url.URL, error) {
+		return nil, errors.New("Proxy refused the request")
+	})
+	if err := derived.KVPut(consul.KVPair{Key: "a", Value: []byte("1")}); err == nil || !strings.Contains(err.Error(), "Proxy refused") {
+		t.Errorf("err = %v, want the derived client's request sent through the proxy set on its parent", err)
+	}
+	client.SetProxy(nil)
+	if err := derived.KVPut(consul.KVPair{Key: "a", Value: []byte("1")}); err != nil {
+		t.Errorf("KVPut: %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+package consul
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/url"
+	"time"
+)
+
+// Coordinate is a node's position in consul's network coordinate system
+type Coordinate struct {
+	Vec        []float64 `json:"Vec"`
+	Error      float64   `json:"Error"`
+	Adjustment float64   `json:"Adjustment"`
+	Height     float64   `json:"Height"`
+}
+
+// CoordinateEntry is the coordinate of a node
+type CoordinateEntry struct {
+	Node    string     `json:"Node"`
+	Segment string     `json:"Segment"`
+	Coord   Coordinate `json:"Coord"`
+}
+
+// CoordinateDatacenterMap is the coordinates of a datacenter's servers in the WAN pool
+type CoordinateDatacenterMap struct {
+	Datacenter  string            `json:"Datacenter"`
+	AreaID      string            `json:"AreaID"`
+	Coordinates []CoordinateEntry `json:"Coordinates"`
+}
+
+// CoordinateDatacenters returns the WAN coordinates of the servers of every datacenter
+func (client *Client) CoordinateDatacenters() ([]CoordinateDatacenterMap, error) {
+	return client.CoordinateDatacentersContext(client.context())
+}
+
+// CoordinateDatacentersContext is CoordinateDatacenters with ctx cancelling its requests
+func (client *Client) CoordinateDatacentersContext(ctx context.Context) ([]CoordinateDatacenterMap, error) {
+	datacenters := []CoordinateDatacenterMap{}
+	err := client.getJSON(ctx, "/coordinate/datacenters", nil, &datacenters)
+	return datacenters, err
+}
+
+// CoordinateNodes returns the LAN coordinates of every node in the datacenter
+func (client *Client) CoordinateNodes(options *QueryOptions) ([]CoordinateEntry, QueryMeta, error) {
+	return client.CoordinateNodesContext(client.context(), options)
+}
+
+// CoordinateNodesContext is CoordinateNodes with ctx cancelling its requests
+func (client *Client) CoordinateNodesContext(ctx context.Context, options *QueryOptions) ([]CoordinateEntry, QueryMeta, error) {
+	entries := []CoordinateEntry{}
+	meta, err := client.query(ctx, "/coordinate/nodes", nil, options, &entries)
+	return entries, meta, err
+}
+
+// CoordinateNode returns the LAN coordinates of the named node, one per network segment it is in
+func (client *Client) CoordinateNode(node string, options *QueryOptions) ([]CoordinateEntry, QueryMeta, error) {
+	return client.CoordinateNodeContext(client.context(), node, options)
+}
+
+// CoordinateNodeContext is CoordinateNode with ctx cancelling its requests
+func (client *Client) CoordinateNodeContext(ctx context.Context, node string, options *QueryOptions) ([]CoordinateEntry, QueryMeta, error) {
+	entries := []CoordinateEntry{}
+	meta, err := client.query(ctx, "/coordinate/node/"+url.PathEscape(node), nil, options, &entries)
+	return entries, meta, err
+}
+
+// RTT estimates the round trip time between the nodes at the two coordinates
+func RTT(from, to Coordinate) (time.Duration, error) {
+	if len(from.Vec) != len(to.Vec) {
+		return 0, fmt.Errorf("Coordinates have different dimensions, %d and %d", len(from.Vec), len(to.Vec))
+	}
+
+	var sum float64
+	for i := range from.Vec {
+		diff := from.Vec[i] - to.Vec[i]
+		sum += diff * diff
+	}
+	seconds := math.Sqrt(sum) + from.Height + to.Height
+
+	// The adjustments correct for systematic error but are left out if they would make the
+	// estimate negative
+	if adjusted := seconds + from.Adjustment + to.Adjustment; adjusted > 0 {
+		seconds = adjusted
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// NodeRTT estimates the round trip time between two nodes in the datacenter from their coordinates
+func (client *Client) NodeRTT(from, to string) (time.Duration, error) {
+	return client.NodeRTTContext(client.context(), from, to)
+}
+
+// NodeRTTContext is NodeRTT with ctx cancelling its requests
+func (client *Client) NodeRTTContext(ctx context.Context, from, to string) (time.Duration, error) {
+	entries, _, err := client.CoordinateNodesContext(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	coordinates := map[string]Coordinate{}
+	for _, entry := range entries {
+		coordinates[entry.Node] = entry.Coord
+	}
+	fromCoordinate, ok := coordinates[from]
+	if !ok {
+		return 0, fmt.Errorf("No coordinate for node %s", from)
+	}
+	toCoordinate, ok := coordinates[to]
+	if !ok {
+		return 0, fmt.Errorf("No coordinate for node %s", to)
+	}
+	return RTT(fromCoordinate, toCoordinate)
+}
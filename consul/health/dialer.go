@@ -0,0 +1,136 @@
+package consul
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const serviceDomain = ".service.consul"
+
+// Dialer dials consul service names such as web.service.consul or v2.web.service.consul, in the
+// same form as consul DNS, by resolving them to passing instances through the health API. Any
+// port in the address is ignored in favour of the instance's. Other addresses are dialed as
+// they are. Resolved instances are cached and connections rotate through them, moving on to
+// the next instance when one can't be reached. DialContext can be used as the DialContext of an
+// http.Transport.
+type Dialer struct {
+	client   *Client
+	cacheTTL time.Duration
+	dialer   net.Dialer
+
+	mutex sync.Mutex
+	cache map[string]*dialerEntry
+}
+
+type dialerEntry struct {
+	picker  *RoundRobin
+	size    int
+	expires time.Time
+}
+
+// NewDialer returns a dialer that caches the instances of each service for cacheTTL
+func (client *Client) NewDialer(cacheTTL time.Duration) *Dialer {
+	return &Dialer{
+		client:   client,
+		cacheTTL: cacheTTL,
+		cache:    map[string]*dialerEntry{},
+	}
+}
+
+// Dial connects to the address, see DialContext
+func (dialer *Dialer) Dial(network, address string) (net.Conn, error) {
+	return dialer.DialContext(context.Background(), network, address)
+}
+
+// DialContext connects to the address, resolving consul service names to their instances
+func (dialer *Dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host := address
+	if splitHost, _, err := net.SplitHostPort(address); err == nil {
+		host = splitHost
+	}
+	host = strings.TrimSuffix(host, ".")
+	if !strings.HasSuffix(host, serviceDomain) {
+		return dialer.dialer.DialContext(ctx, network, address)
+	}
+
+	picker, size, err := dialer.resolve(host)
+	if err != nil {
+		return nil, err
+	}
+
+	var lastErr error
+	for i := 0; i < size; i++ {
+		target, ok := picker.Next()
+		if !ok {
+			break
+		}
+		conn, err := dialer.dialer.DialContext(ctx, network, target.String())
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("No passing instances of %s", host)
+	}
+	dialer.forget(host)
+	return nil, lastErr
+}
+
+// resolve returns a picker over the cached instances of the service name and how many there
+// are, resolving them again if they have expired
+func (dialer *Dialer) resolve(host string) (*RoundRobin, int, error) {
+	dialer.mutex.Lock()
+	entry := dialer.cache[host]
+	if entry != nil && time.Now().Before(entry.expires) {
+		dialer.mutex.Unlock()
+		return entry.picker, entry.size, nil
+	}
+	dialer.mutex.Unlock()
+
+	labels := strings.Split(strings.TrimSuffix(host, serviceDomain), ".")
+	var name, tag string
+	switch len(labels) {
+	case 1:
+		name = labels[0]
+	case 2:
+		tag, name = labels[0], labels[1]
+	default:
+		return nil, 0, fmt.Errorf("Invalid service address %s", host)
+	}
+
+	addresses, err := dialer.client.ResolveService(name, tag)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(addresses) == 0 {
+		return nil, 0, fmt.Errorf("No passing instances of %s", host)
+	}
+
+	dialer.mutex.Lock()
+	defer dialer.mutex.Unlock()
+	entry = dialer.cache[host]
+	if entry == nil {
+		entry = &dialerEntry{picker: NewRoundRobin(addresses)}
+		dialer.cache[host] = entry
+	} else {
+		entry.picker.Update(addresses)
+	}
+	entry.size = len(addresses)
+	entry.expires = time.Now().Add(dialer.cacheTTL)
+	return entry.picker, entry.size, nil
+}
+
+// forget drops the cached instances of the service name so the next dial resolves it again
+func (dialer *Dialer) forget(host string) {
+	dialer.mutex.Lock()
+	defer dialer.mutex.Unlock()
+	delete(dialer.cache, host)
+}
@@ -0,0 +1,43 @@
+package consul
+
+import (
+	"fmt"
+)
+
+// Error is a non 2xx response from consul, carrying the text of the response body
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+// Sentinel errors that can be matched against the errors returned by this package with
+// errors.Is. ErrServerError matches every 5xx response.
+var (
+	ErrPermissionDenied = &Error{StatusCode: 403, Message: "Permission denied"}
+	ErrNotFound         = &Error{StatusCode: 404, Message: "Not found"}
+	ErrRateLimited      = &Error{StatusCode: 429, Message: "Rate limit exceeded"}
+	ErrServerError      = &Error{StatusCode: 500, Message: "Server error"}
+)
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d: %s", e.StatusCode, e.Message)
+}
+
+// Is reports whether target is a consul error with the same status code, or both are 5xx
+// errors when target is ErrServerError
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	if !ok {
+		return false
+	}
+	if t == ErrServerError {
+		return e.StatusCode >= 500
+	}
+	return t.StatusCode == e.StatusCode
+}
+
+// Temporary reports whether the request may succeed if retried later, which is the case for
+// rate limiting and server errors
+func (e *Error) Temporary() bool {
+	return e.StatusCode == 429 || e.StatusCode >= 500
+}
@@ -0,0 +1,84 @@
+package consul
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+)
+
+// UserEvent is a custom event propagated through the cluster's gossip
+type UserEvent struct {
+	ID            string `json:"ID"`
+	Name          string `json:"Name"`
+	Payload       []byte `json:"Payload"`
+	NodeFilter    string `json:"NodeFilter"`
+	ServiceFilter string `json:"ServiceFilter"`
+	TagFilter     string `json:"TagFilter"`
+	Version       int    `json:"Version"`
+	LTime         uint64 `json:"LTime"`
+}
+
+// EventFilters limit which nodes act on an event, an empty filter matches every node. NodeFilter
+// and ServiceFilter are regular expressions and TagFilter only applies with a ServiceFilter.
+type EventFilters struct {
+	NodeFilter    string
+	ServiceFilter string
+	TagFilter     string
+}
+
+// FireEvent fires the named event with payload, returning the event as it was fired
+func (client *Client) FireEvent(name string, payload []byte, filters EventFilters) (UserEvent, error) {
+	return client.FireEventContext(client.context(), name, payload, filters)
+}
+
+// FireEventContext is FireEvent with ctx cancelling its requests
+func (client *Client) FireEventContext(ctx context.Context, name string, payload []byte, filters EventFilters) (UserEvent, error) {
+	query := url.Values{}
+	if filters.NodeFilter != "" {
+		query.Set("node", filters.NodeFilter)
+	}
+	if filters.ServiceFilter != "" {
+		query.Set("service", filters.ServiceFilter)
+	}
+	if filters.TagFilter != "" {
+		query.Set("tag", filters.TagFilter)
+	}
+
+	response, err := client.httpPutResponse(ctx, "/event/fire/"+url.PathEscape(name), query, payload)
+	if err != nil {
+		return UserEvent{}, err
+	}
+	defer response.Body.Close()
+
+	if err := checkResponse(response); err != nil {
+		return UserEvent{}, err
+	}
+
+	responseBytes, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return UserEvent{}, err
+	}
+
+	var event UserEvent
+	err = json.Unmarshal(responseBytes, &event)
+	return event, err
+}
+
+// ListEvents returns the most recent events the agent has seen, only those with the given name
+// unless name is empty. Blocking on the list waits for a new event.
+func (client *Client) ListEvents(name string, options *QueryOptions) ([]UserEvent, QueryMeta, error) {
+	return client.ListEventsContext(client.context(), name, options)
+}
+
+// ListEventsContext is ListEvents with ctx cancelling its requests
+func (client *Client) ListEventsContext(ctx context.Context, name string, options *QueryOptions) ([]UserEvent, QueryMeta, error) {
+	query := url.Values{}
+	if name != "" {
+		query.Set("name", name)
+	}
+
+	events := []UserEvent{}
+	meta, err := client.query(ctx, "/event/list", query, options, &events)
+	return events, meta, err
+}
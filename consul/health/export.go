@@ -0,0 +1,141 @@
+package consul
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/rarmstrong73/go-utils/internal/marshal"
+)
+
+// Import modes
+const (
+	Overwrite    = "overwrite"
+	SkipExisting = "skip-existing"
+)
+
+// Import change actions
+const (
+	Create = "create"
+	Update = "update"
+	Skip   = "skip"
+)
+
+// ExportedKey is a key in a KV export, relative to the export's prefix. Values are base64 in
+// JSON so binary values survive.
+type ExportedKey struct {
+	Key   string `json:"key"`
+	Flags uint64 `json:"flags,omitempty"`
+	Value []byte `json:"value"`
+}
+
+// KVExport is the keys under a prefix, see ExportKV
+type KVExport struct {
+	Prefix string        `json:"prefix"`
+	Keys   []ExportedKey `json:"keys"`
+}
+
+// WriteJSON writes the export to w as JSON
+func (export KVExport) WriteJSON(w io.Writer) error {
+	return marshal.WriteJSON(w, export)
+}
+
+// WriteYAML writes the export to w as YAML, with values base64 encoded as in the JSON
+func (export KVExport) WriteYAML(w io.Writer) error {
+	return marshal.WriteYAML(w, export)
+}
+
+// ImportOptions controls how ImportKV treats keys that already exist
+type ImportOptions struct {
+	Mode   string
+	DryRun bool
+}
+
+// Change describes a single key written (or that would be written) by ImportKV
+type Change struct {
+	Key      string `json:"key"`
+	Action   string `json:"action"`
+	OldValue []byte `json:"oldValue,omitempty"`
+	NewValue []byte `json:"newValue"`
+	Flags    uint64 `json:"flags,omitempty"`
+}
+
+// ExportKV returns every key under prefix, sorted by key, ready to be encoded as JSON
+func (client *Client) ExportKV(prefix string) (KVExport, error) {
+	return client.ExportKVContext(client.context(), prefix)
+}
+
+// ExportKVContext is ExportKV with ctx cancelling its requests
+func (client *Client) ExportKVContext(ctx context.Context, prefix string) (KVExport, error) {
+	pairs, _, err := client.KVListContext(ctx, prefix, nil)
+	if err != nil {
+		return KVExport{}, err
+	}
+
+	export := KVExport{Prefix: prefix, Keys: []ExportedKey{}}
+	for _, pair := range pairs {
+		export.Keys = append(export.Keys, ExportedKey{
+			Key:   strings.TrimPrefix(pair.Key, prefix),
+			Flags: pair.Flags,
+			Value: pair.Value,
+		})
+	}
+	sort.Slice(export.Keys, func(i, j int) bool { return export.Keys[i].Key < export.Keys[j].Key })
+	return export, nil
+}
+
+// ImportKV writes a previously exported set of keys under prefix, which need not be the prefix
+// they were exported from. Keys whose value and flags already match are left alone. With
+// DryRun set nothing is written and the returned changes describe what would happen.
+func (client *Client) ImportKV(prefix string, data KVExport, opts ImportOptions) ([]Change, error) {
+	return client.ImportKVContext(client.context(), prefix, data, opts)
+}
+
+// ImportKVContext is ImportKV with ctx cancelling its requests
+func (client *Client) ImportKVContext(ctx context.Context, prefix string, data KVExport, opts ImportOptions) ([]Change, error) {
+	if opts.Mode == "" {
+		opts.Mode = Overwrite
+	}
+	if opts.Mode != Overwrite && opts.Mode != SkipExisting {
+		return nil, fmt.Errorf("Unknown import mode %q", opts.Mode)
+	}
+
+	pairs, _, err := client.KVListContext(ctx, prefix, nil)
+	if err != nil {
+		return nil, err
+	}
+	existing := map[string]KVPair{}
+	for _, pair := range pairs {
+		existing[pair.Key] = pair
+	}
+
+	changes := []Change{}
+	for _, key := range data.Keys {
+		destination := prefix + key.Key
+		change := Change{Key: destination, Action: Create, NewValue: key.Value, Flags: key.Flags}
+
+		if old, ok := existing[destination]; ok {
+			if bytes.Equal(old.Value, key.Value) && old.Flags == key.Flags {
+				continue
+			}
+			change.OldValue = old.Value
+			change.Action = Update
+			if opts.Mode == SkipExisting {
+				change.Action = Skip
+			}
+		}
+		changes = append(changes, change)
+
+		if opts.DryRun || change.Action == Skip {
+			continue
+		}
+		err := client.KVPutContext(ctx, KVPair{Key: destination, Value: key.Value, Flags: key.Flags})
+		if err != nil {
+			return changes, err
+		}
+	}
+	return changes, nil
+}
@@ -0,0 +1,58 @@
+package consul
+
+// CheckFilter selects checks on the client, for agents too old to support filter expressions
+// or for filtering results that have already been fetched. Empty fields match every check.
+type CheckFilter struct {
+	// Status matches checks in this state, HealthAny matches every state
+	Status string
+	// ServiceName matches checks of this service
+	ServiceName string
+	// ServiceID matches checks of this service instance
+	ServiceID string
+	// Node matches checks on this node
+	Node string
+	// Tags matches checks of service instances with every one of these tags
+	Tags []string
+}
+
+// Matches reports whether the check passes the filter
+func (filter CheckFilter) Matches(check HealthNode) bool {
+	if filter.Status != "" && filter.Status != HealthAny && check.Status != filter.Status {
+		return false
+	}
+	if filter.ServiceName != "" && check.ServiceName != filter.ServiceName {
+		return false
+	}
+	if filter.ServiceID != "" && check.ServiceID != filter.ServiceID {
+		return false
+	}
+	if filter.Node != "" && check.Node != filter.Node {
+		return false
+	}
+	for _, tag := range filter.Tags {
+		if !hasTag(check.ServiceTags, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// FilterChecks returns the checks that pass the filter
+func FilterChecks(checks []HealthNode, filter CheckFilter) []HealthNode {
+	filtered := []HealthNode{}
+	for _, check := range checks {
+		if filter.Matches(check) {
+			filtered = append(filtered, check)
+		}
+	}
+	return filtered
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, existing := range tags {
+		if existing == tag {
+			return true
+		}
+	}
+	return false
+}
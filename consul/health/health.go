@@ -1,61 +1,142 @@
 package consul
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"io/ioutil"
-	"log"
-	"net/http"
+	"net/url"
 )
 
 var port = 8500
 var apiVersion = "v1"
 
+// Check states, HealthAny matches every state when listing checks
+const (
+	HealthAny      = "any"
+	HealthPassing  = "passing"
+	HealthWarning  = "warning"
+	HealthCritical = "critical"
+	HealthMaint    = "maintenance"
+)
+
 // HealthNode represents the health information about a node in consul
 type HealthNode struct {
-	Node        string `json:"Node"`
-	CheckID     string `json:"CheckID"`
-	Name        string `json:"Name"`
-	Status      string `json:"Status"`
-	Notes       string `json:"Notes"`
-	Output      string `json:"Output"`
-	ServiceID   string `json:"ServiceID"`
-	ServiceName string `json:"ServiceName"`
-	CreateIndex int64  `json:"CreateIndex"`
-	ModifyIndex int64  `json:"ModifyIndex"`
+	Node        string   `json:"Node"`
+	CheckID     string   `json:"CheckID"`
+	Name        string   `json:"Name"`
+	Status      string   `json:"Status"`
+	Notes       string   `json:"Notes"`
+	Output      string   `json:"Output"`
+	ServiceID   string   `json:"ServiceID"`
+	ServiceName string   `json:"ServiceName"`
+	ServiceTags []string `json:"ServiceTags"`
+	// ServiceMeta is only filled in for checks returned by HealthService, consul doesn't include
+	// it in checks
+	ServiceMeta map[string]string `json:"ServiceMeta,omitempty"`
+	CreateIndex int64             `json:"CreateIndex"`
+	ModifyIndex int64             `json:"ModifyIndex"`
 }
 
 // GetHealthChecks returns the checks of a service
 func GetHealthChecks(host, service string) (nodes []HealthNode, err error) {
-	url := fmt.Sprintf("http://%s:%d/%s/health/checks/%s", host, port, apiVersion, service)
-	response := httpGetResponse(url)
-	defer response.Body.Close()
+	return NewClient(Config{Address: host}).GetHealthChecks(service)
+}
 
-	responseBytes, err := ioutil.ReadAll(response.Body)
+// GetHealthChecks returns the checks of a service
+func (client *Client) GetHealthChecks(service string) (nodes []HealthNode, err error) {
+	return client.GetHealthChecksContext(client.context(), service)
+}
+
+// GetHealthChecksContext is GetHealthChecks with ctx cancelling its requests
+func (client *Client) GetHealthChecksContext(ctx context.Context, service string) (nodes []HealthNode, err error) {
+	nodes = []HealthNode{}
+	err = client.getJSON(ctx, "/health/checks/"+url.PathEscape(service), nil, &nodes)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
+	return nodes, nil
+}
+
+// ServiceEntry is a healthy or unhealthy instance of a service, along with its node and every
+// check that applies to it
+type ServiceEntry struct {
+	Node    Node         `json:"Node"`
+	Service AgentService `json:"Service"`
+	Checks  []HealthNode `json:"Checks"`
+}
 
-	err = json.Unmarshal(responseBytes, &nodes)
+// HealthService returns the instances of the named service. A non-empty tag only returns
+// instances with that tag and passingOnly only returns instances whose checks are all passing.
+func (client *Client) HealthService(name, tag string, passingOnly bool, options *QueryOptions) ([]ServiceEntry, QueryMeta, error) {
+	return client.HealthServiceContext(client.context(), name, tag, passingOnly, options)
+}
+
+// HealthServiceContext is HealthService with ctx cancelling its requests
+func (client *Client) HealthServiceContext(ctx context.Context, name, tag string, passingOnly bool, options *QueryOptions) ([]ServiceEntry, QueryMeta, error) {
+	var tags []string
+	if tag != "" {
+		tags = []string{tag}
+	}
+	return client.HealthServiceTagsContext(ctx, name, tags, passingOnly, options)
+}
+
+// HealthServiceTags returns the instances of the named service that have every one of tags, see HealthService
+func (client *Client) HealthServiceTags(name string, tags []string, passingOnly bool, options *QueryOptions) ([]ServiceEntry, QueryMeta, error) {
+	return client.HealthServiceTagsContext(client.context(), name, tags, passingOnly, options)
+}
+
+// HealthServiceTagsContext is HealthServiceTags with ctx cancelling its requests
+func (client *Client) HealthServiceTagsContext(ctx context.Context, name string, tags []string, passingOnly bool, options *QueryOptions) ([]ServiceEntry, QueryMeta, error) {
+	query := url.Values{}
+	for _, tag := range tags {
+		query.Add("tag", tag)
+	}
+	if passingOnly {
+		query.Set("passing", "")
+	}
+
+	entries := []ServiceEntry{}
+	meta, err := client.query(ctx, "/health/service/"+url.PathEscape(name), query, options, &entries)
 	if err != nil {
-		log.Fatal(err)
+		return entries, meta, err
 	}
 
-	if len(nodes) == 0 {
-		return []HealthNode{}, fmt.Errorf("Consul returned 0 checks.")
+	for _, entry := range entries {
+		for i := range entry.Checks {
+			if entry.Checks[i].ServiceID == entry.Service.ID {
+				entry.Checks[i].ServiceTags = entry.Service.Tags
+				entry.Checks[i].ServiceMeta = entry.Service.Meta
+			}
+		}
 	}
+	return entries, meta, nil
+}
 
-	return nodes, nil
+// HealthNodeChecks returns every check registered on the named node
+func (client *Client) HealthNodeChecks(node string, options *QueryOptions) ([]HealthNode, QueryMeta, error) {
+	return client.HealthNodeChecksContext(client.context(), node, options)
 }
 
-// ============================================================================
-// ============================= HTTP UTILS ===================================
-// ============================================================================
+// HealthNodeChecksContext is HealthNodeChecks with ctx cancelling its requests
+func (client *Client) HealthNodeChecksContext(ctx context.Context, node string, options *QueryOptions) ([]HealthNode, QueryMeta, error) {
+	checks := []HealthNode{}
+	meta, err := client.query(ctx, "/health/node/"+url.PathEscape(node), nil, options, &checks)
+	return checks, meta, err
+}
 
-func httpGetResponse(url string) *http.Response {
-	response, err := http.Get(url)
-	if err != nil {
-		log.Fatal(err)
+// HealthState returns every check in the datacenter in the given state, see HealthAny
+func (client *Client) HealthState(state string, options *QueryOptions) ([]HealthNode, QueryMeta, error) {
+	return client.HealthStateContext(client.context(), state, options)
+}
+
+// HealthStateContext is HealthState with ctx cancelling its requests
+func (client *Client) HealthStateContext(ctx context.Context, state string, options *QueryOptions) ([]HealthNode, QueryMeta, error) {
+	switch state {
+	case HealthAny, HealthPassing, HealthWarning, HealthCritical:
+	default:
+		return nil, QueryMeta{}, fmt.Errorf("Unknown health state %q", state)
 	}
-	return response
+
+	checks := []HealthNode{}
+	meta, err := client.query(ctx, "/health/state/"+state, nil, options, &checks)
+	return checks, meta, err
 }
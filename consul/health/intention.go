@@ -0,0 +1,80 @@
+package consul
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// Intention actions
+const (
+	IntentionAllow = "allow"
+	IntentionDeny  = "deny"
+)
+
+// Intention allows or denies Connect traffic from a source service to a destination service,
+// either of which can be "*"
+type Intention struct {
+	ID              string            `json:"ID,omitempty"`
+	Description     string            `json:"Description,omitempty"`
+	SourceName      string            `json:"SourceName"`
+	DestinationName string            `json:"DestinationName"`
+	SourceType      string            `json:"SourceType,omitempty"`
+	Action          string            `json:"Action"`
+	Meta            map[string]string `json:"Meta,omitempty"`
+	Precedence      int               `json:"Precedence,omitempty"`
+	CreateIndex     uint64            `json:"CreateIndex,omitempty"`
+	ModifyIndex     uint64            `json:"ModifyIndex,omitempty"`
+}
+
+// Intentions returns every intention
+func (client *Client) Intentions(options *QueryOptions) ([]Intention, QueryMeta, error) {
+	return client.IntentionsContext(client.context(), options)
+}
+
+// IntentionsContext is Intentions with ctx cancelling its requests
+func (client *Client) IntentionsContext(ctx context.Context, options *QueryOptions) ([]Intention, QueryMeta, error) {
+	intentions := []Intention{}
+	meta, err := client.query(ctx, "/connect/intentions", nil, options, &intentions)
+	return intentions, meta, err
+}
+
+// IntentionCreate creates the intention, returning its ID
+func (client *Client) IntentionCreate(intention Intention) (string, error) {
+	return client.IntentionCreateContext(client.context(), intention)
+}
+
+// IntentionCreateContext is IntentionCreate with ctx cancelling its requests
+func (client *Client) IntentionCreateContext(ctx context.Context, intention Intention) (string, error) {
+	var created struct {
+		ID string `json:"ID"`
+	}
+	err := client.sendJSON(ctx, http.MethodPost, "/connect/intentions", nil, intention, &created)
+	return created.ID, err
+}
+
+// IntentionDelete deletes the intention
+func (client *Client) IntentionDelete(id string) error {
+	return client.IntentionDeleteContext(client.context(), id)
+}
+
+// IntentionDeleteContext is IntentionDelete with ctx cancelling its requests
+func (client *Client) IntentionDeleteContext(ctx context.Context, id string) error {
+	return client.sendJSON(ctx, http.MethodDelete, "/connect/intentions/"+url.PathEscape(id), nil, nil, nil)
+}
+
+// IntentionCheck reports whether the intentions allow the source service to connect to the
+// destination service
+func (client *Client) IntentionCheck(source, destination string) (bool, error) {
+	return client.IntentionCheckContext(client.context(), source, destination)
+}
+
+// IntentionCheckContext is IntentionCheck with ctx cancelling its requests
+func (client *Client) IntentionCheckContext(ctx context.Context, source, destination string) (bool, error) {
+	query := url.Values{"source": {source}, "destination": {destination}}
+	var check struct {
+		Allowed bool `json:"Allowed"`
+	}
+	err := client.getJSON(ctx, "/connect/intentions/check", query, &check)
+	return check.Allowed, err
+}
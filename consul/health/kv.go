@@ -0,0 +1,223 @@
+package consul
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ErrKeyNotFound is returned when reading a key that doesn't exist
+var ErrKeyNotFound = errors.New("Key not found")
+
+// KVPair is a key in the consul KV store
+type KVPair struct {
+	Key         string `json:"Key"`
+	CreateIndex uint64 `json:"CreateIndex"`
+	ModifyIndex uint64 `json:"ModifyIndex"`
+	LockIndex   uint64 `json:"LockIndex"`
+	Flags       uint64 `json:"Flags"`
+	Value       []byte `json:"Value"`
+	Session     string `json:"Session"`
+}
+
+// KVGet returns the key, ErrKeyNotFound is returned if it doesn't exist
+func (client *Client) KVGet(key string, options *QueryOptions) (KVPair, QueryMeta, error) {
+	return client.KVGetContext(client.context(), key, options)
+}
+
+// KVGetContext is KVGet with ctx cancelling its requests
+func (client *Client) KVGetContext(ctx context.Context, key string, options *QueryOptions) (KVPair, QueryMeta, error) {
+	var pairs []KVPair
+	found, meta, err := client.getKV(ctx, key, nil, options, &pairs)
+	if err != nil {
+		return KVPair{}, meta, err
+	}
+	if !found || len(pairs) == 0 {
+		return KVPair{}, meta, ErrKeyNotFound
+	}
+	return pairs[0], meta, nil
+}
+
+// KVList returns every key starting with prefix, a prefix with no keys is not an error
+func (client *Client) KVList(prefix string, options *QueryOptions) ([]KVPair, QueryMeta, error) {
+	return client.KVListContext(client.context(), prefix, options)
+}
+
+// KVListContext is KVList with ctx cancelling its requests
+func (client *Client) KVListContext(ctx context.Context, prefix string, options *QueryOptions) ([]KVPair, QueryMeta, error) {
+	pairs := []KVPair{}
+	_, meta, err := client.getKV(ctx, prefix, url.Values{"recurse": {""}}, options, &pairs)
+	return pairs, meta, err
+}
+
+// KVKeys returns the names of the keys starting with prefix without their values. When separator
+// is set, keys are only listed up to the first separator after the prefix, so "/" lists a
+// single level of the tree.
+func (client *Client) KVKeys(prefix, separator string, options *QueryOptions) ([]string, QueryMeta, error) {
+	return client.KVKeysContext(client.context(), prefix, separator, options)
+}
+
+// KVKeysContext is KVKeys with ctx cancelling its requests
+func (client *Client) KVKeysContext(ctx context.Context, prefix, separator string, options *QueryOptions) ([]string, QueryMeta, error) {
+	query := url.Values{"keys": {""}}
+	if separator != "" {
+		query.Set("separator", separator)
+	}
+
+	keys := []string{}
+	_, meta, err := client.getKV(ctx, prefix, query, options, &keys)
+	return keys, meta, err
+}
+
+// KVPut sets the key's value and flags
+func (client *Client) KVPut(pair KVPair) error {
+	return client.KVPutContext(client.context(), pair)
+}
+
+// KVPutContext is KVPut with ctx cancelling its requests
+func (client *Client) KVPutContext(ctx context.Context, pair KVPair) error {
+	_, err := client.putKV(ctx, pair, nil)
+	return err
+}
+
+// KVCAS sets the key only if its ModifyIndex still matches pair's, returning whether it was
+// set. A ModifyIndex of 0 only sets the key if it doesn't exist.
+func (client *Client) KVCAS(pair KVPair) (bool, error) {
+	return client.KVCASContext(client.context(), pair)
+}
+
+// KVCASContext is KVCAS with ctx cancelling its requests
+func (client *Client) KVCASContext(ctx context.Context, pair KVPair) (bool, error) {
+	return client.putKV(ctx, pair, url.Values{"cas": {strconv.FormatUint(pair.ModifyIndex, 10)}})
+}
+
+// KVAcquire sets the key and locks it with pair.Session, returning whether the lock was acquired.
+// A key stays locked until it is released or the session is invalidated.
+func (client *Client) KVAcquire(pair KVPair) (bool, error) {
+	return client.KVAcquireContext(client.context(), pair)
+}
+
+// KVAcquireContext is KVAcquire with ctx cancelling its requests
+func (client *Client) KVAcquireContext(ctx context.Context, pair KVPair) (bool, error) {
+	return client.putKV(ctx, pair, url.Values{"acquire": {pair.Session}})
+}
+
+// KVRelease sets the key and unlocks it if it is locked by pair.Session, returning whether it was released
+func (client *Client) KVRelease(pair KVPair) (bool, error) {
+	return client.KVReleaseContext(client.context(), pair)
+}
+
+// KVReleaseContext is KVRelease with ctx cancelling its requests
+func (client *Client) KVReleaseContext(ctx context.Context, pair KVPair) (bool, error) {
+	return client.putKV(ctx, pair, url.Values{"release": {pair.Session}})
+}
+
+// KVDelete deletes the key, deleting a key that doesn't exist is not an error
+func (client *Client) KVDelete(key string) error {
+	return client.KVDeleteContext(client.context(), key)
+}
+
+// KVDeleteContext is KVDelete with ctx cancelling its requests
+func (client *Client) KVDeleteContext(ctx context.Context, key string) error {
+	_, err := client.deleteKV(ctx, key, nil)
+	return err
+}
+
+// KVDeleteTree deletes every key starting with prefix
+func (client *Client) KVDeleteTree(prefix string) error {
+	return client.KVDeleteTreeContext(client.context(), prefix)
+}
+
+// KVDeleteTreeContext is KVDeleteTree with ctx cancelling its requests
+func (client *Client) KVDeleteTreeContext(ctx context.Context, prefix string) error {
+	_, err := client.deleteKV(ctx, prefix, url.Values{"recurse": {""}})
+	return err
+}
+
+// KVDeleteCAS deletes the key only if its ModifyIndex still matches pair's, returning whether
+// it was deleted
+func (client *Client) KVDeleteCAS(pair KVPair) (bool, error) {
+	return client.KVDeleteCASContext(client.context(), pair)
+}
+
+// KVDeleteCASContext is KVDeleteCAS with ctx cancelling its requests
+func (client *Client) KVDeleteCASContext(ctx context.Context, pair KVPair) (bool, error) {
+	return client.deleteKV(ctx, pair.Key, url.Values{"cas": {strconv.FormatUint(pair.ModifyIndex, 10)}})
+}
+
+// getKV decodes the keys at path into result, returning false if there are none
+func (client *Client) getKV(ctx context.Context, key string, query url.Values, options *QueryOptions, result interface{}) (bool, QueryMeta, error) {
+	response, meta, err := client.queryResponse(ctx, kvPath(key), query, options)
+	if err != nil {
+		return false, meta, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
+		return false, meta, nil
+	}
+	if err := checkResponse(response); err != nil {
+		return false, meta, err
+	}
+
+	responseBytes, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return false, meta, err
+	}
+	return true, meta, json.Unmarshal(responseBytes, result)
+}
+
+func (client *Client) putKV(ctx context.Context, pair KVPair, query url.Values) (bool, error) {
+	if query == nil {
+		query = url.Values{}
+	}
+	if pair.Flags != 0 {
+		query.Set("flags", strconv.FormatUint(pair.Flags, 10))
+	}
+
+	response, err := client.httpPutResponse(ctx, kvPath(pair.Key), query, pair.Value)
+	if err != nil {
+		return false, err
+	}
+	defer response.Body.Close()
+	return decodeBool(response)
+}
+
+func (client *Client) deleteKV(ctx context.Context, key string, query url.Values) (bool, error) {
+	response, err := client.httpDeleteResponse(ctx, kvPath(key), query)
+	if err != nil {
+		return false, err
+	}
+	defer response.Body.Close()
+	return decodeBool(response)
+}
+
+// kvPath returns the API path of a key, escaping each of its segments
+func kvPath(key string) string {
+	segments := strings.Split(strings.TrimPrefix(key, "/"), "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return "/kv/" + strings.Join(segments, "/")
+}
+
+// decodeBool decodes the true or false returned by write endpoints
+func decodeBool(response *http.Response) (bool, error) {
+	if err := checkResponse(response); err != nil {
+		return false, err
+	}
+
+	responseBytes, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return false, err
+	}
+
+	var result bool
+	err = json.Unmarshal(responseBytes, &result)
+	return result, err
+}
@@ -0,0 +1,209 @@
+package consul
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rarmstrong73/go-utils/apierror"
+)
+
+var lockSessionTTL = "15s"
+var lockWaitTime = 15 * time.Second
+var lockRetryTime = 5 * time.Second
+
+// Lock is a mutual exclusion lock on a KV key following consul's leader election pattern. The
+// key is acquired with a session that is renewed in the background, so the lock is released
+// automatically if the holder dies.
+type Lock struct {
+	client *Client
+	key    string
+	value  []byte
+
+	mutex   sync.Mutex
+	session *Session
+	lost    chan struct{}
+	done    chan struct{}
+}
+
+// NewLock returns a lock on key, value is stored in the key while the lock is held
+func (client *Client) NewLock(key string, value []byte) *Lock {
+	return &Lock{client: client, key: key, value: value}
+}
+
+// Lock blocks until the lock is acquired and returns a channel that is closed when the lock is
+// unlocked or lost, either because its session is invalidated or the key is released by someone
+// else. It gives up with an error if stop is closed first.
+func (lock *Lock) Lock(stop <-chan struct{}) (<-chan struct{}, error) {
+	lock.mutex.Lock()
+	defer lock.mutex.Unlock()
+	if lock.session != nil {
+		return nil, fmt.Errorf("Lock %s is already held", lock.key)
+	}
+
+	sessionID, err := lock.client.SessionCreate(SessionEntry{
+		Name:     "Lock " + lock.key,
+		TTL:      lockSessionTTL,
+		Behavior: SessionBehaviorRelease,
+	})
+	if err != nil {
+		return nil, err
+	}
+	session, err := lock.client.KeepSessionAlive(sessionID)
+	if err != nil {
+		lock.client.SessionDestroy(sessionID)
+		return nil, err
+	}
+
+	ctx, cancel := lock.client.stopContext(stop)
+	acquired, err := lock.acquire(ctx, session, stop)
+	cancel()
+	if err != nil || !acquired {
+		session.Stop()
+		lock.client.SessionDestroy(sessionID)
+		if err == nil {
+			err = fmt.Errorf("Stopped waiting for lock %s", lock.key)
+		}
+		return nil, err
+	}
+
+	lock.session = session
+	lock.lost = make(chan struct{})
+	lock.done = make(chan struct{})
+	go lock.monitor(session, lock.lost, lock.done)
+	return lock.lost, nil
+}
+
+// Unlock releases the lock, unlocking a lock that isn't held is an error
+func (lock *Lock) Unlock() error {
+	lock.mutex.Lock()
+	defer lock.mutex.Unlock()
+	if lock.session == nil {
+		return fmt.Errorf("Lock %s is not held", lock.key)
+	}
+
+	session := lock.session
+	lock.session = nil
+	close(lock.done)
+	session.Stop()
+
+	_, err := lock.client.KVRelease(KVPair{Key: lock.key, Value: lock.value, Session: session.ID})
+	destroyErr := lock.client.SessionDestroy(session.ID)
+	if err != nil {
+		return err
+	}
+	return destroyErr
+}
+
+// acquire waits for the key to be free and tries to take it, until it succeeds or stop is closed
+func (lock *Lock) acquire(ctx context.Context, session *Session, stop <-chan struct{}) (bool, error) {
+	var index uint64
+	for {
+		select {
+		case <-stop:
+			return false, nil
+		case <-session.Lost():
+			return false, fmt.Errorf("Session for lock %s was lost", lock.key)
+		default:
+		}
+
+		pair, meta, err := lock.client.KVGetContext(ctx, lock.key, &QueryOptions{WaitIndex: index, WaitTime: lockWaitTime})
+		if err != nil && !errors.Is(err, ErrKeyNotFound) {
+			return false, stoppedOr(stop, err)
+		}
+		index = meta.LastIndex
+		if pair.Session != "" {
+			continue
+		}
+
+		acquired, err := lock.client.KVAcquireContext(ctx, KVPair{Key: lock.key, Value: lock.value, Session: session.ID})
+		if err != nil {
+			return false, stoppedOr(stop, err)
+		}
+		if acquired {
+			return true, nil
+		}
+
+		// The key was released recently and is still in its lock delay
+		select {
+		case <-stop:
+			return false, nil
+		case <-time.After(lockRetryTime):
+		}
+	}
+}
+
+// monitor closes lost once the session is lost or the key no longer belongs to it. The query
+// polling the key stops with it, its context being cancelled when monitor returns.
+func (lock *Lock) monitor(session *Session, lost, done chan struct{}) {
+	defer close(lost)
+
+	ctx, cancel := lock.client.stopContext(done)
+	defer cancel()
+
+	changed := make(chan bool)
+	go func() {
+		var index uint64
+		for {
+			pair, meta, err := lock.client.KVGetContext(ctx, lock.key, &QueryOptions{WaitIndex: index, WaitTime: lockWaitTime})
+			if ctx.Err() != nil || errors.Is(err, apierror.ErrClosed) {
+				// The lock was unlocked or lost, or the session is lost along with its client
+				return
+			}
+			held := true
+			if errors.Is(err, ErrKeyNotFound) || (err == nil && pair.Session != session.ID) {
+				held = false
+			} else if err != nil {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(time.Second):
+				}
+			}
+			index = meta.LastIndex
+
+			if !held {
+				select {
+				case changed <- true:
+				case <-ctx.Done():
+				}
+				return
+			}
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-session.Lost():
+		lock.client.logger.Info("Lost lock with its session", "key", lock.key)
+	case <-changed:
+		lock.client.logger.Info("Lost lock, the key was released or taken", "key", lock.key)
+	}
+}
+
+// stopContext returns a context of the client's that is cancelled once stop is closed. The
+// returned cancel function must be called once the context is no longer needed.
+func (client *Client) stopContext(stop <-chan struct{}) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(client.context())
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// stoppedOr returns nil if stop has been closed, so that requests cancelled by stopping aren't
+// reported as failures, and err otherwise
+func stoppedOr(stop <-chan struct{}, err error) error {
+	select {
+	case <-stop:
+		return nil
+	default:
+		return err
+	}
+}
@@ -0,0 +1,98 @@
+package consul_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rarmstrong73/go-utils/consul/consultest"
+	consul "github.com/rarmstrong73/go-utils/consul/health"
+)
+
+// newShortTTLAgent puts a proxy in front of agent that reports every renewed session with a
+// 200ms TTL, so sessions are renewed often and expire quickly. Every request fails once broken is
+// set.
+func newShortTTLAgent(agent *consultest.Server, broken *int32) *httptest.Server {
+	target, _ := url.Parse(agent.URL)
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.ModifyResponse = func(response *http.Response) error {
+		if !strings.HasPrefix(response.Request.URL.Path, "/v1/session/renew/") {
+			return nil
+		}
+		body, err := ioutil.ReadAll(response.Body)
+		if err != nil {
+			return err
+		}
+		body = bytes.ReplaceAll(body, []byte(`"TTL":"15s"`), []byte(`"TTL":"200ms"`))
+		response.Body = ioutil.NopCloser(bytes.NewReader(body))
+		response.ContentLength = int64(len(body))
+		response.Header.Set("Content-Length", strconv.Itoa(len(body)))
+		return nil
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(broken) == 1 {
+			http.Error(w, "No cluster leader", http.StatusInternalServerError)
+			return
+		}
+		proxy.ServeHTTP(w, r)
+	}))
+}
+
+// running reports whether a goroutine is running function, named as in stack traces
+func running(function string) bool {
+	stacks := make([]byte, 1<<20)
+	return bytes.Contains(stacks[:runtime.Stack(stacks, true)], []byte(function))
+}
+
+func TestMonitorsStopWithTheirSession(t *testing.T) {
+	tests := []struct {
+		name    string
+		monitor string
+		hold    func(client *consul.Client) (<-chan struct{}, error)
+	}{
+		{"lock", "health.(*Lock).monitor.func1", func(client *consul.Client) (<-chan struct{}, error) {
+			return client.NewLock("service/leader", []byte("me")).Lock(nil)
+		}},
+		{"semaphore", "health.(*Semaphore).monitor.func1", func(client *consul.Client) (<-chan struct{}, error) {
+			return client.NewSemaphore("service/workers", 2).Acquire(nil)
+		}},
+	}
+	for _, test := range tests {
+		agent := consultest.NewServer()
+		var broken int32
+		proxy := newShortTTLAgent(agent, &broken)
+		client := consul.NewClient(consul.Config{Address: strings.TrimPrefix(proxy.URL, "http://")})
+
+		lost, err := test.hold(client)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		// The session expires without its renewals getting through while the monitor's query is
+		// still waiting on the key, which the agent never releases
+		atomic.StoreInt32(&broken, 1)
+		select {
+		case <-lost:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: not lost after its session expired", test.name)
+		}
+
+		deadline := time.Now().Add(2 * time.Second)
+		for running(test.monitor) && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if running(test.monitor) {
+			t.Errorf("%s: the monitor's polling kept running after it was lost", test.name)
+		}
+		proxy.Close()
+		agent.Close()
+	}
+}
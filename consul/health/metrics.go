@@ -0,0 +1,67 @@
+package consul
+
+import (
+	"strings"
+	"time"
+)
+
+// RequestInfo describes a single HTTP request made by a client
+type RequestInfo struct {
+	// Method is the HTTP method of the request
+	Method string
+	// Endpoint names the API endpoint without the keys, names or IDs in its path, such as
+	// health/service or kv, so that it can be used as a metric label
+	Endpoint string
+	// Agent is the address of the agent the request went to
+	Agent      string
+	StatusCode int
+	Err        error
+	Duration   time.Duration
+	// Blocking is whether the request was a blocking query, whose Duration is mostly time spent
+	// waiting for the result to change rather than for the agent to answer
+	Blocking bool
+}
+
+// Metrics receives instrumentation from a client. Implementations must be safe for concurrent use.
+type Metrics interface {
+	// RequestDone is called after every request attempt, including ones failed over to another agent
+	RequestDone(info RequestInfo)
+	// WatchRestart is called when a watch's query fails and is retried, or its index goes
+	// backwards and it starts again from scratch. The watch is named like RequestInfo's Endpoint.
+	WatchRestart(watch string)
+}
+
+type noopMetrics struct{}
+
+func (noopMetrics) RequestDone(RequestInfo) {}
+func (noopMetrics) WatchRestart(string)     {}
+
+// SetMetrics sets where the client reports its instrumentation, nil turns reporting off. Clients
+// derived from the client afterwards report to the same place.
+func (client *Client) SetMetrics(metrics Metrics) {
+	if metrics == nil {
+		metrics = noopMetrics{}
+	}
+	client.metrics = metrics
+}
+
+// endpointName names the API path for RequestInfo, keeping the fixed segments that identify the
+// endpoint and dropping the keys, names and IDs that follow them
+func endpointName(path string) string {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	switch segments[0] {
+	case "kv", "query", "txn", "snapshot":
+		return segments[0]
+	}
+	if len(segments) > 2 && segments[0] == "agent" && (segments[1] == "service" || segments[1] == "check") {
+		// Such as agent/service/register and agent/check/pass
+		return strings.Join(segments[:3], "/")
+	}
+	if len(segments) > 2 && segments[0] == "connect" && segments[2] == "check" {
+		return "connect/intentions/check"
+	}
+	if len(segments) > 1 {
+		return strings.Join(segments[:2], "/")
+	}
+	return segments[0]
+}
@@ -0,0 +1,148 @@
+package consul
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+)
+
+// ErrQueryNotFound is returned when reading a prepared query that doesn't exist
+var ErrQueryNotFound = errors.New("Prepared query not found")
+
+// QueryFailover controls which other datacenters a prepared query fails over to when no healthy
+// instances are found in the local one. NearestN picks the nearest datacenters by round trip
+// time, which are tried before the explicitly listed Datacenters.
+type QueryFailover struct {
+	NearestN    int      `json:"NearestN,omitempty"`
+	Datacenters []string `json:"Datacenters,omitempty"`
+}
+
+// ServiceQuery is the service a prepared query looks up
+type ServiceQuery struct {
+	Service     string            `json:"Service"`
+	Failover    QueryFailover     `json:"Failover,omitempty"`
+	OnlyPassing bool              `json:"OnlyPassing,omitempty"`
+	Near        string            `json:"Near,omitempty"`
+	Tags        []string          `json:"Tags,omitempty"`
+	NodeMeta    map[string]string `json:"NodeMeta,omitempty"`
+}
+
+// QueryDNSOptions controls how a prepared query is answered over DNS
+type QueryDNSOptions struct {
+	TTL string `json:"TTL,omitempty"`
+}
+
+// PreparedQueryDefinition is a prepared query stored in consul
+type PreparedQueryDefinition struct {
+	ID      string          `json:"ID,omitempty"`
+	Name    string          `json:"Name,omitempty"`
+	Session string          `json:"Session,omitempty"`
+	Token   string          `json:"Token,omitempty"`
+	Service ServiceQuery    `json:"Service"`
+	DNS     QueryDNSOptions `json:"DNS,omitempty"`
+}
+
+// PreparedQueryExecuteResponse is the result of executing a prepared query
+type PreparedQueryExecuteResponse struct {
+	Service    string          `json:"Service"`
+	Nodes      []ServiceEntry  `json:"Nodes"`
+	DNS        QueryDNSOptions `json:"DNS"`
+	Datacenter string          `json:"Datacenter"`
+	Failovers  int             `json:"Failovers"`
+}
+
+// PreparedQueryCreate stores a new prepared query, returning its ID
+func (client *Client) PreparedQueryCreate(query PreparedQueryDefinition) (string, error) {
+	return client.PreparedQueryCreateContext(client.context(), query)
+}
+
+// PreparedQueryCreateContext is PreparedQueryCreate with ctx cancelling its requests
+func (client *Client) PreparedQueryCreateContext(ctx context.Context, query PreparedQueryDefinition) (string, error) {
+	var created struct {
+		ID string `json:"ID"`
+	}
+	err := client.sendJSON(ctx, http.MethodPost, "/query", nil, query, &created)
+	return created.ID, err
+}
+
+// PreparedQueryUpdate replaces the prepared query with query.ID
+func (client *Client) PreparedQueryUpdate(query PreparedQueryDefinition) error {
+	return client.PreparedQueryUpdateContext(client.context(), query)
+}
+
+// PreparedQueryUpdateContext is PreparedQueryUpdate with ctx cancelling its requests
+func (client *Client) PreparedQueryUpdateContext(ctx context.Context, query PreparedQueryDefinition) error {
+	return client.putJSON(ctx, "/query/"+url.PathEscape(query.ID), nil, query)
+}
+
+// PreparedQueryGet returns the prepared query, ErrQueryNotFound is returned if it doesn't exist
+func (client *Client) PreparedQueryGet(id string) (PreparedQueryDefinition, error) {
+	return client.PreparedQueryGetContext(client.context(), id)
+}
+
+// PreparedQueryGetContext is PreparedQueryGet with ctx cancelling its requests
+func (client *Client) PreparedQueryGetContext(ctx context.Context, id string) (PreparedQueryDefinition, error) {
+	response, _, err := client.queryResponse(ctx, "/query/"+url.PathEscape(id), nil, nil)
+	if err != nil {
+		return PreparedQueryDefinition{}, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
+		return PreparedQueryDefinition{}, ErrQueryNotFound
+	}
+	if err := checkResponse(response); err != nil {
+		return PreparedQueryDefinition{}, err
+	}
+
+	responseBytes, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return PreparedQueryDefinition{}, err
+	}
+
+	var queries []PreparedQueryDefinition
+	if err := json.Unmarshal(responseBytes, &queries); err != nil {
+		return PreparedQueryDefinition{}, err
+	}
+	if len(queries) == 0 {
+		return PreparedQueryDefinition{}, ErrQueryNotFound
+	}
+	return queries[0], nil
+}
+
+// PreparedQueryList returns every prepared query the token can see
+func (client *Client) PreparedQueryList() ([]PreparedQueryDefinition, error) {
+	return client.PreparedQueryListContext(client.context())
+}
+
+// PreparedQueryListContext is PreparedQueryList with ctx cancelling its requests
+func (client *Client) PreparedQueryListContext(ctx context.Context) ([]PreparedQueryDefinition, error) {
+	queries := []PreparedQueryDefinition{}
+	err := client.getJSON(ctx, "/query", nil, &queries)
+	return queries, err
+}
+
+// PreparedQueryDelete deletes the prepared query
+func (client *Client) PreparedQueryDelete(id string) error {
+	return client.PreparedQueryDeleteContext(client.context(), id)
+}
+
+// PreparedQueryDeleteContext is PreparedQueryDelete with ctx cancelling its requests
+func (client *Client) PreparedQueryDeleteContext(ctx context.Context, id string) error {
+	return client.sendJSON(ctx, http.MethodDelete, "/query/"+url.PathEscape(id), nil, nil, nil)
+}
+
+// PreparedQueryExecute runs the prepared query with the given ID or name
+func (client *Client) PreparedQueryExecute(idOrName string, options *QueryOptions) (PreparedQueryExecuteResponse, QueryMeta, error) {
+	return client.PreparedQueryExecuteContext(client.context(), idOrName, options)
+}
+
+// PreparedQueryExecuteContext is PreparedQueryExecute with ctx cancelling its requests
+func (client *Client) PreparedQueryExecuteContext(ctx context.Context, idOrName string, options *QueryOptions) (PreparedQueryExecuteResponse, QueryMeta, error) {
+	var result PreparedQueryExecuteResponse
+	meta, err := client.query(ctx, "/query/"+url.PathEscape(idOrName)+"/execute", nil, options, &result)
+	return result, meta, err
+}
@@ -0,0 +1,126 @@
+package consul
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// QueryOptions are the options of a read request, a nil *QueryOptions uses the defaults
+type QueryOptions struct {
+	// Datacenter overrides the client's datacenter
+	Datacenter string
+	// Token overrides the client's ACL token
+	Token string
+	// WaitIndex makes the request block until the result's index is greater than WaitIndex,
+	// usually the LastIndex of the previous QueryMeta
+	WaitIndex uint64
+	// WaitTime limits how long a blocking request waits, consul's default is 5 minutes
+	WaitTime time.Duration
+	// AllowStale lets any server answer the request, not only the leader
+	AllowStale bool
+	// RequireConsistent makes the leader confirm it is still the leader before answering
+	RequireConsistent bool
+	// Filter is a filter expression evaluated by the server on endpoints that support filtering,
+	// such as `ServiceName == "web" and Status != "passing"`
+	Filter string
+	// NodeMeta only returns results from nodes with all of these metadata values, on the catalog
+	// and health endpoints
+	NodeMeta map[string]string
+}
+
+// QueryMeta is the metadata returned by a read request
+type QueryMeta struct {
+	// LastIndex is the index of the result, pass it as WaitIndex to wait for the result to change
+	LastIndex uint64
+	// LastContact is how long since the server answering last heard from the leader
+	LastContact time.Duration
+	// KnownLeader is whether the server answering knows of a leader
+	KnownLeader bool
+	// Agent is the address of the agent that answered
+	Agent string
+}
+
+// apply adds the options to the request's query and headers
+func (options *QueryOptions) apply(query url.Values, header http.Header) {
+	if options == nil {
+		return
+	}
+	if options.Datacenter != "" {
+		query.Set("dc", options.Datacenter)
+	}
+	if options.Token != "" {
+		header.Set("X-Consul-Token", options.Token)
+	}
+	if options.WaitIndex != 0 {
+		query.Set("index", strconv.FormatUint(options.WaitIndex, 10))
+	}
+	if options.WaitTime != 0 {
+		query.Set("wait", fmt.Sprintf("%dms", options.WaitTime/time.Millisecond))
+	}
+	if options.AllowStale {
+		query.Set("stale", "")
+	}
+	if options.RequireConsistent {
+		query.Set("consistent", "")
+	}
+	if options.Filter != "" {
+		query.Set("filter", options.Filter)
+	}
+	for key, value := range options.NodeMeta {
+		query.Add("node-meta", key+":"+value)
+	}
+}
+
+// query decodes the response to a GET of the API path into result
+func (client *Client) query(ctx context.Context, path string, query url.Values, options *QueryOptions, result interface{}) (QueryMeta, error) {
+	response, meta, err := client.queryResponse(ctx, path, query, options)
+	if err != nil {
+		return meta, err
+	}
+	defer response.Body.Close()
+
+	if err := checkResponse(response); err != nil {
+		return meta, err
+	}
+
+	responseBytes, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return meta, err
+	}
+	return meta, json.Unmarshal(responseBytes, result)
+}
+
+// queryResponse sends a GET of the API path with the options applied, the caller must close the
+// response's body
+func (client *Client) queryResponse(ctx context.Context, path string, query url.Values, options *QueryOptions) (*http.Response, QueryMeta, error) {
+	if query == nil {
+		query = url.Values{}
+	}
+	header := http.Header{}
+	options.apply(query, header)
+
+	response, err := client.doHTTPResponse(ctx, http.MethodGet, path, query, header, nil)
+	if err != nil {
+		return nil, QueryMeta{}, err
+	}
+	return response, parseQueryMeta(response), nil
+}
+
+// parseQueryMeta reads the query metadata from the response's headers
+func parseQueryMeta(response *http.Response) QueryMeta {
+	var meta QueryMeta
+	meta.LastIndex, _ = strconv.ParseUint(response.Header.Get("X-Consul-Index"), 10, 64)
+	lastContact, _ := strconv.ParseUint(response.Header.Get("X-Consul-LastContact"), 10, 64)
+	meta.LastContact = time.Duration(lastContact) * time.Millisecond
+	meta.KnownLeader = response.Header.Get("X-Consul-KnownLeader") == "true"
+	if response.Request != nil {
+		meta.Agent = response.Request.URL.Host
+	}
+	return meta
+}
@@ -0,0 +1,97 @@
+package consul
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// OnChange calls callback with each event from the watch until stop is closed or callback fails,
+// then stops the watch. It returns the callback's error, or nil once stopped.
+//
+//	watch := client.WatchPrefix("config/web/")
+//	err := consul.OnChange(watch, stop, consul.RenderTemplate(tmpl, "/etc/web.conf", "/run/web.pid", syscall.SIGHUP))
+func OnChange(watch *Watch, stop <-chan struct{}, callback func(WatchEvent) error) error {
+	defer watch.Stop()
+	for {
+		select {
+		case <-stop:
+			return nil
+		case event, ok := <-watch.Events():
+			if !ok {
+				return nil
+			}
+			if err := callback(event); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// RenderTemplate returns a callback for OnChange that renders tmpl with each event into the
+// file at path, replacing it atomically. When the rendered file changes and pidFile is set, the
+// process whose PID is in pidFile is sent signal so it can reload the file.
+func RenderTemplate(tmpl *template.Template, path, pidFile string, signal os.Signal) func(WatchEvent) error {
+	return func(event WatchEvent) error {
+		var rendered bytes.Buffer
+		if err := tmpl.Execute(&rendered, event); err != nil {
+			return err
+		}
+
+		existing, err := ioutil.ReadFile(path)
+		if err == nil && bytes.Equal(existing, rendered.Bytes()) {
+			return nil
+		}
+
+		temporary, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+		if err != nil {
+			return err
+		}
+		defer os.Remove(temporary.Name())
+		if _, err := temporary.Write(rendered.Bytes()); err != nil {
+			temporary.Close()
+			return err
+		}
+		if err := temporary.Close(); err != nil {
+			return err
+		}
+		mode := os.FileMode(0644)
+		if info, err := os.Stat(path); err == nil {
+			mode = info.Mode()
+		}
+		if err := os.Chmod(temporary.Name(), mode); err != nil {
+			return err
+		}
+		if err := os.Rename(temporary.Name(), path); err != nil {
+			return err
+		}
+
+		if pidFile == "" {
+			return nil
+		}
+		return signalPIDFile(pidFile, signal)
+	}
+}
+
+// signalPIDFile sends signal to the process whose PID is in pidFile
+func signalPIDFile(pidFile string, signal os.Signal) error {
+	pidBytes, err := ioutil.ReadFile(pidFile)
+	if err != nil {
+		return err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(pidBytes)))
+	if err != nil {
+		return fmt.Errorf("Invalid PID in %s: %v", pidFile, err)
+	}
+
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return process.Signal(signal)
+}
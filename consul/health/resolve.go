@@ -0,0 +1,74 @@
+package consul
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// ResolveService returns the addresses of the instances of the named service whose checks are
+// all passing, only those with tag unless it is empty. An instance's service address is used
+// when it registered one and its node's address otherwise.
+func (client *Client) ResolveService(name, tag string) ([]net.TCPAddr, error) {
+	return client.ResolveServiceContext(client.context(), name, tag)
+}
+
+// ResolveServiceContext is ResolveService with ctx cancelling its requests
+func (client *Client) ResolveServiceContext(ctx context.Context, name, tag string) ([]net.TCPAddr, error) {
+	entries, _, err := client.HealthServiceContext(ctx, name, tag, true, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	addresses := make([]net.TCPAddr, 0, len(entries))
+	for _, entry := range entries {
+		address, err := net.ResolveTCPAddr("tcp", entryAddress(entry))
+		if err != nil {
+			return nil, err
+		}
+		addresses = append(addresses, *address)
+	}
+	return addresses, nil
+}
+
+// entryAddress returns host:port of a service instance, preferring the service address over the node's
+func entryAddress(entry ServiceEntry) string {
+	host := entry.Service.Address
+	if host == "" {
+		host = entry.Node.Address
+	}
+	return net.JoinHostPort(host, strconv.Itoa(entry.Service.Port))
+}
+
+// RoundRobin hands out addresses in turn, it is safe for concurrent use
+type RoundRobin struct {
+	mutex     sync.Mutex
+	addresses []net.TCPAddr
+	next      int
+}
+
+// NewRoundRobin returns a picker over addresses, usually the result of ResolveService
+func NewRoundRobin(addresses []net.TCPAddr) *RoundRobin {
+	return &RoundRobin{addresses: addresses}
+}
+
+// Next returns the next address, false if there are none
+func (roundRobin *RoundRobin) Next() (net.TCPAddr, bool) {
+	roundRobin.mutex.Lock()
+	defer roundRobin.mutex.Unlock()
+	if len(roundRobin.addresses) == 0 {
+		return net.TCPAddr{}, false
+	}
+
+	address := roundRobin.addresses[roundRobin.next%len(roundRobin.addresses)]
+	roundRobin.next = (roundRobin.next + 1) % len(roundRobin.addresses)
+	return address, true
+}
+
+// Update replaces the addresses handed out, for when the service has been resolved again
+func (roundRobin *RoundRobin) Update(addresses []net.TCPAddr) {
+	roundRobin.mutex.Lock()
+	defer roundRobin.mutex.Unlock()
+	roundRobin.addresses = addresses
+}
@@ -0,0 +1,296 @@
+package consul
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rarmstrong73/go-utils/apierror"
+)
+
+const semaphoreLockKey = ".lock"
+
+// Semaphore is a counting semaphore under a KV prefix following consul's semaphore pattern. Each
+// contender holds a key under the prefix locked with its own session, and the holders of the
+// slots are kept in a coordination key updated with check-and-set. Holders whose session is
+// invalidated lose their slot the next time the semaphore is updated.
+type Semaphore struct {
+	client *Client
+	prefix string
+	limit  int
+
+	mutex   sync.Mutex
+	session *Session
+	lost    chan struct{}
+	done    chan struct{}
+}
+
+type semaphoreState struct {
+	Limit   int             `json:"Limit"`
+	Holders map[string]bool `json:"Holders"`
+}
+
+// NewSemaphore returns a semaphore under prefix with limit slots
+func (client *Client) NewSemaphore(prefix string, limit int) *Semaphore {
+	return &Semaphore{client: client, prefix: strings.TrimSuffix(prefix, "/") + "/", limit: limit}
+}
+
+// Acquire blocks until a slot is taken and returns a channel that is closed when the slot is
+// released or lost. It gives up with an error if stop is closed first.
+func (semaphore *Semaphore) Acquire(stop <-chan struct{}) (<-chan struct{}, error) {
+	semaphore.mutex.Lock()
+	defer semaphore.mutex.Unlock()
+	if semaphore.session != nil {
+		return nil, fmt.Errorf("Semaphore %s is already held", semaphore.prefix)
+	}
+
+	sessionID, err := semaphore.client.SessionCreate(SessionEntry{
+		Name:     "Semaphore " + semaphore.prefix,
+		TTL:      lockSessionTTL,
+		Behavior: SessionBehaviorDelete,
+	})
+	if err != nil {
+		return nil, err
+	}
+	session, err := semaphore.client.KeepSessionAlive(sessionID)
+	if err != nil {
+		semaphore.client.SessionDestroy(sessionID)
+		return nil, err
+	}
+
+	ctx, cancel := semaphore.client.stopContext(stop)
+	acquired, err := semaphore.acquire(ctx, session, stop)
+	cancel()
+	if err != nil || !acquired {
+		session.Stop()
+		semaphore.client.SessionDestroy(sessionID)
+		if err == nil {
+			err = fmt.Errorf("Stopped waiting for semaphore %s", semaphore.prefix)
+		}
+		return nil, err
+	}
+
+	semaphore.session = session
+	semaphore.lost = make(chan struct{})
+	semaphore.done = make(chan struct{})
+	go semaphore.monitor(session, semaphore.lost, semaphore.done)
+	return semaphore.lost, nil
+}
+
+// Release gives up the slot, releasing a semaphore that isn't held is an error
+func (semaphore *Semaphore) Release() error {
+	semaphore.mutex.Lock()
+	defer semaphore.mutex.Unlock()
+	if semaphore.session == nil {
+		return fmt.Errorf("Semaphore %s is not held", semaphore.prefix)
+	}
+
+	session := semaphore.session
+	semaphore.session = nil
+	close(semaphore.done)
+	session.Stop()
+
+	err := semaphore.update(func(state *semaphoreState) bool {
+		if !state.Holders[session.ID] {
+			return false
+		}
+		delete(state.Holders, session.ID)
+		return true
+	})
+	semaphore.client.KVDelete(semaphore.prefix + session.ID)
+	destroyErr := semaphore.client.SessionDestroy(session.ID)
+	if err != nil {
+		return err
+	}
+	return destroyErr
+}
+
+// Holders returns the sessions holding a slot, leaving out any that have been invalidated
+func (semaphore *Semaphore) Holders() ([]string, error) {
+	pairs, _, err := semaphore.client.KVList(semaphore.prefix, nil)
+	if err != nil {
+		return nil, err
+	}
+	state, live, _, err := semaphore.parse(pairs)
+	if err != nil {
+		return nil, err
+	}
+
+	holders := []string{}
+	for holder := range state.Holders {
+		if live[holder] {
+			holders = append(holders, holder)
+		}
+	}
+	return holders, nil
+}
+
+// acquire registers the session as a contender and waits for a free slot
+func (semaphore *Semaphore) acquire(ctx context.Context, session *Session, stop <-chan struct{}) (bool, error) {
+	contender := KVPair{Key: semaphore.prefix + session.ID, Session: session.ID}
+	acquired, err := semaphore.client.KVAcquire(contender)
+	if err != nil {
+		return false, err
+	}
+	if !acquired {
+		return false, fmt.Errorf("Failed to register as a contender for semaphore %s", semaphore.prefix)
+	}
+
+	var index uint64
+	for {
+		select {
+		case <-stop:
+			semaphore.client.KVDelete(contender.Key)
+			return false, nil
+		case <-session.Lost():
+			return false, fmt.Errorf("Session for semaphore %s was lost", semaphore.prefix)
+		default:
+		}
+
+		taken := false
+		err := semaphore.update(func(state *semaphoreState) bool {
+			if len(state.Holders) >= state.Limit {
+				return false
+			}
+			state.Holders[session.ID] = true
+			taken = true
+			return true
+		})
+		if err != nil {
+			semaphore.client.KVDelete(contender.Key)
+			return false, err
+		}
+		if taken {
+			return true, nil
+		}
+
+		// Wait for something under the prefix to change before trying again
+		_, meta, err := semaphore.client.KVListContext(ctx, semaphore.prefix, &QueryOptions{WaitIndex: index, WaitTime: lockWaitTime})
+		if err != nil && stoppedOr(stop, err) != nil {
+			time.Sleep(time.Second)
+			continue
+		}
+		index = meta.LastIndex
+	}
+}
+
+// update applies change to the coordination key with check-and-set, retrying on conflicts.
+// Holders whose session has been invalidated are dropped before change sees the state. change
+// returns false to leave the state as it is.
+func (semaphore *Semaphore) update(change func(state *semaphoreState) bool) error {
+	for {
+		pairs, _, err := semaphore.client.KVList(semaphore.prefix, nil)
+		if err != nil {
+			return err
+		}
+		state, live, modifyIndex, err := semaphore.parse(pairs)
+		if err != nil {
+			return err
+		}
+
+		pruned := false
+		for holder := range state.Holders {
+			if !live[holder] {
+				delete(state.Holders, holder)
+				pruned = true
+			}
+		}
+
+		if !change(&state) && !pruned {
+			return nil
+		}
+
+		stateBytes, err := json.Marshal(state)
+		if err != nil {
+			return err
+		}
+		set, err := semaphore.client.KVCAS(KVPair{
+			Key:         semaphore.prefix + semaphoreLockKey,
+			Value:       stateBytes,
+			ModifyIndex: modifyIndex,
+		})
+		if err != nil || set {
+			return err
+		}
+	}
+}
+
+// parse splits the keys under the prefix into the coordination state, the set of contenders
+// with a live session and the modify index of the coordination key
+func (semaphore *Semaphore) parse(pairs []KVPair) (semaphoreState, map[string]bool, uint64, error) {
+	state := semaphoreState{Limit: semaphore.limit, Holders: map[string]bool{}}
+	live := map[string]bool{}
+	var modifyIndex uint64
+
+	for _, pair := range pairs {
+		name := strings.TrimPrefix(pair.Key, semaphore.prefix)
+		if name != semaphoreLockKey {
+			if pair.Session != "" && pair.Session == name {
+				live[name] = true
+			}
+			continue
+		}
+
+		modifyIndex = pair.ModifyIndex
+		if err := json.Unmarshal(pair.Value, &state); err != nil {
+			return semaphoreState{}, nil, 0, fmt.Errorf("Invalid semaphore state at %s: %v", pair.Key, err)
+		}
+		if state.Limit != semaphore.limit {
+			return semaphoreState{}, nil, 0, fmt.Errorf("Semaphore %s has a limit of %d, not %d", semaphore.prefix, state.Limit, semaphore.limit)
+		}
+		if state.Holders == nil {
+			state.Holders = map[string]bool{}
+		}
+	}
+	return state, live, modifyIndex, nil
+}
+
+// monitor closes lost once the session is lost or the semaphore no longer lists it as a holder
+func (semaphore *Semaphore) monitor(session *Session, lost, done chan struct{}) {
+	defer close(lost)
+
+	ctx, cancel := semaphore.client.stopContext(done)
+	defer cancel()
+
+	changed := make(chan bool)
+	go func() {
+		var index uint64
+		for {
+			pairs, meta, err := semaphore.client.KVListContext(ctx, semaphore.prefix, &QueryOptions{WaitIndex: index, WaitTime: lockWaitTime})
+			if ctx.Err() != nil || errors.Is(err, apierror.ErrClosed) {
+				// The semaphore was released or lost, or the session is lost along with its client
+				return
+			}
+			held := true
+			if err == nil {
+				state, _, _, parseErr := semaphore.parse(pairs)
+				held = parseErr != nil || state.Holders[session.ID]
+			} else {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(time.Second):
+				}
+			}
+			index = meta.LastIndex
+
+			if !held {
+				select {
+				case changed <- true:
+				case <-ctx.Done():
+				}
+				return
+			}
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-session.Lost():
+	case <-changed:
+	}
+}
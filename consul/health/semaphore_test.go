@@ -0,0 +1,82 @@
+package consul_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rarmstrong73/go-utils/consul/consultest"
+)
+
+func TestSemaphoreLimitsHolders(t *testing.T) {
+	agent := consultest.NewServer()
+	defer agent.Close()
+	client := agent.Client()
+	a, b, c := client.NewSemaphore("workers", 2), client.NewSemaphore("workers", 2), client.NewSemaphore("workers", 2)
+
+	lostA, err := a.Acquire(nil)
+	if err != nil {
+		t.Fatalf("Acquire(a): %v", err)
+	}
+	if _, err := b.Acquire(nil); err != nil {
+		t.Fatalf("Acquire(b): %v", err)
+	}
+	defer b.Release()
+	acquired := make(chan error, 1)
+	go func() {
+		_, err := c.Acquire(nil)
+		acquired <- err
+	}()
+	select {
+	case err := <-acquired:
+		t.Fatalf("Acquire(c) = %v with both slots held", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+	if holders, err := a.Holders(); err != nil || len(holders) != 2 {
+		t.Errorf("Holders = %v, %v, want a and b", holders, err)
+	}
+
+	if err := a.Release(); err != nil {
+		t.Fatalf("Release(a): %v", err)
+	}
+	select {
+	case <-lostA:
+	case <-time.After(time.Second):
+		t.Error("a's channel wasn't closed by releasing it")
+	}
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Fatalf("Acquire(c): %v", err)
+		}
+		defer c.Release()
+	case <-time.After(5 * time.Second):
+		t.Fatal("Acquire(c) still blocked after a released its slot")
+	}
+	if holders, err := c.Holders(); err != nil || len(holders) != 2 {
+		t.Errorf("Holders = %v, %v, want b and c", holders, err)
+	}
+	if err := a.Release(); err == nil {
+		t.Error("releasing a again succeeded, want an error for a semaphore that isn't held")
+	}
+}
+
+func TestSemaphoreStopsWaiting(t *testing.T) {
+	agent := consultest.NewServer()
+	defer agent.Close()
+	client := agent.Client()
+	holder := client.NewSemaphore("workers", 1)
+	if _, err := holder.Acquire(nil); err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+	defer holder.Release()
+
+	stop := make(chan struct{})
+	time.AfterFunc(100*time.Millisecond, func() { close(stop) })
+	if _, err := client.NewSemaphore("workers", 1).Acquire(stop); err == nil {
+		t.Fatal("Acquire succeeded with the only slot held")
+	}
+	sessions, _, err := client.SessionList(nil)
+	if err != nil || len(sessions) != 1 {
+		t.Errorf("sessions = %+v, %v, want only the holder's left", sessions, err)
+	}
+}
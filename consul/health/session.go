@@ -0,0 +1,225 @@
+package consul
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Session behaviors, deciding what happens to the locks a session holds when it is invalidated
+const (
+	SessionBehaviorRelease = "release"
+	SessionBehaviorDelete  = "delete"
+)
+
+// ErrSessionNotFound is returned when renewing or reading a session that has been invalidated
+var ErrSessionNotFound = errors.New("Session not found")
+
+// SessionEntry is a consul session
+type SessionEntry struct {
+	ID   string `json:"ID,omitempty"`
+	Name string `json:"Name,omitempty"`
+	// Node is the node the session is tied to, defaulting to the agent's node
+	Node string `json:"Node,omitempty"`
+	// Checks are the health checks that invalidate the session when they go critical, defaulting
+	// to the node's serfHealth check
+	Checks []string `json:"Checks,omitempty"`
+	// LockDelay is how long locks released by the session can't be reacquired, defaulting to 15s
+	LockDelay time.Duration `json:"LockDelay,omitempty"`
+	// Behavior is one of the SessionBehavior constants, defaulting to release
+	Behavior string `json:"Behavior,omitempty"`
+	// TTL invalidates the session unless it is renewed within the TTL, a string such as "15s"
+	TTL         string `json:"TTL,omitempty"`
+	CreateIndex uint64 `json:"CreateIndex,omitempty"`
+	ModifyIndex uint64 `json:"ModifyIndex,omitempty"`
+}
+
+// SessionCreate creates a session, returning its ID
+func (client *Client) SessionCreate(session SessionEntry) (string, error) {
+	return client.SessionCreateContext(client.context(), session)
+}
+
+// SessionCreateContext is SessionCreate with ctx cancelling its requests
+func (client *Client) SessionCreateContext(ctx context.Context, session SessionEntry) (string, error) {
+	var created struct {
+		ID string `json:"ID"`
+	}
+	err := client.writeJSON(ctx, "/session/create", nil, session, &created)
+	return created.ID, err
+}
+
+// SessionDestroy invalidates the session
+func (client *Client) SessionDestroy(id string) error {
+	return client.SessionDestroyContext(client.context(), id)
+}
+
+// SessionDestroyContext is SessionDestroy with ctx cancelling its requests
+func (client *Client) SessionDestroyContext(ctx context.Context, id string) error {
+	return client.putJSON(ctx, "/session/destroy/"+url.PathEscape(id), nil, nil)
+}
+
+// SessionRenew resets the session's TTL, ErrSessionNotFound is returned if it has already expired
+func (client *Client) SessionRenew(id string) (SessionEntry, error) {
+	return client.SessionRenewContext(client.context(), id)
+}
+
+// SessionRenewContext is SessionRenew with ctx cancelling its requests
+func (client *Client) SessionRenewContext(ctx context.Context, id string) (SessionEntry, error) {
+	response, err := client.httpPutResponse(ctx, "/session/renew/"+url.PathEscape(id), nil, nil)
+	if err != nil {
+		return SessionEntry{}, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
+		return SessionEntry{}, ErrSessionNotFound
+	}
+	if err := checkResponse(response); err != nil {
+		return SessionEntry{}, err
+	}
+
+	var sessions []SessionEntry
+	responseBytes, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return SessionEntry{}, err
+	}
+	if err := json.Unmarshal(responseBytes, &sessions); err != nil {
+		return SessionEntry{}, err
+	}
+	if len(sessions) == 0 {
+		return SessionEntry{}, ErrSessionNotFound
+	}
+	return sessions[0], nil
+}
+
+// SessionInfo returns the session, ErrSessionNotFound is returned if it doesn't exist
+func (client *Client) SessionInfo(id string, options *QueryOptions) (SessionEntry, QueryMeta, error) {
+	return client.SessionInfoContext(client.context(), id, options)
+}
+
+// SessionInfoContext is SessionInfo with ctx cancelling its requests
+func (client *Client) SessionInfoContext(ctx context.Context, id string, options *QueryOptions) (SessionEntry, QueryMeta, error) {
+	var sessions []SessionEntry
+	meta, err := client.query(ctx, "/session/info/"+url.PathEscape(id), nil, options, &sessions)
+	if err != nil {
+		return SessionEntry{}, meta, err
+	}
+	if len(sessions) == 0 {
+		return SessionEntry{}, meta, ErrSessionNotFound
+	}
+	return sessions[0], meta, nil
+}
+
+// SessionList returns every session in the datacenter
+func (client *Client) SessionList(options *QueryOptions) ([]SessionEntry, QueryMeta, error) {
+	return client.SessionListContext(client.context(), options)
+}
+
+// SessionListContext is SessionList with ctx cancelling its requests
+func (client *Client) SessionListContext(ctx context.Context, options *QueryOptions) ([]SessionEntry, QueryMeta, error) {
+	sessions := []SessionEntry{}
+	meta, err := client.query(ctx, "/session/list", nil, options, &sessions)
+	return sessions, meta, err
+}
+
+// SessionNode returns the sessions tied to the named node
+func (client *Client) SessionNode(node string, options *QueryOptions) ([]SessionEntry, QueryMeta, error) {
+	return client.SessionNodeContext(client.context(), node, options)
+}
+
+// SessionNodeContext is SessionNode with ctx cancelling its requests
+func (client *Client) SessionNodeContext(ctx context.Context, node string, options *QueryOptions) ([]SessionEntry, QueryMeta, error) {
+	sessions := []SessionEntry{}
+	meta, err := client.query(ctx, "/session/node/"+url.PathEscape(node), nil, options, &sessions)
+	return sessions, meta, err
+}
+
+// Session is a session being renewed in the background
+type Session struct {
+	ID string
+
+	client   *Client
+	lost     chan struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// KeepSessionAlive renews the session once and then keeps renewing it in the background at half
+// of its TTL until Stop is called or the session is lost
+func (client *Client) KeepSessionAlive(id string) (*Session, error) {
+	entry, err := client.SessionRenew(id)
+	if err != nil {
+		return nil, err
+	}
+	ttl, err := time.ParseDuration(entry.TTL)
+	if err != nil || ttl <= 0 {
+		return nil, fmt.Errorf("Session %s has no TTL to renew", id)
+	}
+
+	session := &Session{
+		ID:     id,
+		client: client,
+		lost:   make(chan struct{}),
+		stop:   make(chan struct{}),
+	}
+	go session.keepAlive(ttl)
+	return session, nil
+}
+
+// Lost returns a channel that is closed when the session is invalidated, expires without being
+// renewed or its client is closed
+func (session *Session) Lost() <-chan struct{} {
+	return session.lost
+}
+
+// Stop stops renewing the session, it is left to expire unless destroyed
+func (session *Session) Stop() {
+	session.stopOnce.Do(func() { close(session.stop) })
+}
+
+func (session *Session) keepAlive(ttl time.Duration) {
+	deadline := time.Now().Add(ttl)
+	interval := ttl / 2
+
+	for {
+		select {
+		case <-session.stop:
+			return
+		case <-session.client.httpClient.Closing():
+			session.client.logger.Info("Client was closed, session can't be renewed", "session", session.ID)
+			close(session.lost)
+			return
+		case <-time.After(interval):
+		}
+
+		entry, err := session.client.SessionRenew(session.ID)
+		if errors.Is(err, ErrSessionNotFound) {
+			session.client.logger.Info("Session was invalidated", "session", session.ID)
+			close(session.lost)
+			return
+		}
+		if err == nil {
+			if renewed, parseErr := time.ParseDuration(entry.TTL); parseErr == nil && renewed > 0 {
+				ttl = renewed
+			}
+			deadline = time.Now().Add(ttl)
+			interval = ttl / 2
+			continue
+		}
+
+		if time.Now().After(deadline) {
+			session.client.logger.Error("Session expired without being renewed", "session", session.ID, "error", err)
+			close(session.lost)
+			return
+		}
+		session.client.logger.Debug("Failed to renew session", "session", session.ID, "error", err)
+		// Retry quickly until the session would have expired
+		interval = time.Second
+	}
+}
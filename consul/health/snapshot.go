@@ -0,0 +1,51 @@
+package consul
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"github.com/rarmstrong73/go-utils/internal/httpclient"
+)
+
+// Snapshot writes a snapshot of the servers' state to w as a gzipped tar archive, returning the
+// index it was taken at. Snapshots need a management token.
+func (client *Client) Snapshot(w io.Writer, options *QueryOptions) (QueryMeta, error) {
+	return client.SnapshotContext(client.context(), w, options)
+}
+
+// SnapshotContext is Snapshot with ctx cancelling its requests
+func (client *Client) SnapshotContext(ctx context.Context, w io.Writer, options *QueryOptions) (QueryMeta, error) {
+	response, meta, err := client.queryResponse(httpclient.Streaming(ctx), "/snapshot", nil, options)
+	if err != nil {
+		return QueryMeta{}, err
+	}
+	defer response.Body.Close()
+
+	if err := checkResponse(response); err != nil {
+		return meta, err
+	}
+	_, err = io.Copy(w, response.Body)
+	return meta, err
+}
+
+// SnapshotRestore replaces the servers' state with the snapshot read from r, as written by
+// Snapshot. Restoring needs a management token.
+func (client *Client) SnapshotRestore(r io.Reader) error {
+	return client.SnapshotRestoreContext(client.context(), r)
+}
+
+// SnapshotRestoreContext is SnapshotRestore with ctx cancelling its requests
+func (client *Client) SnapshotRestoreContext(ctx context.Context, r io.Reader) error {
+	request, err := client.newRequest(httpclient.Streaming(ctx), http.MethodPut, "/snapshot", nil, nil, r, client.currentAgent())
+	if err != nil {
+		return err
+	}
+
+	response, err := client.do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	return checkResponse(response)
+}
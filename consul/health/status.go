@@ -0,0 +1,27 @@
+package consul
+
+import "context"
+
+// StatusLeader returns the raft address of the datacenter's leader, empty if there is none
+func (client *Client) StatusLeader() (string, error) {
+	return client.StatusLeaderContext(client.context())
+}
+
+// StatusLeaderContext is StatusLeader with ctx cancelling its requests
+func (client *Client) StatusLeaderContext(ctx context.Context) (string, error) {
+	var leader string
+	err := client.getJSON(ctx, "/status/leader", nil, &leader)
+	return leader, err
+}
+
+// StatusPeers returns the raft addresses of the datacenter's servers
+func (client *Client) StatusPeers() ([]string, error) {
+	return client.StatusPeersContext(client.context())
+}
+
+// StatusPeersContext is StatusPeers with ctx cancelling its requests
+func (client *Client) StatusPeersContext(ctx context.Context) ([]string, error) {
+	peers := []string{}
+	err := client.getJSON(ctx, "/status/peers", nil, &peers)
+	return peers, err
+}
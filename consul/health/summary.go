@@ -0,0 +1,157 @@
+package consul
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// ServiceHealth counts the instances of a service by their worst check
+type ServiceHealth struct {
+	Name     string
+	Passing  int
+	Warning  int
+	Critical int
+}
+
+// HealthSummary is the health of every service in the datacenter
+type HealthSummary struct {
+	Services []ServiceHealth
+	// Flapping are the checks that changed status repeatedly over the recent summaries
+	Flapping []HealthNode
+}
+
+// HealthSummary counts the passing, warning and critical instances of every service with checks
+// in the datacenter. An instance is as healthy as the worst of its own checks and its node's
+// checks. A single summary can't tell which checks are flapping, see HealthSummarizer.
+func (client *Client) HealthSummary() (HealthSummary, error) {
+	return client.HealthSummaryContext(client.context())
+}
+
+// HealthSummaryContext is HealthSummary with ctx cancelling its requests
+func (client *Client) HealthSummaryContext(ctx context.Context) (HealthSummary, error) {
+	checks, _, err := client.HealthStateContext(ctx, HealthAny, nil)
+	if err != nil {
+		return HealthSummary{}, err
+	}
+	return HealthSummary{Services: summarizeServices(checks), Flapping: []HealthNode{}}, nil
+}
+
+// HealthSummarizer produces health summaries, remembering the status of every check over the
+// last window summaries so that checks changing status at least threshold times in that window
+// are reported as flapping
+type HealthSummarizer struct {
+	client    *Client
+	window    int
+	threshold int
+
+	mutex   sync.Mutex
+	history map[string][]string
+}
+
+// NewHealthSummarizer returns a summarizer that remembers window summaries and reports checks
+// that changed status threshold times within them as flapping
+func (client *Client) NewHealthSummarizer(window, threshold int) *HealthSummarizer {
+	return &HealthSummarizer{
+		client:    client,
+		window:    window,
+		threshold: threshold,
+		history:   map[string][]string{},
+	}
+}
+
+// Summary observes the checks once more and returns the summary, see Client.HealthSummary
+func (summarizer *HealthSummarizer) Summary() (HealthSummary, error) {
+	checks, _, err := summarizer.client.HealthState(HealthAny, nil)
+	if err != nil {
+		return HealthSummary{}, err
+	}
+
+	summarizer.mutex.Lock()
+	defer summarizer.mutex.Unlock()
+
+	seen := map[string]bool{}
+	flapping := []HealthNode{}
+	for _, check := range checks {
+		key := check.Node + "/" + check.CheckID
+		seen[key] = true
+
+		history := append(summarizer.history[key], check.Status)
+		if len(history) > summarizer.window {
+			history = history[len(history)-summarizer.window:]
+		}
+		summarizer.history[key] = history
+
+		changes := 0
+		for i := 1; i < len(history); i++ {
+			if history[i] != history[i-1] {
+				changes++
+			}
+		}
+		if changes >= summarizer.threshold && summarizer.threshold > 0 {
+			flapping = append(flapping, check)
+		}
+	}
+
+	// Forget checks that have been deregistered
+	for key := range summarizer.history {
+		if !seen[key] {
+			delete(summarizer.history, key)
+		}
+	}
+
+	return HealthSummary{Services: summarizeServices(checks), Flapping: flapping}, nil
+}
+
+// summarizeServices counts the instances of each service by their worst check, sorted by name
+func summarizeServices(checks []HealthNode) []ServiceHealth {
+	nodeStatus := map[string]string{}
+	instanceStatus := map[[2]string]string{}
+	instanceService := map[[2]string]string{}
+	for _, check := range checks {
+		if check.ServiceID == "" {
+			nodeStatus[check.Node] = worseStatus(nodeStatus[check.Node], check.Status)
+			continue
+		}
+		instance := [2]string{check.Node, check.ServiceID}
+		instanceStatus[instance] = worseStatus(instanceStatus[instance], check.Status)
+		instanceService[instance] = check.ServiceName
+	}
+
+	services := map[string]*ServiceHealth{}
+	for instance, status := range instanceStatus {
+		name := instanceService[instance]
+		if services[name] == nil {
+			services[name] = &ServiceHealth{Name: name}
+		}
+		switch worseStatus(status, nodeStatus[instance[0]]) {
+		case HealthCritical, HealthMaint:
+			services[name].Critical++
+		case HealthWarning:
+			services[name].Warning++
+		default:
+			services[name].Passing++
+		}
+	}
+
+	summary := make([]ServiceHealth, 0, len(services))
+	for _, service := range services {
+		summary = append(summary, *service)
+	}
+	sort.Slice(summary, func(i, j int) bool { return summary[i].Name < summary[j].Name })
+	return summary
+}
+
+var statusSeverity = map[string]int{
+	HealthPassing:  1,
+	HealthWarning:  2,
+	HealthCritical: 3,
+	HealthMaint:    4,
+}
+
+func worseStatus(a, b string) string {
+	if statusSeverity[b] > statusSeverity[a] {
+		return b
+	}
+	return a
+}
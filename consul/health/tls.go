@@ -0,0 +1,32 @@
+package consul
+
+import (
+	"github.com/rarmstrong73/go-utils/internal/httpclient"
+)
+
+// TLSConfig configures how a client connects to an agent's HTTPS listener
+type TLSConfig struct {
+	// CAFile is a PEM file of the certificate authorities to verify the agent with, defaulting
+	// to the system's
+	CAFile string
+	// CertFile and KeyFile are a PEM client certificate and key, for agents that verify clients
+	CertFile string
+	KeyFile  string
+	// ServerName overrides the name verified against the agent's certificate
+	ServerName string
+	// InsecureSkipVerify turns off verification of the agent's certificate
+	InsecureSkipVerify bool
+}
+
+// SetTLSConfig switches the client to https, connecting with the given TLS settings
+func (client *Client) SetTLSConfig(config TLSConfig) error {
+	tlsConfig, err := httpclient.LoadTLS(httpclient.TLSFiles(config))
+	if err != nil {
+		return err
+	}
+	client.httpClient.Configure(func(options *httpclient.Options) {
+		options.TLS = tlsConfig
+	})
+	client.config.Scheme = "https"
+	return nil
+}
@@ -0,0 +1,106 @@
+package consul
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// KV transaction verbs
+const (
+	KVOpSet        = "set"
+	KVOpCAS        = "cas"
+	KVOpGet        = "get"
+	KVOpGetTree    = "get-tree"
+	KVOpCheckIndex = "check-index"
+	KVOpDelete     = "delete"
+	KVOpDeleteTree = "delete-tree"
+	KVOpDeleteCAS  = "delete-cas"
+)
+
+var maxTxnOps = 64
+
+// KVTxnOp is a single operation in a KV transaction. Index is the ModifyIndex compared by the
+// cas, check-index and delete-cas verbs.
+type KVTxnOp struct {
+	Verb    string `json:"Verb"`
+	Key     string `json:"Key"`
+	Value   []byte `json:"Value,omitempty"`
+	Flags   uint64 `json:"Flags,omitempty"`
+	Index   uint64 `json:"Index,omitempty"`
+	Session string `json:"Session,omitempty"`
+}
+
+// TxnError is why an operation made a transaction roll back
+type TxnError struct {
+	OpIndex int    `json:"OpIndex"`
+	What    string `json:"What"`
+}
+
+// TxnResponse is the result of a transaction, Results has the keys read or written by each
+// operation that returns one when it commits and Errors says why it rolled back when it doesn't
+type TxnResponse struct {
+	Results []KVPair
+	Errors  []TxnError
+}
+
+// KVTxn applies the operations atomically, either all of them are applied or none are. It
+// returns whether the transaction committed; a transaction rolled back because a check failed
+// is not an error. Consul limits a transaction to 64 operations.
+func (client *Client) KVTxn(ops []KVTxnOp) (bool, TxnResponse, error) {
+	return client.KVTxnContext(client.context(), ops)
+}
+
+// KVTxnContext is KVTxn with ctx cancelling its requests
+func (client *Client) KVTxnContext(ctx context.Context, ops []KVTxnOp) (bool, TxnResponse, error) {
+	if len(ops) > maxTxnOps {
+		return false, TxnResponse{}, fmt.Errorf("Transaction has %d operations, the limit is %d", len(ops), maxTxnOps)
+	}
+
+	type txnOp struct {
+		KV KVTxnOp `json:"KV"`
+	}
+	request := make([]txnOp, 0, len(ops))
+	for _, op := range ops {
+		request = append(request, txnOp{KV: op})
+	}
+	requestBytes, err := json.Marshal(request)
+	if err != nil {
+		return false, TxnResponse{}, err
+	}
+
+	response, err := client.httpPutResponse(ctx, "/txn", nil, requestBytes)
+	if err != nil {
+		return false, TxnResponse{}, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusConflict {
+		if err := checkResponse(response); err != nil {
+			return false, TxnResponse{}, err
+		}
+	}
+
+	responseBytes, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return false, TxnResponse{}, err
+	}
+
+	var txnResponse struct {
+		Results []struct {
+			KV KVPair `json:"KV"`
+		} `json:"Results"`
+		Errors []TxnError `json:"Errors"`
+	}
+	if err := json.Unmarshal(responseBytes, &txnResponse); err != nil {
+		return false, TxnResponse{}, err
+	}
+
+	result := TxnResponse{Results: []KVPair{}, Errors: txnResponse.Errors}
+	for _, opResult := range txnResponse.Results {
+		result.Results = append(result.Results, opResult.KV)
+	}
+	return response.StatusCode != http.StatusConflict, result, nil
+}
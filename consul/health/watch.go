@@ -0,0 +1,205 @@
+package consul
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/rarmstrong73/go-utils/apierror"
+	"github.com/rarmstrong73/go-utils/retry"
+)
+
+var watchWaitTime = 5 * time.Minute
+var watchPollInterval = time.Second
+
+// watchRetry paces the retries of failed watch queries, which are retried for as long as the
+// watch runs
+var watchRetry = retry.Policy{InitialBackoff: time.Second, MaxBackoff: time.Minute, Jitter: 0.2}
+
+// WatchEvent is a new result of a watched query
+type WatchEvent struct {
+	// Index is the consul index of the result
+	Index uint64
+	// Value is the result, its type depends on how the watch was created
+	Value interface{}
+}
+
+// Watch repeatedly runs a blocking query and delivers each changed result on Events. The first
+// result is always delivered. Failed queries are retried with an exponential backoff, results
+// that are the same as the last one delivered are dropped.
+type Watch struct {
+	client   *Client
+	ctx      context.Context
+	name     string
+	fetch    func(ctx context.Context, options *QueryOptions) (interface{}, QueryMeta, error)
+	events   chan WatchEvent
+	stop     chan struct{}
+	stopOnce sync.Once
+	cancel   context.CancelFunc
+
+	mutex   sync.Mutex
+	lastErr error
+}
+
+// WatchKey watches a single key, delivering a KVPair or nil when the key doesn't exist
+func (client *Client) WatchKey(key string) *Watch {
+	return client.WatchKeyContext(client.context(), key)
+}
+
+// WatchKeyContext is WatchKey with ctx, the watch stops once ctx is done
+func (client *Client) WatchKeyContext(ctx context.Context, key string) *Watch {
+	return client.watch(ctx, "kv", func(ctx context.Context, options *QueryOptions) (interface{}, QueryMeta, error) {
+		pair, meta, err := client.KVGetContext(ctx, key, options)
+		if errors.Is(err, ErrKeyNotFound) {
+			return nil, meta, nil
+		}
+		return pair, meta, err
+	})
+}
+
+// WatchPrefix watches every key starting with prefix, delivering a []KVPair
+func (client *Client) WatchPrefix(prefix string) *Watch {
+	return client.WatchPrefixContext(client.context(), prefix)
+}
+
+// WatchPrefixContext is WatchPrefix with ctx, the watch stops once ctx is done
+func (client *Client) WatchPrefixContext(ctx context.Context, prefix string) *Watch {
+	return client.watch(ctx, "kv", func(ctx context.Context, options *QueryOptions) (interface{}, QueryMeta, error) {
+		return client.KVListContext(ctx, prefix, options)
+	})
+}
+
+// WatchService watches the instances of a service as returned by HealthService, delivering a []ServiceEntry
+func (client *Client) WatchService(name, tag string, passingOnly bool) *Watch {
+	return client.WatchServiceContext(client.context(), name, tag, passingOnly)
+}
+
+// WatchServiceContext is WatchService with ctx, the watch stops once ctx is done
+func (client *Client) WatchServiceContext(ctx context.Context, name, tag string, passingOnly bool) *Watch {
+	return client.watch(ctx, "health/service", func(ctx context.Context, options *QueryOptions) (interface{}, QueryMeta, error) {
+		return client.HealthServiceContext(ctx, name, tag, passingOnly, options)
+	})
+}
+
+// WatchChecks watches the checks in the given state as returned by HealthState, delivering a []HealthNode
+func (client *Client) WatchChecks(state string) *Watch {
+	return client.WatchChecksContext(client.context(), state)
+}
+
+// WatchChecksContext is WatchChecks with ctx, the watch stops once ctx is done
+func (client *Client) WatchChecksContext(ctx context.Context, state string) *Watch {
+	return client.watch(ctx, "health/state", func(ctx context.Context, options *QueryOptions) (interface{}, QueryMeta, error) {
+		return client.HealthStateContext(ctx, state, options)
+	})
+}
+
+func (client *Client) watch(ctx context.Context, name string, fetch func(ctx context.Context, options *QueryOptions) (interface{}, QueryMeta, error)) *Watch {
+	ctx, cancel := context.WithCancel(ctx)
+	watch := &Watch{
+		client: client,
+		ctx:    ctx,
+		name:   name,
+		fetch:  fetch,
+		events: make(chan WatchEvent),
+		stop:   make(chan struct{}),
+		cancel: cancel,
+	}
+	go watch.run()
+	return watch
+}
+
+// Events returns the channel results are delivered on, it is closed once the watch stops
+func (watch *Watch) Events() <-chan WatchEvent {
+	return watch.events
+}
+
+// Stop stops the watch, cancelling the query in progress. The watch also stops when its context
+// is done or the client is closed.
+func (watch *Watch) Stop() {
+	watch.stopOnce.Do(func() {
+		close(watch.stop)
+		watch.cancel()
+	})
+}
+
+// Err returns the error of the last query if it failed, or nil if it succeeded
+func (watch *Watch) Err() error {
+	watch.mutex.Lock()
+	defer watch.mutex.Unlock()
+	return watch.lastErr
+}
+
+func (watch *Watch) run() {
+	defer close(watch.events)
+
+	var index uint64
+	var last interface{}
+	delivered := false
+	failures := 0
+
+	for {
+		wait := time.Duration(0)
+		if delivered && index == 0 {
+			// Without an index the query can't block, so poll instead of spinning
+			wait = watchPollInterval
+		}
+		select {
+		case <-watch.stop:
+			return
+		case <-watch.ctx.Done():
+			return
+		case <-watch.client.httpClient.Closing():
+			return
+		case <-time.After(wait):
+		}
+
+		value, meta, err := watch.fetch(watch.ctx, &QueryOptions{WaitIndex: index, WaitTime: watchWaitTime})
+		watch.mutex.Lock()
+		watch.lastErr = err
+		watch.mutex.Unlock()
+
+		if watch.ctx.Err() != nil || errors.Is(err, apierror.ErrClosed) {
+			return
+		}
+		if err != nil {
+			backoff := watchRetry.Backoff(failures)
+			failures++
+			watch.client.metrics.WatchRestart(watch.name)
+			watch.client.logger.Debug("Watch query failed, retrying", "watch", watch.name, "error", err, "backoff", backoff)
+			select {
+			case <-watch.stop:
+				return
+			case <-watch.ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			continue
+		}
+		failures = 0
+
+		// An index that goes backwards means the raft state was reset, start again from scratch
+		if meta.LastIndex < index {
+			watch.client.metrics.WatchRestart(watch.name)
+			watch.client.logger.Info("Watch index went backwards, starting again", "watch", watch.name)
+			index = 0
+			continue
+		}
+		index = meta.LastIndex
+
+		if delivered && reflect.DeepEqual(value, last) {
+			continue
+		}
+
+		select {
+		case <-watch.stop:
+			return
+		case <-watch.ctx.Done():
+			return
+		case watch.events <- WatchEvent{Index: meta.LastIndex, Value: value}:
+		}
+		last = value
+		delivered = true
+	}
+}
@@ -0,0 +1,42 @@
+package consul_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	consul "github.com/rarmstrong73/go-utils/consul/health"
+)
+
+func TestWatchStopsWhenContextIsDoneDuringBackoff(t *testing.T) {
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "No cluster leader", http.StatusInternalServerError)
+	}))
+	defer agent.Close()
+	client := consul.NewClient(consul.Config{Address: strings.TrimPrefix(agent.URL, "http://")})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	watch := client.WatchKeyContext(ctx, "a")
+	deadline := time.Now().Add(5 * time.Second)
+	for watch.Err() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("the watch's query never failed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The watch is now backing off for at least 800ms before its next query
+	cancel()
+	select {
+	case _, ok := <-watch.Events():
+		if ok {
+			t.Error("got an event, want the events channel closed")
+		}
+	case <-time.After(300 * time.Millisecond):
+		t.Error("the watch kept backing off after its context was done")
+	}
+}
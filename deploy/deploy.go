@@ -0,0 +1,323 @@
+// Package deploy rolls a new version of an app out across its fleet units: the new image is
+// pulled on the machines running the units first, then the units are replaced a batch at a time,
+// each batch waiting for the units' consul health checks to pass before the next starts. When a
+// batch fails, the units already replaced are put back the way they were.
+//
+//	err := deploy.Deploy(ctx, deploy.Config{Fleet: fleetClient, Consul: consulClient, BatchSize: 2}, deploy.Deployment{
+//		Name:    "web",
+//		Image:   "registry.example.com/web:2.0",
+//		Options: options,
+//		Service: "web",
+//	})
+package deploy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	consul "github.com/rarmstrong73/go-utils/consul/health"
+	"github.com/rarmstrong73/go-utils/docker"
+	"github.com/rarmstrong73/go-utils/fanout"
+	"github.com/rarmstrong73/go-utils/fleet"
+	"github.com/rarmstrong73/go-utils/logging"
+)
+
+// Config describes the cluster deployments are made to and how they are rolled out
+type Config struct {
+	// Fleet is the client the units are managed through
+	Fleet *fleet.Client
+	// Consul is the client health checks are read with, no batch waits for consul when nil
+	Consul *consul.Client
+	// DockerHost returns the host to pass to the docker functions for a machine, defaulting to
+	// its PrimaryIP
+	DockerHost func(machine fleet.Machine) string
+	// ServiceID returns the consul service ID a unit registers, defaulting to the unit's name
+	ServiceID func(unit string) string
+	// BatchSize is how many units are replaced at once, defaulting to 1
+	BatchSize int
+	// HealthTimeout is how long a batch has to become healthy, defaulting to 5 minutes
+	HealthTimeout time.Duration
+	// PollInterval is how often a batch's health is checked, defaulting to 2 seconds
+	PollInterval time.Duration
+	// Logger is where the deployment logs, nowhere when nil
+	Logger logging.Logger
+}
+
+// Deployment is a new version of an app
+type Deployment struct {
+	// Name is the app's name, whose units are Name@instance.service
+	Name string
+	// Image is pulled on the units' machines before any unit is replaced, none when empty
+	Image string
+	// Options are the unit options of the new version, which run Image
+	Options []fleet.Option
+	// Service is the consul service the units register, healthy once its checks pass after the
+	// unit is replaced
+	Service string
+}
+
+// Error is returned when a deployment fails
+type Error struct {
+	// Unit is the unit that failed, empty if the deployment failed before replacing any unit
+	Unit string
+	Err  error
+	// RolledBack are the units put back to their previous version
+	RolledBack []string
+	// RollbackErr is the error of the rollback, nil when it succeeded
+	RollbackErr error
+}
+
+func (e *Error) Error() string {
+	message := "Deployment failed"
+	if e.Unit != "" {
+		message += " at " + e.Unit
+	}
+	message += fmt.Sprintf(": %v", e.Err)
+	if len(e.RolledBack) > 0 {
+		message += fmt.Sprintf(", rolled back %s", strings.Join(e.RolledBack, ", "))
+	}
+	if e.RollbackErr != nil {
+		message += fmt.Sprintf(", rollback failed: %v", e.RollbackErr)
+	}
+	return message
+}
+
+// Unwrap returns the error the deployment failed with
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// ErrUnhealthy is the error of a unit whose health checks didn't pass in time
+var ErrUnhealthy = errors.New("Unit didn't become healthy")
+
+// Deploy rolls deployment out to the units of its app, returning an *Error if it fails. Units
+// are replaced in name order, and the app's template unit, if any, is replaced last so units
+// created afterwards run the new version. Rolled back units aren't waited on to become healthy.
+func Deploy(ctx context.Context, config Config, deployment Deployment) error {
+	config = withDefaults(config)
+	logger := logging.OrNop(config.Logger)
+
+	template, units, err := config.Fleet.ListUnitsByName(deployment.Name)
+	if err != nil {
+		return &Error{Err: err}
+	}
+	if len(units) == 0 {
+		return &Error{Err: fmt.Errorf("No units of %s to deploy to", deployment.Name)}
+	}
+	sort.Slice(units, func(i, j int) bool { return units[i].Name < units[j].Name })
+
+	if deployment.Image != "" {
+		if err := pullImage(ctx, config, deployment.Name, deployment.Image); err != nil {
+			return &Error{Err: err}
+		}
+	}
+
+	replaced := []fleet.Unit{}
+	for start := 0; start < len(units); start += config.BatchSize {
+		end := start + config.BatchSize
+		if end > len(units) {
+			end = len(units)
+		}
+		batch := units[start:end]
+		logger.Info("Deploying batch", "app", deployment.Name, "units", unitNames(batch))
+
+		since, err := healthIndex(config, deployment)
+		if err != nil {
+			return rollback(config, logger, batch[0].Name, err, replaced)
+		}
+		for _, unit := range batch {
+			replaced = append(replaced, unit)
+			if err := replaceUnit(config.Fleet, unit, deployment.Options); err != nil {
+				return rollback(config, logger, unit.Name, err, replaced)
+			}
+		}
+		if failed, err := waitHealthy(ctx, config, deployment, batch, since); err != nil {
+			return rollback(config, logger, failed, err, replaced)
+		}
+	}
+
+	if template.Name != "" {
+		if err := replaceUnit(config.Fleet, template, deployment.Options); err != nil {
+			return &Error{Unit: template.Name, Err: err}
+		}
+	}
+	logger.Info("Deployed", "app", deployment.Name, "units", len(units))
+	return nil
+}
+
+// pullImage pulls image on every machine running one of the app's units, failing if any pull
+// fails
+func pullImage(ctx context.Context, config Config, name, image string) error {
+	states, err := config.Fleet.ListUnitStatesByName(name)
+	if err != nil {
+		return err
+	}
+	machines, err := config.Fleet.ListMachines()
+	if err != nil {
+		return err
+	}
+	onMachine := map[string]bool{}
+	for _, state := range states {
+		onMachine[state.MachineID] = true
+	}
+	hosts := []string{}
+	for _, machine := range machines {
+		if onMachine[machine.ID] {
+			hosts = append(hosts, config.DockerHost(machine))
+		}
+	}
+
+	_, err = fanout.Run(ctx, hosts, fanout.Options{}, func(ctx context.Context, host string) (interface{}, error) {
+		return nil, docker.CreateImage(host, image, "", "", "")
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to pull %s: %v", image, err)
+	}
+	return nil
+}
+
+// replaceUnit replaces a unit with one with options, keeping its desired state. fleet can't
+// change the options of a unit, so it is destroyed and created again.
+func replaceUnit(client *fleet.Client, unit fleet.Unit, options []fleet.Option) error {
+	desiredState := unit.DesiredState
+	if desiredState == "" {
+		desiredState = fleet.Launched
+	}
+	if err := client.DestroyUnit(unit.Name); err != nil {
+		return err
+	}
+	return client.CreateUnit(unit.Name, desiredState, options)
+}
+
+// healthIndex returns the consul index of the deployment's service before a batch is replaced,
+// 0 when the deployment doesn't wait for consul
+func healthIndex(config Config, deployment Deployment) (uint64, error) {
+	if config.Consul == nil || deployment.Service == "" {
+		return 0, nil
+	}
+	_, meta, err := config.Consul.HealthService(deployment.Service, "", false, nil)
+	if err != nil {
+		return 0, err
+	}
+	return meta.LastIndex, nil
+}
+
+// waitHealthy waits for the launched units of a batch to be running the new version in fleet and
+// passing consul checks updated after since, returning the first unit that isn't once the timeout
+// passes
+func waitHealthy(ctx context.Context, config Config, deployment Deployment, batch []fleet.Unit, since uint64) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, config.HealthTimeout)
+	defer cancel()
+	ticker := time.NewTicker(config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		unhealthy, err := firstUnhealthy(config, deployment, batch, since)
+		if err == nil && unhealthy == "" {
+			return "", nil
+		}
+		select {
+		case <-ctx.Done():
+			if err == nil {
+				err = ErrUnhealthy
+			}
+			return unhealthy, err
+		case <-ticker.C:
+		}
+	}
+}
+
+// firstUnhealthy returns the first unit of a batch that should be running and isn't healthy. A
+// unit is healthy once fleet reports it active with the new options and all its consul checks
+// pass, one of its service checks having changed after since so a check left over from the
+// replaced unit doesn't count.
+func firstUnhealthy(config Config, deployment Deployment, batch []fleet.Unit, since uint64) (string, error) {
+	passing := map[string]bool{}
+	if config.Consul != nil && deployment.Service != "" {
+		entries, _, err := config.Consul.HealthService(deployment.Service, "", false, nil)
+		if err != nil {
+			return "", err
+		}
+		for _, entry := range entries {
+			healthy, updated := true, false
+			for _, check := range entry.Checks {
+				if check.Status != consul.HealthPassing {
+					healthy = false
+				}
+				if check.ServiceID != "" && uint64(check.ModifyIndex) > since {
+					updated = true
+				}
+			}
+			passing[entry.Service.ID] = healthy && updated
+		}
+	}
+
+	hash := fleet.UnitHash(deployment.Options)
+	for _, unit := range batch {
+		if unit.DesiredState != "" && unit.DesiredState != fleet.Launched {
+			continue
+		}
+		states, err := config.Fleet.GetUnitStatesByUnitName(unit.Name)
+		if err != nil {
+			return unit.Name, err
+		}
+		if len(states) == 0 || states[0].Hash != hash || states[0].SystemdActiveState != "active" {
+			return unit.Name, nil
+		}
+		if config.Consul != nil && deployment.Service != "" && !passing[config.ServiceID(unit.Name)] {
+			return unit.Name, nil
+		}
+	}
+	return "", nil
+}
+
+// rollback puts the replaced units back to their previous version, returning the *Error for the
+// deployment failing at unit with err
+func rollback(config Config, logger logging.Logger, unit string, err error, replaced []fleet.Unit) error {
+	logger.Error("Deployment failed, rolling back", "unit", unit, "error", err)
+	deployErr := &Error{Unit: unit, Err: err}
+	failures := []string{}
+	for i := len(replaced) - 1; i >= 0; i-- {
+		previous := replaced[i]
+		if rollbackErr := replaceUnit(config.Fleet, previous, previous.Options); rollbackErr != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", previous.Name, rollbackErr))
+			continue
+		}
+		deployErr.RolledBack = append(deployErr.RolledBack, previous.Name)
+	}
+	if len(failures) > 0 {
+		deployErr.RollbackErr = errors.New(strings.Join(failures, "; "))
+	}
+	return deployErr
+}
+
+func withDefaults(config Config) Config {
+	if config.DockerHost == nil {
+		config.DockerHost = func(machine fleet.Machine) string { return machine.PrimaryIP }
+	}
+	if config.ServiceID == nil {
+		config.ServiceID = func(unit string) string { return unit }
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 1
+	}
+	if config.HealthTimeout <= 0 {
+		config.HealthTimeout = 5 * time.Minute
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = 2 * time.Second
+	}
+	return config
+}
+
+func unitNames(units []fleet.Unit) string {
+	names := []string{}
+	for _, unit := range units {
+		names = append(names, unit.Name)
+	}
+	return strings.Join(names, ",")
+}
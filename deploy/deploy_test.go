@@ -0,0 +1,136 @@
+package deploy_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rarmstrong73/go-utils/consul/consultest"
+	consul "github.com/rarmstrong73/go-utils/consul/health"
+	"github.com/rarmstrong73/go-utils/deploy"
+	"github.com/rarmstrong73/go-utils/fleet"
+	"github.com/rarmstrong73/go-utils/fleet/fleettest"
+)
+
+var (
+	oldOptions = []fleet.Option{{Section: "Service", Name: "ExecStart", Value: "/usr/bin/docker run web:1.0"}}
+	newOptions = []fleet.Option{{Section: "Service", Name: "ExecStart", Value: "/usr/bin/docker run web:2.0"}}
+)
+
+// newCluster starts a fleet with web@1.service running oldOptions and a consul agent where the
+// unit's service is already passing its check
+func newCluster(t *testing.T) (*fleettest.Server, *consultest.Server) {
+	fleetServer := fleettest.NewServer()
+	fleetServer.AddMachine(fleet.Machine{ID: "m1", PrimaryIP: "10.0.0.1"})
+	client := fleet.NewClient(fleet.Config{Host: fleetServer.Host()})
+	if err := client.CreateUnit("web@1.service", fleet.Launched, oldOptions); err != nil {
+		t.Fatalf("CreateUnit: %v", err)
+	}
+	consulServer := consultest.NewServer()
+	err := consulServer.Client().AgentRegisterService(consul.AgentServiceRegistration{
+		ID:    "web@1.service",
+		Name:  "web",
+		Check: &consul.AgentServiceCheck{TTL: "10s", Status: consul.HealthPassing},
+	})
+	if err != nil {
+		t.Fatalf("AgentRegisterService: %v", err)
+	}
+	return fleetServer, consulServer
+}
+
+// passOnLaunch makes the consul check of every unit launched from now on pass again, as the new
+// instance's agent would
+func passOnLaunch(fleetServer *fleettest.Server, consulServer *consultest.Server) {
+	fleetServer.OnChange(func(unit fleet.Unit, state *fleet.UnitState) {
+		if state != nil && unit.DesiredState == fleet.Launched {
+			consulServer.SetCheckStatus("service:"+unit.Name, consul.HealthPassing, "")
+		}
+	})
+}
+
+func deployWeb(fleetHost string, consulServer *consultest.Server) error {
+	config := deploy.Config{
+		Fleet:         fleet.NewClient(fleet.Config{Host: fleetHost}),
+		Consul:        consulServer.Client(),
+		HealthTimeout: 200 * time.Millisecond,
+		PollInterval:  10 * time.Millisecond,
+	}
+	return deploy.Deploy(context.Background(), config, deploy.Deployment{Name: "web", Options: newOptions, Service: "web"})
+}
+
+func unitOptions(t *testing.T, fleetServer *fleettest.Server) []fleet.Option {
+	units := fleetServer.Units()
+	if len(units) != 1 {
+		t.Fatalf("units = %+v, want web@1.service", units)
+	}
+	return units[0].Options
+}
+
+func TestDeploySucceedsOnceReplacedUnitsPass(t *testing.T) {
+	fleetServer, consulServer := newCluster(t)
+	defer fleetServer.Close()
+	defer consulServer.Close()
+	passOnLaunch(fleetServer, consulServer)
+
+	if err := deployWeb(fleetServer.Host(), consulServer); err != nil {
+		t.Fatalf("Deploy: %v", err)
+	}
+	if options := unitOptions(t, fleetServer); options[0].Value != newOptions[0].Value {
+		t.Errorf("options = %+v, want %+v", options, newOptions)
+	}
+}
+
+func TestDeployIgnoresChecksOfReplacedUnits(t *testing.T) {
+	fleetServer, consulServer := newCluster(t)
+	defer fleetServer.Close()
+	defer consulServer.Close()
+
+	err := deployWeb(fleetServer.Host(), consulServer)
+	if !errors.Is(err, deploy.ErrUnhealthy) {
+		t.Fatalf("err = %v, want %v with the check only passing from before the replacement", err, deploy.ErrUnhealthy)
+	}
+	if options := unitOptions(t, fleetServer); options[0].Value != oldOptions[0].Value {
+		t.Errorf("options = %+v, want rolled back to %+v", options, oldOptions)
+	}
+}
+
+func TestDeployIgnoresStatesOfReplacedUnits(t *testing.T) {
+	fleetServer, consulServer := newCluster(t)
+	defer fleetServer.Close()
+	defer consulServer.Close()
+	passOnLaunch(fleetServer, consulServer)
+
+	// fleet keeps reporting the state of the replaced unit, as it does until the new one is loaded
+	target, _ := url.Parse(fleetServer.URL)
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	proxy.ModifyResponse = func(response *http.Response) error {
+		if !strings.HasPrefix(response.Request.URL.Path, "/fleet/v1/state") {
+			return nil
+		}
+		body, err := ioutil.ReadAll(response.Body)
+		if err != nil {
+			return err
+		}
+		body = bytes.ReplaceAll(body, []byte(fleet.UnitHash(newOptions)), []byte(fleet.UnitHash(oldOptions)))
+		response.Body = ioutil.NopCloser(bytes.NewReader(body))
+		response.ContentLength = int64(len(body))
+		response.Header.Set("Content-Length", strconv.Itoa(len(body)))
+		return nil
+	}
+	stale := httptest.NewServer(proxy)
+	defer stale.Close()
+
+	err := deployWeb(strings.TrimPrefix(stale.URL, "http://"), consulServer)
+	if !errors.Is(err, deploy.ErrUnhealthy) {
+		t.Fatalf("err = %v, want %v with fleet reporting the replaced unit", err, deploy.ErrUnhealthy)
+	}
+}
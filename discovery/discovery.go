@@ -0,0 +1,215 @@
+// Package discovery finds the endpoints of etcd, consul, fleet and other services from DNS SRV
+// records, including consul's DNS interface, and keeps them up to date so configuration doesn't
+// need to list IPs:
+//
+//	endpoints := discovery.New(discovery.SRV("etcd-client", "tcp", "example.com"), time.Minute)
+//	if err := endpoints.Refresh(ctx); err != nil {
+//		...
+//	}
+//	client := etcd.NewClient(endpoints.Hosts()...)
+//	endpoints.OnChange(func(hosts []string) { client.SetHosts(hosts...) })
+//	go endpoints.Run(ctx)
+//
+// Consul clients take new endpoints with SetAddresses. The fleet and docker packages take a host
+// with every call, which can be endpoints.Host().
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rarmstrong73/go-utils/logging"
+)
+
+// Resolver returns the current host:port endpoints of a service
+type Resolver func(ctx context.Context) ([]string, error)
+
+// SRV resolves the _service._proto.domain SRV records, such as _etcd-client._tcp.example.com
+func SRV(service, proto, domain string) Resolver {
+	return func(ctx context.Context) ([]string, error) {
+		return lookup(ctx, net.DefaultResolver, service, proto, domain)
+	}
+}
+
+// SRVName resolves the SRV records of a full name, such as _etcd-client._tcp.example.com
+func SRVName(name string) Resolver {
+	return SRV("", "", name)
+}
+
+// Consul resolves a service registered in consul through consul's DNS interface at dnsAddress,
+// such as 127.0.0.1:8600, with the name service.service.consul, tag.service.service.consul when
+// tag isn't empty or service.service.datacenter.consul when datacenter isn't empty. Consul only
+// returns healthy instances.
+func Consul(dnsAddress, service, tag, datacenter string) Resolver {
+	name := service + ".service"
+	if tag != "" {
+		name = tag + "." + name
+	}
+	if datacenter != "" {
+		name += "." + datacenter
+	}
+	name += ".consul"
+
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, dnsAddress)
+		},
+	}
+	return func(ctx context.Context) ([]string, error) {
+		return lookup(ctx, resolver, "", "", name)
+	}
+}
+
+// Static always returns hosts, for services whose endpoints are configured
+func Static(hosts ...string) Resolver {
+	return func(ctx context.Context) ([]string, error) {
+		return append([]string{}, hosts...), nil
+	}
+}
+
+// lookup resolves SRV records into endpoints in the order the records are to be tried in
+func lookup(ctx context.Context, resolver *net.Resolver, service, proto, name string) ([]string, error) {
+	_, records, err := resolver.LookupSRV(ctx, service, proto, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("No SRV records found for %s", recordName(service, proto, name))
+	}
+
+	endpoints := make([]string, 0, len(records))
+	for _, record := range records {
+		host := strings.TrimSuffix(record.Target, ".")
+		endpoints = append(endpoints, net.JoinHostPort(host, strconv.Itoa(int(record.Port))))
+	}
+	return endpoints, nil
+}
+
+func recordName(service, proto, name string) string {
+	if service == "" && proto == "" {
+		return name
+	}
+	return "_" + service + "._" + proto + "." + name
+}
+
+// Endpoints are the endpoints of a service, resolved again every interval by Run
+type Endpoints struct {
+	resolve  Resolver
+	interval time.Duration
+
+	mutex    sync.Mutex
+	hosts    []string
+	onChange []func(hosts []string)
+	logger   logging.Logger
+}
+
+// New returns the endpoints found by resolve, which are empty until the first Refresh
+func New(resolve Resolver, interval time.Duration) *Endpoints {
+	return &Endpoints{resolve: resolve, interval: interval, logger: logging.Nop}
+}
+
+// SetLogger sets where the endpoints log, nil turns logging off
+func (endpoints *Endpoints) SetLogger(logger logging.Logger) {
+	endpoints.mutex.Lock()
+	defer endpoints.mutex.Unlock()
+	endpoints.logger = logging.OrNop(logger)
+}
+
+// OnChange adds a function called with the new endpoints whenever a refresh changes them, such as
+// an etcd client's SetHosts
+func (endpoints *Endpoints) OnChange(onChange func(hosts []string)) {
+	endpoints.mutex.Lock()
+	defer endpoints.mutex.Unlock()
+	endpoints.onChange = append(endpoints.onChange, onChange)
+}
+
+// Hosts returns the endpoints found by the last successful refresh
+func (endpoints *Endpoints) Hosts() []string {
+	endpoints.mutex.Lock()
+	defer endpoints.mutex.Unlock()
+	return append([]string{}, endpoints.hosts...)
+}
+
+// Host returns the preferred endpoint, "" before the first successful refresh
+func (endpoints *Endpoints) Host() string {
+	endpoints.mutex.Lock()
+	defer endpoints.mutex.Unlock()
+	if len(endpoints.hosts) == 0 {
+		return ""
+	}
+	return endpoints.hosts[0]
+}
+
+// Refresh resolves the endpoints again, keeping the last ones when resolving fails or finds
+// none. The same endpoints in a different order don't count as a change.
+func (endpoints *Endpoints) Refresh(ctx context.Context) error {
+	hosts, err := endpoints.resolve(ctx)
+	if err == nil && len(hosts) == 0 {
+		err = fmt.Errorf("No endpoints found")
+	}
+	if err != nil {
+		return err
+	}
+
+	endpoints.mutex.Lock()
+	changed := !sameHosts(endpoints.hosts, hosts)
+	if changed {
+		endpoints.hosts = append([]string{}, hosts...)
+	}
+	onChange := endpoints.onChange
+	logger := endpoints.logger
+	endpoints.mutex.Unlock()
+
+	if changed {
+		logger.Info("Discovered endpoints", "endpoints", strings.Join(hosts, ","))
+		for _, change := range onChange {
+			change(append([]string{}, hosts...))
+		}
+	}
+	return nil
+}
+
+// Run refreshes the endpoints every interval until ctx is done, logging failures
+func (endpoints *Endpoints) Run(ctx context.Context) error {
+	ticker := time.NewTicker(endpoints.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		if err := endpoints.Refresh(ctx); err != nil && ctx.Err() == nil {
+			endpoints.mutex.Lock()
+			logger := endpoints.logger
+			endpoints.mutex.Unlock()
+			logger.Error("Failed to discover endpoints", "error", err)
+		}
+	}
+}
+
+// sameHosts reports whether two lists have the same hosts, in any order. SRV records of equal
+// priority come back shuffled by weight on every lookup.
+func sameHosts(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	sortedA := append([]string{}, a...)
+	sortedB := append([]string{}, b...)
+	sort.Strings(sortedA)
+	sort.Strings(sortedB)
+	for i := range sortedA {
+		if sortedA[i] != sortedB[i] {
+			return false
+		}
+	}
+	return true
+}
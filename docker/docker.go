@@ -1,17 +1,98 @@
 package docker
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+
+	"github.com/rarmstrong73/go-utils/apierror"
+	"github.com/rarmstrong73/go-utils/breaker"
+	"github.com/rarmstrong73/go-utils/cache"
+	"github.com/rarmstrong73/go-utils/internal/httpclient"
+	"github.com/rarmstrong73/go-utils/logging"
+	"github.com/rarmstrong73/go-utils/proxy"
+	"github.com/rarmstrong73/go-utils/ratelimit"
+	"github.com/rarmstrong73/go-utils/retry"
+	"github.com/rarmstrong73/go-utils/timeouts"
 )
 
 var port = 2375
 
+var httpClient = httpclient.New(httpclient.Options{Service: apierror.Docker, Endpoint: endpointName})
+
+// logger is where the package logs, set with SetLogger
+var logger = &logging.Swappable{}
+
+// SetLogger sets where the package logs, nil turns logging off
+func SetLogger(l logging.Logger) {
+	logger.Set(l)
+}
+
+// SetRateLimiter limits the rate at which the package sends requests to every host, nil removes
+// the limit
+func SetRateLimiter(limiter ratelimit.Limiter) {
+	httpClient.Configure(func(options *httpclient.Options) {
+		options.Limiter = limiter
+	})
+}
+
+// SetCircuitBreaker stops the package sending requests to hosts whose circuit is open, nil
+// removes the breaker
+func SetCircuitBreaker(b breaker.Breaker) {
+	httpClient.Configure(func(options *httpclient.Options) {
+		options.Breaker = b
+	})
+}
+
+// SetCache serves the package's reads from responses when it has them, nil turns caching off
+func SetCache(responses cache.Cache) {
+	httpClient.Configure(func(options *httpclient.Options) {
+		options.Cache = responses
+	})
+}
+
+// SetTimeouts sets the package's connect, read and operation timeouts, nil goes back to the
+// defaults of the timeouts package
+func SetTimeouts(t *timeouts.Timeouts) {
+	httpClient.Configure(func(options *httpclient.Options) {
+		options.Timeouts = t
+	})
+}
+
+// SetProxy sets the proxy the package's requests go through, nil goes back to the default of the
+// proxy package
+func SetProxy(p proxy.Func) {
+	httpClient.Configure(func(options *httpclient.Options) {
+		options.Proxy = p
+	})
+}
+
+// SetRetryPolicy sets how the package retries failed requests, retry.Never by default
+func SetRetryPolicy(policy retry.Policy) {
+	httpClient.Configure(func(options *httpclient.Options) {
+		options.Retry = policy
+	})
+}
+
+// Shutdown stops the package: new requests fail with apierror.ErrClosed, event streams stop
+// straight away and requests in flight are given until ctx is done to finish before they are
+// cancelled. It returns ctx's error if requests had to be cancelled.
+func Shutdown(ctx context.Context) error {
+	return httpClient.Shutdown(ctx)
+}
+
+// Close stops the package like Shutdown, cancelling requests in flight straight away
+func Close() error {
+	return httpClient.Close()
+}
+
 // Bridge represents the bridge information
 type Bridge struct {
 	IPAMConfig          string `json:"IPAMConfig"`
@@ -85,7 +166,7 @@ func ListContainers(host string, all bool) (containers []Container, err error) {
 	queryStringParams := map[string]string{
 		"all": strconv.FormatBool(all),
 	}
-	containers, err = getContainers(fmt.Sprintf("http://%s:%d/containers/json", host, port), queryStringParams)
+	containers, err = getContainers(fmt.Sprintf("%s/containers/json", baseURL(host)), queryStringParams)
 	return containers, err
 }
 
@@ -100,6 +181,9 @@ func getContainers(url string, queryStringParams map[string]string) (containers
 	if err != nil {
 		return nil, err
 	}
+	if response.StatusCode != 200 {
+		return nil, responseError(response, strings.TrimSpace(string(jsonBytes)))
+	}
 
 	err = json.Unmarshal(jsonBytes, &containers)
 	if err != nil {
@@ -111,7 +195,7 @@ func getContainers(url string, queryStringParams map[string]string) (containers
 
 // RemoveContainer deletes the given container from the given host
 func RemoveContainer(host, nameOrID string, deleteVolumes, force bool) error {
-	url := fmt.Sprintf("http://%s:%d/containers/%s", host, port, nameOrID)
+	url := fmt.Sprintf("%s/containers/%s", baseURL(host), nameOrID)
 	queryStringParams := map[string]string{
 		"v":     strconv.FormatBool(deleteVolumes),
 		"force": strconv.FormatBool(force),
@@ -123,16 +207,16 @@ func RemoveContainer(host, nameOrID string, deleteVolumes, force bool) error {
 	defer response.Body.Close()
 
 	if response.StatusCode == 400 {
-		return fmt.Errorf("%d: One of the supplied paramaters was bad %v", response.StatusCode, queryStringParams)
+		return responseError(response, fmt.Sprintf("One of the supplied paramaters was bad %v", queryStringParams))
 	} else if response.StatusCode == 404 {
-		return fmt.Errorf("%d: %s didn't exist on %s's filesystem.\n", response.StatusCode, nameOrID, host)
+		return responseError(response, fmt.Sprintf("%s didn't exist on %s's filesystem", nameOrID, host))
 	} else if response.StatusCode == 409 {
-		return fmt.Errorf("%d: There was a conflict trying to remove %s from %s's filesystem.\n", response.StatusCode, nameOrID, host)
+		return responseError(response, fmt.Sprintf("There was a conflict trying to remove %s from %s's filesystem", nameOrID, host))
 	} else if response.StatusCode == 500 {
-		return fmt.Errorf("%d: There was a server error trying to remove %s from %s.\n", response.StatusCode, nameOrID, host)
+		return responseError(response, fmt.Sprintf("There was a server error trying to remove %s from %s", nameOrID, host))
 	}
 
-	log.Printf("%s successfully removed from %s's filesystem.\n", nameOrID, host)
+	logger.Info("Removed container", "container", nameOrID, "host", host)
 	return nil
 }
 
@@ -142,7 +226,7 @@ func ListImages(host string, all bool) (images []Image, err error) {
 		"all": strconv.FormatBool(all),
 	}
 
-	response, err := httpGetResponse(fmt.Sprintf("http://%s:%d/images/json", host, port), queryStringParams)
+	response, err := httpGetResponse(fmt.Sprintf("%s/images/json", baseURL(host)), queryStringParams)
 	if err != nil {
 		return nil, err
 	}
@@ -152,6 +236,9 @@ func ListImages(host string, all bool) (images []Image, err error) {
 	if err != nil {
 		return nil, err
 	}
+	if response.StatusCode != 200 {
+		return nil, responseError(response, strings.TrimSpace(string(jsonBytes)))
+	}
 
 	err = json.Unmarshal(jsonBytes, &images)
 	if err != nil {
@@ -163,7 +250,7 @@ func ListImages(host string, all bool) (images []Image, err error) {
 
 // CreateImage creates an image either by pulling it from the registry or by importing it
 func CreateImage(host, fromImage, fromSrc, repo, tag string) error {
-	url := fmt.Sprintf("http://%s:%d/images/create", host, port)
+	url := fmt.Sprintf("%s/images/create", baseURL(host))
 	queryStringParams := map[string]string{}
 
 	if fromImage != "" {
@@ -182,22 +269,51 @@ func CreateImage(host, fromImage, fromSrc, repo, tag string) error {
 		queryStringParams["tag"] = tag
 	}
 
-	response, err := httpPostRequest(url, queryStringParams)
+	// Pulls take as long as the layers take to download, so only the connect timeout applies
+	response, err := doHTTPResponseContext(httpclient.Streaming(context.Background()), http.MethodPost, url, queryStringParams)
 	if err != nil {
 		return err
 	}
 	defer response.Body.Close()
 
 	if response.StatusCode != 200 {
-		return fmt.Errorf("Failed to start container")
+		return responseError(response, "Failed to create image")
 	}
 
-	return nil
+	// The daemon answers 200 straight away and reports failures such as unknown tags in the
+	// progress stream, which has to be read to the end for the pull to finish
+	decoder := json.NewDecoder(response.Body)
+	for {
+		var message progressMessage
+		err := decoder.Decode(&message)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return apierror.Transport(apierror.Docker, response.Request.Method+" "+response.Request.URL.Path, fmt.Errorf("Failed to read image progress: %w", err))
+		}
+		if message.Error != "" || message.ErrorDetail.Message != "" {
+			errorMessage := message.ErrorDetail.Message
+			if errorMessage == "" {
+				errorMessage = message.Error
+			}
+			return responseError(response, errorMessage)
+		}
+	}
+}
+
+// progressMessage is a message of the progress stream of an image pull or import
+type progressMessage struct {
+	Status      string `json:"status"`
+	Error       string `json:"error"`
+	ErrorDetail struct {
+		Message string `json:"message"`
+	} `json:"errorDetail"`
 }
 
 // RemoveImage will remove the image from the hosts filesystem
 func RemoveImage(host, image string, force, noPrune bool) error {
-	url := fmt.Sprintf("http://%s:%d/images/%s", host, port, image)
+	url := fmt.Sprintf("%s/images/%s", baseURL(host), image)
 	queryStringParams := map[string]string{
 		"force":   strconv.FormatBool(force),
 		"noprune": strconv.FormatBool(noPrune),
@@ -209,61 +325,127 @@ func RemoveImage(host, image string, force, noPrune bool) error {
 	defer response.Body.Close()
 
 	if response.StatusCode == 404 {
-		return fmt.Errorf("%d: %s didn't exist on %s's filesystem", response.StatusCode, image, host)
+		return responseError(response, fmt.Sprintf("%s didn't exist on %s's filesystem", image, host))
 	} else if response.StatusCode == 409 {
 		bodyBytes, _ := ioutil.ReadAll(response.Body)
 		bodyString := string(bodyBytes)
 		if strings.Contains(bodyString, "image is being used by running container") {
 			return nil
 		} else if strings.Contains(bodyString, "image is referenced in multiple repositories") {
-			log.Printf("%s must be fored because it is referenced in multiple repositories", image)
+			logger.Info("Forcing removal of image referenced in multiple repositories", "image", image, "host", host)
 			err := RemoveImage(host, image, true, false)
 			if err != nil {
-				return fmt.Errorf("%d: There was a error trying to remove %s from %s's filesystem", response.StatusCode, image, host)
+				return responseError(response, fmt.Sprintf("There was a error trying to remove %s from %s's filesystem", image, host))
 			}
 			return nil
 		}
-		return fmt.Errorf("%d: There was a conflict trying to remove %s from %s's filesystem", response.StatusCode, image, host)
+		return responseError(response, fmt.Sprintf("There was a conflict trying to remove %s from %s's filesystem", image, host))
 	} else if response.StatusCode == 500 {
-		return fmt.Errorf("%d: There was an error trying to remove %s from %s", response.StatusCode, image, host)
+		return responseError(response, fmt.Sprintf("There was an error trying to remove %s from %s", image, host))
 	}
 
-	log.Printf("%s successfully removed from %s's filesystem", image, host)
+	logger.Info("Removed image", "image", image, "host", host)
 	return nil
 }
 
+// Version is the version of a docker daemon and of the API it serves
+type Version struct {
+	Version string `json:"Version"`
+	// APIVersion is the newest API version the daemon serves, such as 1.41
+	APIVersion string `json:"ApiVersion"`
+	// MinAPIVersion is the oldest API version the daemon serves, empty before API 1.25
+	MinAPIVersion string `json:"MinAPIVersion"`
+	Os            string `json:"Os"`
+	Arch          string `json:"Arch"`
+}
+
+// GetVersion returns the version of the docker daemon on host
+func GetVersion(host string) (version Version, err error) {
+	response, err := httpGetResponse(fmt.Sprintf("%s/version", baseURL(host)), nil)
+	if err != nil {
+		return Version{}, err
+	}
+	defer response.Body.Close()
+
+	jsonBytes, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return Version{}, err
+	}
+	if response.StatusCode != 200 {
+		return Version{}, responseError(response, strings.TrimSpace(string(jsonBytes)))
+	}
+
+	err = json.Unmarshal(jsonBytes, &version)
+	return version, err
+}
+
+// Ping checks that the docker daemon on host is up and answering
+func Ping(host string) error {
+	response, err := httpGetResponse(fmt.Sprintf("%s/_ping", baseURL(host)), nil)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != 200 {
+		return responseError(response, fmt.Sprintf("Docker daemon on %s isn't healthy", host))
+	}
+	return nil
+}
+
+// baseURL returns the URL of the docker API on host, which listens on the default port unless
+// host has one
+func baseURL(host string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return "http://" + host
+	}
+	return fmt.Sprintf("http://%s:%d", host, port)
+}
+
+// endpointName names a request path for observations, such as containers/json or images for
+// requests about a single image
+func endpointName(path string) string {
+	segments := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	if len(segments) > 1 && (segments[1] == "json" || segments[1] == "create") {
+		return segments[0] + "/" + segments[1]
+	}
+	return segments[0]
+}
+
+// responseError returns an *apierror.Error for a failed response, described by message
+func responseError(response *http.Response, message string) error {
+	return apierror.FromResponse(apierror.Docker, response, "", message, nil)
+}
+
 // ============================================================================
 // ============================= HTTP UTILS ===================================
 // ============================================================================
 
 func httpGetResponse(url string, queryStringParams map[string]string) (*http.Response, error) {
-	resp, err := doHTTPResponse(http.MethodDelete, url, queryStringParams)
-	return resp, err
+	return doHTTPResponse(http.MethodGet, url, queryStringParams)
 }
 
 func httpPostRequest(url string, queryStringParams map[string]string) (*http.Response, error) {
-	resp, err := doHTTPResponse(http.MethodDelete, url, queryStringParams)
-	return resp, err
+	return doHTTPResponse(http.MethodPost, url, queryStringParams)
 }
 
 func httpDeleteResponse(url string, queryStringParams map[string]string) (*http.Response, error) {
-	resp, err := doHTTPResponse(http.MethodDelete, url, queryStringParams)
-	return resp, err
+	return doHTTPResponse(http.MethodDelete, url, queryStringParams)
 }
 
-func doHTTPResponse(method, url string, queryStringParams map[string]string) (*http.Response, error) {
-	client := &http.Client{}
-	request, err := http.NewRequest(method, url, strings.NewReader(""))
-	if err != nil {
-		return nil, err
-	}
+func doHTTPResponse(method, requestURL string, queryStringParams map[string]string) (*http.Response, error) {
+	return doHTTPResponseContext(context.Background(), method, requestURL, queryStringParams)
+}
 
-	queryString := request.URL.Query()
+func doHTTPResponseContext(ctx context.Context, method, requestURL string, queryStringParams map[string]string) (*http.Response, error) {
+	query := url.Values{}
 	for key, value := range queryStringParams {
-		queryString.Add(key, value)
+		query.Add(key, value)
 	}
-	request.URL.RawQuery = queryString.Encode()
-
-	response, err := client.Do(request)
-	return response, err
+	request := httpclient.Request{Method: method, URL: requestURL, Query: query}
+	response, err := httpClient.Do(ctx, request)
+	if err != nil {
+		return nil, apierror.Transport(apierror.Docker, request.Operation(), err)
+	}
+	return response, nil
 }
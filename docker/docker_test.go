@@ -0,0 +1,36 @@
+package docker_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/rarmstrong73/go-utils/docker"
+	"github.com/rarmstrong73/go-utils/docker/dockertest"
+)
+
+func TestCreateImagePullsImage(t *testing.T) {
+	server := dockertest.NewServer()
+	defer server.Close()
+
+	if err := docker.CreateImage(server.Host(), "nginx:1.25", "", "", ""); err != nil {
+		t.Fatalf("CreateImage: %v", err)
+	}
+	images := server.Images()
+	if len(images) != 1 || images[0].RepoTags[0] != "nginx:1.25" {
+		t.Errorf("images = %+v, want nginx:1.25", images)
+	}
+}
+
+func TestCreateImageReturnsErrorsOfProgressStream(t *testing.T) {
+	server := dockertest.NewServer()
+	defer server.Close()
+	server.FailPull("nginx:nope", "manifest for nginx:nope not found")
+
+	err := docker.CreateImage(server.Host(), "nginx:nope", "", "", "")
+	if err == nil || !strings.Contains(err.Error(), "manifest for nginx:nope not found") {
+		t.Fatalf("CreateImage error = %v, want the pull's error", err)
+	}
+	if images := server.Images(); len(images) != 0 {
+		t.Errorf("images = %+v, want none", images)
+	}
+}
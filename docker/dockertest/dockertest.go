@@ -0,0 +1,461 @@
+// Package dockertest provides an in-memory fake of a docker daemon's container and image API
+// behind an httptest server, so code using the docker package can be tested without a daemon. It
+// supports listing and removing containers, and listing, pulling and removing images with the
+// daemon's conflicts for images used by containers or tagged in several repositories. Pulls
+// stream their progress, and FailPull makes them fail inside the stream as the daemon's do. Changes
+// are streamed as events, which can be filtered by type and event, and /_ping and /version
+// answer like the daemon's.
+package dockertest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rarmstrong73/go-utils/docker"
+)
+
+// Server is a fake docker daemon
+type Server struct {
+	*httptest.Server
+
+	mutex      sync.Mutex
+	containers map[string]*container
+	images     map[string]*docker.Image
+	events     []docker.Event
+	pullErrors map[string]string
+	listeners  map[chan docker.Event]bool
+	stop       chan struct{}
+}
+
+type container struct {
+	docker.Container
+	running bool
+}
+
+// NewServer starts a fake docker daemon with no containers or images
+func NewServer() *Server {
+	server := &Server{
+		containers: map[string]*container{},
+		images:     map[string]*docker.Image{},
+		pullErrors: map[string]string{},
+		listeners:  map[chan docker.Event]bool{},
+		stop:       make(chan struct{}),
+	}
+	server.Server = httptest.NewServer(http.HandlerFunc(server.handle))
+	return server
+}
+
+// Host returns the host:port the server listens on, to be passed to the docker functions
+func (server *Server) Host() string {
+	return strings.TrimPrefix(server.URL, "http://")
+}
+
+// Close ends the event streams and shuts the server down
+func (server *Server) Close() {
+	close(server.stop)
+	server.Server.Close()
+}
+
+// AddImage adds an image tagged with the given repo:tags, pulling it as the daemon would,
+// returning its ID
+func (server *Server) AddImage(tags ...string) string {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return server.addImageLocked(tags...)
+}
+
+// FailPull makes pulls of image fail with message, reported in the progress stream of a 200
+// response as the daemon reports unknown tags and registry errors
+func (server *Server) FailPull(image, message string) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.pullErrors[image] = message
+}
+
+// RunContainer starts a container named name from image, pulling the image if the daemon
+// doesn't have it, returning the container's ID
+func (server *Server) RunContainer(name, image string) string {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	found := server.findImageLocked(image)
+	if found == nil {
+		found = server.images[server.addImageLocked(image)]
+	}
+	id := newID()
+	server.containers[id] = &container{
+		Container: docker.Container{
+			ID:      id,
+			Names:   []string{"/" + name},
+			Image:   image,
+			ImageID: found.ID,
+			Created: time.Now().Unix(),
+			Status:  "Up Less than a second",
+			Labels:  map[string]string{},
+		},
+		running: true,
+	}
+	server.containerEventLocked("create", server.containers[id])
+	server.containerEventLocked("start", server.containers[id])
+	return id
+}
+
+// StopContainer stops a running container, returning false if there is no such container
+func (server *Server) StopContainer(nameOrID string) bool {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	found := server.findContainerLocked(nameOrID)
+	if found == nil {
+		return false
+	}
+	found.running = false
+	found.Status = "Exited (0) Less than a second ago"
+	server.containerEventLocked("die", found)
+	server.containerEventLocked("stop", found)
+	return true
+}
+
+// StartContainer starts a stopped container, returning false if there is no such container
+func (server *Server) StartContainer(nameOrID string) bool {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	found := server.findContainerLocked(nameOrID)
+	if found == nil {
+		return false
+	}
+	found.running = true
+	found.Status = "Up Less than a second"
+	server.containerEventLocked("start", found)
+	return true
+}
+
+// RemoveContainer removes a container whether it is running or not, returning false if there
+// is no such container
+func (server *Server) RemoveContainer(nameOrID string) bool {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	found := server.findContainerLocked(nameOrID)
+	if found == nil {
+		return false
+	}
+	delete(server.containers, found.ID)
+	server.containerEventLocked("destroy", found)
+	return true
+}
+
+// Containers returns every container, running or not, sorted by name
+func (server *Server) Containers() []docker.Container {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return server.containersLocked(true)
+}
+
+// Images returns every image, sorted by ID
+func (server *Server) Images() []docker.Image {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return server.imagesLocked()
+}
+
+func (server *Server) handle(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(r.URL.Path, "/")
+	query := r.URL.Query()
+	if path == "events" && r.Method == http.MethodGet {
+		server.streamEvents(w, r)
+		return
+	}
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	switch {
+	case path == "_ping" && r.Method == http.MethodGet:
+		w.Write([]byte("OK"))
+	case path == "version" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, docker.Version{Version: "20.10.24", APIVersion: "1.41", MinAPIVersion: "1.12", Os: "linux", Arch: "amd64"})
+	case path == "containers/json" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, server.containersLocked(query.Get("all") == "true" || query.Get("all") == "1"))
+	case strings.HasPrefix(path, "containers/") && r.Method == http.MethodDelete:
+		found := server.findContainerLocked(strings.TrimPrefix(path, "containers/"))
+		switch {
+		case found == nil:
+			writeError(w, http.StatusNotFound, "No such container: "+strings.TrimPrefix(path, "containers/"))
+		case found.running && query.Get("force") != "true" && query.Get("force") != "1":
+			writeError(w, http.StatusConflict, "You cannot remove a running container "+found.ID+". Stop the container before attempting removal or force remove")
+		default:
+			delete(server.containers, found.ID)
+			server.containerEventLocked("destroy", found)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	case path == "images/json" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, server.imagesLocked())
+	case path == "images/create" && r.Method == http.MethodPost:
+		image := query.Get("fromImage")
+		if image == "" {
+			image = query.Get("repo")
+		}
+		if image == "" {
+			writeError(w, http.StatusBadRequest, "fromImage or fromSrc must be given")
+			return
+		}
+		if tag := query.Get("tag"); tag != "" && !strings.Contains(image, ":") {
+			image += ":" + tag
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		encoder := json.NewEncoder(w)
+		encoder.Encode(map[string]string{"status": "Pulling from " + image})
+		if message, ok := server.pullErrors[image]; ok {
+			encoder.Encode(map[string]interface{}{"errorDetail": map[string]string{"message": message}, "error": message})
+			return
+		}
+		if server.findImageLocked(image) == nil {
+			server.addImageLocked(image)
+		}
+		server.eventLocked("image", "pull", image, map[string]string{"name": image})
+		encoder.Encode(map[string]string{"status": "Status: Downloaded newer image for " + image})
+	case strings.HasPrefix(path, "images/") && r.Method == http.MethodDelete:
+		server.removeImageLocked(w, strings.TrimPrefix(path, "images/"), query.Get("force") == "true" || query.Get("force") == "1")
+	default:
+		writeError(w, http.StatusNotFound, "page not found")
+	}
+}
+
+// removeImageLocked removes an image, or untags it when given a tag of an image with several
+func (server *Server) removeImageLocked(w http.ResponseWriter, name string, force bool) {
+	image := server.findImageLocked(name)
+	if image == nil {
+		writeError(w, http.StatusNotFound, "No such image: "+name)
+		return
+	}
+	for _, c := range server.containers {
+		if c.ImageID == image.ID && c.running {
+			writeError(w, http.StatusConflict, fmt.Sprintf("conflict: unable to delete %s (cannot be forced) - image is being used by running container %s", name, c.ID[:12]))
+			return
+		}
+	}
+
+	byID := !strings.Contains(name, ":") || strings.HasPrefix(name, "sha256:")
+	if len(image.RepoTags) > 1 && !force {
+		if byID {
+			writeError(w, http.StatusConflict, fmt.Sprintf("conflict: unable to delete %s (must be forced) - image is referenced in multiple repositories", name))
+			return
+		}
+		tags := []string{}
+		for _, tag := range image.RepoTags {
+			if tag != name {
+				tags = append(tags, tag)
+			}
+		}
+		image.RepoTags = tags
+		server.eventLocked("image", "untag", image.ID, map[string]string{"name": name})
+		writeJSON(w, http.StatusOK, []map[string]string{{"Untagged": name}})
+		return
+	}
+	delete(server.images, image.ID)
+	server.eventLocked("image", "delete", image.ID, map[string]string{"name": name})
+	writeJSON(w, http.StatusOK, []map[string]string{{"Deleted": image.ID}})
+}
+
+// streamEvents writes the events since the request's since, then every new event until the
+// request is cancelled or the server closes
+func (server *Server) streamEvents(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filters := map[string][]string{}
+	if encoded := query.Get("filters"); encoded != "" {
+		if err := json.Unmarshal([]byte(encoded), &filters); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid filters: "+err.Error())
+			return
+		}
+	}
+	matches := func(event docker.Event) bool {
+		return matchesFilter(filters["type"], event.Type) && matchesFilter(filters["event"], event.Action)
+	}
+
+	listener := make(chan docker.Event, 100)
+	server.mutex.Lock()
+	past := []docker.Event{}
+	if since, err := strconv.ParseInt(query.Get("since"), 10, 64); err == nil {
+		for _, event := range server.events {
+			if event.Time >= since {
+				past = append(past, event)
+			}
+		}
+	}
+	server.listeners[listener] = true
+	server.mutex.Unlock()
+	defer func() {
+		server.mutex.Lock()
+		delete(server.listeners, listener)
+		server.mutex.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+	write := func(event docker.Event) {
+		if matches(event) {
+			encoder.Encode(event)
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+	for _, event := range past {
+		write(event)
+	}
+	if flusher != nil {
+		flusher.Flush()
+	}
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-server.stop:
+			return
+		case event := <-listener:
+			write(event)
+		}
+	}
+}
+
+func matchesFilter(values []string, value string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, allowed := range values {
+		if allowed == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (server *Server) containerEventLocked(action string, c *container) {
+	server.eventLocked("container", action, c.ID, map[string]string{
+		"name":  strings.TrimPrefix(c.Names[0], "/"),
+		"image": c.Image,
+	})
+}
+
+// eventLocked records an event and sends it to the streams, dropping it for streams that are
+// behind
+func (server *Server) eventLocked(eventType, action, id string, attributes map[string]string) {
+	now := time.Now()
+	event := docker.Event{
+		Type:     eventType,
+		Action:   action,
+		Actor:    docker.EventActor{ID: id, Attributes: attributes},
+		Time:     now.Unix(),
+		TimeNano: now.UnixNano(),
+	}
+	server.events = append(server.events, event)
+	for listener := range server.listeners {
+		select {
+		case listener <- event:
+		default:
+		}
+	}
+}
+
+func (server *Server) addImageLocked(tags ...string) string {
+	repoTags := []string{}
+	for _, tag := range tags {
+		if !strings.Contains(tag, ":") {
+			tag += ":latest"
+		}
+		repoTags = append(repoTags, tag)
+	}
+	id := "sha256:" + newID()
+	server.images[id] = &docker.Image{
+		ID:          id,
+		RepoTags:    repoTags,
+		RepoDigests: []string{},
+		Created:     time.Now().Unix(),
+		Labels:      map[string]string{},
+	}
+	return id
+}
+
+// findImageLocked finds an image by repo:tag, repo meaning repo:latest, or ID
+func (server *Server) findImageLocked(name string) *docker.Image {
+	tag := name
+	if !strings.Contains(tag, ":") {
+		tag += ":latest"
+	}
+	for _, image := range server.images {
+		if image.ID == name || image.ID == "sha256:"+name || strings.HasPrefix(image.ID, "sha256:"+name) {
+			return image
+		}
+		for _, repoTag := range image.RepoTags {
+			if repoTag == tag {
+				return image
+			}
+		}
+	}
+	return nil
+}
+
+// findContainerLocked finds a container by name or ID
+func (server *Server) findContainerLocked(nameOrID string) *container {
+	for id, c := range server.containers {
+		if strings.HasPrefix(id, nameOrID) {
+			return c
+		}
+		for _, name := range c.Names {
+			if name == "/"+nameOrID {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+func (server *Server) containersLocked(all bool) []docker.Container {
+	containers := []docker.Container{}
+	for _, c := range server.containers {
+		if all || c.running {
+			containers = append(containers, c.Container)
+		}
+	}
+	sort.Slice(containers, func(i, j int) bool { return containers[i].Names[0] < containers[j].Names[0] })
+	return containers
+}
+
+func (server *Server) imagesLocked() []docker.Image {
+	images := []docker.Image{}
+	for _, image := range server.images {
+		images = append(images, *image)
+	}
+	sort.Slice(images, func(i, j int) bool { return images[i].ID < images[j].ID })
+	return images
+}
+
+func newID() string {
+	id := make([]byte, 32)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"message": message})
+}
@@ -0,0 +1,102 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rarmstrong73/go-utils/apierror"
+	"github.com/rarmstrong73/go-utils/internal/httpclient"
+)
+
+// Event is a change on a daemon, such as a container starting or an image being pulled
+type Event struct {
+	// Type is what changed, such as container, image, network or volume
+	Type string `json:"Type"`
+	// Action is what happened to it, such as create, start, die or pull
+	Action string     `json:"Action"`
+	Actor  EventActor `json:"Actor"`
+	// Time and TimeNano are when it happened, in seconds and nanoseconds since the epoch
+	Time     int64 `json:"time"`
+	TimeNano int64 `json:"timeNano"`
+	// Status, ID and From are how daemons before API 1.22 describe events, which have no Type,
+	// Action or Actor: the action, the container's or image's ID and the container's image
+	Status string `json:"status,omitempty"`
+	ID     string `json:"id,omitempty"`
+	From   string `json:"from,omitempty"`
+}
+
+// EventActor is the container, image or other object an event is about
+type EventActor struct {
+	ID string `json:"ID"`
+	// Attributes describe the actor, such as a container's name and image
+	Attributes map[string]string `json:"Attributes"`
+}
+
+// When returns the time of the event
+func (event Event) When() time.Time {
+	if event.TimeNano != 0 {
+		return time.Unix(0, event.TimeNano)
+	}
+	return time.Unix(event.Time, 0)
+}
+
+// StreamEvents calls handle with the events of the daemon on host since the given time, or from
+// now when since is zero, then with every new event as it happens, until ctx is done or the
+// stream fails. Filters narrow the events the way docker events --filter does, such as
+// {"type": {"container"}}. It returns ctx's error once ctx is done.
+func StreamEvents(ctx context.Context, host string, since time.Time, filters map[string][]string, handle func(Event)) error {
+	query := url.Values{}
+	if !since.IsZero() {
+		query.Set("since", strconv.FormatInt(since.Unix(), 10))
+	}
+	if len(filters) > 0 {
+		encoded, err := json.Marshal(filters)
+		if err != nil {
+			return err
+		}
+		query.Set("filters", string(encoded))
+	}
+
+	request := httpclient.Request{Method: http.MethodGet, URL: baseURL(host), Path: "/events", Query: query}
+	httpRequest, err := httpClient.NewRequest(httpclient.Streaming(ctx), request)
+	if err != nil {
+		return err
+	}
+	response, err := httpClient.Send(httpRequest)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return apierror.Transport(apierror.Docker, request.Operation(), err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(response.Body)
+		return responseError(response, strings.TrimSpace(string(body)))
+	}
+	logger.Debug("Streaming docker events", "host", host)
+
+	decoder := json.NewDecoder(response.Body)
+	for {
+		var event Event
+		err := decoder.Decode(&event)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return apierror.Transport(apierror.Docker, request.Operation(), fmt.Errorf("Failed to decode event: %w", err))
+		}
+		handle(event)
+	}
+}
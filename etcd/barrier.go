@@ -0,0 +1,144 @@
+package etcd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Barrier blocks participants until count of them have arrived. Participants register under
+// the barrier's directory and the last one to arrive marks the barrier ready, after which every
+// participant, including late ones, passes straight through until the barrier is Reset.
+// Barriers need a v2 client.
+type Barrier struct {
+	client *Client
+	path   string
+	count  int
+}
+
+// NewBarrier returns a barrier under path that opens once count participants have arrived
+func (client *Client) NewBarrier(path string, count int) *Barrier {
+	return &Barrier{client: client, path: "/" + strings.Trim(path, "/"), count: count}
+}
+
+// Wait registers participant at the barrier and blocks until the barrier is ready. It gives up
+// with an error if stop is closed first.
+func (barrier *Barrier) Wait(participant string, stop <-chan struct{}) error {
+	if _, err := barrier.client.SetKey(barrier.participantKey(participant), participant); err != nil {
+		return err
+	}
+
+	return barrier.waitFor(stop, func(ready bool, participants int) (bool, error) {
+		if ready {
+			return true, nil
+		}
+		if participants < barrier.count {
+			return false, nil
+		}
+		_, err := barrier.client.Create(barrier.readyKey(), "true", 0)
+		if err != nil && !errors.Is(err, ErrNodeExist) {
+			return false, err
+		}
+		return true, nil
+	})
+}
+
+// Participants returns the participants that have arrived at the barrier
+func (barrier *Barrier) Participants() ([]string, error) {
+	values, err := barrier.client.existingValues(barrier.path + "/participants")
+	if err != nil {
+		return nil, err
+	}
+
+	participants := []string{}
+	for _, participant := range values {
+		participants = append(participants, participant)
+	}
+	return participants, nil
+}
+
+// Reset removes the barrier so it can be used again
+func (barrier *Barrier) Reset() error {
+	return barrier.client.removeKey(barrier.path)
+}
+
+// waitFor reads the barrier and calls done with whether it is ready and how many participants
+// are registered, watching for changes until done returns true
+func (barrier *Barrier) waitFor(stop <-chan struct{}, done func(ready bool, participants int) (bool, error)) error {
+	for {
+		node, etcdIndex, err := barrier.client.getTree(barrier.path)
+		if err != nil {
+			return err
+		}
+
+		ready := false
+		participants := 0
+		for _, leaf := range leafNodes(node) {
+			if leaf.Key == barrier.readyKey() {
+				ready = true
+			} else if strings.HasPrefix(leaf.Key, barrier.path+"/participants/") {
+				participants++
+			}
+		}
+
+		finished, err := done(ready, participants)
+		if err != nil || finished {
+			return err
+		}
+
+		_, err = barrier.client.WatchKey(barrier.path, true, etcdIndex+1, stop)
+		select {
+		case <-stop:
+			return fmt.Errorf("Stopped waiting at barrier %s", barrier.path)
+		default:
+		}
+		if err != nil && !errors.Is(err, ErrEventIndexCleared) {
+			return err
+		}
+	}
+}
+
+func (barrier *Barrier) readyKey() string {
+	return barrier.path + "/ready"
+}
+
+func (barrier *Barrier) participantKey(participant string) string {
+	return barrier.path + "/participants/" + participant
+}
+
+// DoubleBarrier synchronizes participants on both entering and leaving a section of work:
+// Enter blocks until count participants have entered and Leave blocks until every participant
+// has left. DoubleBarriers need a v2 client.
+type DoubleBarrier struct {
+	Barrier
+}
+
+// NewDoubleBarrier returns a double barrier under path for count participants
+func (client *Client) NewDoubleBarrier(path string, count int) *DoubleBarrier {
+	return &DoubleBarrier{Barrier: *client.NewBarrier(path, count)}
+}
+
+// Enter registers participant and blocks until count participants have entered
+func (barrier *DoubleBarrier) Enter(participant string, stop <-chan struct{}) error {
+	return barrier.Wait(participant, stop)
+}
+
+// Leave unregisters participant and blocks until every participant has left. The last
+// participant to leave removes the barrier so it can be entered again.
+func (barrier *DoubleBarrier) Leave(participant string, stop <-chan struct{}) error {
+	if err := barrier.client.removeKey(barrier.participantKey(participant)); err != nil {
+		return err
+	}
+
+	return barrier.waitFor(stop, func(ready bool, participants int) (bool, error) {
+		if participants > 0 {
+			return false, nil
+		}
+		if ready {
+			if err := barrier.client.removeKey(barrier.readyKey()); err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+	})
+}
@@ -0,0 +1,80 @@
+package etcd_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rarmstrong73/go-utils/etcd/etcdtest"
+)
+
+func TestBarrierWaitsForEveryParticipant(t *testing.T) {
+	server := etcdtest.NewServer()
+	defer server.Close()
+	barrier := server.Client().NewBarrier("/barrier", 2)
+
+	first := make(chan error, 1)
+	go func() { first <- barrier.Wait("a", nil) }()
+	select {
+	case err := <-first:
+		t.Fatalf("Wait(a) = %v before b arrived", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := barrier.Wait("b", nil); err != nil {
+		t.Fatalf("Wait(b): %v", err)
+	}
+	select {
+	case err := <-first:
+		if err != nil {
+			t.Errorf("Wait(a): %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Wait(a) still blocked after b arrived")
+	}
+
+	// Late participants pass straight through until the barrier is reset
+	if err := barrier.Wait("c", nil); err != nil {
+		t.Errorf("Wait(c): %v", err)
+	}
+	if err := barrier.Reset(); err != nil {
+		t.Fatalf("Reset: %v", err)
+	}
+	stop := make(chan struct{})
+	time.AfterFunc(100*time.Millisecond, func() { close(stop) })
+	if err := barrier.Wait("d", stop); err == nil {
+		t.Error("Wait(d) passed a reset barrier alone")
+	}
+}
+
+func TestDoubleBarrier(t *testing.T) {
+	server := etcdtest.NewServer()
+	defer server.Close()
+	client := server.Client()
+	participants := []string{"a", "b", "c"}
+
+	errs := make(chan error, len(participants))
+	for _, participant := range participants {
+		go func(participant string) {
+			barrier := client.NewDoubleBarrier("/double", len(participants))
+			if err := barrier.Enter(participant, nil); err != nil {
+				errs <- err
+				return
+			}
+			errs <- barrier.Leave(participant, nil)
+		}(participant)
+	}
+	for range participants {
+		select {
+		case err := <-errs:
+			if err != nil {
+				t.Errorf("Enter or Leave: %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("participants still blocked at the double barrier")
+		}
+	}
+
+	if values := server.Values(); len(values) != 0 {
+		t.Errorf("values = %v, want the barrier removed by the last to leave", values)
+	}
+}
@@ -0,0 +1,166 @@
+package etcd
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rarmstrong73/go-utils/apierror"
+	"github.com/rarmstrong73/go-utils/internal/httpclient"
+)
+
+// PublicDiscoveryURL is the public etcd discovery service
+var PublicDiscoveryURL = "https://discovery.etcd.io"
+
+// discoveryClient talks to discovery services, which are outside the cluster
+var discoveryClient = httpclient.New(httpclient.Options{
+	Timeout:  30 * time.Second,
+	Service:  apierror.Etcd,
+	Endpoint: func(string) string { return "discovery" },
+})
+
+// discoveryRegistry is where self hosted discovery tokens live on a cluster
+var discoveryRegistry = "/_etcd/registry"
+
+// DiscoveryMember is a member that has registered itself with a discovery token
+type DiscoveryMember struct {
+	ID       string
+	Name     string
+	PeerURLs []string
+}
+
+// DiscoveryStatus describes how far a cluster bootstrapping from a discovery token has got
+type DiscoveryStatus struct {
+	Size    int
+	Members []DiscoveryMember
+}
+
+// Remaining returns how many members still have to register before the cluster is complete
+func (status DiscoveryStatus) Remaining() int {
+	if remaining := status.Size - len(status.Members); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+// NewDiscoveryToken asks the discovery service at serviceURL, such as PublicDiscoveryURL, for a
+// new token for a cluster of size members and returns the token's URL
+func NewDiscoveryToken(serviceURL string, size int) (string, error) {
+	response, err := discoveryClient.Do(context.Background(), httpclient.Request{
+		Method: http.MethodGet,
+		URL:    serviceURL,
+		Path:   "/new",
+		Query:  url.Values{"size": {strconv.Itoa(size)}},
+	})
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	responseBytes, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return "", err
+	}
+	if response.StatusCode != 200 {
+		return "", fmt.Errorf("%d: Failed to create discovery token: %s", response.StatusCode, strings.TrimSpace(string(responseBytes)))
+	}
+
+	return strings.TrimSpace(string(responseBytes)), nil
+}
+
+// CreateDiscoveryToken creates a token for a cluster of size members on the cluster the client
+// talks to, for bring-up without the public service. The returned URL is what new members are
+// given with --discovery. Discovery runs over the v2 API so this needs a v2 client.
+func (client *Client) CreateDiscoveryToken(size int) (string, error) {
+	if client.v3 {
+		return "", errV3Unsupported
+	}
+
+	tokenBytes := make([]byte, 16)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(tokenBytes)
+
+	path := fmt.Sprintf("%s/%s", discoveryRegistry, token)
+	if _, err := client.SetKey(path+"/_config/size", strconv.Itoa(size)); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("http://%s%s", hostAddress(client.Hosts()[0]), client.keyURL(path)), nil
+}
+
+// GetDiscoveryStatus returns the expected cluster size and the members registered so far with
+// the discovery token at tokenURL
+func GetDiscoveryStatus(tokenURL string) (DiscoveryStatus, error) {
+	tokenURL = strings.TrimSuffix(tokenURL, "/")
+
+	sizeNode, err := getDiscoveryNode(tokenURL + "/_config/size")
+	if err != nil {
+		return DiscoveryStatus{}, err
+	}
+	size, err := strconv.Atoi(sizeNode.Value)
+	if err != nil {
+		return DiscoveryStatus{}, fmt.Errorf("Invalid discovery size %q: %v", sizeNode.Value, err)
+	}
+
+	tokenNode, err := getDiscoveryNode(tokenURL)
+	if err != nil {
+		return DiscoveryStatus{}, err
+	}
+
+	status := DiscoveryStatus{Size: size}
+	for _, node := range tokenNode.Nodes {
+		if node.Dir {
+			continue
+		}
+		status.Members = append(status.Members, parseDiscoveryMember(node))
+	}
+	return status, nil
+}
+
+// parseDiscoveryMember parses a registration, whose value looks like name=peerURL,name=peerURL
+func parseDiscoveryMember(node Node) DiscoveryMember {
+	member := DiscoveryMember{ID: node.Key[strings.LastIndex(node.Key, "/")+1:]}
+	for _, pair := range strings.Split(node.Value, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		member.Name = parts[0]
+		member.PeerURLs = append(member.PeerURLs, parts[1])
+	}
+	return member
+}
+
+func getDiscoveryNode(url string) (Node, error) {
+	response, err := discoveryClient.Do(context.Background(), httpclient.Request{Method: http.MethodGet, URL: url})
+	if err != nil {
+		return Node{}, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != 200 {
+		return Node{}, handleError(response)
+	}
+
+	responseBytes, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return Node{}, err
+	}
+
+	var nodeResponse Response
+	err = json.Unmarshal(responseBytes, &nodeResponse)
+	if err != nil {
+		return Node{}, err
+	}
+	return nodeResponse.Node, nil
+}
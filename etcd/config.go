@@ -0,0 +1,219 @@
+package etcd
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// LoadConfig reads the subtree at prefix into the struct pointed to by config. Each exported
+// field is read from the key named by its `etcd` tag, or by the field name when it has no tag,
+// and a tag of "-" skips the field. Nested structs are read from the subdirectory of the same
+// name. Strings, bools, ints, uints, floats and time.Durations are supported. Fields whose keys
+// don't exist are left unchanged, so defaults can be set before loading.
+func LoadConfig(host, prefix string, config interface{}) error {
+	return NewClient(host).LoadConfig(prefix, config)
+}
+
+// LoadConfig reads the subtree at prefix into the struct pointed to by config, see the package
+// level LoadConfig for details
+func (client *Client) LoadConfig(prefix string, config interface{}) error {
+	value := reflect.ValueOf(config)
+	if value.Kind() != reflect.Ptr || value.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("LoadConfig needs a pointer to a struct, got %T", config)
+	}
+
+	values, err := client.existingValues(prefix)
+	if err != nil {
+		return err
+	}
+
+	return loadStruct(value.Elem(), "/"+strings.Trim(prefix, "/"), values)
+}
+
+func loadStruct(value reflect.Value, dir string, values map[string]string) error {
+	structType := value.Type()
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		name, ok := configKey(field)
+		if !ok {
+			continue
+		}
+		key := dir + "/" + name
+
+		if field.Type.Kind() == reflect.Struct {
+			if err := loadStruct(value.Field(i), key, values); err != nil {
+				return err
+			}
+			continue
+		}
+
+		raw, ok := values[key]
+		if !ok {
+			continue
+		}
+		if err := setField(value.Field(i), raw); err != nil {
+			return fmt.Errorf("Failed to load %s into %s: %v", key, field.Name, err)
+		}
+	}
+	return nil
+}
+
+func setField(field reflect.Value, raw string) error {
+	if field.Type() == durationType {
+		duration, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(duration))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(raw)
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		field.SetBool(parsed)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		parsed, err := strconv.ParseInt(raw, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetInt(parsed)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		parsed, err := strconv.ParseUint(raw, 10, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetUint(parsed)
+	case reflect.Float32, reflect.Float64:
+		parsed, err := strconv.ParseFloat(raw, field.Type().Bits())
+		if err != nil {
+			return err
+		}
+		field.SetFloat(parsed)
+	default:
+		return fmt.Errorf("Unsupported field type %s", field.Type())
+	}
+	return nil
+}
+
+// SaveConfig writes the struct config as individual keys under prefix, using the same field to
+// key mapping as LoadConfig. Only keys that are missing or whose value differs are written, the
+// returned changes list them.
+func SaveConfig(host, prefix string, config interface{}) ([]Change, error) {
+	return NewClient(host).SaveConfig(prefix, config)
+}
+
+// SaveConfig writes the struct config as individual keys under prefix, see the package level
+// SaveConfig for details
+func (client *Client) SaveConfig(prefix string, config interface{}) ([]Change, error) {
+	value := reflect.Indirect(reflect.ValueOf(config))
+	if value.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("SaveConfig needs a struct, got %T", config)
+	}
+
+	values := map[string]string{}
+	if err := saveStruct(value, "/"+strings.Trim(prefix, "/"), values); err != nil {
+		return nil, err
+	}
+
+	existing, err := client.existingValues(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var changes []Change
+	for _, key := range keys {
+		change := Change{Key: key, Action: Create, NewValue: values[key]}
+		if oldValue, ok := existing[key]; ok {
+			if oldValue == values[key] {
+				continue
+			}
+			change.Action = Update
+			change.OldValue = oldValue
+		}
+
+		if _, err = client.SetKey(key, values[key]); err != nil {
+			return changes, err
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+func saveStruct(value reflect.Value, dir string, values map[string]string) error {
+	structType := value.Type()
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		name, ok := configKey(field)
+		if !ok {
+			continue
+		}
+		key := dir + "/" + name
+
+		if field.Type.Kind() == reflect.Struct {
+			if err := saveStruct(value.Field(i), key, values); err != nil {
+				return err
+			}
+			continue
+		}
+
+		raw, err := formatField(value.Field(i))
+		if err != nil {
+			return fmt.Errorf("Failed to save %s from %s: %v", key, field.Name, err)
+		}
+		values[key] = raw
+	}
+	return nil
+}
+
+func formatField(field reflect.Value) (string, error) {
+	if field.Type() == durationType {
+		return time.Duration(field.Int()).String(), nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		return field.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(field.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(field.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(field.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(field.Float(), 'g', -1, field.Type().Bits()), nil
+	}
+	return "", fmt.Errorf("Unsupported field type %s", field.Type())
+}
+
+// configKey returns the key name of a struct field and whether the field is part of the config
+func configKey(field reflect.StructField) (string, bool) {
+	if field.PkgPath != "" {
+		return "", false
+	}
+	tag := field.Tag.Get("etcd")
+	if tag == "-" {
+		return "", false
+	}
+	if tag == "" {
+		return field.Name, true
+	}
+	return tag, true
+}
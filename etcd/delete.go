@@ -0,0 +1,69 @@
+package etcd
+
+import (
+	"sort"
+	"strings"
+)
+
+// DeleteOptions controls DeletePrefix
+type DeleteOptions struct {
+	// DryRun lists the keys that would be removed without removing them
+	DryRun bool
+}
+
+// DeleteResult reports what DeletePrefix removed, or would have removed on a dry run
+type DeleteResult struct {
+	Count int
+	Keys  []string
+}
+
+// DeletePrefix removes the directory at prefix and every key below it. A prefix that doesn't
+// exist removes nothing and is not an error.
+func DeletePrefix(host, prefix string, opts DeleteOptions) (DeleteResult, error) {
+	return NewClient(host).DeletePrefix(prefix, opts)
+}
+
+// DeletePrefix removes the directory at prefix and every key below it. v2 clients delete the
+// directory recursively and v3 clients delete the key range, in both cases in a single request.
+// A prefix that doesn't exist removes nothing and is not an error.
+func (client *Client) DeletePrefix(prefix string, opts DeleteOptions) (DeleteResult, error) {
+	prefix = "/" + strings.Trim(prefix, "/")
+
+	if client.v3 && !opts.DryRun {
+		return client.v3DeletePrefix(prefix)
+	}
+
+	values, err := client.existingValues(prefix)
+	if err != nil {
+		return DeleteResult{}, err
+	}
+	result := DeleteResult{Count: len(values)}
+	for key := range values {
+		result.Keys = append(result.Keys, key)
+	}
+	sort.Strings(result.Keys)
+
+	if opts.DryRun || len(values) == 0 {
+		return result, nil
+	}
+	return result, client.removeKey(prefix)
+}
+
+func (client *Client) v3DeletePrefix(prefix string) (DeleteResult, error) {
+	result := DeleteResult{}
+	for _, keyRange := range [][2][]byte{
+		{[]byte(prefix), nil},
+		{[]byte(prefix + "/"), PrefixRangeEnd([]byte(prefix + "/"))},
+	} {
+		deleteResponse, err := client.DeleteRange(keyRange[0], keyRange[1])
+		if err != nil {
+			return result, err
+		}
+		result.Count += int(deleteResponse.Deleted)
+		for _, kv := range deleteResponse.PrevKvs {
+			result.Keys = append(result.Keys, string(kv.Key))
+		}
+	}
+	sort.Strings(result.Keys)
+	return result, nil
+}
@@ -0,0 +1,35 @@
+package etcd
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// DiscoverEndpoints resolves the _etcd-client._tcp SRV records of domain into host:port
+// endpoints, ordered by priority and weight, that can be passed to NewClient or NewV3Client
+func DiscoverEndpoints(domain string) ([]string, error) {
+	_, records, err := net.LookupSRV("etcd-client", "tcp", domain)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("No _etcd-client._tcp SRV records found for %s", domain)
+	}
+
+	endpoints := make([]string, 0, len(records))
+	for _, record := range records {
+		host := strings.TrimSuffix(record.Target, ".")
+		endpoints = append(endpoints, net.JoinHostPort(host, strconv.Itoa(int(record.Port))))
+	}
+	return endpoints, nil
+}
+
+// hostAddress returns host with the default client port added if it doesn't specify one
+func hostAddress(host string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
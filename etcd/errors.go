@@ -0,0 +1,78 @@
+package etcd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/rarmstrong73/go-utils/apierror"
+)
+
+// etcd error codes
+const (
+	EcodeKeyNotFound       = 100
+	EcodeTestFailed        = 101
+	EcodeNotFile           = 102
+	EcodeNotDir            = 104
+	EcodeNodeExist         = 105
+	EcodeRootROnly         = 107
+	EcodeDirNotEmpty       = 108
+	EcodeUnauthorized      = 110
+	EcodePrevValueRequired = 201
+	EcodeTTLNaN            = 202
+	EcodeIndexNaN          = 203
+	EcodeInvalidField      = 209
+	EcodeInvalidForm       = 210
+	EcodeRaftInternal      = 300
+	EcodeLeaderElect       = 301
+	EcodeWatcherCleared    = 400
+	EcodeEventIndexCleared = 401
+)
+
+// Sentinel errors that can be matched against the errors returned by this package with errors.Is
+var (
+	ErrKeyNotFound       = &Error{ErrorCode: EcodeKeyNotFound, Message: "Key not found"}
+	ErrTestFailed        = &Error{ErrorCode: EcodeTestFailed, Message: "Compare failed"}
+	ErrNotFile           = &Error{ErrorCode: EcodeNotFile, Message: "Not a file"}
+	ErrNotDir            = &Error{ErrorCode: EcodeNotDir, Message: "Not a directory"}
+	ErrNodeExist         = &Error{ErrorCode: EcodeNodeExist, Message: "Key already exists"}
+	ErrRootReadOnly      = &Error{ErrorCode: EcodeRootROnly, Message: "Root is read only"}
+	ErrDirNotEmpty       = &Error{ErrorCode: EcodeDirNotEmpty, Message: "Directory not empty"}
+	ErrUnauthorized      = &Error{ErrorCode: EcodeUnauthorized, Message: "The request requires user authentication"}
+	ErrRaftInternal      = &Error{ErrorCode: EcodeRaftInternal, Message: "Raft Internal Error"}
+	ErrLeaderElect       = &Error{ErrorCode: EcodeLeaderElect, Message: "During Leader Election"}
+	ErrWatcherCleared    = &Error{ErrorCode: EcodeWatcherCleared, Message: "watcher is cleared due to etcd recovery"}
+	ErrEventIndexCleared = &Error{ErrorCode: EcodeEventIndexCleared, Message: "The event in requested index is outdated and cleared"}
+)
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d: %s (%s)", e.ErrorCode, e.Message, e.Cause)
+}
+
+// Is reports whether target is an etcd error with the same error code
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.ErrorCode == e.ErrorCode
+}
+
+// handleError returns the error for a failed response, an *apierror.Error wrapping the *Error
+// in the response's body
+func handleError(response *http.Response) error {
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	return responseError(response, body)
+}
+
+// responseError returns the error for a failed response whose body has already been read
+func responseError(response *http.Response, body []byte) error {
+	var errorResponse Error
+	if err := json.Unmarshal(body, &errorResponse); err != nil || errorResponse.ErrorCode == 0 {
+		return apierror.FromResponse(apierror.Etcd, response, "", strings.TrimSpace(string(body)), nil)
+	}
+	return apierror.FromResponse(apierror.Etcd, response, strconv.Itoa(errorResponse.ErrorCode), errorResponse.Message, &errorResponse)
+}
@@ -1,14 +1,27 @@
 package etcd
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/rarmstrong73/go-utils/apierror"
+	"github.com/rarmstrong73/go-utils/breaker"
+	"github.com/rarmstrong73/go-utils/cache"
+	"github.com/rarmstrong73/go-utils/internal/httpclient"
+	"github.com/rarmstrong73/go-utils/logging"
+	"github.com/rarmstrong73/go-utils/proxy"
+	"github.com/rarmstrong73/go-utils/ratelimit"
+	"github.com/rarmstrong73/go-utils/timeouts"
 )
 
 var port = 2379
@@ -16,12 +29,22 @@ var apiVersion = "v2"
 
 // Node represents an etcd node
 type Node struct {
-	Dir           bool   `json:"dir"`
-	Nodes         []Node `json:"nodes"`
-	Key           string `json:"key"`
-	Value         string `json:"value"`
-	ModifiedIndex int64  `json:"modifiedIndex"`
-	CreatedIndex  int64  `json:"createdIndex"`
+	Dir           bool       `json:"dir"`
+	Nodes         []Node     `json:"nodes"`
+	Key           string     `json:"key"`
+	Value         string     `json:"value"`
+	ModifiedIndex int64      `json:"modifiedIndex"`
+	CreatedIndex  int64      `json:"createdIndex"`
+	TTL           int64      `json:"ttl,omitempty"`
+	Expiration    *time.Time `json:"expiration,omitempty"`
+}
+
+// ExpiresIn returns how long until the node expires, and false if the node has no TTL
+func (node Node) ExpiresIn() (time.Duration, bool) {
+	if node.Expiration == nil {
+		return 0, false
+	}
+	return time.Until(*node.Expiration), true
 }
 
 // Response is the response from a get request to etcd
@@ -30,7 +53,7 @@ type Response struct {
 	Node   Node   `json:"node"`
 }
 
-// SetResponse is the response object returned by running a set
+// SetResponse is the response object returned by running a set or delete
 type SetResponse struct {
 	Action   string `json:"action"`
 	Node     Node   `json:"node"`
@@ -45,127 +68,455 @@ type Error struct {
 	Index     int64  `json:"index"`
 }
 
+// Client is a connection to an etcd cluster
+type Client struct {
+	hosts      []string
+	v3         bool
+	httpClient *httpclient.Client
+	retry      RetryPolicy
+	metrics    Metrics
+	logger     logging.Logger
+	namespace  string
+
+	mutex   sync.Mutex
+	current int
+}
+
+// NewClient returns a client for the etcd cluster with members on the given hosts. Hosts without
+// a port use the default client port. Requests go to the first host until it fails, see RetryPolicy.
+func NewClient(hosts ...string) *Client {
+	return &Client{
+		hosts:      hosts,
+		httpClient: httpclient.New(httpclient.Options{Service: apierror.Etcd, Endpoint: endpointName}),
+		retry:      DefaultRetryPolicy,
+		metrics:    noopMetrics{},
+		logger:     logging.Nop,
+	}
+}
+
+// SetHosts replaces the hosts of the cluster's members, such as with ones found by the discovery
+// package. Requests keep going to the current member while it is still one of the hosts.
+// Namespaces and other clients made from the client before keep the hosts they had.
+func (client *Client) SetHosts(hosts ...string) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	current := ""
+	if client.current < len(client.hosts) {
+		current = client.hosts[client.current]
+	}
+	client.hosts = append([]string{}, hosts...)
+	client.current = 0
+	for index, host := range client.hosts {
+		if host == current {
+			client.current = index
+		}
+	}
+}
+
+// Hosts returns the hosts of the cluster's members
+func (client *Client) Hosts() []string {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	return append([]string{}, client.hosts...)
+}
+
+// SetLogger sets where the client logs, nil turns logging off
+func (client *Client) SetLogger(logger logging.Logger) {
+	client.logger = logging.OrNop(logger)
+}
+
+// SetRateLimiter limits the rate at which the client sends requests, nil removes the limit
+func (client *Client) SetRateLimiter(limiter ratelimit.Limiter) {
+	client.httpClient.Configure(func(options *httpclient.Options) {
+		options.Limiter = limiter
+	})
+}
+
+// SetCircuitBreaker stops the client sending requests to members whose circuit is open, failing
+// over to the next member, nil removes the breaker
+func (client *Client) SetCircuitBreaker(b breaker.Breaker) {
+	client.httpClient.Configure(func(options *httpclient.Options) {
+		options.Breaker = b
+	})
+}
+
+// SetCache serves the client's reads from responses when it has them, nil turns caching off
+func (client *Client) SetCache(responses cache.Cache) {
+	client.httpClient.Configure(func(options *httpclient.Options) {
+		options.Cache = responses
+	})
+}
+
+// SetTimeouts sets the client's connect, read and operation timeouts, nil goes back to the
+// defaults of the timeouts package. Watches only have the connect timeout.
+func (client *Client) SetTimeouts(t *timeouts.Timeouts) {
+	client.httpClient.Configure(func(options *httpclient.Options) {
+		options.Timeouts = t
+	})
+}
+
+// SetProxy sets the proxy the client's requests go through, nil goes back to the default of the
+// proxy package
+func (client *Client) SetProxy(p proxy.Func) {
+	client.httpClient.Configure(func(options *httpclient.Options) {
+		options.Proxy = p
+	})
+}
+
+// Shutdown stops the client and the clients made from it: new requests fail with
+// apierror.ErrClosed, watches, subscriptions and leases stop straight away and requests in flight
+// are given until ctx is done to finish before they are cancelled. It returns ctx's error if
+// requests had to be cancelled.
+func (client *Client) Shutdown(ctx context.Context) error {
+	return client.httpClient.Shutdown(ctx)
+}
+
+// Close stops the client like Shutdown, cancelling requests in flight straight away
+func (client *Client) Close() error {
+	return client.httpClient.Close()
+}
+
 // GetKey returns the node at the given path
 func GetKey(host, path string) (Node, error) {
-	url := fmt.Sprintf("http://%s:%d/%s/keys/%s", host, port, apiVersion, path)
-	response := httpGetResponse(url)
+	return NewClient(host).GetKey(path)
+}
+
+// GetKey returns the node at the given path
+func (client *Client) GetKey(path string) (Node, error) {
+	if client.v3 {
+		return client.v3GetKey(path, false)
+	}
+
+	response, err := client.httpGetResponse(client.keyURL(path))
+	if err != nil {
+		return Node{}, err
+	}
 	defer response.Body.Close()
 
-	if response.StatusCode == 404 {
-		return Node{}, handleError(response.Body)
+	if response.StatusCode != 200 {
+		return Node{}, handleError(response)
 	}
 
 	responseBytes, err := ioutil.ReadAll(response.Body)
 	if err != nil {
-		log.Fatal(err)
+		return Node{}, err
 	}
 
 	var nodeResponse Response
 	err = json.Unmarshal(responseBytes, &nodeResponse)
 	if err != nil {
-		log.Fatal(err)
+		return Node{}, err
 	}
 
-	return nodeResponse.Node, nil
+	return client.stripNode(nodeResponse.Node), nil
+}
+
+// Exists reports whether the key at the given path exists, a missing key is not an error
+func Exists(host, path string) (bool, error) {
+	return NewClient(host).Exists(path)
+}
+
+// Exists reports whether the key at the given path exists, a missing key is not an error
+func (client *Client) Exists(path string) (bool, error) {
+	_, err := client.GetKey(path)
+	var apiError *apierror.Error
+	if errors.Is(err, ErrKeyNotFound) || (errors.As(err, &apiError) && apiError.StatusCode == http.StatusNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
 }
 
 // SetKey sets or updates the value at the given path
 func SetKey(host, path, value string) (prevNode Node, err error) {
-	url := fmt.Sprintf("http://%s:%d/%s/keys/%s", host, port, apiVersion, path)
-	body := fmt.Sprintf("value=%s", value)
+	return NewClient(host).SetKey(path, value)
+}
+
+// SetKey sets or updates the value at the given path
+func (client *Client) SetKey(path, value string) (prevNode Node, err error) {
+	setResponse, err := client.Set(path, value)
+	if err != nil {
+		return Node{}, err
+	}
+	return setResponse.PrevNode, nil
+}
 
-	response := httpPutResponse(url, []byte(body))
+// Set sets or updates the value at the given path, returning the new node as well as the previous one
+func Set(host, path, value string) (SetResponse, error) {
+	return NewClient(host).Set(path, value)
+}
+
+// Set sets or updates the value at the given path, returning the new node as well as the previous one
+func (client *Client) Set(path, value string) (SetResponse, error) {
+	if client.v3 {
+		return client.v3Set(path, value)
+	}
+
+	body := url.Values{"value": {value}}.Encode()
+
+	response, err := client.httpPutResponse(client.keyURL(path), []byte(body))
+	if err != nil {
+		return SetResponse{}, err
+	}
 	defer response.Body.Close()
 
 	if response.StatusCode != 200 && response.StatusCode != 201 {
-		return Node{}, handleError(response.Body)
+		return SetResponse{}, handleError(response)
 	}
 
-	responseBytes, err := ioutil.ReadAll(response.Body)
+	return client.decodeSetResponse(response.Body)
+}
+
+// SetWithTTL sets the value at the given path, the key is deleted after ttl seconds unless it is
+// set or refreshed again. Only v2 clients support TTLs, v3 clients should use leases.
+func (client *Client) SetWithTTL(path, value string, ttl int) (SetResponse, error) {
+	return client.conditionalSet(ActionSet, path, url.Values{"value": {value}, "ttl": {strconv.Itoa(ttl)}})
+}
+
+// Create sets the value at the given path only if the key doesn't exist yet, failing with
+// ErrNodeExist otherwise. A ttl of 0 creates a permanent key.
+func (client *Client) Create(path, value string, ttl int) (SetResponse, error) {
+	form := url.Values{"value": {value}, "prevExist": {"false"}}
+	if ttl > 0 {
+		form.Set("ttl", strconv.Itoa(ttl))
+	}
+	return client.conditionalSet(ActionCreate, path, form)
+}
+
+// CompareAndSwap sets the value at the given path only if the key was last modified at
+// prevIndex, failing with ErrTestFailed otherwise
+func (client *Client) CompareAndSwap(path, value string, prevIndex int64) (SetResponse, error) {
+	return client.conditionalSet(ActionCompareAndSwap, path, url.Values{"value": {value}, "prevIndex": {strconv.FormatInt(prevIndex, 10)}})
+}
+
+// CompareAndDelete deletes the key at the given path only if it was last modified at prevIndex,
+// failing with ErrTestFailed otherwise
+func (client *Client) CompareAndDelete(path string, prevIndex int64) (SetResponse, error) {
+	if client.v3 {
+		return SetResponse{}, errV3Unsupported
+	}
+
+	response, err := client.httpDeleteResponse(client.keyURL(fmt.Sprintf("%s?prevIndex=%d", path, prevIndex)))
 	if err != nil {
-		log.Fatal(err)
+		return SetResponse{}, err
 	}
+	defer response.Body.Close()
 
-	var setResponse SetResponse
-	err = json.Unmarshal(responseBytes, &setResponse)
+	if response.StatusCode != 200 {
+		return SetResponse{}, client.reportConflict(ActionCompareAndDelete, handleError(response))
+	}
+
+	return client.decodeSetResponse(response.Body)
+}
+
+// conditionalSet sets the key at the given path from form, reporting a failed condition as a
+// conflict of action
+func (client *Client) conditionalSet(action, path string, form url.Values) (SetResponse, error) {
+	if client.v3 {
+		return SetResponse{}, errV3Unsupported
+	}
+
+	response, err := client.httpPutResponse(client.keyURL(path), []byte(form.Encode()))
 	if err != nil {
-		log.Fatal(err)
+		return SetResponse{}, err
 	}
+	defer response.Body.Close()
 
-	return setResponse.PrevNode, nil
+	if response.StatusCode != 200 && response.StatusCode != 201 {
+		return SetResponse{}, client.reportConflict(action, handleError(response))
+	}
+
+	return client.decodeSetResponse(response.Body)
+}
+
+// RefreshKey resets the TTL of the existing key at the given path without changing its value,
+// so watchers of the key aren't notified
+func RefreshKey(host, path string, ttl int) (Node, error) {
+	return NewClient(host).RefreshKey(path, ttl)
+}
+
+// RefreshKey resets the TTL of the existing key at the given path without changing its value,
+// so watchers of the key aren't notified. Only v2 clients support refreshing keys.
+func (client *Client) RefreshKey(path string, ttl int) (Node, error) {
+	if client.v3 {
+		return Node{}, errV3Unsupported
+	}
+
+	body := url.Values{
+		"ttl":       {strconv.Itoa(ttl)},
+		"refresh":   {"true"},
+		"prevExist": {"true"},
+	}.Encode()
+
+	response, err := client.httpPutResponse(client.keyURL(path), []byte(body))
+	if err != nil {
+		return Node{}, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != 200 {
+		return Node{}, handleError(response)
+	}
+
+	setResponse, err := client.decodeSetResponse(response.Body)
+	if err != nil {
+		return Node{}, err
+	}
+
+	return setResponse.Node, nil
 }
 
 // DeleteKey deletes the key at the given path
 func DeleteKey(host, path string) error {
-	url := fmt.Sprintf("http://%s:%d/%s/keys/%s", host, port, apiVersion, path)
-	response := httpDeleteResponse(url)
+	return NewClient(host).DeleteKey(path)
+}
+
+// DeleteKey deletes the key at the given path
+func (client *Client) DeleteKey(path string) error {
+	_, err := client.Delete(path)
+	return err
+}
+
+// Delete deletes the key at the given path, returning the deleted node's final state
+func Delete(host, path string) (SetResponse, error) {
+	return NewClient(host).Delete(path)
+}
+
+// Delete deletes the key at the given path, returning the deleted node's final state
+func (client *Client) Delete(path string) (SetResponse, error) {
+	if client.v3 {
+		return client.v3Delete(path)
+	}
+
+	response, err := client.httpDeleteResponse(client.keyURL(path))
+	if err != nil {
+		return SetResponse{}, err
+	}
 	defer response.Body.Close()
 
 	if response.StatusCode != 200 {
-		return handleError(response.Body)
+		return SetResponse{}, handleError(response)
 	}
 
-	return nil
+	return client.decodeSetResponse(response.Body)
 }
 
 // RecurseKeys returns a recursive listing of the keys at the given path
 func RecurseKeys(host, path string) (Node, error) {
-	return GetKey(host, fmt.Sprintf("%s?recursive=true", path))
+	return NewClient(host).RecurseKeys(path)
+}
+
+// RecurseKeys returns a recursive listing of the keys at the given path
+func (client *Client) RecurseKeys(path string) (Node, error) {
+	if client.v3 {
+		return client.v3GetKey(path, true)
+	}
+	return client.GetKey(fmt.Sprintf("%s?recursive=true", path))
 }
 
-func handleError(body io.ReadCloser) error {
-	bytes, err := ioutil.ReadAll(body)
+// GetValues returns the values of every key under prefix keyed by their full key, directories are omitted
+func GetValues(host, prefix string) (map[string]string, error) {
+	return NewClient(host).GetValues(prefix)
+}
+
+// GetValues returns the values of every key under prefix keyed by their full key, directories are omitted
+func (client *Client) GetValues(prefix string) (map[string]string, error) {
+	node, err := client.RecurseKeys(prefix)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
+	return Export{Root: node}.Flatten(), nil
+}
 
-	var errorResponse Error
-	err = json.Unmarshal(bytes, &errorResponse)
+func (client *Client) decodeSetResponse(body io.Reader) (SetResponse, error) {
+	responseBytes, err := ioutil.ReadAll(body)
 	if err != nil {
-		log.Fatal(err)
+		return SetResponse{}, err
 	}
 
-	return fmt.Errorf("%d: %s (%s)", errorResponse.ErrorCode, errorResponse.Message, errorResponse.Cause)
+	var setResponse SetResponse
+	err = json.Unmarshal(responseBytes, &setResponse)
+	if err != nil {
+		return SetResponse{}, err
+	}
+
+	setResponse.Node = client.stripNode(setResponse.Node)
+	setResponse.PrevNode = client.stripNode(setResponse.PrevNode)
+	return setResponse, nil
+}
+
+func (client *Client) keyURL(path string) string {
+	return fmt.Sprintf("/%s/keys%s/%s", apiVersion, client.namespace, strings.TrimPrefix(path, "/"))
 }
 
 // ============================================================================
 // ============================= HTTP UTILS ===================================
 // ============================================================================
 
-func httpGetResponse(url string) *http.Response {
-	response, err := http.Get(url)
-	if err != nil {
-		log.Fatal(err)
-	}
-	return response
+func (client *Client) httpGetResponse(path string) (*http.Response, error) {
+	return client.doHTTPResponse(context.Background(), http.MethodGet, path, nil, "")
 }
 
-func httpPutResponse(url string, body []byte) *http.Response {
-	client := &http.Client{}
-	request, err := http.NewRequest(http.MethodPut, url, bytes.NewReader(body))
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	request.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+func (client *Client) httpPutResponse(path string, body []byte) (*http.Response, error) {
+	return client.doHTTPResponse(context.Background(), http.MethodPut, path, body, "application/x-www-form-urlencoded")
+}
 
-	response, err := client.Do(request)
-	if err != nil {
-		log.Fatal(err)
-	}
-	return response
+func (client *Client) httpDeleteResponse(path string) (*http.Response, error) {
+	return client.doHTTPResponse(context.Background(), http.MethodDelete, path, nil, "")
 }
 
-func httpDeleteResponse(url string) *http.Response {
-	client := &http.Client{}
-	request, err := http.NewRequest(http.MethodDelete, url, strings.NewReader(""))
-	if err != nil {
-		log.Fatal(err)
+// doHTTPResponse sends the request to the current endpoint, moving on to the next endpoint and
+// backing off according to the retry policy when the request fails in a retryable way. Requests
+// that aren't idempotent are only retried when they never reached a member.
+func (client *Client) doHTTPResponse(ctx context.Context, method, path string, body []byte, contentType string) (*http.Response, error) {
+	client.mutex.Lock()
+	hosts := client.hosts
+	start := client.current
+	client.mutex.Unlock()
+	if len(hosts) == 0 {
+		return nil, errNoEndpoints
 	}
 
-	response, err := client.Do(request)
-	if err != nil {
-		log.Fatal(err)
+	ctx, release := client.httpClient.Deadline(ctx)
+	index := start
+	response, err := httpclient.Retry(ctx, client.retryPolicy(method, path, body), func(attempt int) (*http.Response, error) {
+		index = (start + attempt) % len(hosts)
+		request, err := client.httpClient.NewRequest(ctx, httpclient.Request{
+			Method:      method,
+			URL:         "http://" + hostAddress(hosts[index]),
+			Path:        path,
+			Body:        body,
+			ContentType: contentType,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		started := time.Now()
+		response, err := client.httpClient.Send(request)
+		info := RequestInfo{
+			Operation: operationName(method, path),
+			Endpoint:  hosts[index],
+			Err:       err,
+			Duration:  time.Since(started),
+		}
+		if response != nil {
+			info.StatusCode = response.StatusCode
+		}
+		client.metrics.RequestDone(info)
+		if attempt > 0 {
+			client.logger.Debug("Retried etcd request", "operation", info.Operation, "endpoint", info.Endpoint, "attempt", attempt+1)
+		}
+		return response, apierror.Transport(apierror.Etcd, method+" "+strings.SplitN(path, "?", 2)[0], err)
+	})
+	if err == nil {
+		client.mutex.Lock()
+		if index < len(client.hosts) && client.hosts[index] == hosts[index] {
+			client.current = index
+		}
+		client.mutex.Unlock()
 	}
-	return response
+	return httpclient.ReleaseOnClose(response, release), err
 }
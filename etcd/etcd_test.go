@@ -0,0 +1,111 @@
+package etcd_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rarmstrong73/go-utils/apierror"
+	"github.com/rarmstrong73/go-utils/etcd"
+	"github.com/rarmstrong73/go-utils/etcd/etcdtest"
+	"github.com/rarmstrong73/go-utils/timeouts"
+)
+
+func TestExists(t *testing.T) {
+	server := etcdtest.NewServer()
+	defer server.Close()
+	client := server.Client()
+	if _, err := client.SetKey("/a", "1"); err != nil {
+		t.Fatalf("SetKey: %v", err)
+	}
+
+	if exists, err := client.Exists("/a"); err != nil || !exists {
+		t.Errorf("Exists(/a) = %t, %v, want true", exists, err)
+	}
+	if exists, err := client.Exists("/missing"); err != nil || exists {
+		t.Errorf("Exists(/missing) = %t, %v, want false", exists, err)
+	}
+}
+
+func TestGetKeyReturnsErrorResponses(t *testing.T) {
+	tests := []struct {
+		status int
+		body   string
+	}{
+		{http.StatusForbidden, `{"errorCode":110,"message":"The request requires user authentication"}`},
+		{http.StatusInternalServerError, `{"errorCode":300,"message":"Raft Internal Error"}`},
+	}
+	for _, test := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(test.status)
+			w.Write([]byte(test.body))
+		}))
+		client := etcd.NewClient(strings.TrimPrefix(server.URL, "http://"))
+		client.SetRetryPolicy(etcd.RetryPolicy{MaxAttempts: 1})
+
+		var apiError *apierror.Error
+		if _, err := client.GetKey("/a"); !errors.As(err, &apiError) || apiError.StatusCode != test.status {
+			t.Errorf("GetKey with a %d = %v, want the error response", test.status, err)
+		}
+		if exists, err := client.Exists("/a"); err == nil {
+			t.Errorf("Exists with a %d = %t, want the error response", test.status, exists)
+		}
+		server.Close()
+	}
+}
+
+// TestSettersWhileRequesting changes a client's configuration while it and a namespace of it send
+// requests, which go test -race reports if the setters aren't safe for it
+func TestSettersWhileRequesting(t *testing.T) {
+	server := etcdtest.NewServer()
+	defer server.Close()
+	client := server.Client()
+	namespaced := client.Namespace("/app")
+	if _, err := namespaced.SetKey("/a", "1"); err != nil {
+		t.Fatalf("SetKey: %v", err)
+	}
+
+	var wait sync.WaitGroup
+	for _, requester := range []*etcd.Client{client, namespaced, client, namespaced} {
+		wait.Add(1)
+		go func(requester *etcd.Client) {
+			defer wait.Done()
+			for j := 0; j < 20; j++ {
+				if _, err := requester.GetKey("/a"); err != nil && !errors.Is(err, etcd.ErrKeyNotFound) {
+					t.Errorf("GetKey: %v", err)
+					return
+				}
+			}
+		}(requester)
+	}
+	for j := 0; j < 20; j++ {
+		client.SetTimeouts(&timeouts.Timeouts{Connect: time.Second, Read: time.Duration(j+1) * time.Second})
+		client.SetRateLimiter(nil)
+		client.SetCache(nil)
+	}
+	wait.Wait()
+}
+
+func TestSettersApplyToNamespaces(t *testing.T) {
+	server := etcdtest.NewServer()
+	defer server.Close()
+	client := server.Client()
+	client.SetRetryPolicy(etcd.RetryPolicy{MaxAttempts: 1})
+	namespaced := client.Namespace("/app")
+
+	client.SetProxy(func(*http.Request) (*url.URL, error) {
+		return nil, errors.New("Proxy refused the request")
+	})
+	if _, err := namespaced.SetKey("/a", "1"); err == nil || !strings.Contains(err.Error(), "Proxy refused") {
+		t.Errorf("err = %v, want the namespace's request sent through the proxy set on its client", err)
+	}
+	client.SetProxy(nil)
+	if _, err := namespaced.SetKey("/a", "1"); err != nil {
+		t.Errorf("SetKey: %v", err)
+	}
+}
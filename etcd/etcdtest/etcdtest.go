@@ -0,0 +1,486 @@
+// Package etcdtest provides an in-memory fake of the etcd v2 keys API behind an httptest server,
+// so code using the etcd package can be tested without a real cluster. It supports gets,
+// recursive listings, sets with TTLs, creates, compare-and-swap, compare-and-delete, refreshes,
+// deletes, watches and the /health and /version endpoints of an etcd 2.3 member.
+package etcdtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rarmstrong73/go-utils/etcd"
+)
+
+var apiVersion = "v2"
+
+// historySize is how many events are kept for watches, older wait indexes get a 401
+var historySize = 1000
+
+var expiryInterval = 50 * time.Millisecond
+
+type entry struct {
+	key        string
+	value      string
+	dir        bool
+	created    int64
+	modified   int64
+	expiration *time.Time
+}
+
+// Server is a fake etcd member
+type Server struct {
+	*httptest.Server
+
+	mutex   sync.Mutex
+	entries map[string]*entry
+	index   int64
+	history []etcd.WatchResponse
+	cleared int64
+	changed chan struct{}
+	stop    chan struct{}
+}
+
+// NewServer starts a fake etcd member with an empty keyspace
+func NewServer() *Server {
+	server := &Server{
+		entries: map[string]*entry{"/": {key: "/", dir: true}},
+		changed: make(chan struct{}),
+		stop:    make(chan struct{}),
+	}
+	server.Server = httptest.NewServer(http.HandlerFunc(server.handle))
+	go server.expire()
+	return server
+}
+
+// Host returns the host:port the server listens on, to be passed to etcd.NewClient
+func (server *Server) Host() string {
+	return strings.TrimPrefix(server.URL, "http://")
+}
+
+// Client returns a v2 client for the server
+func (server *Server) Client() *etcd.Client {
+	return etcd.NewClient(server.Host())
+}
+
+// Close stops expiring keys and shuts the server down
+func (server *Server) Close() {
+	close(server.stop)
+	server.Server.Close()
+}
+
+// Index returns the server's current etcd index
+func (server *Server) Index() int64 {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return server.index
+}
+
+// Values returns every key and value currently stored, directories are omitted
+func (server *Server) Values() map[string]string {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	values := map[string]string{}
+	for key, e := range server.entries {
+		if !e.dir {
+			values[key] = e.value
+		}
+	}
+	return values
+}
+
+func (server *Server) handle(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/health" {
+		server.mutex.Lock()
+		defer server.mutex.Unlock()
+		server.writeLocked(w, http.StatusOK, map[string]string{"health": "true"})
+		return
+	}
+	if r.URL.Path == "/version" {
+		server.mutex.Lock()
+		defer server.mutex.Unlock()
+		server.writeLocked(w, http.StatusOK, etcd.Version{Server: "2.3.8", Cluster: "2.3.0"})
+		return
+	}
+
+	prefix := "/" + apiVersion + "/keys"
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		http.NotFound(w, r)
+		return
+	}
+	key := "/" + strings.Trim(strings.TrimPrefix(r.URL.Path, prefix), "/")
+
+	if err := r.ParseForm(); err != nil {
+		server.writeError(w, etcd.EcodeInvalidForm, "Invalid form", err.Error())
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if r.Form.Get("wait") == "true" {
+			server.watch(w, r, key)
+		} else {
+			server.get(w, r, key)
+		}
+	case http.MethodPut:
+		server.put(w, r, key)
+	case http.MethodDelete:
+		server.delete(w, r, key)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (server *Server) get(w http.ResponseWriter, r *http.Request, key string) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	e, ok := server.entries[key]
+	if !ok {
+		server.writeErrorLocked(w, etcd.EcodeKeyNotFound, "Key not found", key)
+		return
+	}
+
+	recursive := r.Form.Get("recursive") == "true"
+	server.writeLocked(w, http.StatusOK, etcd.Response{Action: etcd.ActionGet, Node: server.nodeLocked(e, recursive, true)})
+}
+
+func (server *Server) put(w http.ResponseWriter, r *http.Request, key string) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	if key == "/" {
+		server.writeErrorLocked(w, etcd.EcodeRootROnly, "Root is read only", key)
+		return
+	}
+
+	existing, exists := server.entries[key]
+	action := etcd.ActionSet
+
+	if prevExist := r.Form.Get("prevExist"); prevExist == "false" {
+		if exists {
+			server.writeErrorLocked(w, etcd.EcodeNodeExist, "Key already exists", key)
+			return
+		}
+		action = etcd.ActionCreate
+	} else if prevExist == "true" {
+		if !exists {
+			server.writeErrorLocked(w, etcd.EcodeKeyNotFound, "Key not found", key)
+			return
+		}
+		action = etcd.ActionUpdate
+	}
+
+	if r.Form.Get("prevIndex") != "" || r.Form.Get("prevValue") != "" {
+		if !exists {
+			server.writeErrorLocked(w, etcd.EcodeKeyNotFound, "Key not found", key)
+			return
+		}
+		if cause, ok := compare(existing, r.Form); !ok {
+			server.writeErrorLocked(w, etcd.EcodeTestFailed, "Compare failed", cause)
+			return
+		}
+		action = etcd.ActionCompareAndSwap
+	}
+
+	var expiration *time.Time
+	if ttl := r.Form.Get("ttl"); ttl != "" {
+		seconds, err := strconv.Atoi(ttl)
+		if err != nil {
+			server.writeErrorLocked(w, etcd.EcodeTTLNaN, "The given TTL in POST form is not a number", "Update")
+			return
+		}
+		expires := time.Now().Add(time.Duration(seconds) * time.Second)
+		expiration = &expires
+	}
+
+	if r.Form.Get("refresh") == "true" {
+		if !exists {
+			server.writeErrorLocked(w, etcd.EcodeKeyNotFound, "Key not found", key)
+			return
+		}
+		// Refreshing only moves the expiration, watchers aren't told
+		server.index++
+		prevNode := server.nodeLocked(existing, false, false)
+		existing.expiration = expiration
+		existing.modified = server.index
+		server.writeLocked(w, http.StatusOK, etcd.SetResponse{
+			Action:   etcd.ActionUpdate,
+			Node:     server.nodeLocked(existing, false, false),
+			PrevNode: prevNode,
+		})
+		return
+	}
+
+	dir := r.Form.Get("dir") == "true"
+	if exists && existing.dir && !dir {
+		server.writeErrorLocked(w, etcd.EcodeNotFile, "Not a file", key)
+		return
+	}
+	if !server.makeParentsLocked(w, key) {
+		return
+	}
+
+	server.index++
+	e := &entry{key: key, value: r.Form.Get("value"), dir: dir, created: server.index, modified: server.index, expiration: expiration}
+	status := http.StatusCreated
+	var prevNode etcd.Node
+	if exists {
+		prevNode = server.nodeLocked(existing, false, false)
+		e.created = existing.created
+		status = http.StatusOK
+	}
+	server.entries[key] = e
+
+	response := etcd.SetResponse{Action: action, Node: server.nodeLocked(e, false, false), PrevNode: prevNode}
+	server.recordLocked(etcd.WatchResponse(response))
+	server.writeLocked(w, status, response)
+}
+
+func (server *Server) delete(w http.ResponseWriter, r *http.Request, key string) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	existing, exists := server.entries[key]
+	if !exists {
+		server.writeErrorLocked(w, etcd.EcodeKeyNotFound, "Key not found", key)
+		return
+	}
+	if key == "/" {
+		server.writeErrorLocked(w, etcd.EcodeRootROnly, "Root is read only", key)
+		return
+	}
+
+	action := etcd.ActionDelete
+	if r.Form.Get("prevIndex") != "" || r.Form.Get("prevValue") != "" {
+		if cause, ok := compare(existing, r.Form); !ok {
+			server.writeErrorLocked(w, etcd.EcodeTestFailed, "Compare failed", cause)
+			return
+		}
+		action = etcd.ActionCompareAndDelete
+	}
+
+	recursive := r.Form.Get("recursive") == "true"
+	if existing.dir && !recursive {
+		if r.Form.Get("dir") != "true" {
+			server.writeErrorLocked(w, etcd.EcodeNotFile, "Not a file", key)
+			return
+		}
+		if len(server.childrenLocked(key)) > 0 {
+			server.writeErrorLocked(w, etcd.EcodeDirNotEmpty, "Directory not empty", key)
+			return
+		}
+	}
+
+	server.index++
+	response := server.removeLocked(existing, action)
+	server.writeLocked(w, http.StatusOK, response)
+}
+
+func (server *Server) watch(w http.ResponseWriter, r *http.Request, key string) {
+	recursive := r.Form.Get("recursive") == "true"
+
+	server.mutex.Lock()
+	waitIndex := server.index + 1
+	if raw := r.Form.Get("waitIndex"); raw != "" {
+		parsed, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			server.writeErrorLocked(w, etcd.EcodeIndexNaN, "The given index in POST form is not a number", "Watch")
+			server.mutex.Unlock()
+			return
+		}
+		waitIndex = parsed
+	}
+
+	for {
+		if waitIndex <= server.cleared {
+			cause := fmt.Sprintf("the requested history has been cleared [%d/%d]", server.cleared+1, waitIndex)
+			server.writeErrorLocked(w, etcd.EcodeEventIndexCleared, "The event in requested index is outdated and cleared", cause)
+			server.mutex.Unlock()
+			return
+		}
+
+		for _, event := range server.history {
+			if event.Node.ModifiedIndex < waitIndex {
+				continue
+			}
+			if event.Node.Key == key || (recursive && strings.HasPrefix(event.Node.Key, strings.TrimSuffix(key, "/")+"/")) {
+				server.writeLocked(w, http.StatusOK, event)
+				server.mutex.Unlock()
+				return
+			}
+		}
+
+		changed := server.changed
+		server.mutex.Unlock()
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		case <-server.stop:
+			return
+		}
+		server.mutex.Lock()
+	}
+}
+
+// expire deletes keys whose TTL has passed until the server is closed
+func (server *Server) expire() {
+	ticker := time.NewTicker(expiryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-server.stop:
+			return
+		case now := <-ticker.C:
+			server.mutex.Lock()
+			for _, key := range sortedKeys(server.entries) {
+				e, ok := server.entries[key]
+				if ok && e.expiration != nil && now.After(*e.expiration) {
+					server.index++
+					server.removeLocked(e, etcd.ActionExpire)
+				}
+			}
+			server.mutex.Unlock()
+		}
+	}
+}
+
+// removeLocked deletes e and everything below it at the current index and records the event
+func (server *Server) removeLocked(e *entry, action string) etcd.SetResponse {
+	prevNode := server.nodeLocked(e, false, false)
+	for key := range server.entries {
+		if key == e.key || strings.HasPrefix(key, e.key+"/") {
+			delete(server.entries, key)
+		}
+	}
+
+	response := etcd.SetResponse{
+		Action:   action,
+		Node:     etcd.Node{Key: e.key, Dir: e.dir, CreatedIndex: e.created, ModifiedIndex: server.index},
+		PrevNode: prevNode,
+	}
+	server.recordLocked(etcd.WatchResponse(response))
+	return response
+}
+
+func (server *Server) recordLocked(event etcd.WatchResponse) {
+	server.history = append(server.history, event)
+	if len(server.history) > historySize {
+		dropped := len(server.history) - historySize
+		server.cleared = server.history[dropped-1].Node.ModifiedIndex
+		server.history = server.history[dropped:]
+	}
+	close(server.changed)
+	server.changed = make(chan struct{})
+}
+
+// makeParentsLocked creates the directories above key, writing an error if one of them is a file
+func (server *Server) makeParentsLocked(w http.ResponseWriter, key string) bool {
+	parts := strings.Split(strings.Trim(key, "/"), "/")
+	parent := ""
+	for _, part := range parts[:len(parts)-1] {
+		parent += "/" + part
+		e, ok := server.entries[parent]
+		if ok && !e.dir {
+			server.writeErrorLocked(w, etcd.EcodeNotDir, "Not a directory", parent)
+			return false
+		}
+		if !ok {
+			server.entries[parent] = &entry{key: parent, dir: true, created: server.index + 1, modified: server.index + 1}
+		}
+	}
+	return true
+}
+
+func (server *Server) childrenLocked(key string) []*entry {
+	prefix := strings.TrimSuffix(key, "/") + "/"
+	var children []*entry
+	for _, childKey := range sortedKeys(server.entries) {
+		if childKey == "/" || !strings.HasPrefix(childKey, prefix) {
+			continue
+		}
+		if !strings.Contains(strings.TrimPrefix(childKey, prefix), "/") {
+			children = append(children, server.entries[childKey])
+		}
+	}
+	return children
+}
+
+// nodeLocked converts an entry to the node returned to clients. Directories list their children
+// when listChildren is set, recursively when recursive is set.
+func (server *Server) nodeLocked(e *entry, recursive, listChildren bool) etcd.Node {
+	node := etcd.Node{
+		Key:           e.key,
+		Dir:           e.dir,
+		Value:         e.value,
+		CreatedIndex:  e.created,
+		ModifiedIndex: e.modified,
+	}
+	if e.expiration != nil {
+		expiration := e.expiration.UTC()
+		node.Expiration = &expiration
+		node.TTL = int64(time.Until(expiration)/time.Second) + 1
+	}
+	if e.dir && listChildren {
+		for _, child := range server.childrenLocked(e.key) {
+			node.Nodes = append(node.Nodes, server.nodeLocked(child, recursive, recursive))
+		}
+	}
+	return node
+}
+
+func (server *Server) writeLocked(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Etcd-Index", strconv.FormatInt(server.index, 10))
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func (server *Server) writeError(w http.ResponseWriter, code int, message, cause string) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.writeErrorLocked(w, code, message, cause)
+}
+
+func (server *Server) writeErrorLocked(w http.ResponseWriter, code int, message, cause string) {
+	status := http.StatusBadRequest
+	switch code {
+	case etcd.EcodeKeyNotFound:
+		status = http.StatusNotFound
+	case etcd.EcodeTestFailed, etcd.EcodeNodeExist:
+		status = http.StatusPreconditionFailed
+	case etcd.EcodeNotFile, etcd.EcodeNotDir, etcd.EcodeDirNotEmpty, etcd.EcodeRootROnly:
+		status = http.StatusForbidden
+	}
+	server.writeLocked(w, status, etcd.Error{ErrorCode: code, Message: message, Cause: cause, Index: server.index})
+}
+
+// compare checks the prevIndex and prevValue conditions in form against e
+func compare(e *entry, form url.Values) (string, bool) {
+	if prevValue := form.Get("prevValue"); prevValue != "" && prevValue != e.value {
+		return fmt.Sprintf("[%s != %s]", prevValue, e.value), false
+	}
+	if prevIndex := form.Get("prevIndex"); prevIndex != "" && prevIndex != strconv.FormatInt(e.modified, 10) {
+		return fmt.Sprintf("[%s != %d]", prevIndex, e.modified), false
+	}
+	return "", true
+}
+
+func sortedKeys(entries map[string]*entry) []string {
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,109 @@
+package etcdtest_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rarmstrong73/go-utils/etcd"
+	"github.com/rarmstrong73/go-utils/etcd/etcdtest"
+)
+
+func TestConditionalWrites(t *testing.T) {
+	server := etcdtest.NewServer()
+	defer server.Close()
+	client := server.Client()
+
+	created, err := client.Create("/a", "1", 0)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	index := created.Node.ModifiedIndex
+
+	tests := []struct {
+		name string
+		run  func() error
+		want error
+	}{
+		{"create existing key", func() error { _, err := client.Create("/a", "2", 0); return err }, etcd.ErrNodeExist},
+		{"swap at a stale index", func() error { _, err := client.CompareAndSwap("/a", "2", index-1); return err }, etcd.ErrTestFailed},
+		{"delete at a stale index", func() error { _, err := client.CompareAndDelete("/a", index-1); return err }, etcd.ErrTestFailed},
+		{"swap missing key", func() error { _, err := client.CompareAndSwap("/missing", "2", index); return err }, etcd.ErrKeyNotFound},
+		{"swap at the current index", func() error { _, err := client.CompareAndSwap("/a", "2", index); return err }, nil},
+	}
+	for _, test := range tests {
+		if err := test.run(); !errors.Is(err, test.want) {
+			t.Errorf("%s: err = %v, want %v", test.name, err, test.want)
+		}
+	}
+	if values := server.Values(); values["/a"] != "2" || len(values) != 1 {
+		t.Errorf("values = %v, want only the successful swap applied", values)
+	}
+}
+
+func TestWatchFromHistory(t *testing.T) {
+	server := etcdtest.NewServer()
+	defer server.Close()
+	client := server.Client()
+	indexes := []int64{}
+	for _, value := range []string{"1", "2", "3"} {
+		response, err := client.Set("/dir/a", value)
+		if err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+		indexes = append(indexes, response.Node.ModifiedIndex)
+	}
+
+	response, err := client.WatchKey("/dir", true, indexes[1], nil)
+	if err != nil {
+		t.Fatalf("WatchKey: %v", err)
+	}
+	if response.Node.Value != "2" || response.PrevNode.Value != "1" {
+		t.Errorf("watch = %+v, want the change at index %d", response, indexes[1])
+	}
+
+	watched := make(chan etcd.WatchResponse, 1)
+	next := server.Index() + 1
+	go func() {
+		response, err := client.WatchKey("/dir", true, next, nil)
+		if err != nil {
+			t.Errorf("WatchKey: %v", err)
+		}
+		watched <- response
+	}()
+	if err := client.DeleteKey("/dir/a"); err != nil {
+		t.Fatalf("DeleteKey: %v", err)
+	}
+	select {
+	case response := <-watched:
+		if response.Action != etcd.ActionDelete || response.Node.Key != "/dir/a" {
+			t.Errorf("watch = %+v, want /dir/a deleted", response)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the watch didn't see the delete")
+	}
+}
+
+func TestKeysExpire(t *testing.T) {
+	server := etcdtest.NewServer()
+	defer server.Close()
+	client := server.Client()
+	if _, err := client.SetWithTTL("/session", "1", 1); err != nil {
+		t.Fatalf("SetWithTTL: %v", err)
+	}
+	node, err := client.GetKey("/session")
+	if err != nil || node.TTL <= 0 || node.Expiration == nil {
+		t.Fatalf("GetKey = %+v, %v, want a TTL and expiration", node, err)
+	}
+
+	response, err := client.WatchKey("/session", false, server.Index()+1, nil)
+	if err != nil {
+		t.Fatalf("WatchKey: %v", err)
+	}
+	if response.Action != etcd.ActionExpire {
+		t.Errorf("action = %s, want %s", response.Action, etcd.ActionExpire)
+	}
+	if _, err := client.GetKey("/session"); !errors.Is(err, etcd.ErrKeyNotFound) {
+		t.Errorf("err = %v, want %v once expired", err, etcd.ErrKeyNotFound)
+	}
+}
@@ -0,0 +1,77 @@
+package etcd
+
+import (
+	"io"
+	"path"
+
+	"github.com/rarmstrong73/go-utils/internal/marshal"
+)
+
+// Export is a snapshot of a key subtree, as returned by ExportTree
+type Export struct {
+	Root Node `json:"root"`
+}
+
+// ExportTree returns the full subtree at the given path so it can be backed up
+func ExportTree(host, path string) (Export, error) {
+	return NewClient(host).ExportTree(path)
+}
+
+// ExportTree returns the full subtree at the given path so it can be backed up
+func (client *Client) ExportTree(path string) (Export, error) {
+	node, err := client.RecurseKeys(path)
+	if err != nil {
+		return Export{}, err
+	}
+	return Export{Root: node}, nil
+}
+
+// Flatten returns the exported values keyed by their full key, directories are omitted
+func (export Export) Flatten() map[string]string {
+	values := map[string]string{}
+	flattenNode(export.Root, values)
+	return values
+}
+
+func flattenNode(node Node, values map[string]string) {
+	if !node.Dir {
+		values[node.Key] = node.Value
+		return
+	}
+	for _, child := range node.Nodes {
+		flattenNode(child, values)
+	}
+}
+
+// WriteJSON writes the export to w as JSON, either as the nested node tree or as a flat key/value map
+func (export Export) WriteJSON(w io.Writer, flat bool) error {
+	if flat {
+		return marshal.WriteJSON(w, export.Flatten())
+	}
+	return marshal.WriteJSON(w, export)
+}
+
+// WriteYAML writes the export to w as YAML, either as mappings nested like the directories or as
+// a flat key/value map
+func (export Export) WriteYAML(w io.Writer, flat bool) error {
+	if flat {
+		return marshal.WriteYAML(w, export.Flatten())
+	}
+	if !export.Root.Dir {
+		return marshal.WriteYAML(w, map[string]interface{}{path.Base(export.Root.Key): export.Root.Value})
+	}
+	return marshal.WriteYAML(w, nestedValues(export.Root))
+}
+
+// nestedValues returns a directory's values keyed by their base names, with directories nested
+func nestedValues(dir Node) map[string]interface{} {
+	values := map[string]interface{}{}
+	for _, node := range dir.Nodes {
+		if node.Dir {
+			values[path.Base(node.Key)] = nestedValues(node)
+			continue
+		}
+		values[path.Base(node.Key)] = node.Value
+	}
+	return values
+}
@@ -0,0 +1,101 @@
+package etcd
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Import modes
+const (
+	Overwrite    = "overwrite"
+	SkipExisting = "skip-existing"
+)
+
+// Import change actions
+const (
+	Create = "create"
+	Update = "update"
+	Skip   = "skip"
+)
+
+// ImportOptions controls how ImportTree treats keys that already exist
+type ImportOptions struct {
+	Mode   string
+	DryRun bool
+}
+
+// Change describes a single key written (or that would be written) by ImportTree
+type Change struct {
+	Key      string `json:"key"`
+	Action   string `json:"action"`
+	OldValue string `json:"oldValue,omitempty"`
+	NewValue string `json:"newValue"`
+}
+
+// ImportTree writes a previously exported tree back into etcd under the given path.
+// Keys are rebased from the export's root onto path. With DryRun set nothing is
+// written and the returned changes describe what would happen.
+func ImportTree(host, path string, data Export, opts ImportOptions) (changes []Change, err error) {
+	return NewClient(host).ImportTree(path, data, opts)
+}
+
+// ImportTree writes a previously exported tree back into etcd under the given path.
+// See the package level ImportTree for details.
+func (client *Client) ImportTree(path string, data Export, opts ImportOptions) (changes []Change, err error) {
+	if opts.Mode == "" {
+		opts.Mode = Overwrite
+	}
+	if opts.Mode != Overwrite && opts.Mode != SkipExisting {
+		return nil, fmt.Errorf("Unknown import mode %q", opts.Mode)
+	}
+
+	target := "/" + strings.Trim(path, "/")
+	existing, err := client.existingValues(target)
+	if err != nil {
+		return nil, err
+	}
+
+	values := data.Flatten()
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		destination := target + strings.TrimPrefix(key, data.Root.Key)
+		change := Change{Key: destination, Action: Create, NewValue: values[key]}
+
+		if oldValue, ok := existing[destination]; ok {
+			if oldValue == values[key] {
+				continue
+			}
+			change.OldValue = oldValue
+			change.Action = Update
+			if opts.Mode == SkipExisting {
+				change.Action = Skip
+			}
+		}
+
+		if !opts.DryRun && change.Action != Skip {
+			_, err = client.SetKey(destination, change.NewValue)
+			if err != nil {
+				return changes, err
+			}
+		}
+		changes = append(changes, change)
+	}
+
+	return changes, nil
+}
+
+// existingValues returns the flattened values under key, or an empty map if it doesn't exist
+func (client *Client) existingValues(key string) (map[string]string, error) {
+	values, err := client.GetValues(key)
+	if errors.Is(err, ErrKeyNotFound) {
+		return map[string]string{}, nil
+	}
+	return values, err
+}
@@ -0,0 +1,113 @@
+package etcd
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DirIterator walks every key below a directory in bounded batches, see IterateDir
+type DirIterator struct {
+	client    *Client
+	batchSize int
+	batch     []Node
+	err       error
+
+	// v3 clients page through the prefix
+	pager *PrefixPager
+
+	// v2 clients list one directory at a time
+	pendingDirs []string
+	pendingKeys []Node
+}
+
+// IterateDir returns an iterator over the keys below the directory at path that yields at most
+// batchSize keys at a time, directories themselves are not yielded. v3 clients read the keys
+// by key range so no request returns more than batchSize keys. v2 clients can't limit a listing,
+// so instead of a single recursive read each directory is listed on its own as it is reached.
+//
+//	iterator := client.IterateDir("/registry", 1000)
+//	for iterator.Next() {
+//		for _, node := range iterator.Batch() {
+//			...
+//		}
+//	}
+//	if err := iterator.Err(); err != nil {
+//		...
+//	}
+func (client *Client) IterateDir(path string, batchSize int) *DirIterator {
+	iterator := &DirIterator{client: client, batchSize: batchSize}
+	if batchSize <= 0 {
+		iterator.err = fmt.Errorf("Batch size must be positive, got %d", batchSize)
+		return iterator
+	}
+
+	if client.v3 {
+		prefix := strings.TrimSuffix(v3Key(path), "/") + "/"
+		iterator.pager = client.GetPrefix(prefix, int64(batchSize), SortAscend)
+	} else {
+		iterator.pendingDirs = []string{path}
+	}
+	return iterator
+}
+
+// Next reads the next batch, returning false once every key has been read or a request fails
+func (iterator *DirIterator) Next() bool {
+	if iterator.err != nil {
+		return false
+	}
+
+	if iterator.pager != nil {
+		if !iterator.pager.Next() {
+			iterator.err = iterator.pager.Err()
+			return false
+		}
+		iterator.batch = make([]Node, 0, len(iterator.pager.Page()))
+		for _, kv := range iterator.pager.Page() {
+			iterator.batch = append(iterator.batch, nodeFromKeyValue(kv))
+		}
+		return true
+	}
+
+	for len(iterator.pendingKeys) < iterator.batchSize && len(iterator.pendingDirs) > 0 {
+		dir := iterator.pendingDirs[0]
+		iterator.pendingDirs = iterator.pendingDirs[1:]
+
+		node, err := iterator.client.GetKey(dir + "?sorted=true")
+		if err != nil {
+			iterator.err = err
+			return false
+		}
+
+		var dirs []string
+		for _, child := range node.Nodes {
+			if child.Dir {
+				dirs = append(dirs, child.Key)
+			} else {
+				iterator.pendingKeys = append(iterator.pendingKeys, child)
+			}
+		}
+		iterator.pendingDirs = append(dirs, iterator.pendingDirs...)
+	}
+
+	if len(iterator.pendingKeys) == 0 {
+		return false
+	}
+
+	size := iterator.batchSize
+	if size > len(iterator.pendingKeys) {
+		size = len(iterator.pendingKeys)
+	}
+	iterator.batch = iterator.pendingKeys[:size]
+	iterator.pendingKeys = iterator.pendingKeys[size:]
+	return true
+}
+
+// Batch returns the keys read by the last call to Next
+func (iterator *DirIterator) Batch() []Node {
+	return iterator.batch
+}
+
+// Err returns the error that stopped the iterator, if any
+func (iterator *DirIterator) Err() error {
+	return iterator.err
+}
@@ -0,0 +1,169 @@
+package etcd
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rarmstrong73/go-utils/apierror"
+)
+
+// LeaseGrantResponse is the response from granting a lease
+type LeaseGrantResponse struct {
+	Header ResponseHeader `json:"header"`
+	ID     int64          `json:"ID,string"`
+	TTL    int64          `json:"TTL,string"`
+	Error  string         `json:"error"`
+}
+
+// LeaseKeepAliveResponse is the response from renewing a lease, a TTL of 0 means the lease has expired
+type LeaseKeepAliveResponse struct {
+	Header ResponseHeader `json:"header"`
+	ID     int64          `json:"ID,string"`
+	TTL    int64          `json:"TTL,string"`
+}
+
+// Grant creates a lease that expires after ttl seconds unless it is kept alive
+func (client *Client) Grant(ttl int64) (LeaseGrantResponse, error) {
+	if !client.v3 {
+		return LeaseGrantResponse{}, errV2Unsupported
+	}
+
+	var grantResponse LeaseGrantResponse
+	err := client.v3Post("lease/grant", map[string]interface{}{"TTL": ttl}, &grantResponse)
+	if err != nil {
+		return LeaseGrantResponse{}, err
+	}
+	if grantResponse.Error != "" {
+		return LeaseGrantResponse{}, fmt.Errorf("Failed to grant lease: %s", grantResponse.Error)
+	}
+	return grantResponse, nil
+}
+
+// Revoke revokes the lease, deleting every key attached to it
+func (client *Client) Revoke(id int64) error {
+	if !client.v3 {
+		return errV2Unsupported
+	}
+	return client.v3Post("lease/revoke", map[string]interface{}{"ID": id}, nil)
+}
+
+// KeepAliveOnce renews the lease a single time
+func (client *Client) KeepAliveOnce(id int64) (LeaseKeepAliveResponse, error) {
+	if !client.v3 {
+		return LeaseKeepAliveResponse{}, errV2Unsupported
+	}
+
+	var streamResponse struct {
+		Result LeaseKeepAliveResponse `json:"result"`
+		Error  *V3Error               `json:"error"`
+	}
+	err := client.v3Post("lease/keepalive", map[string]interface{}{"ID": id}, &streamResponse)
+	if err != nil {
+		return LeaseKeepAliveResponse{}, err
+	}
+	if streamResponse.Error != nil {
+		return LeaseKeepAliveResponse{}, streamResponse.Error
+	}
+	return streamResponse.Result, nil
+}
+
+// PutWithLease sets key to value and attaches it to the lease, the key is deleted when the lease expires
+func (client *Client) PutWithLease(key, value []byte, id int64) (PutResponse, error) {
+	if !client.v3 {
+		return PutResponse{}, errV2Unsupported
+	}
+
+	request := map[string]interface{}{
+		"key":     client.withNamespace(key),
+		"value":   value,
+		"lease":   id,
+		"prev_kv": true,
+	}
+
+	var putResponse PutResponse
+	err := client.v3Post("kv/put", request, &putResponse)
+	if putResponse.PrevKv != nil {
+		putResponse.PrevKv.Key = []byte(client.stripKey(string(putResponse.PrevKv.Key)))
+	}
+	return putResponse, err
+}
+
+// Lease is a lease being kept alive in the background
+type Lease struct {
+	ID int64
+
+	client   *Client
+	lost     chan struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// KeepAlive renews the lease once and then keeps renewing it in the background at a third of
+// its TTL until Stop is called or the lease is lost
+func (client *Client) KeepAlive(id int64) (*Lease, error) {
+	keepAliveResponse, err := client.KeepAliveOnce(id)
+	if err != nil {
+		return nil, err
+	}
+	if keepAliveResponse.TTL <= 0 {
+		return nil, fmt.Errorf("Lease %d has expired", id)
+	}
+
+	lease := &Lease{
+		ID:     id,
+		client: client,
+		lost:   make(chan struct{}),
+		stop:   make(chan struct{}),
+	}
+	go lease.keepAlive(time.Duration(keepAliveResponse.TTL) * time.Second)
+	return lease, nil
+}
+
+// Lost returns a channel that is closed when the lease expires without being renewed or the
+// client is closed
+func (lease *Lease) Lost() <-chan struct{} {
+	return lease.lost
+}
+
+// Stop stops renewing the lease, it is left to expire unless revoked
+func (lease *Lease) Stop() {
+	lease.stopOnce.Do(func() { close(lease.stop) })
+}
+
+func (lease *Lease) keepAlive(ttl time.Duration) {
+	deadline := time.Now().Add(ttl)
+	interval := ttl / 3
+
+	for {
+		select {
+		case <-lease.stop:
+			return
+		case <-lease.client.httpClient.Closing():
+			close(lease.lost)
+			return
+		case <-time.After(interval):
+		}
+
+		keepAliveResponse, err := lease.client.KeepAliveOnce(lease.ID)
+		// A closed client can't renew the lease anymore, so it is as good as lost
+		if (err == nil && keepAliveResponse.TTL <= 0) || errors.Is(err, apierror.ErrClosed) {
+			close(lease.lost)
+			return
+		}
+		if err == nil {
+			ttl = time.Duration(keepAliveResponse.TTL) * time.Second
+			deadline = time.Now().Add(ttl)
+			interval = ttl / 3
+			continue
+		}
+
+		if time.Now().After(deadline) {
+			close(lease.lost)
+			return
+		}
+		// Retry quickly until the lease would have expired
+		interval = time.Second
+	}
+}
@@ -0,0 +1,165 @@
+package etcd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/rarmstrong73/go-utils/apierror"
+)
+
+// CompactionResponse is the response from compacting the key history
+type CompactionResponse struct {
+	Header ResponseHeader `json:"header"`
+}
+
+// DefragmentResponse is the response from defragmenting a member's backend
+type DefragmentResponse struct {
+	Header ResponseHeader `json:"header"`
+}
+
+// Compact discards the key history before revision. When physical is set the call waits for
+// the compaction to be applied to the backend of every member.
+func (client *Client) Compact(revision int64, physical bool) (CompactionResponse, error) {
+	if !client.v3 {
+		return CompactionResponse{}, errV2Unsupported
+	}
+
+	request := map[string]interface{}{
+		"revision": revision,
+		"physical": physical,
+	}
+
+	var compactionResponse CompactionResponse
+	err := client.v3Post("kv/compaction", request, &compactionResponse)
+	return compactionResponse, err
+}
+
+// AutoCompact compacts away everything but the most recent keepRevisions revisions and returns
+// the revision it compacted to, or 0 if there wasn't enough history to compact
+func (client *Client) AutoCompact(keepRevisions int64) (int64, error) {
+	revision, err := client.CurrentRevision()
+	if err != nil {
+		return 0, err
+	}
+
+	compactTo := revision - keepRevisions
+	if compactTo <= 0 {
+		return 0, nil
+	}
+
+	_, err = client.Compact(compactTo, true)
+	if err != nil {
+		return 0, err
+	}
+	return compactTo, nil
+}
+
+// CurrentRevision returns the cluster's latest revision
+func (client *Client) CurrentRevision() (int64, error) {
+	if !client.v3 {
+		return 0, errV2Unsupported
+	}
+
+	request := map[string]interface{}{
+		"key":        []byte{0},
+		"count_only": true,
+	}
+
+	var rangeResponse RangeResponse
+	err := client.v3Post("kv/range", request, &rangeResponse)
+	return rangeResponse.Header.Revision, err
+}
+
+// Defragment defragments the backend of the member on host, which releases the space freed by
+// compaction. The member is unavailable while it defragments.
+func (client *Client) Defragment(host string) (DefragmentResponse, error) {
+	if !client.v3 {
+		return DefragmentResponse{}, errV2Unsupported
+	}
+
+	member := client.member(host)
+	var defragmentResponse DefragmentResponse
+	err := member.v3Post("maintenance/defragment", map[string]interface{}{}, &defragmentResponse)
+	return defragmentResponse, err
+}
+
+// DefragmentAll defragments each of the client's members in turn, stopping at the first failure
+func (client *Client) DefragmentAll() error {
+	for _, host := range client.Hosts() {
+		if _, err := client.Defragment(host); err != nil {
+			return fmt.Errorf("Failed to defragment %s: %v", host, err)
+		}
+	}
+	return nil
+}
+
+// Health checks that the member on host is healthy, which it is while its cluster has a leader
+func (client *Client) Health(host string) error {
+	response, err := client.member(host).httpGetResponse("/health")
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+
+	var health struct {
+		Health string `json:"health"`
+		Reason string `json:"reason"`
+	}
+	if err := json.Unmarshal(body, &health); err != nil || health.Health == "" {
+		return apierror.FromResponse(apierror.Etcd, response, "", string(bytes.TrimSpace(body)), nil)
+	}
+	if health.Health != "true" {
+		if health.Reason != "" {
+			return fmt.Errorf("Member %s is unhealthy: %s", host, health.Reason)
+		}
+		return fmt.Errorf("Member %s is unhealthy", host)
+	}
+	return nil
+}
+
+// Version is the version of an etcd member and of its cluster
+type Version struct {
+	Server string `json:"etcdserver"`
+	// Cluster is the version every member of the cluster runs at least, such as 3.5.0
+	Cluster string `json:"etcdcluster"`
+}
+
+// GetVersion returns the version of the member the client talks to and of its cluster
+func (client *Client) GetVersion() (Version, error) {
+	response, err := client.httpGetResponse("/version")
+	if err != nil {
+		return Version{}, err
+	}
+	defer response.Body.Close()
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return Version{}, err
+	}
+	if response.StatusCode != 200 {
+		return Version{}, apierror.FromResponse(apierror.Etcd, response, "", string(bytes.TrimSpace(body)), nil)
+	}
+
+	var version Version
+	err = json.Unmarshal(body, &version)
+	return version, err
+}
+
+// member returns a client that only talks to the member on host
+func (client *Client) member(host string) *Client {
+	member := NewClient(host)
+	member.v3 = client.v3
+	member.httpClient = client.httpClient
+	member.retry = client.retry
+	member.metrics = client.metrics
+	member.logger = client.logger
+	member.retry.MaxAttempts = 1
+	return member
+}
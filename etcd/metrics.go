@@ -0,0 +1,68 @@
+package etcd
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// RequestInfo describes a single HTTP request made by a client
+type RequestInfo struct {
+	// Operation is the HTTP method for v2 key requests, or the v3 gateway endpoint such as kv/range
+	Operation  string
+	Endpoint   string
+	StatusCode int
+	Err        error
+	Duration   time.Duration
+}
+
+// Metrics receives instrumentation from a client. Implementations must be safe for concurrent use.
+type Metrics interface {
+	// RequestDone is called after every request attempt, including retried ones
+	RequestDone(info RequestInfo)
+	// CASConflict is called when a compare-and-swap, create or transaction fails its comparison
+	CASConflict(operation string)
+	// WatchReconnect is called when a long running watch on path has to be re-established
+	WatchReconnect(path string)
+}
+
+type noopMetrics struct{}
+
+func (noopMetrics) RequestDone(RequestInfo) {}
+func (noopMetrics) CASConflict(string)      {}
+func (noopMetrics) WatchReconnect(string)   {}
+
+// SetMetrics sets where the client reports its instrumentation, nil turns reporting off
+func (client *Client) SetMetrics(metrics Metrics) {
+	if metrics == nil {
+		metrics = noopMetrics{}
+	}
+	client.metrics = metrics
+}
+
+// operationName names a request for RequestInfo
+func operationName(method, path string) string {
+	v3Prefix := "/" + v3APIVersion + "/"
+	if strings.HasPrefix(path, v3Prefix) {
+		return strings.TrimPrefix(path, v3Prefix)
+	}
+	return method
+}
+
+// endpointName names a request path for observations, such as kv/range for the v3 API and keys
+// for the v2 key space
+func endpointName(path string) string {
+	if v3Prefix := "/" + v3APIVersion + "/"; strings.HasPrefix(path, v3Prefix) {
+		return strings.TrimPrefix(path, v3Prefix)
+	}
+	segments := strings.Split(strings.TrimPrefix(path, "/"+apiVersion+"/"), "/")
+	return segments[0]
+}
+
+// reportConflict tells the client's metrics about failed comparisons and passes err through
+func (client *Client) reportConflict(operation string, err error) error {
+	if errors.Is(err, ErrTestFailed) || errors.Is(err, ErrNodeExist) {
+		client.metrics.CASConflict(operation)
+	}
+	return err
+}
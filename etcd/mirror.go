@@ -0,0 +1,115 @@
+package etcd
+
+import (
+	"errors"
+	"strings"
+)
+
+// Mirror copies everything under prefix from src to dst and then follows watches on src to
+// keep dst in sync. Keys under prefix on dst that don't exist on src are removed. If src has
+// compacted away the events needed to resume the watch, the prefix is copied again. Mirror
+// blocks until stop is closed, returning nil, or until a request fails. src must be a v2 client.
+func Mirror(src, dst *Client, prefix string, stop <-chan struct{}) error {
+	prefix = "/" + strings.Trim(prefix, "/")
+
+	for {
+		etcdIndex, err := mirrorTree(src, dst, prefix)
+		if err != nil {
+			return err
+		}
+
+		err = mirrorChanges(src, dst, prefix, etcdIndex+1, stop)
+		if errors.Is(err, ErrEventIndexCleared) {
+			src.metrics.WatchReconnect(prefix)
+			src.logger.Info("Mirror fell behind, copying again", "prefix", prefix)
+			continue
+		}
+		select {
+		case <-stop:
+			return nil
+		default:
+			return err
+		}
+	}
+}
+
+// mirrorTree makes dst's prefix identical to src's and returns src's index at the time of the copy
+func mirrorTree(src, dst *Client, prefix string) (int64, error) {
+	node, etcdIndex, err := src.getTree(prefix)
+	if err != nil {
+		return 0, err
+	}
+	srcValues := Export{Root: node}.Flatten()
+
+	dstValues, err := dst.existingValues(prefix)
+	if err != nil {
+		return 0, err
+	}
+
+	for key, value := range srcValues {
+		if dstValue, ok := dstValues[key]; ok && dstValue == value {
+			continue
+		}
+		if _, err = dst.SetKey(key, value); err != nil {
+			return 0, err
+		}
+	}
+
+	for key := range dstValues {
+		if _, ok := srcValues[key]; ok {
+			continue
+		}
+		if err = dst.removeKey(key); err != nil {
+			return 0, err
+		}
+	}
+
+	return etcdIndex, nil
+}
+
+// mirrorChanges applies every change under prefix on src to dst, starting at waitIndex
+func mirrorChanges(src, dst *Client, prefix string, waitIndex int64, stop <-chan struct{}) error {
+	for {
+		event, err := src.WatchKey(prefix, true, waitIndex, stop)
+		if err != nil {
+			return err
+		}
+		waitIndex = event.Node.ModifiedIndex + 1
+
+		switch event.Action {
+		case ActionSet, ActionCreate, ActionUpdate, ActionCompareAndSwap:
+			if event.Node.Dir {
+				continue
+			}
+			_, err = dst.SetKey(event.Node.Key, event.Node.Value)
+		case ActionDelete, ActionExpire, ActionCompareAndDelete:
+			err = dst.removeKey(event.Node.Key)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// removeKey recursively deletes the key at path, a key that doesn't exist is not an error
+func (client *Client) removeKey(path string) error {
+	if client.v3 {
+		err := client.v3DeleteKey(path, true)
+		if errors.Is(err, ErrKeyNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	response, err := client.httpDeleteResponse(client.keyURL(path + "?recursive=true"))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != 200 && response.StatusCode != 404 {
+		return handleError(response)
+	}
+
+	return nil
+}
@@ -0,0 +1,81 @@
+package etcd_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rarmstrong73/go-utils/etcd"
+	"github.com/rarmstrong73/go-utils/etcd/etcdtest"
+)
+
+// waitForValues waits until server holds the values of want and none of the unwanted keys
+func waitForValues(t *testing.T, server *etcdtest.Server, want map[string]string, unwanted ...string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		values := server.Values()
+		got := map[string]string{}
+		for key := range want {
+			if value, ok := values[key]; ok {
+				got[key] = value
+			}
+		}
+		for _, key := range unwanted {
+			if value, ok := values[key]; ok {
+				got[key] = value
+			}
+		}
+		if reflect.DeepEqual(got, want) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("values = %v, want %v", got, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMirror(t *testing.T) {
+	srcServer := etcdtest.NewServer()
+	defer srcServer.Close()
+	dstServer := etcdtest.NewServer()
+	defer dstServer.Close()
+	src, dst := srcServer.Client(), dstServer.Client()
+	for key, value := range map[string]string{"/app/a": "1", "/app/dir/b": "2", "/other/c": "3"} {
+		if _, err := src.SetKey(key, value); err != nil {
+			t.Fatalf("SetKey(%s): %v", key, err)
+		}
+	}
+	for key, value := range map[string]string{"/app/a": "0", "/app/stale": "0", "/kept": "0"} {
+		if _, err := dst.SetKey(key, value); err != nil {
+			t.Fatalf("SetKey(%s): %v", key, err)
+		}
+	}
+
+	stop := make(chan struct{})
+	mirrored := make(chan error, 1)
+	go func() { mirrored <- etcd.Mirror(src, dst, "app", stop) }()
+
+	waitForValues(t, dstServer, map[string]string{"/app/a": "1", "/app/dir/b": "2", "/kept": "0"}, "/app/stale", "/other/c")
+
+	if _, err := src.SetKey("/app/d", "4"); err != nil {
+		t.Fatalf("SetKey: %v", err)
+	}
+	if err := src.DeleteKey("/app/a"); err != nil {
+		t.Fatalf("DeleteKey: %v", err)
+	}
+	if _, err := src.SetKey("/other/e", "5"); err != nil {
+		t.Fatalf("SetKey: %v", err)
+	}
+	waitForValues(t, dstServer, map[string]string{"/app/d": "4", "/app/dir/b": "2"}, "/app/a", "/other/e")
+
+	close(stop)
+	select {
+	case err := <-mirrored:
+		if err != nil {
+			t.Errorf("Mirror = %v, want nil once stopped", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Mirror didn't return once stopped")
+	}
+}
@@ -0,0 +1,88 @@
+package etcd
+
+import (
+	"bytes"
+	"strings"
+)
+
+// Namespace returns a client that shares this client's connection but keeps all of its keys
+// under prefix. Keys passed to the returned client are relative to prefix and keys in the nodes,
+// key values and events it returns have prefix stripped, so the namespace is invisible to its
+// users. Namespaces nest.
+func (client *Client) Namespace(prefix string) *Client {
+	namespaced := &Client{
+		hosts:      client.Hosts(),
+		v3:         client.v3,
+		httpClient: client.httpClient,
+		retry:      client.retry,
+		metrics:    client.metrics,
+		logger:     client.logger,
+		namespace:  client.namespace,
+		current:    client.currentEndpoint(),
+	}
+	if trimmed := strings.Trim(prefix, "/"); trimmed != "" {
+		namespaced.namespace += "/" + trimmed
+	}
+	return namespaced
+}
+
+func (client *Client) currentEndpoint() int {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	return client.current
+}
+
+// withNamespace prefixes a v3 key with the namespace. Keys are placed below the namespace's
+// directory whether or not they start with "/", so no key reaches into a sibling namespace.
+func (client *Client) withNamespace(key []byte) []byte {
+	if client.namespace == "" {
+		return key
+	}
+	return append([]byte(client.namespace+"/"), bytes.TrimPrefix(key, []byte("/"))...)
+}
+
+// withNamespaceEnd prefixes a v3 range end with the namespace. A range end of "\x00", meaning
+// every key from the start of the range, is limited to the end of the namespace instead.
+func (client *Client) withNamespaceEnd(rangeEnd []byte) []byte {
+	if client.namespace == "" || len(rangeEnd) == 0 {
+		return rangeEnd
+	}
+	if bytes.Equal(rangeEnd, []byte{0}) {
+		return PrefixRangeEnd([]byte(client.namespace + "/"))
+	}
+	return client.withNamespace(rangeEnd)
+}
+
+// stripKey removes the namespace from a key below it, other keys are returned as they are
+func (client *Client) stripKey(key string) string {
+	switch {
+	case client.namespace == "":
+		return key
+	case key == client.namespace:
+		return "/"
+	case strings.HasPrefix(key, client.namespace+"/"):
+		return strings.TrimPrefix(key, client.namespace)
+	}
+	return key
+}
+
+func (client *Client) stripNode(node Node) Node {
+	if client.namespace == "" {
+		return node
+	}
+	node.Key = client.stripKey(node.Key)
+	if len(node.Nodes) > 0 {
+		nodes := make([]Node, len(node.Nodes))
+		for i, child := range node.Nodes {
+			nodes[i] = client.stripNode(child)
+		}
+		node.Nodes = nodes
+	}
+	return node
+}
+
+func (client *Client) stripKeyValues(kvs []KeyValue) {
+	for i := range kvs {
+		kvs[i].Key = []byte(client.stripKey(string(kvs[i].Key)))
+	}
+}
@@ -0,0 +1,78 @@
+package etcd_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/rarmstrong73/go-utils/etcd"
+	"github.com/rarmstrong73/go-utils/etcd/etcdtest"
+)
+
+func TestNamespacesDontSeeSiblings(t *testing.T) {
+	server := etcdtest.NewServer()
+	defer server.Close()
+	app := server.Client().Namespace("/app")
+	app2 := server.Client().Namespace("/app2")
+	if _, err := app.SetKey("/a", "app"); err != nil {
+		t.Fatalf("SetKey(app): %v", err)
+	}
+	if _, err := app2.SetKey("/a", "app2"); err != nil {
+		t.Fatalf("SetKey(app2): %v", err)
+	}
+
+	root, err := app.RecurseKeys("/")
+	if err != nil {
+		t.Fatalf("RecurseKeys: %v", err)
+	}
+	if len(root.Nodes) != 1 || root.Nodes[0].Key != "/a" || root.Nodes[0].Value != "app" {
+		t.Errorf("app's keys = %+v, want only its own /a", root.Nodes)
+	}
+	if err := app.DeleteKey("/a"); err != nil {
+		t.Fatalf("DeleteKey: %v", err)
+	}
+	if values := server.Values(); values["/app2/a"] != "app2" {
+		t.Errorf("values = %v, want /app2/a kept", values)
+	}
+}
+
+// rangeRequest is the part of a v3 range request the namespace applies to
+type rangeRequest struct {
+	Key      []byte `json:"key"`
+	RangeEnd []byte `json:"range_end"`
+}
+
+func TestNamespacedRangesStayInTheirNamespace(t *testing.T) {
+	var mutex sync.Mutex
+	requests := []rangeRequest{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		var request rangeRequest
+		json.Unmarshal(body, &request)
+		mutex.Lock()
+		requests = append(requests, request)
+		mutex.Unlock()
+		w.Write([]byte(`{"kvs":[{"key":"L2FwcC9h","value":"MQ=="},{"key":"L2FwcDIvYQ==","value":"Mg=="}]}`))
+	}))
+	defer server.Close()
+	app := etcd.NewV3Client(strings.TrimPrefix(server.URL, "http://")).Namespace("/app")
+
+	rangeResponse, err := app.Range([]byte("2"), []byte{0})
+	if err != nil {
+		t.Fatalf("Range: %v", err)
+	}
+	if len(requests) != 1 || string(requests[0].Key) != "/app/2" || string(requests[0].RangeEnd) != "/app0" {
+		t.Errorf("range requests = %q, want /app/2 up to the end of /app/", requests)
+	}
+	keys := []string{}
+	for _, kv := range rangeResponse.Kvs {
+		keys = append(keys, string(kv.Key))
+	}
+	if strings.Join(keys, ",") != "/a,/app2/a" {
+		t.Errorf("keys = %v, want /a and the sibling's key left as it is", keys)
+	}
+}
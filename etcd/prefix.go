@@ -0,0 +1,113 @@
+package etcd
+
+import (
+	"fmt"
+)
+
+// Sort orders for GetPrefix
+const (
+	SortAscend  = "ASCEND"
+	SortDescend = "DESCEND"
+)
+
+// PrefixPager reads the keys under a prefix a page at a time, see GetPrefix
+type PrefixPager struct {
+	client    *Client
+	limit     int64
+	sortOrder string
+	key       []byte
+	rangeEnd  []byte
+	revision  int64
+	page      []KeyValue
+	done      bool
+	err       error
+}
+
+// GetPrefix returns a pager over the keys starting with prefix, sorted by key in the given order
+// and read at most limit keys at a time. Every page is read at the revision of the first page so
+// the listing is consistent. Only v3 clients support GetPrefix.
+//
+//	pager := client.GetPrefix("/services/", 500, etcd.SortAscend)
+//	for pager.Next() {
+//		for _, kv := range pager.Page() {
+//			...
+//		}
+//	}
+//	if err := pager.Err(); err != nil {
+//		...
+//	}
+func (client *Client) GetPrefix(prefix string, limit int64, sortOrder string) *PrefixPager {
+	pager := &PrefixPager{
+		client:    client,
+		limit:     limit,
+		sortOrder: sortOrder,
+		key:       []byte(prefix),
+		rangeEnd:  PrefixRangeEnd([]byte(prefix)),
+	}
+
+	if !client.v3 {
+		pager.err = errV2Unsupported
+	} else if sortOrder != SortAscend && sortOrder != SortDescend {
+		pager.err = fmt.Errorf("Unknown sort order %q", sortOrder)
+	} else if limit <= 0 {
+		pager.err = fmt.Errorf("Limit must be positive, got %d", limit)
+	}
+	return pager
+}
+
+// Next reads the next page, returning false once every key has been read or a request fails
+func (pager *PrefixPager) Next() bool {
+	if pager.err != nil || pager.done {
+		return false
+	}
+
+	request := map[string]interface{}{
+		"key":         pager.client.withNamespace(pager.key),
+		"range_end":   pager.client.withNamespaceEnd(pager.rangeEnd),
+		"limit":       pager.limit,
+		"sort_order":  pager.sortOrder,
+		"sort_target": "KEY",
+	}
+	if pager.revision > 0 {
+		request["revision"] = pager.revision
+	}
+
+	var rangeResponse RangeResponse
+	pager.err = pager.client.v3Post("kv/range", request, &rangeResponse)
+	if pager.err != nil {
+		return false
+	}
+	if pager.revision == 0 {
+		pager.revision = rangeResponse.Header.Revision
+	}
+	pager.client.stripKeyValues(rangeResponse.Kvs)
+
+	pager.page = rangeResponse.Kvs
+	if !rangeResponse.More || len(rangeResponse.Kvs) == 0 {
+		pager.done = true
+		return len(pager.page) > 0
+	}
+
+	last := rangeResponse.Kvs[len(rangeResponse.Kvs)-1].Key
+	if pager.sortOrder == SortAscend {
+		pager.key = append(append([]byte{}, last...), 0)
+	} else {
+		pager.rangeEnd = append([]byte{}, last...)
+	}
+	return true
+}
+
+// Page returns the keys read by the last call to Next
+func (pager *PrefixPager) Page() []KeyValue {
+	return pager.page
+}
+
+// Revision returns the revision the listing is being read at
+func (pager *PrefixPager) Revision() int64 {
+	return pager.revision
+}
+
+// Err returns the error that stopped the pager, if any
+func (pager *PrefixPager) Err() error {
+	return pager.err
+}
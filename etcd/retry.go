@@ -0,0 +1,74 @@
+package etcd
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/rarmstrong73/go-utils/retry"
+)
+
+var errNoEndpoints = errors.New("No etcd endpoints configured")
+
+// RetryPolicy controls how a client retries requests, by default those that fail at the
+// transport level or with a 5xx from the member. Each retry goes to the next configured
+// endpoint. Application errors such as a failed compare-and-swap are never retried, and
+// requests that aren't idempotent, such as Create, CompareAndSwap, deletes and v3 transactions,
+// are only retried when they never reached a member since a retry could apply them twice.
+type RetryPolicy = retry.Policy
+
+// DefaultRetryPolicy is the retry policy of new clients
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: 100 * time.Millisecond,
+	MaxBackoff:     2 * time.Second,
+}
+
+// v3IdempotentEndpoints are the v3 endpoints whose requests can be sent twice with the same
+// effect as once
+var v3IdempotentEndpoints = map[string]bool{
+	"kv/range":               true,
+	"kv/put":                 true,
+	"lease/keepalive":        true,
+	"maintenance/snapshot":   true,
+	"maintenance/defragment": true,
+}
+
+// retryPolicy returns the policy a request is retried with, which retries requests that aren't
+// idempotent only when they never reached the member
+func (client *Client) retryPolicy(method, path string, body []byte) RetryPolicy {
+	policy := client.retry
+	if idempotent(method, path, body) {
+		return policy
+	}
+	policy.RetryOn = func(response *http.Response, err error) bool {
+		return retry.Unsent(response, err) && client.retry.Retryable(response, err)
+	}
+	return policy
+}
+
+// idempotent reports whether sending the request twice has the same effect as sending it once:
+// reads, unconditional v2 sets and the v3 endpoints of v3IdempotentEndpoints. Deletes aren't, a
+// retried delete that was applied fails with ErrKeyNotFound.
+func idempotent(method, path string, body []byte) bool {
+	switch method {
+	case http.MethodGet:
+		return true
+	case http.MethodPut:
+		form, err := url.ParseQuery(string(body))
+		return err == nil && form.Get("prevIndex") == "" && form.Get("prevValue") == "" && form.Get("prevExist") != "false"
+	case http.MethodPost:
+		return v3IdempotentEndpoints[strings.TrimPrefix(path, "/"+v3APIVersion+"/")]
+	}
+	return false
+}
+
+// SetRetryPolicy replaces the client's retry policy, a MaxAttempts of 1 disables retries
+func (client *Client) SetRetryPolicy(policy RetryPolicy) {
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+	client.retry = policy
+}
@@ -0,0 +1,113 @@
+package etcd_test
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rarmstrong73/go-utils/etcd"
+	"github.com/rarmstrong73/go-utils/etcd/etcdtest"
+)
+
+// conflicts records the operations of the conflicts a client reports
+type conflicts struct {
+	mutex      sync.Mutex
+	operations []string
+}
+
+func (conflicts *conflicts) RequestDone(etcd.RequestInfo) {}
+func (conflicts *conflicts) WatchReconnect(string)        {}
+
+func (conflicts *conflicts) CASConflict(operation string) {
+	conflicts.mutex.Lock()
+	defer conflicts.mutex.Unlock()
+	conflicts.operations = append(conflicts.operations, operation)
+}
+
+func TestRetryOnlyIdempotentRequests(t *testing.T) {
+	var mutex sync.Mutex
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		attempts++
+		mutex.Unlock()
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	client := etcd.NewClient(strings.TrimPrefix(server.URL, "http://"))
+	client.SetRetryPolicy(etcd.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond})
+
+	tests := []struct {
+		name    string
+		request func() error
+		want    int
+	}{
+		{"GetKey", func() error { _, err := client.GetKey("/a"); return err }, 3},
+		{"Set", func() error { _, err := client.Set("/a", "1"); return err }, 3},
+		{"Create", func() error { _, err := client.Create("/a", "1", 0); return err }, 1},
+		{"CompareAndSwap", func() error { _, err := client.CompareAndSwap("/a", "1", 7); return err }, 1},
+		{"CompareAndDelete", func() error { _, err := client.CompareAndDelete("/a", 7); return err }, 1},
+		{"DeleteKey", func() error { return client.DeleteKey("/a") }, 1},
+	}
+	for _, test := range tests {
+		mutex.Lock()
+		attempts = 0
+		mutex.Unlock()
+		if err := test.request(); err == nil {
+			t.Errorf("%s succeeded against an unavailable member", test.name)
+		}
+		mutex.Lock()
+		if attempts != test.want {
+			t.Errorf("%s made %d attempts, want %d", test.name, attempts, test.want)
+		}
+		mutex.Unlock()
+	}
+}
+
+func TestRetryConditionalWritesThatNeverReachedAMember(t *testing.T) {
+	server := etcdtest.NewServer()
+	defer server.Close()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	refused := listener.Addr().String()
+	listener.Close()
+
+	client := etcd.NewClient(refused, server.Host())
+	client.SetRetryPolicy(etcd.RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond})
+	if _, err := client.Create("/a", "1", 0); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if values := server.Values(); values["/a"] != "1" {
+		t.Errorf("values = %v, want /a created", values)
+	}
+}
+
+func TestConflictsReportTheirAction(t *testing.T) {
+	server := etcdtest.NewServer()
+	defer server.Close()
+	client := server.Client()
+	recorded := &conflicts{}
+	client.SetMetrics(recorded)
+
+	created, err := client.Create("/a", "1", 0)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := client.Create("/a", "2", 0); err == nil {
+		t.Error("Create of an existing key succeeded")
+	}
+	if _, err := client.CompareAndSwap("/a", "2", created.Node.ModifiedIndex+1); err == nil {
+		t.Error("CompareAndSwap at a stale index succeeded")
+	}
+
+	want := []string{etcd.ActionCreate, etcd.ActionCompareAndSwap}
+	if strings.Join(recorded.operations, ",") != strings.Join(want, ",") {
+		t.Errorf("conflicts = %v, want %v", recorded.operations, want)
+	}
+}
@@ -0,0 +1,232 @@
+package etcd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Semaphore is a counting semaphore stored under a directory in etcd that allows at most max
+// holders at once. The list of holders is kept in a single state key updated with
+// compare-and-swap, and each holder also owns a key with a TTL so that holders that die without
+// releasing are dropped once their key expires. Holders must call Refresh more often than the
+// TTL to keep their slot. Semaphores need a v2 client.
+type Semaphore struct {
+	client *Client
+	path   string
+	max    int
+	ttl    time.Duration
+}
+
+type semaphoreState struct {
+	Max     int      `json:"max"`
+	Holders []string `json:"holders"`
+}
+
+// NewSemaphore returns a semaphore under path with max slots whose holders expire after ttl
+func (client *Client) NewSemaphore(path string, max int, ttl time.Duration) *Semaphore {
+	return &Semaphore{client: client, path: "/" + strings.Trim(path, "/"), max: max, ttl: ttl}
+}
+
+// TryAcquire takes a slot for holder if one is free and reports whether it did. Acquiring a
+// semaphore the holder already holds refreshes it and succeeds.
+func (semaphore *Semaphore) TryAcquire(holder string) (bool, error) {
+	acquired, _, err := semaphore.tryAcquire(holder)
+	return acquired, err
+}
+
+// tryAcquire is TryAcquire, also returning the etcd index the semaphore was last read at
+func (semaphore *Semaphore) tryAcquire(holder string) (bool, int64, error) {
+	if _, err := semaphore.client.SetWithTTL(semaphore.holderKey(holder), holder, semaphore.ttlSeconds()); err != nil {
+		return false, 0, err
+	}
+
+	acquired := false
+	etcdIndex, err := semaphore.update(func(state *semaphoreState) bool {
+		for _, existing := range state.Holders {
+			if existing == holder {
+				acquired = true
+				return false
+			}
+		}
+		if len(state.Holders) >= state.Max {
+			acquired = false
+			return false
+		}
+		state.Holders = append(state.Holders, holder)
+		acquired = true
+		return true
+	})
+	if err != nil {
+		return false, 0, err
+	}
+	if !acquired {
+		return false, etcdIndex, semaphore.client.removeKey(semaphore.holderKey(holder))
+	}
+	return true, etcdIndex, nil
+}
+
+// Acquire blocks until holder takes a slot, watching the semaphore for released or expired
+// slots. It gives up with an error if stop is closed first.
+func (semaphore *Semaphore) Acquire(holder string, stop <-chan struct{}) error {
+	for {
+		acquired, etcdIndex, err := semaphore.tryAcquire(holder)
+		if err != nil || acquired {
+			return err
+		}
+
+		// Watching from after the read catches slots released before the watch starts
+		_, err = semaphore.client.WatchKey(semaphore.path, true, etcdIndex+1, stop)
+		select {
+		case <-stop:
+			return fmt.Errorf("Stopped waiting for semaphore %s", semaphore.path)
+		default:
+		}
+		if err != nil && !errors.Is(err, ErrEventIndexCleared) {
+			return err
+		}
+	}
+}
+
+// Release gives up holder's slot, releasing a slot that isn't held is not an error
+func (semaphore *Semaphore) Release(holder string) error {
+	_, err := semaphore.update(func(state *semaphoreState) bool {
+		for i, existing := range state.Holders {
+			if existing == holder {
+				state.Holders = append(state.Holders[:i], state.Holders[i+1:]...)
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil {
+		return err
+	}
+	return semaphore.client.removeKey(semaphore.holderKey(holder))
+}
+
+// Refresh extends holder's TTL, it fails if the holder's slot has already expired
+func (semaphore *Semaphore) Refresh(holder string) error {
+	_, err := semaphore.client.RefreshKey(semaphore.holderKey(holder), semaphore.ttlSeconds())
+	return err
+}
+
+// Holders returns the current holders of the semaphore, leaving out any whose TTL has expired
+func (semaphore *Semaphore) Holders() ([]string, error) {
+	snapshot, err := semaphore.read()
+	if err != nil {
+		return nil, err
+	}
+
+	holders := []string{}
+	for _, holder := range snapshot.state.Holders {
+		if snapshot.live[holder] {
+			holders = append(holders, holder)
+		}
+	}
+	return holders, nil
+}
+
+// update applies change to the semaphore state with compare-and-swap, retrying on conflicts.
+// Expired holders are dropped before change sees the state. change returns false to leave the
+// state as it is. It returns the etcd index the semaphore was last read at.
+func (semaphore *Semaphore) update(change func(state *semaphoreState) bool) (int64, error) {
+	stateExists := false
+	for {
+		snapshot, err := semaphore.read()
+		if err != nil {
+			return 0, err
+		}
+		if stateExists && snapshot.index == 0 {
+			return 0, fmt.Errorf("Semaphore state %s exists but isn't in the semaphore's tree", semaphore.stateKey())
+		}
+		state := snapshot.state
+
+		pruned := state.Holders[:0]
+		for _, holder := range state.Holders {
+			if snapshot.live[holder] {
+				pruned = append(pruned, holder)
+			}
+		}
+		expired := len(pruned) != len(state.Holders)
+		state.Holders = pruned
+
+		if !change(&state) && !expired {
+			return snapshot.etcdIndex, nil
+		}
+
+		stateBytes, err := json.Marshal(state)
+		if err != nil {
+			return 0, err
+		}
+		if snapshot.index == 0 {
+			_, err = semaphore.client.Create(semaphore.stateKey(), string(stateBytes), 0)
+		} else {
+			_, err = semaphore.client.CompareAndSwap(semaphore.stateKey(), string(stateBytes), snapshot.index)
+		}
+		if errors.Is(err, ErrNodeExist) {
+			stateExists = true
+			continue
+		}
+		if errors.Is(err, ErrTestFailed) {
+			continue
+		}
+		return snapshot.etcdIndex, err
+	}
+}
+
+// semaphoreSnapshot is the semaphore as read at one etcd index
+type semaphoreSnapshot struct {
+	state semaphoreState
+	// index is the modified index of the state to compare against, 0 if it doesn't exist yet
+	index int64
+	// live are the holders whose keys haven't expired
+	live      map[string]bool
+	etcdIndex int64
+}
+
+// read reads the state and the holders' keys in one request, so they agree with each other and
+// with the etcd index to watch from
+func (semaphore *Semaphore) read() (semaphoreSnapshot, error) {
+	node, etcdIndex, err := semaphore.client.getTree(semaphore.path)
+	if err != nil {
+		return semaphoreSnapshot{}, err
+	}
+
+	snapshot := semaphoreSnapshot{
+		state:     semaphoreState{Max: semaphore.max},
+		live:      map[string]bool{},
+		etcdIndex: etcdIndex,
+	}
+	for _, leaf := range leafNodes(node) {
+		if leaf.Key == semaphore.stateKey() {
+			var state semaphoreState
+			if err := json.Unmarshal([]byte(leaf.Value), &state); err != nil {
+				return semaphoreSnapshot{}, fmt.Errorf("Invalid semaphore state at %s: %v", leaf.Key, err)
+			}
+			snapshot.state = state
+			snapshot.index = leaf.ModifiedIndex
+		} else if strings.HasPrefix(leaf.Key, semaphore.path+"/holders/") {
+			snapshot.live[leaf.Value] = true
+		}
+	}
+	return snapshot, nil
+}
+
+func (semaphore *Semaphore) stateKey() string {
+	return semaphore.path + "/state"
+}
+
+func (semaphore *Semaphore) holderKey(holder string) string {
+	return semaphore.path + "/holders/" + holder
+}
+
+func (semaphore *Semaphore) ttlSeconds() int {
+	seconds := int(semaphore.ttl / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return seconds
+}
@@ -0,0 +1,91 @@
+package etcd_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rarmstrong73/go-utils/etcd"
+	"github.com/rarmstrong73/go-utils/etcd/etcdtest"
+)
+
+// TestSemaphoreAcquireSeesReleaseBeforeWatch releases the slot after the waiter has read the
+// semaphore but before its watch reaches etcd, which the waiter must not miss
+func TestSemaphoreAcquireSeesReleaseBeforeWatch(t *testing.T) {
+	server := etcdtest.NewServer()
+	defer server.Close()
+	holder := server.Client().NewSemaphore("/semaphore", 1, time.Minute)
+	if acquired, err := holder.TryAcquire("a"); err != nil || !acquired {
+		t.Fatalf("TryAcquire(a) = %t, %v", acquired, err)
+	}
+
+	target, _ := url.Parse(server.URL)
+	forward := httputil.NewSingleHostReverseProxy(target)
+	var once sync.Once
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("wait") == "true" {
+			once.Do(func() {
+				if err := holder.Release("a"); err != nil {
+					t.Errorf("Release(a): %v", err)
+				}
+			})
+		}
+		forward.ServeHTTP(w, r)
+	}))
+	defer proxy.Close()
+
+	waiter := etcd.NewClient(strings.TrimPrefix(proxy.URL, "http://")).NewSemaphore("/semaphore", 1, time.Minute)
+	stop := make(chan struct{})
+	acquired := make(chan error, 1)
+	go func() { acquired <- waiter.Acquire("b", stop) }()
+
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Fatalf("Acquire(b): %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		close(stop)
+		t.Fatal("Acquire(b) missed the release")
+	}
+	holders, err := waiter.Holders()
+	if err != nil || len(holders) != 1 || holders[0] != "b" {
+		t.Errorf("Holders = %v, %v, want [b]", holders, err)
+	}
+}
+
+func TestSemaphoreWithRelativePath(t *testing.T) {
+	server := etcdtest.NewServer()
+	defer server.Close()
+	semaphore := server.Client().NewSemaphore("jobs/semaphore", 1, time.Minute)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if acquired, err := semaphore.TryAcquire("a"); err != nil || !acquired {
+			t.Errorf("TryAcquire(a) = %t, %v", acquired, err)
+		}
+		if acquired, err := semaphore.TryAcquire("b"); err != nil || acquired {
+			t.Errorf("TryAcquire(b) = %t, %v, want the slot taken", acquired, err)
+		}
+		if err := semaphore.Release("a"); err != nil {
+			t.Errorf("Release(a): %v", err)
+		}
+		if acquired, err := semaphore.TryAcquire("b"); err != nil || !acquired {
+			t.Errorf("TryAcquire(b) after the release = %t, %v", acquired, err)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("semaphore under a relative path hung")
+	}
+	if values := server.Values(); values["/jobs/semaphore/state"] == "" {
+		t.Errorf("values = %v, want the state under /jobs/semaphore", values)
+	}
+}
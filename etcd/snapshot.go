@@ -0,0 +1,96 @@
+package etcd
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/rarmstrong73/go-utils/internal/httpclient"
+)
+
+// Snapshot streams a consistent backup of the cluster to w and returns the hex encoded SHA-256
+// hash of the data it verified. v3 clients save the member's backend database, which ends with
+// the SHA-256 hash of everything before it. This hash is checked once the stream completes and
+// the file written to w can be restored with etcdctl. v2 clients export the whole key tree as
+// JSON, see ExportTree, and return the hash of the JSON written.
+func (client *Client) Snapshot(w io.Writer) (string, error) {
+	if !client.v3 {
+		return client.v2Snapshot(w)
+	}
+
+	path := fmt.Sprintf("/%s/maintenance/snapshot", v3APIVersion)
+	response, err := client.doHTTPResponse(httpclient.Streaming(context.Background()), http.MethodPost, path, []byte("{}"), "application/json")
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != 200 {
+		responseBytes, _ := ioutil.ReadAll(response.Body)
+		return "", fmt.Errorf("%d: %s", response.StatusCode, bytes.TrimSpace(responseBytes))
+	}
+
+	hasher := sha256.New()
+	var tail []byte
+	decoder := json.NewDecoder(response.Body)
+	for {
+		var message struct {
+			Result *struct {
+				RemainingBytes uint64 `json:"remaining_bytes,string"`
+				Blob           []byte `json:"blob"`
+			} `json:"result"`
+			Error *V3Error `json:"error"`
+		}
+		err = decoder.Decode(&message)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if message.Error != nil {
+			return "", message.Error
+		}
+		if message.Result == nil {
+			continue
+		}
+
+		if _, err = w.Write(message.Result.Blob); err != nil {
+			return "", err
+		}
+
+		// The trailing hash isn't part of what it hashes, so hold back the last sha256.Size bytes
+		data := append(tail, message.Result.Blob...)
+		if len(data) > sha256.Size {
+			hasher.Write(data[:len(data)-sha256.Size])
+			data = data[len(data)-sha256.Size:]
+		}
+		tail = append([]byte{}, data...)
+	}
+
+	sum := hasher.Sum(nil)
+	if !bytes.Equal(sum, tail) {
+		return "", fmt.Errorf("Snapshot integrity check failed: expected %x, got %x", tail, sum)
+	}
+	return hex.EncodeToString(sum), nil
+}
+
+func (client *Client) v2Snapshot(w io.Writer) (string, error) {
+	export, err := client.ExportTree("/")
+	if err != nil {
+		return "", err
+	}
+
+	hasher := sha256.New()
+	err = export.WriteJSON(io.MultiWriter(w, hasher), false)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
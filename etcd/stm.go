@@ -0,0 +1,222 @@
+package etcd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// ErrConflict is returned by Atomically when the keys it read kept changing before it could
+// commit, until its retry policy allowed no more attempts
+var ErrConflict = errors.New("Transaction conflicted with another write")
+
+// DefaultConflictPolicy retries transactions that conflicted, backing off so transactions
+// contending for the same keys don't keep running in lockstep
+var DefaultConflictPolicy = RetryPolicy{
+	MaxAttempts:    10,
+	InitialBackoff: 10 * time.Millisecond,
+	MaxBackoff:     time.Second,
+	Jitter:         0.5,
+}
+
+// STM is the view of the keyspace given to an Atomically function. Reads record the revision of
+// each key they see, writes are buffered until the function returns.
+type STM struct {
+	client *Client
+	reads  map[string]stmRead
+	writes map[string]*string
+}
+
+type stmRead struct {
+	value    string
+	exists   bool
+	revision int64
+}
+
+// Atomically runs apply and then commits its writes only if none of the keys it read have
+// changed in the meantime, even when apply wrote nothing. Whenever a conflicting write got in
+// first apply runs again on a fresh view, as often and as paced as policy allows, after which
+// Atomically fails with ErrConflict. Only conflicts are retried, policy's RetryOn is ignored. An
+// error from apply or ctx being done aborts without writing anything. Keys are paths as used by
+// GetKey and SetKey.
+//
+// v3 clients commit every write in a single transaction. v2 clients can only compare and swap a
+// single key, so there apply may read and write only one key, and fails without writing
+// anything when it uses more.
+func (client *Client) Atomically(ctx context.Context, policy RetryPolicy, apply func(stm *STM) error) error {
+	policy.RetryOn = func(response *http.Response, err error) bool {
+		return errors.Is(err, ErrConflict)
+	}
+	return policy.Do(ctx, func(attempt int) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		stm := &STM{
+			client: client,
+			reads:  map[string]stmRead{},
+			writes: map[string]*string{},
+		}
+		if err := apply(stm); err != nil {
+			return err
+		}
+		return stm.commit()
+	})
+}
+
+// Get returns the value of key and whether it exists, as of the first time it was read in this
+// attempt or as last written by it
+func (stm *STM) Get(key string) (string, bool, error) {
+	key = v3Key(key)
+	if value, ok := stm.writes[key]; ok {
+		if value == nil {
+			return "", false, nil
+		}
+		return *value, true, nil
+	}
+	if read, ok := stm.reads[key]; ok {
+		return read.value, read.exists, nil
+	}
+
+	read, err := stm.read(key)
+	if err != nil {
+		return "", false, err
+	}
+	stm.reads[key] = read
+	return read.value, read.exists, nil
+}
+
+// Put sets key to value when the transaction commits
+func (stm *STM) Put(key, value string) {
+	stm.writes[v3Key(key)] = &value
+}
+
+// Delete deletes key when the transaction commits
+func (stm *STM) Delete(key string) {
+	stm.writes[v3Key(key)] = nil
+}
+
+func (stm *STM) read(key string) (stmRead, error) {
+	if stm.client.v3 {
+		rangeResponse, err := stm.client.Range([]byte(key), nil)
+		if err != nil {
+			return stmRead{}, err
+		}
+		if len(rangeResponse.Kvs) == 0 {
+			return stmRead{}, nil
+		}
+		kv := rangeResponse.Kvs[0]
+		return stmRead{value: string(kv.Value), exists: true, revision: kv.ModRevision}, nil
+	}
+
+	node, err := stm.client.GetKey(key)
+	if errors.Is(err, ErrKeyNotFound) {
+		return stmRead{}, nil
+	}
+	if err != nil {
+		return stmRead{}, err
+	}
+	return stmRead{value: node.Value, exists: true, revision: node.ModifiedIndex}, nil
+}
+
+func (stm *STM) commit() error {
+	if len(stm.reads) == 0 && len(stm.writes) == 0 {
+		return nil
+	}
+	if stm.client.v3 {
+		return stm.commitV3()
+	}
+	return stm.commitV2()
+}
+
+func (stm *STM) commitV3() error {
+	txn := stm.client.Txn()
+	for _, key := range sortedKeys(stm.reads) {
+		read := stm.reads[key]
+		if read.exists {
+			txn.If(CompareModRevision(key, Equal, read.revision))
+		} else {
+			txn.If(CompareVersion(key, Equal, 0))
+		}
+	}
+	for key, value := range stm.writes {
+		if value == nil {
+			txn.Then(OpDelete(key))
+		} else {
+			txn.Then(OpPut(key, *value))
+		}
+	}
+
+	txnResponse, err := txn.Commit()
+	if err != nil {
+		return err
+	}
+	if !txnResponse.Succeeded {
+		return ErrConflict
+	}
+	return nil
+}
+
+func (stm *STM) commitV2() error {
+	keys := map[string]bool{}
+	for key := range stm.reads {
+		keys[key] = true
+	}
+	for key := range stm.writes {
+		keys[key] = true
+	}
+	if len(keys) > 1 {
+		return fmt.Errorf("v2 clients can only read and write one key atomically, got %d", len(keys))
+	}
+
+	var key string
+	for key = range keys {
+		break
+	}
+	read, wasRead := stm.reads[key]
+	value, written := stm.writes[key]
+	var err error
+	switch {
+	case !written || (value == nil && wasRead && !read.exists):
+		// Nothing changes, the transaction holds as long as the key still is as it was read
+		return stm.validate(key, read)
+	case value == nil && wasRead:
+		_, err = stm.client.CompareAndDelete(key, read.revision)
+	case value == nil:
+		err = stm.client.removeKey(key)
+	case wasRead && read.exists:
+		_, err = stm.client.CompareAndSwap(key, *value, read.revision)
+	case wasRead:
+		_, err = stm.client.Create(key, *value, 0)
+	default:
+		_, err = stm.client.SetKey(key, *value)
+	}
+
+	if errors.Is(err, ErrTestFailed) || errors.Is(err, ErrNodeExist) || errors.Is(err, ErrKeyNotFound) {
+		return ErrConflict
+	}
+	return err
+}
+
+// validate returns ErrConflict if key has changed since it was read
+func (stm *STM) validate(key string, read stmRead) error {
+	current, err := stm.read(key)
+	if err != nil {
+		return err
+	}
+	if current.exists != read.exists || current.revision != read.revision {
+		return ErrConflict
+	}
+	return nil
+}
+
+func sortedKeys(reads map[string]stmRead) []string {
+	keys := make([]string, 0, len(reads))
+	for key := range reads {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
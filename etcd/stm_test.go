@@ -0,0 +1,138 @@
+package etcd_test
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/rarmstrong73/go-utils/etcd"
+	"github.com/rarmstrong73/go-utils/etcd/etcdtest"
+)
+
+var conflictPolicy = etcd.RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Millisecond}
+
+func TestAtomicallyRetriesConflicts(t *testing.T) {
+	server := etcdtest.NewServer()
+	defer server.Close()
+	client := server.Client()
+	if _, err := client.SetKey("/counter", "1"); err != nil {
+		t.Fatalf("SetKey: %v", err)
+	}
+
+	attempts := 0
+	err := client.Atomically(context.Background(), conflictPolicy, func(stm *etcd.STM) error {
+		attempts++
+		value, _, err := stm.Get("/counter")
+		if err != nil {
+			return err
+		}
+		if attempts == 1 {
+			if _, err := client.SetKey("/counter", "5"); err != nil {
+				return err
+			}
+		}
+		counter, _ := strconv.Atoi(value)
+		stm.Put("/counter", strconv.Itoa(counter+1))
+		return nil
+	})
+	if err != nil || attempts != 2 {
+		t.Fatalf("Atomically = %v after %d attempts, want success after 2", err, attempts)
+	}
+	if values := server.Values(); values["/counter"] != "6" {
+		t.Errorf("counter = %s, want 6", values["/counter"])
+	}
+}
+
+func TestAtomicallyValidatesReads(t *testing.T) {
+	server := etcdtest.NewServer()
+	defer server.Close()
+	client := server.Client()
+	if _, err := client.SetKey("/a", "1"); err != nil {
+		t.Fatalf("SetKey: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		key   string
+		apply func(stm *etcd.STM)
+	}{
+		{"read only", "/a", func(stm *etcd.STM) {}},
+		{"delete of a missing key", "/missing", func(stm *etcd.STM) { stm.Delete("/missing") }},
+	}
+	for _, test := range tests {
+		attempts := 0
+		err := client.Atomically(context.Background(), conflictPolicy, func(stm *etcd.STM) error {
+			attempts++
+			if _, _, err := stm.Get(test.key); err != nil {
+				return err
+			}
+			if attempts == 1 {
+				if _, err := client.SetKey(test.key, "2"); err != nil {
+					return err
+				}
+			}
+			test.apply(stm)
+			return nil
+		})
+		if err != nil || attempts != 2 {
+			t.Errorf("%s: Atomically = %v after %d attempts, want success after 2", test.name, err, attempts)
+		}
+	}
+	if _, ok := server.Values()["/missing"]; ok {
+		t.Error("/missing survived the retried delete")
+	}
+}
+
+func TestAtomicallyGivesUp(t *testing.T) {
+	server := etcdtest.NewServer()
+	defer server.Close()
+	client := server.Client()
+
+	attempts := 0
+	err := client.Atomically(context.Background(), etcd.RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}, func(stm *etcd.STM) error {
+		attempts++
+		if _, _, err := stm.Get("/a"); err != nil {
+			return err
+		}
+		if _, err := client.SetKey("/a", strconv.Itoa(attempts)); err != nil {
+			return err
+		}
+		stm.Put("/a", "mine")
+		return nil
+	})
+	if !errors.Is(err, etcd.ErrConflict) || attempts != 3 {
+		t.Errorf("Atomically = %v after %d attempts, want ErrConflict after 3", err, attempts)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = client.Atomically(ctx, conflictPolicy, func(stm *etcd.STM) error {
+		t.Error("apply ran with a done context")
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Atomically with a done context = %v, want context.Canceled", err)
+	}
+}
+
+func TestAtomicallyRejectsSeveralKeysOnV2(t *testing.T) {
+	server := etcdtest.NewServer()
+	defer server.Close()
+	client := server.Client()
+
+	err := client.Atomically(context.Background(), conflictPolicy, func(stm *etcd.STM) error {
+		if _, _, err := stm.Get("/a"); err != nil {
+			return err
+		}
+		stm.Put("/b", "1")
+		return nil
+	})
+	if err == nil {
+		t.Fatal("Atomically of two keys succeeded on a v2 client")
+	}
+	if _, ok := server.Values()["/b"]; ok {
+		t.Error("/b was written")
+	}
+}
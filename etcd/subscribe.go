@@ -0,0 +1,226 @@
+package etcd
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rarmstrong73/go-utils/apierror"
+)
+
+// Subscription event actions that don't come from etcd itself
+const (
+	// ActionSync is delivered for every key when a subscription first reads its prefix
+	ActionSync = "sync"
+)
+
+var resubscribeDelay = time.Second
+
+// Event is a change under a subscribed prefix. Events synthesized after the subscription had to
+// re-read its prefix, because etcd no longer had the events it missed, have Resync set.
+type Event struct {
+	Prefix   string
+	Action   string
+	Node     Node
+	PrevNode Node
+	Resync   bool
+}
+
+// Subscriptions watches prefixes on behalf of registered callbacks. Each prefix is read once and
+// then watched, keeping a copy of its values so that when the watch falls too far behind etcd's
+// event history the prefix is re-read and the difference delivered as events. Callbacks for all
+// prefixes are called one at a time from a single goroutine, in the order the changes happened
+// within each prefix. Subscriptions need a v2 client.
+type Subscriptions struct {
+	client     *Client
+	deliveries chan func()
+	stop       chan struct{}
+	stopOnce   sync.Once
+	wg         sync.WaitGroup
+}
+
+// NewSubscriptions returns a subscription manager using the given client
+func NewSubscriptions(client *Client) *Subscriptions {
+	subscriptions := &Subscriptions{
+		client:     client,
+		deliveries: make(chan func(), 64),
+		stop:       make(chan struct{}),
+	}
+	go subscriptions.deliver()
+	return subscriptions
+}
+
+// Subscribe calls callback with an ActionSync event for every key currently under prefix and
+// then with every change to the prefix until the manager or its client is closed. It fails for
+// v3 clients, which can't watch.
+func (subscriptions *Subscriptions) Subscribe(prefix string, callback func(Event)) error {
+	if subscriptions.client.v3 {
+		return errV3Unsupported
+	}
+	prefix = "/" + strings.Trim(prefix, "/")
+	subscriptions.wg.Add(1)
+	go subscriptions.follow(prefix, callback)
+	return nil
+}
+
+// Close stops every watch and waits for them to finish, callbacks already queued are dropped
+func (subscriptions *Subscriptions) Close() {
+	subscriptions.stopOnce.Do(func() { close(subscriptions.stop) })
+	subscriptions.wg.Wait()
+}
+
+func (subscriptions *Subscriptions) deliver() {
+	for {
+		select {
+		case <-subscriptions.stop:
+			return
+		case delivery := <-subscriptions.deliveries:
+			delivery()
+		}
+	}
+}
+
+func (subscriptions *Subscriptions) send(callback func(Event), event Event) bool {
+	select {
+	case <-subscriptions.stop:
+		return false
+	case subscriptions.deliveries <- func() { callback(event) }:
+		return true
+	}
+}
+
+func (subscriptions *Subscriptions) follow(prefix string, callback func(Event)) {
+	defer subscriptions.wg.Done()
+
+	var known map[string]string
+	for {
+		etcdIndex, err := subscriptions.resync(prefix, callback, &known)
+		if err == nil {
+			err = subscriptions.watch(prefix, callback, known, etcdIndex+1)
+		}
+
+		select {
+		case <-subscriptions.stop:
+			return
+		default:
+		}
+		if errors.Is(err, apierror.ErrClosed) {
+			subscriptions.client.logger.Info("Client was closed, stopping subscription", "prefix", prefix)
+			return
+		}
+		subscriptions.client.metrics.WatchReconnect(prefix)
+		if err != nil {
+			subscriptions.client.logger.Info("Resubscribing", "prefix", prefix, "error", err)
+		}
+
+		// The watch can resume straight away from a re-read when etcd dropped the events it
+		// needed, anything else waits a little so an unreachable cluster isn't hammered
+		if !errors.Is(err, ErrEventIndexCleared) {
+			select {
+			case <-subscriptions.stop:
+				return
+			case <-time.After(resubscribeDelay):
+			}
+		}
+	}
+}
+
+// resync reads the prefix and delivers the difference from the known values, which it replaces
+func (subscriptions *Subscriptions) resync(prefix string, callback func(Event), known *map[string]string) (int64, error) {
+	node, etcdIndex, err := subscriptions.client.getTree(prefix)
+	if err != nil {
+		return 0, err
+	}
+
+	initial := *known == nil
+	current := map[string]string{}
+	for _, leaf := range leafNodes(node) {
+		current[leaf.Key] = leaf.Value
+
+		if initial {
+			if !subscriptions.send(callback, Event{Prefix: prefix, Action: ActionSync, Node: leaf}) {
+				return 0, nil
+			}
+			continue
+		}
+		oldValue, ok := (*known)[leaf.Key]
+		if ok && oldValue == leaf.Value {
+			continue
+		}
+		event := Event{Prefix: prefix, Action: ActionSet, Node: leaf, Resync: true}
+		if ok {
+			event.PrevNode = Node{Key: leaf.Key, Value: oldValue}
+		}
+		if !subscriptions.send(callback, event) {
+			return 0, nil
+		}
+	}
+
+	if !initial {
+		for key, oldValue := range *known {
+			if _, ok := current[key]; ok {
+				continue
+			}
+			event := Event{
+				Prefix:   prefix,
+				Action:   ActionDelete,
+				Node:     Node{Key: key},
+				PrevNode: Node{Key: key, Value: oldValue},
+				Resync:   true,
+			}
+			if !subscriptions.send(callback, event) {
+				return 0, nil
+			}
+		}
+	}
+
+	*known = current
+	return etcdIndex, nil
+}
+
+// watch delivers every change under prefix from waitIndex, keeping known up to date
+func (subscriptions *Subscriptions) watch(prefix string, callback func(Event), known map[string]string, waitIndex int64) error {
+	for {
+		watchResponse, err := subscriptions.client.WatchKey(prefix, true, waitIndex, subscriptions.stop)
+		if err != nil {
+			return err
+		}
+		waitIndex = watchResponse.Node.ModifiedIndex + 1
+
+		switch watchResponse.Action {
+		case ActionSet, ActionCreate, ActionUpdate, ActionCompareAndSwap:
+			if watchResponse.Node.Dir {
+				continue
+			}
+			known[watchResponse.Node.Key] = watchResponse.Node.Value
+		case ActionDelete, ActionExpire, ActionCompareAndDelete:
+			for key := range known {
+				if key == watchResponse.Node.Key || strings.HasPrefix(key, watchResponse.Node.Key+"/") {
+					delete(known, key)
+				}
+			}
+		}
+
+		event := Event{
+			Prefix:   prefix,
+			Action:   watchResponse.Action,
+			Node:     watchResponse.Node,
+			PrevNode: watchResponse.PrevNode,
+		}
+		if !subscriptions.send(callback, event) {
+			return nil
+		}
+	}
+}
+
+func leafNodes(node Node) []Node {
+	if !node.Dir {
+		return []Node{node}
+	}
+	var leaves []Node
+	for _, child := range node.Nodes {
+		leaves = append(leaves, leafNodes(child)...)
+	}
+	return leaves
+}
@@ -0,0 +1,64 @@
+package etcd_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rarmstrong73/go-utils/etcd"
+	"github.com/rarmstrong73/go-utils/etcd/etcdtest"
+)
+
+func TestSubscribe(t *testing.T) {
+	server := etcdtest.NewServer()
+	defer server.Close()
+	client := server.Client()
+	if _, err := client.SetKey("/app/a", "1"); err != nil {
+		t.Fatalf("SetKey: %v", err)
+	}
+
+	subscriptions := etcd.NewSubscriptions(client)
+	defer subscriptions.Close()
+	events := make(chan etcd.Event, 4)
+	if err := subscriptions.Subscribe("app", func(event etcd.Event) { events <- event }); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	expect := func(action, key, value string) {
+		select {
+		case event := <-events:
+			if event.Action != action || event.Node.Key != key || event.Node.Value != value {
+				t.Errorf("event = %s %s=%s, want %s %s=%s", event.Action, event.Node.Key, event.Node.Value, action, key, value)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no event, want %s %s=%s", action, key, value)
+		}
+	}
+	expect(etcd.ActionSync, "/app/a", "1")
+	if _, err := client.SetKey("/app/b", "2"); err != nil {
+		t.Fatalf("SetKey: %v", err)
+	}
+	expect(etcd.ActionSet, "/app/b", "2")
+}
+
+func TestSubscribeFailsForV3Clients(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	subscriptions := etcd.NewSubscriptions(etcd.NewV3Client(strings.TrimPrefix(server.URL, "http://")))
+	defer subscriptions.Close()
+	if err := subscriptions.Subscribe("/app", func(etcd.Event) {}); err == nil {
+		t.Error("Subscribe succeeded, want an error for a client that can't watch")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&requests); n != 0 {
+		t.Errorf("%d requests sent, want none", n)
+	}
+}
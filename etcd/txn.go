@@ -0,0 +1,187 @@
+package etcd
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+var errV2Unsupported = errors.New("Operation requires a v3 client")
+
+// Comparison operators for transaction compares
+const (
+	Equal    = "="
+	NotEqual = "!="
+	Greater  = ">"
+	Less     = "<"
+)
+
+var compareResults = map[string]string{
+	Equal:    "EQUAL",
+	NotEqual: "NOT_EQUAL",
+	Greater:  "GREATER",
+	Less:     "LESS",
+}
+
+// Compare is a single condition checked by a transaction
+type Compare struct {
+	key    string
+	op     string
+	target string
+	field  string
+	value  interface{}
+}
+
+// CompareValue compares the value of key against value
+func CompareValue(key, op, value string) Compare {
+	return Compare{key: key, op: op, target: "VALUE", field: "value", value: []byte(value)}
+}
+
+// CompareVersion compares the version of key against version, a missing key has version 0
+func CompareVersion(key, op string, version int64) Compare {
+	return Compare{key: key, op: op, target: "VERSION", field: "version", value: strconv.FormatInt(version, 10)}
+}
+
+// CompareCreateRevision compares the revision key was created at against revision
+func CompareCreateRevision(key, op string, revision int64) Compare {
+	return Compare{key: key, op: op, target: "CREATE", field: "create_revision", value: strconv.FormatInt(revision, 10)}
+}
+
+// CompareModRevision compares the revision key was last modified at against revision
+func CompareModRevision(key, op string, revision int64) Compare {
+	return Compare{key: key, op: op, target: "MOD", field: "mod_revision", value: strconv.FormatInt(revision, 10)}
+}
+
+// Op is a single operation run by a transaction
+type Op struct {
+	request map[string]interface{}
+}
+
+// OpGet reads key
+func OpGet(key string) Op {
+	return Op{request: map[string]interface{}{
+		"request_range": map[string]interface{}{"key": []byte(key)},
+	}}
+}
+
+// OpPut sets key to value
+func OpPut(key, value string) Op {
+	return Op{request: map[string]interface{}{
+		"request_put": map[string]interface{}{"key": []byte(key), "value": []byte(value), "prev_kv": true},
+	}}
+}
+
+// OpDelete deletes key
+func OpDelete(key string) Op {
+	return Op{request: map[string]interface{}{
+		"request_delete_range": map[string]interface{}{"key": []byte(key), "prev_kv": true},
+	}}
+}
+
+// ResponseOp is the result of a single transaction operation, only the field matching the operation is set
+type ResponseOp struct {
+	ResponseRange       *RangeResponse       `json:"response_range"`
+	ResponsePut         *PutResponse         `json:"response_put"`
+	ResponseDeleteRange *DeleteRangeResponse `json:"response_delete_range"`
+}
+
+// TxnResponse is the response from committing a transaction
+type TxnResponse struct {
+	Header    ResponseHeader `json:"header"`
+	Succeeded bool           `json:"succeeded"`
+	Responses []ResponseOp   `json:"responses"`
+}
+
+// Txn builds a v3 transaction: if every compare holds the success operations are run, otherwise
+// the failure operations are. Keys are raw v3 keys.
+type Txn struct {
+	client   *Client
+	compares []Compare
+	success  []Op
+	failure  []Op
+}
+
+// Txn starts a new transaction against the client
+func (client *Client) Txn() *Txn {
+	return &Txn{client: client}
+}
+
+// If adds compares to the transaction
+func (txn *Txn) If(compares ...Compare) *Txn {
+	txn.compares = append(txn.compares, compares...)
+	return txn
+}
+
+// Then adds operations run when all compares hold
+func (txn *Txn) Then(ops ...Op) *Txn {
+	txn.success = append(txn.success, ops...)
+	return txn
+}
+
+// Else adds operations run when any compare fails
+func (txn *Txn) Else(ops ...Op) *Txn {
+	txn.failure = append(txn.failure, ops...)
+	return txn
+}
+
+// Commit runs the transaction atomically
+func (txn *Txn) Commit() (TxnResponse, error) {
+	if !txn.client.v3 {
+		return TxnResponse{}, errV2Unsupported
+	}
+
+	compares := make([]map[string]interface{}, 0, len(txn.compares))
+	for _, compare := range txn.compares {
+		result, ok := compareResults[compare.op]
+		if !ok {
+			return TxnResponse{}, fmt.Errorf("Unknown compare operator %q", compare.op)
+		}
+		compares = append(compares, map[string]interface{}{
+			"key":         txn.client.withNamespace([]byte(compare.key)),
+			"result":      result,
+			"target":      compare.target,
+			compare.field: compare.value,
+		})
+	}
+
+	request := map[string]interface{}{
+		"compare": compares,
+		"success": txn.client.opRequests(txn.success),
+		"failure": txn.client.opRequests(txn.failure),
+	}
+
+	var txnResponse TxnResponse
+	err := txn.client.v3Post("kv/txn", request, &txnResponse)
+	for _, response := range txnResponse.Responses {
+		if response.ResponseRange != nil {
+			txn.client.stripKeyValues(response.ResponseRange.Kvs)
+		}
+		if response.ResponsePut != nil && response.ResponsePut.PrevKv != nil {
+			response.ResponsePut.PrevKv.Key = []byte(txn.client.stripKey(string(response.ResponsePut.PrevKv.Key)))
+		}
+		if response.ResponseDeleteRange != nil {
+			txn.client.stripKeyValues(response.ResponseDeleteRange.PrevKvs)
+		}
+	}
+	if err == nil && !txnResponse.Succeeded && len(txn.compares) > 0 {
+		txn.client.metrics.CASConflict("kv/txn")
+	}
+	return txnResponse, err
+}
+
+func (client *Client) opRequests(ops []Op) []map[string]interface{} {
+	requests := make([]map[string]interface{}, 0, len(ops))
+	for _, op := range ops {
+		request := map[string]interface{}{}
+		for name, fields := range op.request {
+			namespaced := map[string]interface{}{}
+			for field, value := range fields.(map[string]interface{}) {
+				namespaced[field] = value
+			}
+			namespaced["key"] = client.withNamespace(namespaced["key"].([]byte))
+			request[name] = namespaced
+		}
+		requests = append(requests, request)
+	}
+	return requests
+}
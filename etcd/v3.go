@@ -0,0 +1,321 @@
+package etcd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rarmstrong73/go-utils/apierror"
+)
+
+var v3APIVersion = "v3"
+
+var errV3Unsupported = errors.New("Operation is not supported by v3 clients")
+
+// KeyValue is a single key as stored by the v3 API
+type KeyValue struct {
+	Key            []byte `json:"key"`
+	Value          []byte `json:"value"`
+	CreateRevision int64  `json:"create_revision,string"`
+	ModRevision    int64  `json:"mod_revision,string"`
+	Version        int64  `json:"version,string"`
+	Lease          int64  `json:"lease,string"`
+}
+
+// ResponseHeader is the header returned with every v3 response
+type ResponseHeader struct {
+	ClusterID uint64 `json:"cluster_id,string"`
+	MemberID  uint64 `json:"member_id,string"`
+	Revision  int64  `json:"revision,string"`
+	RaftTerm  uint64 `json:"raft_term,string"`
+}
+
+// RangeResponse is the response from a v3 range request
+type RangeResponse struct {
+	Header ResponseHeader `json:"header"`
+	Kvs    []KeyValue     `json:"kvs"`
+	More   bool           `json:"more"`
+	Count  int64          `json:"count,string"`
+}
+
+// PutResponse is the response from a v3 put request
+type PutResponse struct {
+	Header ResponseHeader `json:"header"`
+	PrevKv *KeyValue      `json:"prev_kv"`
+}
+
+// DeleteRangeResponse is the response from a v3 delete range request
+type DeleteRangeResponse struct {
+	Header  ResponseHeader `json:"header"`
+	Deleted int64          `json:"deleted,string"`
+	PrevKvs []KeyValue     `json:"prev_kvs"`
+}
+
+// V3Error is an error returned by the v3 gRPC gateway
+type V3Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *V3Error) Error() string {
+	return fmt.Sprintf("%d: %s", e.Code, e.Message)
+}
+
+// NewV3Client returns a client for the etcd cluster with members on the given hosts that talks
+// to the v3 API through its JSON gateway. The key helpers (GetKey, SetKey, DeleteKey, RecurseKeys and the
+// ones built on them) behave as they do against the v2 store, treating "/" as a directory
+// separator in the flat v3 keyspace.
+func NewV3Client(hosts ...string) *Client {
+	client := NewClient(hosts...)
+	client.v3 = true
+	return client
+}
+
+// Range returns the keys in [key, rangeEnd). An empty rangeEnd returns only key.
+func (client *Client) Range(key, rangeEnd []byte) (RangeResponse, error) {
+	request := map[string]interface{}{"key": client.withNamespace(key)}
+	if len(rangeEnd) > 0 {
+		request["range_end"] = client.withNamespaceEnd(rangeEnd)
+	}
+
+	var rangeResponse RangeResponse
+	err := client.v3Post("kv/range", request, &rangeResponse)
+	client.stripKeyValues(rangeResponse.Kvs)
+	return rangeResponse, err
+}
+
+// Put sets key to value, returning the previous key value if there was one
+func (client *Client) Put(key, value []byte) (PutResponse, error) {
+	request := map[string]interface{}{
+		"key":     client.withNamespace(key),
+		"value":   value,
+		"prev_kv": true,
+	}
+
+	var putResponse PutResponse
+	err := client.v3Post("kv/put", request, &putResponse)
+	if putResponse.PrevKv != nil {
+		putResponse.PrevKv.Key = []byte(client.stripKey(string(putResponse.PrevKv.Key)))
+	}
+	return putResponse, err
+}
+
+// DeleteRange deletes the keys in [key, rangeEnd), returning them. An empty rangeEnd deletes only key.
+func (client *Client) DeleteRange(key, rangeEnd []byte) (DeleteRangeResponse, error) {
+	request := map[string]interface{}{"key": client.withNamespace(key), "prev_kv": true}
+	if len(rangeEnd) > 0 {
+		request["range_end"] = client.withNamespaceEnd(rangeEnd)
+	}
+
+	var deleteResponse DeleteRangeResponse
+	err := client.v3Post("kv/deleterange", request, &deleteResponse)
+	client.stripKeyValues(deleteResponse.PrevKvs)
+	return deleteResponse, err
+}
+
+// PrefixRangeEnd returns the range end that selects every key starting with prefix
+func PrefixRangeEnd(prefix []byte) []byte {
+	end := make([]byte, len(prefix))
+	copy(end, prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	// The prefix is all 0xff bytes, select everything from it onwards
+	return []byte{0}
+}
+
+func (client *Client) v3GetKey(path string, recursive bool) (Node, error) {
+	key := v3Key(path)
+
+	rangeResponse, err := client.Range([]byte(key), nil)
+	if err != nil {
+		return Node{}, err
+	}
+	if len(rangeResponse.Kvs) > 0 {
+		return nodeFromKeyValue(rangeResponse.Kvs[0]), nil
+	}
+
+	dirPrefix := strings.TrimSuffix(key, "/") + "/"
+	rangeResponse, err = client.Range([]byte(dirPrefix), PrefixRangeEnd([]byte(dirPrefix)))
+	if err != nil {
+		return Node{}, err
+	}
+	if len(rangeResponse.Kvs) == 0 && key != "/" {
+		notFound := *ErrKeyNotFound
+		notFound.Cause = key
+		notFound.Index = rangeResponse.Header.Revision
+		return Node{}, &notFound
+	}
+
+	root := buildTree(strings.TrimSuffix(key, "/"), rangeResponse.Kvs)
+	if !recursive {
+		for i := range root.Nodes {
+			root.Nodes[i].Nodes = nil
+		}
+	}
+	return root, nil
+}
+
+func (client *Client) v3Set(path, value string) (SetResponse, error) {
+	key := v3Key(path)
+	putResponse, err := client.Put([]byte(key), []byte(value))
+	if err != nil {
+		return SetResponse{}, err
+	}
+
+	setResponse := SetResponse{
+		Action: ActionSet,
+		Node: Node{
+			Key:           key,
+			Value:         value,
+			ModifiedIndex: putResponse.Header.Revision,
+			CreatedIndex:  putResponse.Header.Revision,
+		},
+	}
+	if putResponse.PrevKv != nil {
+		setResponse.PrevNode = nodeFromKeyValue(*putResponse.PrevKv)
+		setResponse.Node.CreatedIndex = putResponse.PrevKv.CreateRevision
+	}
+	return setResponse, nil
+}
+
+func (client *Client) v3Delete(path string) (SetResponse, error) {
+	key := v3Key(path)
+	deleteResponse, err := client.DeleteRange([]byte(key), nil)
+	if err != nil {
+		return SetResponse{}, err
+	}
+
+	if deleteResponse.Deleted == 0 {
+		notFound := *ErrKeyNotFound
+		notFound.Cause = key
+		notFound.Index = deleteResponse.Header.Revision
+		return SetResponse{}, &notFound
+	}
+
+	setResponse := SetResponse{
+		Action: ActionDelete,
+		Node:   Node{Key: key, ModifiedIndex: deleteResponse.Header.Revision},
+	}
+	if len(deleteResponse.PrevKvs) > 0 {
+		setResponse.PrevNode = nodeFromKeyValue(deleteResponse.PrevKvs[0])
+		setResponse.Node.CreatedIndex = setResponse.PrevNode.CreatedIndex
+	}
+	return setResponse, nil
+}
+
+func (client *Client) v3DeleteKey(path string, recursive bool) error {
+	key := []byte(v3Key(path))
+	deleteResponse, err := client.DeleteRange(key, nil)
+	if err != nil {
+		return err
+	}
+
+	if recursive {
+		dirPrefix := []byte(strings.TrimSuffix(string(key), "/") + "/")
+		prefixResponse, err := client.DeleteRange(dirPrefix, PrefixRangeEnd(dirPrefix))
+		if err != nil {
+			return err
+		}
+		deleteResponse.Deleted += prefixResponse.Deleted
+	}
+
+	if deleteResponse.Deleted == 0 {
+		notFound := *ErrKeyNotFound
+		notFound.Cause = string(key)
+		notFound.Index = deleteResponse.Header.Revision
+		return &notFound
+	}
+	return nil
+}
+
+// v3Key maps a v2 style path onto a v3 key, which always starts with a slash
+func v3Key(path string) string {
+	return "/" + strings.TrimPrefix(path, "/")
+}
+
+func nodeFromKeyValue(kv KeyValue) Node {
+	return Node{
+		Key:           string(kv.Key),
+		Value:         string(kv.Value),
+		ModifiedIndex: kv.ModRevision,
+		CreatedIndex:  kv.CreateRevision,
+	}
+}
+
+// buildTree arranges the flat key values found under root into v2 style directory nodes
+func buildTree(root string, kvs []KeyValue) Node {
+	rootNode := Node{Key: root, Dir: true}
+	for _, kv := range kvs {
+		relative := strings.TrimPrefix(string(kv.Key), root+"/")
+		insertNode(&rootNode, strings.Split(relative, "/"), kv)
+	}
+	sortTree(&rootNode)
+	return rootNode
+}
+
+func insertNode(dir *Node, parts []string, kv KeyValue) {
+	key := dir.Key + "/" + parts[0]
+	if len(parts) == 1 {
+		dir.Nodes = append(dir.Nodes, nodeFromKeyValue(kv))
+		return
+	}
+
+	for i := range dir.Nodes {
+		if dir.Nodes[i].Dir && dir.Nodes[i].Key == key {
+			insertNode(&dir.Nodes[i], parts[1:], kv)
+			return
+		}
+	}
+	dir.Nodes = append(dir.Nodes, Node{Key: key, Dir: true})
+	insertNode(&dir.Nodes[len(dir.Nodes)-1], parts[1:], kv)
+}
+
+func sortTree(node *Node) {
+	sort.Slice(node.Nodes, func(i, j int) bool { return node.Nodes[i].Key < node.Nodes[j].Key })
+	for i := range node.Nodes {
+		sortTree(&node.Nodes[i])
+	}
+}
+
+func (client *Client) v3Post(endpoint string, request, response interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	path := fmt.Sprintf("/%s/%s", v3APIVersion, endpoint)
+	httpResponse, err := client.doHTTPResponse(context.Background(), http.MethodPost, path, body, "application/json")
+	if err != nil {
+		return err
+	}
+	defer httpResponse.Body.Close()
+
+	responseBytes, err := ioutil.ReadAll(httpResponse.Body)
+	if err != nil {
+		return err
+	}
+
+	if httpResponse.StatusCode != 200 {
+		var v3Error V3Error
+		if err = json.Unmarshal(responseBytes, &v3Error); err != nil || v3Error.Message == "" {
+			return apierror.FromResponse(apierror.Etcd, httpResponse, "", string(bytes.TrimSpace(responseBytes)), nil)
+		}
+		return apierror.FromResponse(apierror.Etcd, httpResponse, strconv.Itoa(v3Error.Code), v3Error.Message, &v3Error)
+	}
+
+	if response == nil {
+		return nil
+	}
+	return json.Unmarshal(responseBytes, response)
+}
@@ -0,0 +1,74 @@
+package etcd
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// WaitForKey blocks until the key at the given path exists and satisfies predicate, returning
+// its node. A nil predicate waits for the key to exist. An error is returned if the timeout
+// passes first.
+func WaitForKey(host, path string, predicate func(Node) bool, timeout time.Duration) (Node, error) {
+	return NewClient(host).WaitForKey(path, predicate, timeout)
+}
+
+// WaitForKey blocks until the key at the given path exists and satisfies predicate, returning
+// its node. A nil predicate waits for the key to exist. An error is returned if the timeout
+// passes first. Only v2 clients support waiting for keys.
+func (client *Client) WaitForKey(path string, predicate func(Node) bool, timeout time.Duration) (Node, error) {
+	if predicate == nil {
+		predicate = func(Node) bool { return true }
+	}
+
+	stop := make(chan struct{})
+	timer := time.AfterFunc(timeout, func() { close(stop) })
+	defer timer.Stop()
+
+	for {
+		node, err := client.GetKey(path)
+		var waitIndex int64
+		var etcdError *Error
+		if err == nil {
+			if predicate(node) {
+				return node, nil
+			}
+			waitIndex = node.ModifiedIndex + 1
+		} else if errors.As(err, &etcdError) && etcdError.ErrorCode == EcodeKeyNotFound {
+			waitIndex = etcdError.Index + 1
+		} else {
+			return Node{}, err
+		}
+
+		node, err = client.waitForChange(path, predicate, waitIndex, stop)
+		if errors.Is(err, ErrEventIndexCleared) {
+			client.metrics.WatchReconnect(path)
+			client.logger.Debug("Watch index cleared, re-reading", "path", path)
+			continue
+		}
+		select {
+		case <-stop:
+			return Node{}, fmt.Errorf("Timed out after %s waiting for %s", timeout, path)
+		default:
+			return node, err
+		}
+	}
+}
+
+// waitForChange watches the key from waitIndex until it is set to a value satisfying predicate
+func (client *Client) waitForChange(path string, predicate func(Node) bool, waitIndex int64, stop <-chan struct{}) (Node, error) {
+	for {
+		event, err := client.WatchKey(path, false, waitIndex, stop)
+		if err != nil {
+			return Node{}, err
+		}
+		waitIndex = event.Node.ModifiedIndex + 1
+
+		switch event.Action {
+		case ActionSet, ActionCreate, ActionUpdate, ActionCompareAndSwap:
+			if predicate(event.Node) {
+				return event.Node, nil
+			}
+		}
+	}
+}
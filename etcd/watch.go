@@ -0,0 +1,121 @@
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/rarmstrong73/go-utils/internal/httpclient"
+)
+
+// Watch actions
+const (
+	ActionGet              = "get"
+	ActionSet              = "set"
+	ActionCreate           = "create"
+	ActionUpdate           = "update"
+	ActionDelete           = "delete"
+	ActionExpire           = "expire"
+	ActionCompareAndSwap   = "compareAndSwap"
+	ActionCompareAndDelete = "compareAndDelete"
+)
+
+// WatchResponse is a single change event returned by WatchKey
+type WatchResponse struct {
+	Action   string `json:"action"`
+	Node     Node   `json:"node"`
+	PrevNode Node   `json:"prevNode"`
+}
+
+// WatchKey blocks until the key at the given path (or anything below it when recursive is set)
+// changes at or after waitIndex. A waitIndex of 0 waits for the next change. Closing stop
+// abandons the watch. Watches are only supported by v2 clients.
+func (client *Client) WatchKey(path string, recursive bool, waitIndex int64, stop <-chan struct{}) (WatchResponse, error) {
+	if client.v3 {
+		return WatchResponse{}, errV3Unsupported
+	}
+
+	query := fmt.Sprintf("?wait=true&recursive=%t", recursive)
+	if waitIndex > 0 {
+		query += fmt.Sprintf("&waitIndex=%d", waitIndex)
+	}
+
+	ctx, cancel := context.WithCancel(httpclient.Streaming(context.Background()))
+	defer cancel()
+	if stop != nil {
+		go func() {
+			select {
+			case <-stop:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+
+	response, err := client.doHTTPResponse(ctx, http.MethodGet, client.keyURL(path+query), nil, "")
+	if err != nil {
+		return WatchResponse{}, err
+	}
+	defer response.Body.Close()
+
+	responseBytes, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return WatchResponse{}, err
+	}
+
+	if response.StatusCode != 200 {
+		return WatchResponse{}, responseError(response, responseBytes)
+	}
+
+	var watchResponse WatchResponse
+	err = json.Unmarshal(responseBytes, &watchResponse)
+	if err != nil {
+		return WatchResponse{}, err
+	}
+
+	watchResponse.Node = client.stripNode(watchResponse.Node)
+	watchResponse.PrevNode = client.stripNode(watchResponse.PrevNode)
+	return watchResponse, nil
+}
+
+// getTree returns the recursive listing at path along with the cluster's etcd index at the
+// time of the read. A missing key is returned as an empty directory.
+func (client *Client) getTree(path string) (node Node, etcdIndex int64, err error) {
+	if client.v3 {
+		return Node{}, 0, errV3Unsupported
+	}
+
+	response, err := client.httpGetResponse(client.keyURL(path + "?recursive=true"))
+	if err != nil {
+		return Node{}, 0, err
+	}
+	defer response.Body.Close()
+
+	etcdIndex, err = strconv.ParseInt(response.Header.Get("X-Etcd-Index"), 10, 64)
+	if err != nil {
+		return Node{}, 0, fmt.Errorf("Invalid X-Etcd-Index header: %v", err)
+	}
+
+	if response.StatusCode == 404 {
+		return Node{Dir: true, Key: path}, etcdIndex, nil
+	}
+	if response.StatusCode != 200 {
+		return Node{}, 0, handleError(response)
+	}
+
+	responseBytes, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return Node{}, 0, err
+	}
+
+	var nodeResponse Response
+	err = json.Unmarshal(responseBytes, &nodeResponse)
+	if err != nil {
+		return Node{}, 0, err
+	}
+
+	return client.stripNode(nodeResponse.Node), etcdIndex, nil
+}
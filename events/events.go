@@ -0,0 +1,225 @@
+// Package events turns docker daemon events, fleet unit state changes and consul service and KV
+// changes into one stream of events, which subscribers narrow down by type, host and service:
+//
+//	bus := events.NewBus()
+//	subscription := bus.Subscribe(events.Filter{Types: []string{events.DockerContainer, events.FleetUnit}})
+//	go bus.Run(ctx,
+//		events.Docker(machine.PrimaryIP),
+//		events.Fleet(fleetClient, 10*time.Second),
+//		events.ConsulInstances(consulClient, "web"),
+//	)
+//	for event := range subscription.Events() {
+//		fmt.Println(event.Time, event.Type, event.Action, event.Host, event.ID)
+//	}
+package events
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rarmstrong73/go-utils/apierror"
+	"github.com/rarmstrong73/go-utils/logging"
+	"github.com/rarmstrong73/go-utils/retry"
+)
+
+// Event types. Docker events other than container and image ones have the type docker. followed
+// by docker's type, such as docker.network.
+const (
+	DockerContainer = "docker.container"
+	DockerImage     = "docker.image"
+	FleetUnit       = "fleet.unit"
+	ConsulService   = "consul.service"
+	ConsulKV        = "consul.kv"
+)
+
+// Actions of the events the package makes from changes, docker events keep docker's actions such
+// as start and die
+const (
+	Added        = "added"
+	Changed      = "changed"
+	Removed      = "removed"
+	Registered   = "registered"
+	Deregistered = "deregistered"
+	Health       = "health"
+	Set          = "set"
+	Deleted      = "deleted"
+)
+
+// Event is something that happened in the cluster
+type Event struct {
+	// Type is what the event is about, one of the types above
+	Type string `json:"type"`
+	// Action is what happened
+	Action string `json:"action"`
+	// Host is the host it happened on: the docker host, the fleet machine's IP or the consul
+	// node's address. It is empty for consul KV events.
+	Host string `json:"host,omitempty"`
+	// Service is the service it happened to: the container's name, the unit's name without its
+	// instance and suffix or the consul service's name
+	Service string `json:"service,omitempty"`
+	// ID is what changed: the container or image ID, unit name, consul service ID or key
+	ID   string    `json:"id"`
+	Time time.Time `json:"time"`
+	// Attributes describe the change, such as a unit's previous and new active states
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// Filter selects events, an empty list selects everything
+type Filter struct {
+	// Types are the types selected, docker selecting every docker type
+	Types    []string
+	Hosts    []string
+	Services []string
+}
+
+// Match reports whether the filter selects event
+func (filter Filter) Match(event Event) bool {
+	return matches(filter.Types, event.Type, true) && matches(filter.Hosts, event.Host, false) && matches(filter.Services, event.Service, false)
+}
+
+func matches(values []string, value string, prefix bool) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, allowed := range values {
+		if allowed == value || (prefix && strings.HasPrefix(value, allowed+".")) {
+			return true
+		}
+	}
+	return false
+}
+
+// subscriptionBuffer is how many events a subscription holds before it drops them
+const subscriptionBuffer = 256
+
+// sourceRetry paces the restarts of sources that fail
+var sourceRetry = retry.Policy{InitialBackoff: time.Second, MaxBackoff: time.Minute, Jitter: 0.2}
+
+// Bus delivers the events published on it to its subscriptions
+type Bus struct {
+	mutex         sync.Mutex
+	subscriptions map[*Subscription]bool
+	logger        logging.Logger
+}
+
+// NewBus returns a bus without subscriptions
+func NewBus() *Bus {
+	return &Bus{subscriptions: map[*Subscription]bool{}, logger: logging.Nop}
+}
+
+// SetLogger sets where the bus and its sources log, nil turns logging off
+func (bus *Bus) SetLogger(logger logging.Logger) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	bus.logger = logging.OrNop(logger)
+}
+
+// Subscribe returns a subscription to the events filter selects. Subscribers that fall behind
+// miss events rather than hold the bus up, see Subscription.Dropped.
+func (bus *Bus) Subscribe(filter Filter) *Subscription {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	subscription := &Subscription{bus: bus, filter: filter, events: make(chan Event, subscriptionBuffer)}
+	bus.subscriptions[subscription] = true
+	return subscription
+}
+
+// Publish delivers event to the subscriptions selecting it
+func (bus *Bus) Publish(event Event) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	for subscription := range bus.subscriptions {
+		if !subscription.filter.Match(event) {
+			continue
+		}
+		select {
+		case subscription.events <- event:
+		default:
+			atomic.AddInt64(&subscription.dropped, 1)
+			bus.logger.Debug("Subscription is behind, dropped event", "type", event.Type, "action", event.Action, "id", event.ID)
+		}
+	}
+}
+
+// Run runs sources, publishing their events, until ctx is done. Sources that fail are restarted
+// with a backoff, unless their client was closed. It returns once every source stopped, with
+// ctx's error.
+func (bus *Bus) Run(ctx context.Context, sources ...Source) error {
+	var wg sync.WaitGroup
+	for _, source := range sources {
+		wg.Add(1)
+		go func(source Source) {
+			defer wg.Done()
+			bus.runSource(ctx, source)
+		}(source)
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+// runSource runs source until ctx is done or its client is closed, restarting it when it fails.
+// The backoff grows with every failure in a row and starts over once the source got going again,
+// publishing events or running for longer than the longest backoff before it failed.
+func (bus *Bus) runSource(ctx context.Context, source Source) {
+	failures := 0
+	for {
+		started := time.Now()
+		var published int32
+		err := source.Run(ctx, func(event Event) {
+			atomic.StoreInt32(&published, 1)
+			bus.Publish(event)
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		bus.mutex.Lock()
+		logger := bus.logger
+		bus.mutex.Unlock()
+		if errors.Is(err, apierror.ErrClosed) {
+			logger.Info("Event source's client was closed, stopping it", "source", source.Name)
+			return
+		}
+		if atomic.LoadInt32(&published) != 0 || time.Since(started) > sourceRetry.MaxBackoff {
+			failures = 0
+		}
+		backoff := sourceRetry.Backoff(failures)
+		failures++
+		logger.Error("Event source failed, restarting", "source", source.Name, "error", err, "backoff", backoff)
+		if retry.Sleep(ctx, backoff) != nil {
+			return
+		}
+	}
+}
+
+// Subscription is a stream of the events selected by its filter
+type Subscription struct {
+	bus     *Bus
+	filter  Filter
+	events  chan Event
+	dropped int64
+}
+
+// Events returns the channel events are delivered on, it is closed by Close
+func (subscription *Subscription) Events() <-chan Event {
+	return subscription.events
+}
+
+// Dropped returns how many events the subscription missed because it was behind
+func (subscription *Subscription) Dropped() int64 {
+	return atomic.LoadInt64(&subscription.dropped)
+}
+
+// Close stops delivering events to the subscription and closes its channel
+func (subscription *Subscription) Close() {
+	bus := subscription.bus
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	if bus.subscriptions[subscription] {
+		delete(bus.subscriptions, subscription)
+		close(subscription.events)
+	}
+}
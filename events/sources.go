@@ -0,0 +1,292 @@
+package events
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	consul "github.com/rarmstrong73/go-utils/consul/health"
+	"github.com/rarmstrong73/go-utils/docker"
+	"github.com/rarmstrong73/go-utils/fleet"
+)
+
+// Source publishes events until ctx is done or it fails. Sources remember what they have seen,
+// so a source restarted by Bus.Run carries on from where it stopped.
+type Source struct {
+	// Name identifies the source in logs
+	Name string
+	Run  func(ctx context.Context, publish func(Event)) error
+}
+
+// Docker streams the events of the docker daemon on host, starting with those that happen after
+// it first runs
+func Docker(host string) Source {
+	var since time.Time
+	var last int64
+	return Source{Name: "docker " + host, Run: func(ctx context.Context, publish func(Event)) error {
+		return docker.StreamEvents(ctx, host, since, nil, func(event docker.Event) {
+			// Restarting from the second of the last event delivers that second's events again
+			if event.TimeNano != 0 && event.TimeNano <= last {
+				return
+			}
+			last = event.TimeNano
+			since = event.When()
+			publish(dockerEvent(host, event))
+		})
+	}}
+}
+
+func dockerEvent(host string, event docker.Event) Event {
+	eventType := "docker." + event.Type
+	attributes := map[string]string{}
+	for key, value := range event.Actor.Attributes {
+		attributes[key] = value
+	}
+	normalized := Event{Type: eventType, Action: event.Action, Host: host, ID: event.Actor.ID, Time: event.When(), Attributes: attributes}
+	if eventType == DockerContainer {
+		normalized.Service = attributes["name"]
+	}
+	return normalized
+}
+
+// Fleet polls the unit states of the cluster of client every interval, publishing the units added
+// to machines, removed from them and changing systemd state. The states found by the first poll
+// are taken as they are.
+func Fleet(client *fleet.Client, interval time.Duration) Source {
+	var mutex sync.Mutex
+	var known map[string]fleet.UnitState
+	return Source{Name: "fleet " + client.Host(), Run: func(ctx context.Context, publish func(Event)) error {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			states, err := client.ListUnitStates()
+			if err != nil {
+				return err
+			}
+			machines, err := client.ListMachines()
+			if err != nil {
+				return err
+			}
+			ips := map[string]string{}
+			for _, machine := range machines {
+				ips[machine.ID] = machine.PrimaryIP
+			}
+
+			current := map[string]fleet.UnitState{}
+			for _, state := range states {
+				current[state.Name+"/"+state.MachineID] = state
+			}
+			// Guarded as the same source can be run by more than one bus
+			mutex.Lock()
+			events := []Event{}
+			if known != nil {
+				events = diffUnitStates(known, current, ips)
+			}
+			known = current
+			mutex.Unlock()
+			for _, event := range events {
+				publish(event)
+			}
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-ticker.C:
+			}
+		}
+	}}
+}
+
+// diffUnitStates returns the events turning the previous unit states into the current ones,
+// sorted by unit
+func diffUnitStates(previous, current map[string]fleet.UnitState, ips map[string]string) []Event {
+	now := time.Now()
+	unitEvent := func(action string, state fleet.UnitState) Event {
+		host := ips[state.MachineID]
+		if host == "" {
+			host = state.MachineID
+		}
+		return Event{
+			Type:    FleetUnit,
+			Action:  action,
+			Host:    host,
+			Service: unitService(state.Name),
+			ID:      state.Name,
+			Time:    now,
+			Attributes: map[string]string{
+				"machine":     state.MachineID,
+				"loadState":   state.SystemdLoadState,
+				"activeState": state.SystemdActiveState,
+				"subState":    state.SystemdSubState,
+			},
+		}
+	}
+
+	events := []Event{}
+	for _, key := range sortedKeys(current, previous) {
+		state, exists := current[key]
+		old, existed := previous[key]
+		switch {
+		case !existed:
+			events = append(events, unitEvent(Added, state))
+		case !exists:
+			events = append(events, unitEvent(Removed, old))
+		case state.SystemdLoadState != old.SystemdLoadState || state.SystemdActiveState != old.SystemdActiveState || state.SystemdSubState != old.SystemdSubState:
+			event := unitEvent(Changed, state)
+			event.Attributes["previousLoadState"] = old.SystemdLoadState
+			event.Attributes["previousActiveState"] = old.SystemdActiveState
+			event.Attributes["previousSubState"] = old.SystemdSubState
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// unitService returns the app a unit runs, web for web@1.service
+func unitService(name string) string {
+	if i := strings.LastIndex(name, "."); i > 0 {
+		name = name[:i]
+	}
+	if i := strings.Index(name, "@"); i >= 0 {
+		name = name[:i]
+	}
+	return name
+}
+
+// ConsulInstances watches the instances of the named service, publishing instances registering,
+// deregistering and changing health. The instances found when it first runs are taken as they
+// are.
+func ConsulInstances(client *consul.Client, name string) Source {
+	var known map[string]consul.ServiceEntry
+	return Source{Name: "consul service " + name, Run: func(ctx context.Context, publish func(Event)) error {
+		watch := client.WatchServiceContext(ctx, name, "", false)
+		defer watch.Stop()
+		for result := range watch.Events() {
+			entries, _ := result.Value.([]consul.ServiceEntry)
+			current := map[string]consul.ServiceEntry{}
+			for _, entry := range entries {
+				current[entry.Node.Node+"/"+entry.Service.ID] = entry
+			}
+			if known != nil {
+				for _, event := range diffServiceEntries(known, current) {
+					publish(event)
+				}
+			}
+			known = current
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return watch.Err()
+	}}
+}
+
+// diffServiceEntries returns the events turning the previous instances of a service into the
+// current ones, sorted by node and service ID
+func diffServiceEntries(previous, current map[string]consul.ServiceEntry) []Event {
+	now := time.Now()
+	serviceEvent := func(action string, entry consul.ServiceEntry) Event {
+		return Event{
+			Type:    ConsulService,
+			Action:  action,
+			Host:    entry.Node.Address,
+			Service: entry.Service.Service,
+			ID:      entry.Service.ID,
+			Time:    now,
+			Attributes: map[string]string{
+				"node":   entry.Node.Node,
+				"status": serviceStatus(entry),
+			},
+		}
+	}
+
+	events := []Event{}
+	for _, key := range sortedKeys(current, previous) {
+		entry, exists := current[key]
+		old, existed := previous[key]
+		switch {
+		case !existed:
+			events = append(events, serviceEvent(Registered, entry))
+		case !exists:
+			events = append(events, serviceEvent(Deregistered, old))
+		case serviceStatus(entry) != serviceStatus(old):
+			event := serviceEvent(Health, entry)
+			event.Attributes["previousStatus"] = serviceStatus(old)
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// serviceStatus returns the worst status of an instance's checks, passing when it has none
+func serviceStatus(entry consul.ServiceEntry) string {
+	rank := map[string]int{consul.HealthPassing: 0, consul.HealthWarning: 1, consul.HealthCritical: 2}
+	status := consul.HealthPassing
+	for _, check := range entry.Checks {
+		checkRank, known := rank[check.Status]
+		if !known {
+			checkRank = rank[consul.HealthCritical]
+		}
+		if checkRank > rank[status] {
+			status = check.Status
+		}
+	}
+	return status
+}
+
+// ConsulKeys watches the keys starting with prefix, publishing keys being set and deleted. Values
+// aren't published, only the index they were set at. The keys found when it first runs are taken
+// as they are.
+func ConsulKeys(client *consul.Client, prefix string) Source {
+	var known map[string]consul.KVPair
+	return Source{Name: "consul kv " + prefix, Run: func(ctx context.Context, publish func(Event)) error {
+		watch := client.WatchPrefixContext(ctx, prefix)
+		defer watch.Stop()
+		for result := range watch.Events() {
+			pairs, _ := result.Value.([]consul.KVPair)
+			current := map[string]consul.KVPair{}
+			for _, pair := range pairs {
+				current[pair.Key] = pair
+			}
+			if known != nil {
+				now := time.Now()
+				for _, key := range sortedKeys(current, known) {
+					pair, exists := current[key]
+					old, existed := known[key]
+					switch {
+					case !exists:
+						publish(Event{Type: ConsulKV, Action: Deleted, ID: key, Time: now})
+					case !existed || pair.ModifyIndex != old.ModifyIndex:
+						publish(Event{Type: ConsulKV, Action: Set, ID: key, Time: now, Attributes: map[string]string{
+							"modifyIndex": strconv.FormatUint(pair.ModifyIndex, 10),
+						}})
+					}
+				}
+			}
+			known = current
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return watch.Err()
+	}}
+}
+
+// sortedKeys returns the keys of maps, sorted
+func sortedKeys[V any](maps ...map[string]V) []string {
+	keys := map[string]bool{}
+	for _, m := range maps {
+		for key := range m {
+			keys[key] = true
+		}
+	}
+	sorted := make([]string, 0, len(keys))
+	for key := range keys {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
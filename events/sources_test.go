@@ -0,0 +1,73 @@
+package events_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rarmstrong73/go-utils/events"
+	"github.com/rarmstrong73/go-utils/fleet"
+	"github.com/rarmstrong73/go-utils/fleet/fleettest"
+)
+
+func TestFleetPublishesUnitChanges(t *testing.T) {
+	fleetServer := fleettest.NewServer()
+	defer fleetServer.Close()
+	fleetServer.AddMachine(fleet.Machine{ID: "m1", PrimaryIP: "10.0.0.1"})
+	options := []fleet.Option{{Section: "Service", Name: "ExecStart", Value: "/bin/true"}}
+	fleetClient := fleet.NewClient(fleet.Config{Host: fleetServer.Host()})
+	if err := fleetClient.CreateUnit("web@1.service", fleet.Launched, options); err != nil {
+		t.Fatalf("CreateUnit: %v", err)
+	}
+
+	// Count the polls, the first of which is over once the second starts
+	var polls int32
+	target, _ := url.Parse(fleetServer.URL)
+	forward := httputil.NewSingleHostReverseProxy(target)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/fleet/v1/state") && r.URL.Query().Get("nextPageToken") == "" {
+			atomic.AddInt32(&polls, 1)
+		}
+		forward.ServeHTTP(w, r)
+	}))
+	defer proxy.Close()
+
+	bus := events.NewBus()
+	subscription := bus.Subscribe(events.Filter{Types: []string{events.FleetUnit}})
+	defer subscription.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	source := events.Fleet(fleet.NewClient(fleet.Config{Host: strings.TrimPrefix(proxy.URL, "http://")}), 10*time.Millisecond)
+	go bus.Run(ctx, source)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&polls) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("the source never polled fleet twice")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if err := fleetClient.CreateUnit("web@2.service", fleet.Launched, options); err != nil {
+		t.Fatalf("CreateUnit: %v", err)
+	}
+	fleetServer.SetSystemdState("web@1.service", "failed", "failed")
+
+	want := map[string]string{"web@1.service": events.Changed, "web@2.service": events.Added}
+	for len(want) > 0 {
+		select {
+		case event := <-subscription.Events():
+			if event.Action != want[event.ID] || event.Service != "web" || event.Host != "10.0.0.1" {
+				t.Errorf("event = %s %s on %s for %s, want %s", event.Action, event.ID, event.Host, event.Service, want[event.ID])
+			}
+			delete(want, event.ID)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no events for %v", want)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+// Package fanout runs a function against many hosts at once, such as removing stopped
+// containers from every docker daemon in a cluster, and collects the results and errors:
+//
+//	results, err := fanout.Run(ctx, hosts, fanout.Options{Concurrency: 10, Timeout: time.Minute},
+//		func(ctx context.Context, host string) (interface{}, error) {
+//			return nil, docker.CreateImage(host, "web", "", "", "1.0")
+//		})
+//
+// Run returns a *Error listing the hosts that failed when any did.
+package fanout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Options configure Run
+type Options struct {
+	// Concurrency is how many hosts are worked on at once, all of them when 0
+	Concurrency int
+	// Timeout limits the work on each host, none when 0. A function that doesn't watch its
+	// context is left running and its host fails with context.DeadlineExceeded.
+	Timeout time.Duration
+	// StopOnError stops starting work on more hosts once one has failed, the hosts not started
+	// fail with ErrNotRun
+	StopOnError bool
+}
+
+// ErrNotRun is the error of hosts that weren't worked on because Run was stopped
+var ErrNotRun = errors.New("Not run")
+
+// Result is the outcome of the work on a host
+type Result struct {
+	Host     string
+	Value    interface{}
+	Err      error
+	Duration time.Duration
+}
+
+// Error is returned by Run when the work on some hosts failed
+type Error struct {
+	// Failed are the results of the hosts that failed, in the order the hosts were given
+	Failed []Result
+	// Hosts is how many hosts there were
+	Hosts int
+}
+
+func (e *Error) Error() string {
+	messages := []string{}
+	for _, result := range e.Failed {
+		messages = append(messages, fmt.Sprintf("%s: %v", result.Host, result.Err))
+	}
+	return fmt.Sprintf("%d of %d hosts failed: %s", len(e.Failed), e.Hosts, strings.Join(messages, "; "))
+}
+
+// Unwrap returns the errors of the hosts, so errors.Is and errors.As look through them
+func (e *Error) Unwrap() []error {
+	errs := []error{}
+	for _, result := range e.Failed {
+		errs = append(errs, result.Err)
+	}
+	return errs
+}
+
+// FailedHosts returns the failed hosts, sorted
+func (e *Error) FailedHosts() []string {
+	hosts := []string{}
+	for _, result := range e.Failed {
+		hosts = append(hosts, result.Host)
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// Run calls work for every host, returning a result for each host in the order given and a
+// *Error if any failed. Hosts not yet started when ctx is done fail with ctx's error.
+func Run(ctx context.Context, hosts []string, options Options, work func(ctx context.Context, host string) (interface{}, error)) ([]Result, error) {
+	concurrency := options.Concurrency
+	if concurrency <= 0 || concurrency > len(hosts) {
+		concurrency = len(hosts)
+	}
+
+	results := make([]Result, len(hosts))
+	var failed sync.Once
+	stop := make(chan struct{})
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				results[index] = runOne(ctx, hosts[index], options.Timeout, work)
+				if results[index].Err != nil && options.StopOnError {
+					failed.Do(func() { close(stop) })
+				}
+			}
+		}()
+	}
+
+	for index, host := range hosts {
+		select {
+		case <-stop:
+			results[index] = Result{Host: host, Err: ErrNotRun}
+			continue
+		default:
+		}
+		select {
+		case indexes <- index:
+			continue
+		case <-stop:
+			results[index] = Result{Host: host, Err: ErrNotRun}
+		case <-ctx.Done():
+			results[index] = Result{Host: host, Err: ctx.Err()}
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	failures := &Error{Hosts: len(hosts)}
+	for _, result := range results {
+		if result.Err != nil {
+			failures.Failed = append(failures.Failed, result)
+		}
+	}
+	if len(failures.Failed) > 0 {
+		return results, failures
+	}
+	return results, nil
+}
+
+// runOne works on a host, giving up when its timeout passes or ctx is done
+func runOne(ctx context.Context, host string, timeout time.Duration, work func(ctx context.Context, host string) (interface{}, error)) Result {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	started := time.Now()
+	done := make(chan Result, 1)
+	go func() {
+		value, err := work(ctx, host)
+		done <- Result{Host: host, Value: value, Err: err}
+	}()
+
+	var result Result
+	select {
+	case result = <-done:
+	case <-ctx.Done():
+		result = Result{Host: host, Err: ctx.Err()}
+	}
+	result.Duration = time.Since(started)
+	return result
+}
@@ -0,0 +1,108 @@
+package fleet
+
+import (
+	"context"
+	"time"
+
+	"github.com/rarmstrong73/go-utils/apierror"
+	"github.com/rarmstrong73/go-utils/breaker"
+	"github.com/rarmstrong73/go-utils/cache"
+	"github.com/rarmstrong73/go-utils/internal/httpclient"
+	"github.com/rarmstrong73/go-utils/logging"
+	"github.com/rarmstrong73/go-utils/proxy"
+	"github.com/rarmstrong73/go-utils/ratelimit"
+	"github.com/rarmstrong73/go-utils/retry"
+	"github.com/rarmstrong73/go-utils/timeouts"
+)
+
+// Config configures a Client, only Host is required. A client keeps the configuration it was
+// made with, so it can be shared by goroutines.
+type Config struct {
+	// Host is the host of the fleet API, with Port added if it has none
+	Host string
+	// Port is the port the fleet API listens on, defaulting to 49153
+	Port int
+	// Scheme is http or https, defaulting to http
+	Scheme string
+	// Timeout limits each request, including reading the response, 0 means no limit beyond
+	// Timeouts
+	Timeout time.Duration
+	// Timeouts are the connect, read and operation timeouts, the defaults of the timeouts package
+	// when nil
+	Timeouts *timeouts.Timeouts
+	// Proxy is the proxy requests go through, the default of the proxy package when nil
+	Proxy proxy.Func
+	// Retry is how failed requests are retried, retry.Never by default
+	Retry retry.Policy
+	// Limiter limits the rate requests are sent at, no limit when nil
+	Limiter ratelimit.Limiter
+	// Breaker stops requests being sent while its circuit is open, none when nil
+	Breaker breaker.Breaker
+	// Cache serves reads from responses when it has them, no caching when nil
+	Cache cache.Cache
+	// Logger is where the client logs, nothing is logged when nil
+	Logger logging.Logger
+}
+
+// Client is a connection to the fleet API of a cluster, for APIs on other ports or schemes than
+// the package functions use. Its methods are the package functions without their host.
+type Client struct {
+	host       string
+	baseURL    string
+	httpClient *httpclient.Client
+	logger     logging.Logger
+}
+
+// NewClient returns a client for the fleet API described by config
+func NewClient(config Config) *Client {
+	if config.Port == 0 {
+		config.Port = port
+	}
+	if config.Scheme == "" {
+		config.Scheme = "http"
+	}
+	return &Client{
+		host:    config.Host,
+		baseURL: baseURL(config.Scheme, config.Host, config.Port),
+		httpClient: httpclient.New(httpclient.Options{
+			Service:  apierror.Fleet,
+			Endpoint: endpointName,
+			Timeout:  config.Timeout,
+			Timeouts: config.Timeouts,
+			Proxy:    config.Proxy,
+			Retry:    config.Retry,
+			Limiter:  config.Limiter,
+			Breaker:  config.Breaker,
+			Cache:    config.Cache,
+		}),
+		logger: logging.OrNop(config.Logger),
+	}
+}
+
+// packageClient returns the client the package functions use for host, which shares the
+// package's HTTP client and logger
+func packageClient(host string) *Client {
+	return &Client{
+		host:       host,
+		baseURL:    baseURL("http", host, port),
+		httpClient: httpClient,
+		logger:     logger,
+	}
+}
+
+// Host returns the host of the fleet API the client talks to
+func (client *Client) Host() string {
+	return client.host
+}
+
+// Shutdown stops the client: new requests fail with apierror.ErrClosed and requests in flight
+// are given until ctx is done to finish before they are cancelled. It returns ctx's error if
+// requests had to be cancelled.
+func (client *Client) Shutdown(ctx context.Context) error {
+	return client.httpClient.Shutdown(ctx)
+}
+
+// Close stops the client like Shutdown, cancelling requests in flight straight away
+func (client *Client) Close() error {
+	return client.httpClient.Close()
+}
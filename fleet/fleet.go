@@ -1,19 +1,98 @@
 package fleet
 
 import (
-	"bytes"
+	"context"
+	"crypto/sha1"
 	"encoding/json"
 	"fmt"
-	"io"
 	"io/ioutil"
-	"log"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
+
+	"github.com/rarmstrong73/go-utils/apierror"
+	"github.com/rarmstrong73/go-utils/breaker"
+	"github.com/rarmstrong73/go-utils/cache"
+	"github.com/rarmstrong73/go-utils/internal/httpclient"
+	"github.com/rarmstrong73/go-utils/logging"
+	"github.com/rarmstrong73/go-utils/proxy"
+	"github.com/rarmstrong73/go-utils/ratelimit"
+	"github.com/rarmstrong73/go-utils/retry"
+	"github.com/rarmstrong73/go-utils/timeouts"
 )
 
 var port = 49153
 var apiVersion = "v1"
 
+var httpClient = httpclient.New(httpclient.Options{Service: apierror.Fleet, Endpoint: endpointName})
+
+// logger is where the package logs, set with SetLogger
+var logger = &logging.Swappable{}
+
+// SetLogger sets where the package logs, nil turns logging off
+func SetLogger(l logging.Logger) {
+	logger.Set(l)
+}
+
+// SetRateLimiter limits the rate at which the package sends requests to every host, nil removes
+// the limit
+func SetRateLimiter(limiter ratelimit.Limiter) {
+	httpClient.Configure(func(options *httpclient.Options) {
+		options.Limiter = limiter
+	})
+}
+
+// SetCircuitBreaker stops the package sending requests to hosts whose circuit is open, nil
+// removes the breaker
+func SetCircuitBreaker(b breaker.Breaker) {
+	httpClient.Configure(func(options *httpclient.Options) {
+		options.Breaker = b
+	})
+}
+
+// SetCache serves the package's reads from responses when it has them, nil turns caching off
+func SetCache(responses cache.Cache) {
+	httpClient.Configure(func(options *httpclient.Options) {
+		options.Cache = responses
+	})
+}
+
+// SetTimeouts sets the package's connect, read and operation timeouts, nil goes back to the
+// defaults of the timeouts package
+func SetTimeouts(t *timeouts.Timeouts) {
+	httpClient.Configure(func(options *httpclient.Options) {
+		options.Timeouts = t
+	})
+}
+
+// SetProxy sets the proxy the package's requests go through, nil goes back to the default of the
+// proxy package
+func SetProxy(p proxy.Func) {
+	httpClient.Configure(func(options *httpclient.Options) {
+		options.Proxy = p
+	})
+}
+
+// SetRetryPolicy sets how the package retries failed requests, retry.Never by default
+func SetRetryPolicy(policy retry.Policy) {
+	httpClient.Configure(func(options *httpclient.Options) {
+		options.Retry = policy
+	})
+}
+
+// Shutdown stops the package: new requests fail with apierror.ErrClosed and requests in flight
+// are given until ctx is done to finish before they are cancelled. It returns ctx's error if
+// requests had to be cancelled.
+func Shutdown(ctx context.Context) error {
+	return httpClient.Shutdown(ctx)
+}
+
+// Close stops the package like Shutdown, cancelling requests in flight straight away
+func Close() error {
+	return httpClient.Close()
+}
+
 // Acceptable fleet states
 const (
 	Launched = "launched"
@@ -36,6 +115,30 @@ type Unit struct {
 	Options      []Option `json:"options"`
 }
 
+// UnitHash returns the hash fleet reports in the unit states of a unit with options, the SHA1 of
+// its unit file
+func UnitHash(options []Option) string {
+	sections := []string{}
+	bySection := map[string][]Option{}
+	for _, option := range options {
+		if _, ok := bySection[option.Section]; !ok {
+			sections = append(sections, option.Section)
+		}
+		bySection[option.Section] = append(bySection[option.Section], option)
+	}
+	var file strings.Builder
+	for i, section := range sections {
+		if i > 0 {
+			file.WriteString("\n")
+		}
+		file.WriteString("[" + section + "]\n")
+		for _, option := range bySection[section] {
+			file.WriteString(option.Name + "=" + option.Value + "\n")
+		}
+	}
+	return fmt.Sprintf("%x", sha1.Sum([]byte(file.String())))
+}
+
 // UnitState represents a unit state.
 type UnitState struct {
 	Hash               string `json:"hash"`
@@ -84,13 +187,22 @@ type ErrorResponse struct {
 
 // ListUnits returns all fleet units in the host's cluster
 func ListUnits(host string) (units []Unit, err error) {
-	url := fmt.Sprintf("http://%s:%d/fleet/%s/units", host, port, apiVersion)
-	response, err := httpGetResponse(url)
+	return packageClient(host).ListUnits()
+}
+
+// ListUnits returns all fleet units in the cluster
+func (client *Client) ListUnits() (units []Unit, err error) {
+	url := fmt.Sprintf("%s/fleet/%s/units", client.baseURL, apiVersion)
+	response, err := client.httpGetResponse(url)
 	if err != nil {
 		return nil, err
 	}
 	defer response.Body.Close()
 
+	if response.StatusCode != 200 {
+		return nil, handleError(response)
+	}
+
 	jsonBytes, err := ioutil.ReadAll(response.Body)
 	if err != nil {
 		return nil, err
@@ -107,12 +219,16 @@ func ListUnits(host string) (units []Unit, err error) {
 
 	for nextPageToken != "" {
 		nextPageURL := fmt.Sprintf("%s?nextPageToken=%s", url, nextPageToken)
-		resp, err := httpGetResponse(nextPageURL)
+		resp, err := client.httpGetResponse(nextPageURL)
 		if err != nil {
 			return nil, err
 		}
 		defer resp.Body.Close()
 
+		if resp.StatusCode != 200 {
+			return nil, handleError(resp)
+		}
+
 		jsonContent, err := ioutil.ReadAll(resp.Body)
 		if err != nil {
 			return nil, err
@@ -120,6 +236,9 @@ func ListUnits(host string) (units []Unit, err error) {
 
 		var nextPageFleetResponse UnitsResponse
 		err = json.Unmarshal(jsonContent, &nextPageFleetResponse)
+		if err != nil {
+			return nil, err
+		}
 
 		units = append(units, nextPageFleetResponse.Units...)
 		nextPageToken = nextPageFleetResponse.NextPageToken
@@ -130,7 +249,12 @@ func ListUnits(host string) (units []Unit, err error) {
 
 // ListUnitsByName returns the template and any known units with the given name
 func ListUnitsByName(host, name string) (template Unit, units []Unit, err error) {
-	allUnits, err := ListUnits(host)
+	return packageClient(host).ListUnitsByName(name)
+}
+
+// ListUnitsByName returns the template and any known units with the given name
+func (client *Client) ListUnitsByName(name string) (template Unit, units []Unit, err error) {
+	allUnits, err := client.ListUnits()
 	if err != nil {
 		return Unit{}, nil, err
 	}
@@ -148,7 +272,12 @@ func ListUnitsByName(host, name string) (template Unit, units []Unit, err error)
 
 // CreateUnit creates a unit with the given name, desired state, and options
 func CreateUnit(host, name, desiredState string, options []Option) error {
-	url := fmt.Sprintf("http://%s:%d/fleet/%s/units/%s", host, port, apiVersion, name)
+	return packageClient(host).CreateUnit(name, desiredState, options)
+}
+
+// CreateUnit creates a unit with the given name, desired state, and options
+func (client *Client) CreateUnit(name, desiredState string, options []Option) error {
+	url := fmt.Sprintf("%s/fleet/%s/units/%s", client.baseURL, apiVersion, name)
 	body := map[string]interface{}{
 		"desiredState": desiredState,
 		"options":      options,
@@ -156,63 +285,44 @@ func CreateUnit(host, name, desiredState string, options []Option) error {
 
 	bodyBytes, err := json.Marshal(body)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	response, err := httpPutResponse(url, bodyBytes)
+	response, err := client.httpPutResponse(url, bodyBytes)
 	if err != nil {
 		return err
 	}
 	defer response.Body.Close()
 
 	if response.StatusCode == 400 {
-		return handleError(response.Body)
+		return handleError(response)
 	}
 
 	if response.StatusCode == 409 {
-		return handleError(response.Body)
+		return handleError(response)
 	}
 
 	if response.StatusCode != 201 {
-		return handleError(response.Body)
+		return handleError(response)
 	}
 
+	client.logger.Info("Created unit", "unit", name, "desiredState", desiredState, "host", client.host)
 	return nil
 }
 
 // ModifyDesiredState modifies the desired state of the given unit
 func (unit Unit) ModifyDesiredState(host, desiredState string) error {
-	url := fmt.Sprintf("http://%s:%d/fleet/%s/units/%s", host, port, apiVersion, unit.Name)
-
-	body := map[string]string{
-		"desiredState": desiredState,
-	}
-
-	bodyBytes, err := json.Marshal(body)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	response, err := httpPutResponse(url, bodyBytes)
-	if err != nil {
-		return err
-	}
-	defer response.Body.Close()
-
-	if response.StatusCode == 400 {
-		return handleError(response.Body)
-	}
-
-	if response.StatusCode != 204 {
-		return handleError(response.Body)
-	}
-
-	return nil
+	return packageClient(host).ModifyDesiredState(unit.Name, desiredState)
 }
 
 // ModifyDesiredState modifies the desired state of the given unit
 func (unitState UnitState) ModifyDesiredState(host, desiredState string) error {
-	url := fmt.Sprintf("http://%s:%d/fleet/%s/units/%s", host, port, apiVersion, unitState.Name)
+	return packageClient(host).ModifyDesiredState(unitState.Name, desiredState)
+}
+
+// ModifyDesiredState modifies the desired state of the named unit
+func (client *Client) ModifyDesiredState(name, desiredState string) error {
+	url := fmt.Sprintf("%s/fleet/%s/units/%s", client.baseURL, apiVersion, name)
 
 	body := map[string]string{
 		"desiredState": desiredState,
@@ -220,72 +330,79 @@ func (unitState UnitState) ModifyDesiredState(host, desiredState string) error {
 
 	bodyBytes, err := json.Marshal(body)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	response, err := httpPutResponse(url, bodyBytes)
+	response, err := client.httpPutResponse(url, bodyBytes)
 	if err != nil {
 		return err
 	}
 	defer response.Body.Close()
 
 	if response.StatusCode == 400 {
-		return handleError(response.Body)
+		return handleError(response)
 	}
 
 	if response.StatusCode != 204 {
-		return handleError(response.Body)
+		return handleError(response)
 	}
 
+	client.logger.Info("Modified unit desired state", "unit", name, "desiredState", desiredState, "host", client.host)
 	return nil
 }
 
 // Destroy destroys the unit
 func (unit Unit) Destroy(host string) error {
-	url := fmt.Sprintf("http://%s:%d/fleet/%s/units/%s", host, port, apiVersion, unit.Name)
-	response, err := httpDeleteResponse(url)
-	if err != nil {
-		return err
-	}
-	defer response.Body.Close()
-	if response.StatusCode != 204 {
-		return handleError(response.Body)
-	}
-	return nil
+	return packageClient(host).DestroyUnit(unit.Name)
 }
 
 // Destroy destroys the unit
 func (unitState UnitState) Destroy(host string) error {
-	url := fmt.Sprintf("http://%s:%d/fleet/%s/units/%s", host, port, apiVersion, unitState.Name)
-	response, err := httpDeleteResponse(url)
+	return packageClient(host).DestroyUnit(unitState.Name)
+}
+
+// DestroyUnit destroys the named unit
+func (client *Client) DestroyUnit(name string) error {
+	url := fmt.Sprintf("%s/fleet/%s/units/%s", client.baseURL, apiVersion, name)
+	response, err := client.httpDeleteResponse(url)
 	if err != nil {
 		return err
 	}
 	defer response.Body.Close()
 	if response.StatusCode != 204 {
-		return handleError(response.Body)
+		return handleError(response)
 	}
+	client.logger.Info("Destroyed unit", "unit", name, "host", client.host)
 	return nil
 }
 
 // ListUnitStates returns all unit states in the host's cluster
 func ListUnitStates(host string) (unitStates []UnitState, err error) {
-	url := fmt.Sprintf("http://%s:%d/fleet/%s/state", host, port, apiVersion)
-	response, err := httpGetResponse(url)
+	return packageClient(host).ListUnitStates()
+}
+
+// ListUnitStates returns all unit states in the cluster
+func (client *Client) ListUnitStates() (unitStates []UnitState, err error) {
+	url := fmt.Sprintf("%s/fleet/%s/state", client.baseURL, apiVersion)
+	response, err := client.httpGetResponse(url)
 	if err != nil {
 		return nil, err
 	}
 	defer response.Body.Close()
 
+	if response.StatusCode != 200 {
+		return nil, handleError(response)
+	}
+
 	jsonBytes, err := ioutil.ReadAll(response.Body)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
 	var fleetStateResponse UnitStateResponse
 	err = json.Unmarshal(jsonBytes, &fleetStateResponse)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
 	unitStates = append(unitStates, fleetStateResponse.States...)
@@ -293,12 +410,16 @@ func ListUnitStates(host string) (unitStates []UnitState, err error) {
 
 	for nextPageToken != "" {
 		nextPageURL := fmt.Sprintf("%s?nextPageToken=%s", url, nextPageToken)
-		resp, err := httpGetResponse(nextPageURL)
+		resp, err := client.httpGetResponse(nextPageURL)
 		if err != nil {
 			return nil, err
 		}
 		defer resp.Body.Close()
 
+		if resp.StatusCode != 200 {
+			return nil, handleError(resp)
+		}
+
 		jsonContent, err := ioutil.ReadAll(resp.Body)
 		if err != nil {
 			return nil, err
@@ -319,7 +440,12 @@ func ListUnitStates(host string) (unitStates []UnitState, err error) {
 
 // ListUnitStatesByName returns a list of unit states with the given name
 func ListUnitStatesByName(host, name string) (unitStates []UnitState, err error) {
-	allUnitStates, err := ListUnitStates(host)
+	return packageClient(host).ListUnitStatesByName(name)
+}
+
+// ListUnitStatesByName returns a list of unit states with the given name
+func (client *Client) ListUnitStatesByName(name string) (unitStates []UnitState, err error) {
+	allUnitStates, err := client.ListUnitStates()
 	if err != nil {
 		return nil, err
 	}
@@ -333,13 +459,22 @@ func ListUnitStatesByName(host, name string) (unitStates []UnitState, err error)
 
 // GetUnitStatesByMachineID returns the unit states with the given machineID
 func GetUnitStatesByMachineID(host, machineID string) (unitStates []UnitState, err error) {
-	url := fmt.Sprintf("http://%s:%d/fleet/%s/state?machineID=%s", host, port, apiVersion, machineID)
-	response, err := httpGetResponse(url)
+	return packageClient(host).GetUnitStatesByMachineID(machineID)
+}
+
+// GetUnitStatesByMachineID returns the unit states with the given machineID
+func (client *Client) GetUnitStatesByMachineID(machineID string) (unitStates []UnitState, err error) {
+	url := fmt.Sprintf("%s/fleet/%s/state?machineID=%s", client.baseURL, apiVersion, machineID)
+	response, err := client.httpGetResponse(url)
 	if err != nil {
 		return nil, err
 	}
 	defer response.Body.Close()
 
+	if response.StatusCode != 200 {
+		return nil, handleError(response)
+	}
+
 	responseBytes, err := ioutil.ReadAll(response.Body)
 	if err != nil {
 		return nil, err
@@ -356,13 +491,22 @@ func GetUnitStatesByMachineID(host, machineID string) (unitStates []UnitState, e
 
 // GetUnitStatesByUnitName returns the unit states with the given unit name
 func GetUnitStatesByUnitName(host, unitName string) (unitStates []UnitState, err error) {
-	url := fmt.Sprintf("http://%s:%d/fleet/%s/state?unitName=%s", host, port, apiVersion, unitName)
-	response, err := httpGetResponse(url)
+	return packageClient(host).GetUnitStatesByUnitName(unitName)
+}
+
+// GetUnitStatesByUnitName returns the unit states with the given unit name
+func (client *Client) GetUnitStatesByUnitName(unitName string) (unitStates []UnitState, err error) {
+	url := fmt.Sprintf("%s/fleet/%s/state?unitName=%s", client.baseURL, apiVersion, unitName)
+	response, err := client.httpGetResponse(url)
 	if err != nil {
 		return nil, err
 	}
 	defer response.Body.Close()
 
+	if response.StatusCode != 200 {
+		return nil, handleError(response)
+	}
+
 	responseBytes, err := ioutil.ReadAll(response.Body)
 	if err != nil {
 		return nil, err
@@ -379,13 +523,22 @@ func GetUnitStatesByUnitName(host, unitName string) (unitStates []UnitState, err
 
 // ListMachines returns all machines in the host's cluster
 func ListMachines(host string) (machines []Machine, err error) {
-	url := fmt.Sprintf("http://%s:%d/fleet/%s/machines", host, port, apiVersion)
-	response, err := httpGetResponse(url)
+	return packageClient(host).ListMachines()
+}
+
+// ListMachines returns all machines in the cluster
+func (client *Client) ListMachines() (machines []Machine, err error) {
+	url := fmt.Sprintf("%s/fleet/%s/machines", client.baseURL, apiVersion)
+	response, err := client.httpGetResponse(url)
 	if err != nil {
 		return nil, err
 	}
 	defer response.Body.Close()
 
+	if response.StatusCode != 200 {
+		return nil, handleError(response)
+	}
+
 	jsonBytes, err := ioutil.ReadAll(response.Body)
 	if err != nil {
 		return nil, err
@@ -402,12 +555,16 @@ func ListMachines(host string) (machines []Machine, err error) {
 
 	for nextPageToken != "" {
 		nextPageURL := fmt.Sprintf("%s?nextPageToken=%s", url, nextPageToken)
-		resp, err := httpGetResponse(nextPageURL)
+		resp, err := client.httpGetResponse(nextPageURL)
 		if err != nil {
 			return nil, err
 		}
 		defer resp.Body.Close()
 
+		if resp.StatusCode != 200 {
+			return nil, handleError(resp)
+		}
+
 		jsonContent, err := ioutil.ReadAll(resp.Body)
 		if err != nil {
 			return nil, err
@@ -427,15 +584,20 @@ func ListMachines(host string) (machines []Machine, err error) {
 
 // GetStateOfFleet returns all units, states, and machines in the host's cluster
 func GetStateOfFleet(host string) (units []Unit, unitStates []UnitState, machines []Machine, err error) {
-	units, err = ListUnits(host)
+	return packageClient(host).GetStateOfFleet()
+}
+
+// GetStateOfFleet returns all units, states, and machines in the cluster
+func (client *Client) GetStateOfFleet() (units []Unit, unitStates []UnitState, machines []Machine, err error) {
+	units, err = client.ListUnits()
 	if err != nil {
 		return nil, nil, nil, err
 	}
-	unitStates, err = ListUnitStates(host)
+	unitStates, err = client.ListUnitStates()
 	if err != nil {
 		return nil, nil, nil, err
 	}
-	machines, err = ListMachines(host)
+	machines, err = client.ListMachines()
 	if err != nil {
 		return nil, nil, nil, err
 	}
@@ -444,19 +606,24 @@ func GetStateOfFleet(host string) (units []Unit, unitStates []UnitState, machine
 
 // GetUnit returns the single requested unit
 func GetUnit(host, name string) (unit Unit, err error) {
-	url := fmt.Sprintf("http://%s:%d/fleet/%s/units/%s", host, port, apiVersion, name)
-	response, err := httpGetResponse(url)
+	return packageClient(host).GetUnit(name)
+}
+
+// GetUnit returns the single requested unit
+func (client *Client) GetUnit(name string) (unit Unit, err error) {
+	url := fmt.Sprintf("%s/fleet/%s/units/%s", client.baseURL, apiVersion, name)
+	response, err := client.httpGetResponse(url)
 	if err != nil {
 		return Unit{}, err
 	}
 	defer response.Body.Close()
 
 	if response.StatusCode == 404 {
-		return Unit{}, handleError(response.Body)
+		return Unit{}, handleError(response)
 	}
 
 	if response.StatusCode != 200 {
-		return Unit{}, handleError(response.Body)
+		return Unit{}, handleError(response)
 	}
 
 	jsonBytes, err := ioutil.ReadAll(response.Body)
@@ -470,46 +637,62 @@ func GetUnit(host, name string) (unit Unit, err error) {
 	return unit, err
 }
 
-func handleError(body io.ReadCloser) error {
-	errorBytes, err := ioutil.ReadAll(body)
+// baseURL returns the URL of the fleet API on host, which listens on port unless host has one
+func baseURL(scheme, host string, port int) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return scheme + "://" + host
+	}
+	return fmt.Sprintf("%s://%s:%d", scheme, host, port)
+}
+
+// endpointName names a request path for observations, such as units
+func endpointName(path string) string {
+	segments := strings.Split(strings.TrimPrefix(path, "/fleet/"+apiVersion+"/"), "/")
+	return segments[0]
+}
+
+// handleError returns an *apierror.Error with the fleet error in the body of a failed response
+func handleError(response *http.Response) error {
+	errorBytes, err := ioutil.ReadAll(response.Body)
 	if err != nil {
 		return err
 	}
 
 	var errorResponse ErrorResponse
 	err = json.Unmarshal(errorBytes, &errorResponse)
-	if err != nil {
-		return err
+	if err != nil || errorResponse.Error.Message == "" {
+		return apierror.FromResponse(apierror.Fleet, response, "", strings.TrimSpace(string(errorBytes)), nil)
 	}
 
-	return fmt.Errorf("%d: %s", errorResponse.Error.Code, errorResponse.Error.Message)
+	code := strconv.Itoa(errorResponse.Error.Code)
+	return apierror.FromResponse(apierror.Fleet, response, code, errorResponse.Error.Message, nil)
 }
 
 // ============================================================================
 // ============================= HTTP UTILS ===================================
 // ============================================================================
 
-func httpGetResponse(url string) (*http.Response, error) {
-	response, err := http.Get(url)
-	return response, err
+func (client *Client) httpGetResponse(url string) (*http.Response, error) {
+	return client.doHTTPResponse(httpclient.Request{Method: http.MethodGet, URL: url})
 }
 
-func httpPutResponse(url string, body []byte) (*http.Response, error) {
-	client := &http.Client{}
-	request, err := http.NewRequest(http.MethodPut, url, bytes.NewReader(body))
-
-	request.Header.Add("Content-Type", "application/json")
+func (client *Client) httpPutResponse(url string, body []byte) (*http.Response, error) {
+	return client.doHTTPResponse(httpclient.Request{
+		Method:      http.MethodPut,
+		URL:         url,
+		Body:        body,
+		ContentType: "application/json",
+	})
+}
 
-	response, err := client.Do(request)
-	return response, err
+func (client *Client) httpDeleteResponse(url string) (*http.Response, error) {
+	return client.doHTTPResponse(httpclient.Request{Method: http.MethodDelete, URL: url})
 }
 
-func httpDeleteResponse(url string) (*http.Response, error) {
-	client := &http.Client{}
-	request, err := http.NewRequest(http.MethodDelete, url, nil)
+func (client *Client) doHTTPResponse(request httpclient.Request) (*http.Response, error) {
+	response, err := client.httpClient.Do(context.Background(), request)
 	if err != nil {
-		log.Fatal(err)
+		return nil, apierror.Transport(apierror.Fleet, request.Operation(), err)
 	}
-	response, err := client.Do(request)
-	return response, err
+	return response, nil
 }
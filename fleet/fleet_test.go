@@ -0,0 +1,154 @@
+package fleet_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rarmstrong73/go-utils/apierror"
+	"github.com/rarmstrong73/go-utils/fleet"
+	"github.com/rarmstrong73/go-utils/fleet/fleettest"
+	"github.com/rarmstrong73/go-utils/logging"
+	"github.com/rarmstrong73/go-utils/retry"
+	"github.com/rarmstrong73/go-utils/timeouts"
+)
+
+// TestSettersWhileRequesting changes the package's configuration while requests are in flight,
+// which go test -race reports if the setters aren't safe for it
+func TestSettersWhileRequesting(t *testing.T) {
+	server := fleettest.NewServer()
+	defer server.Close()
+	server.AddMachine(fleet.Machine{ID: "m1", PrimaryIP: "10.0.0.1"})
+
+	var wait sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for j := 0; j < 20; j++ {
+				if _, err := fleet.ListMachines(server.Host()); err != nil {
+					t.Errorf("ListMachines: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	for j := 0; j < 20; j++ {
+		fleet.SetTimeouts(&timeouts.Timeouts{Connect: time.Second, Read: time.Duration(j+1) * time.Second})
+		fleet.SetRetryPolicy(retry.Policy{MaxAttempts: j%3 + 1})
+		fleet.SetLogger(logging.Std(log.New(ioutil.Discard, "", 0), true))
+	}
+	wait.Wait()
+
+	fleet.SetTimeouts(nil)
+	fleet.SetRetryPolicy(retry.Policy{})
+	fleet.SetLogger(nil)
+}
+
+func TestClientOnPortOfConfig(t *testing.T) {
+	server := fleettest.NewServer()
+	defer server.Close()
+	server.AddMachine(fleet.Machine{ID: "m1", PrimaryIP: "10.0.0.1"})
+	host, portString, _ := net.SplitHostPort(server.Host())
+	port, _ := strconv.Atoi(portString)
+
+	client := fleet.NewClient(fleet.Config{Host: host, Port: port, Timeout: time.Second, Retry: retry.Policy{MaxAttempts: 2}})
+	options := []fleet.Option{{Section: "Service", Name: "ExecStart", Value: "/bin/true"}}
+	if err := client.CreateUnit("web.service", fleet.Launched, options); err != nil {
+		t.Fatalf("CreateUnit: %v", err)
+	}
+
+	var wait sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			machines, err := client.ListMachines()
+			if err != nil || len(machines) != 1 {
+				t.Errorf("ListMachines = %v, %v", machines, err)
+			}
+			unit, err := client.GetUnit("web.service")
+			if err != nil || unit.DesiredState != fleet.Launched {
+				t.Errorf("GetUnit = %+v, %v", unit, err)
+			}
+		}()
+	}
+	wait.Wait()
+
+	if err := client.DestroyUnit("web.service"); err != nil {
+		t.Fatalf("DestroyUnit: %v", err)
+	}
+	if units, err := fleet.ListUnits(server.Host()); err != nil || len(units) != 0 {
+		t.Errorf("ListUnits = %v, %v, want none", units, err)
+	}
+}
+
+func TestListingsReturnErrorResponses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":{"code":401,"message":"unauthorized"}}`, http.StatusUnauthorized)
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	listings := map[string]func() error{
+		"ListUnits":                func() error { _, err := fleet.ListUnits(host); return err },
+		"ListUnitStates":           func() error { _, err := fleet.ListUnitStates(host); return err },
+		"ListUnitStatesByName":     func() error { _, err := fleet.ListUnitStatesByName(host, "web"); return err },
+		"GetUnitStatesByMachineID": func() error { _, err := fleet.GetUnitStatesByMachineID(host, "m1"); return err },
+		"GetUnitStatesByUnitName":  func() error { _, err := fleet.GetUnitStatesByUnitName(host, "web.service"); return err },
+		"ListMachines":             func() error { _, err := fleet.ListMachines(host); return err },
+	}
+	for name, listing := range listings {
+		var apiError *apierror.Error
+		if err := listing(); !errors.As(err, &apiError) || apiError.StatusCode != http.StatusUnauthorized {
+			t.Errorf("%s error = %v, want a 401 *apierror.Error", name, err)
+		}
+	}
+}
+
+func TestListingsFailOnLaterPages(t *testing.T) {
+	defer func(pageSize int) { fleettest.PageSize = pageSize }(fleettest.PageSize)
+	fleettest.PageSize = 1
+	server := fleettest.NewServer()
+	defer server.Close()
+	server.AddMachine(fleet.Machine{ID: "m1", PrimaryIP: "10.0.0.1"})
+	server.AddMachine(fleet.Machine{ID: "m2", PrimaryIP: "10.0.0.2"})
+	options := []fleet.Option{{Section: "Service", Name: "ExecStart", Value: "/bin/true"}}
+	for _, name := range []string{"a.service", "b.service"} {
+		if err := fleet.CreateUnit(server.Host(), name, fleet.Launched, options); err != nil {
+			t.Fatalf("CreateUnit(%s): %v", name, err)
+		}
+	}
+
+	target, _ := url.Parse(server.URL)
+	forward := httputil.NewSingleHostReverseProxy(target)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("nextPageToken") != "" {
+			http.Error(w, `{"error":{"code":500,"message":"etcd unavailable"}}`, http.StatusInternalServerError)
+			return
+		}
+		forward.ServeHTTP(w, r)
+	}))
+	defer proxy.Close()
+	host := strings.TrimPrefix(proxy.URL, "http://")
+
+	if units, err := fleet.ListUnits(host); err == nil {
+		t.Errorf("ListUnits = %v, want the second page's error", units)
+	}
+	if states, err := fleet.ListUnitStates(host); err == nil {
+		t.Errorf("ListUnitStates = %v, want the second page's error", states)
+	}
+	if machines, err := fleet.ListMachines(host); err == nil {
+		t.Errorf("ListMachines = %v, want the second page's error", machines)
+	}
+}
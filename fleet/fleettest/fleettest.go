@@ -0,0 +1,395 @@
+// Package fleettest provides an in-memory fake of the fleet API behind an httptest server, so code
+// using the fleet package can be tested without a cluster. Units are scheduled as soon as their
+// desired state is set: loaded and launched units are placed on the machine with the fewest
+// units, or on the machine named by an X-Fleet MachineID option, and their systemd states follow
+// their desired state. Template units are never scheduled. Listings are paged by PageSize.
+package fleettest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rarmstrong73/go-utils/fleet"
+)
+
+var apiVersion = "v1"
+
+// PageSize is how many items a listing returns before setting its nextPageToken
+var PageSize = 100
+
+// Server is a fake fleet API
+type Server struct {
+	*httptest.Server
+
+	mutex    sync.Mutex
+	machines map[string]fleet.Machine
+	units    map[string]*fleet.Unit
+	states   map[string]*fleet.UnitState
+	onChange func(unit fleet.Unit, state *fleet.UnitState)
+}
+
+// NewServer starts a fake fleet API with no machines or units
+func NewServer() *Server {
+	server := &Server{
+		machines: map[string]fleet.Machine{},
+		units:    map[string]*fleet.Unit{},
+		states:   map[string]*fleet.UnitState{},
+	}
+	server.Server = httptest.NewServer(http.HandlerFunc(server.handle))
+	return server
+}
+
+// Host returns the host:port the server listens on, to be passed to the fleet functions
+func (server *Server) Host() string {
+	return strings.TrimPrefix(server.URL, "http://")
+}
+
+// OnChange sets a function called whenever a unit is created, changes state, is moved or is
+// destroyed, with the unit's new state or nil when it isn't scheduled. A destroyed unit has an
+// empty DesiredState. It is called without the server locked.
+func (server *Server) OnChange(onChange func(unit fleet.Unit, state *fleet.UnitState)) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.onChange = onChange
+}
+
+// AddMachine adds a machine to the cluster and schedules any units waiting for one
+func (server *Server) AddMachine(machine fleet.Machine) {
+	server.mutex.Lock()
+	server.machines[machine.ID] = machine
+	changed := server.scheduleLocked()
+	server.mutex.Unlock()
+	server.notify(changed)
+}
+
+// RemoveMachine removes a machine from the cluster, as if it had failed, and reschedules its
+// units, returning false if there is no such machine
+func (server *Server) RemoveMachine(id string) bool {
+	server.mutex.Lock()
+	if _, ok := server.machines[id]; !ok {
+		server.mutex.Unlock()
+		return false
+	}
+	delete(server.machines, id)
+	changed := []string{}
+	for name, state := range server.states {
+		if state.MachineID == id {
+			delete(server.states, name)
+			changed = append(changed, name)
+		}
+	}
+	changed = append(changed, server.scheduleLocked()...)
+	server.mutex.Unlock()
+	server.notify(changed)
+	return true
+}
+
+// Units returns every unit, sorted by name
+func (server *Server) Units() []fleet.Unit {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return server.unitsLocked()
+}
+
+// States returns the state of every scheduled unit, sorted by name
+func (server *Server) States() []fleet.UnitState {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return server.statesLocked("", "")
+}
+
+// SetSystemdState overrides the systemd states of a scheduled unit, such as to make it fail,
+// returning false if the unit isn't scheduled
+func (server *Server) SetSystemdState(name, activeState, subState string) bool {
+	server.mutex.Lock()
+	state, ok := server.states[name]
+	if ok {
+		state.SystemdActiveState = activeState
+		state.SystemdSubState = subState
+	}
+	server.mutex.Unlock()
+	if ok {
+		server.notify([]string{name})
+	}
+	return ok
+}
+
+func (server *Server) handle(w http.ResponseWriter, r *http.Request) {
+	prefix := "/fleet/" + apiVersion + "/"
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		http.NotFound(w, r)
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, prefix)
+	query := r.URL.Query()
+
+	switch {
+	case path == "units" && r.Method == http.MethodGet:
+		units := server.Units()
+		page, token := paginate(len(units), query)
+		writeJSON(w, http.StatusOK, fleet.UnitsResponse{Units: units[page[0]:page[1]], NextPageToken: token})
+	case strings.HasPrefix(path, "units/"):
+		server.handleUnit(w, r, strings.TrimPrefix(path, "units/"))
+	case path == "state" && r.Method == http.MethodGet:
+		server.mutex.Lock()
+		states := server.statesLocked(query.Get("machineID"), query.Get("unitName"))
+		server.mutex.Unlock()
+		page, token := paginate(len(states), query)
+		writeJSON(w, http.StatusOK, fleet.UnitStateResponse{States: states[page[0]:page[1]], NextPageToken: token})
+	case path == "machines" && r.Method == http.MethodGet:
+		server.mutex.Lock()
+		machines := []fleet.Machine{}
+		for _, id := range sortedKeys(server.machines) {
+			machines = append(machines, server.machines[id])
+		}
+		server.mutex.Unlock()
+		page, token := paginate(len(machines), query)
+		writeJSON(w, http.StatusOK, fleet.MachinesResponse{Machines: machines[page[0]:page[1]], NextPageToken: token})
+	default:
+		writeError(w, http.StatusNotFound, "Not found")
+	}
+}
+
+func (server *Server) handleUnit(w http.ResponseWriter, r *http.Request, name string) {
+	switch r.Method {
+	case http.MethodGet:
+		server.mutex.Lock()
+		unit, ok := server.units[name]
+		var found fleet.Unit
+		if ok {
+			found = *unit
+		}
+		server.mutex.Unlock()
+		if !ok {
+			writeError(w, http.StatusNotFound, "unit does not exist")
+			return
+		}
+		writeJSON(w, http.StatusOK, found)
+	case http.MethodPut:
+		server.put(w, r, name)
+	case http.MethodDelete:
+		server.mutex.Lock()
+		unit, ok := server.units[name]
+		var destroyed fleet.Unit
+		if ok {
+			destroyed = *unit
+			destroyed.DesiredState = ""
+			delete(server.units, name)
+			delete(server.states, name)
+		}
+		onChange := server.onChange
+		server.mutex.Unlock()
+		if !ok {
+			writeError(w, http.StatusNotFound, "unit does not exist")
+			return
+		}
+		if onChange != nil {
+			onChange(destroyed, nil)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+func (server *Server) put(w http.ResponseWriter, r *http.Request, name string) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var request fleet.Unit
+	if err := json.Unmarshal(body, &request); err != nil {
+		writeError(w, http.StatusBadRequest, "unable to decode body: "+err.Error())
+		return
+	}
+	switch request.DesiredState {
+	case fleet.Inactive, fleet.Loaded, fleet.Launched:
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid desiredState: %s", request.DesiredState))
+		return
+	}
+
+	server.mutex.Lock()
+	unit, exists := server.units[name]
+	switch {
+	case !exists && len(request.Options) == 0:
+		server.mutex.Unlock()
+		writeError(w, http.StatusConflict, "unit does not exist and options field empty")
+		return
+	case exists && len(request.Options) > 0 && !reflect.DeepEqual(request.Options, unit.Options):
+		server.mutex.Unlock()
+		writeError(w, http.StatusConflict, "unit already exists with different options")
+		return
+	case !exists:
+		unit = &fleet.Unit{Name: name, Options: request.Options}
+		server.units[name] = unit
+	}
+	unit.DesiredState = request.DesiredState
+	changed := append([]string{name}, server.scheduleLocked()...)
+	server.mutex.Unlock()
+	server.notify(changed)
+
+	if exists {
+		w.WriteHeader(http.StatusNoContent)
+	} else {
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+// scheduleLocked brings the state of every unit in line with its desired state, returning the
+// names of the units whose state changed
+func (server *Server) scheduleLocked() []string {
+	changed := []string{}
+	for _, name := range sortedKeys(server.units) {
+		unit := server.units[name]
+		state, scheduled := server.states[name]
+		if unit.DesiredState == fleet.Inactive || strings.Contains(name, "@.") {
+			if scheduled {
+				delete(server.states, name)
+				changed = append(changed, name)
+			}
+			unit.CurrentState = fleet.Inactive
+			continue
+		}
+
+		if !scheduled {
+			machineID := server.placeLocked(*unit)
+			if machineID == "" {
+				unit.CurrentState = fleet.Inactive
+				continue
+			}
+			state = &fleet.UnitState{Name: name, MachineID: machineID, Hash: fleet.UnitHash(unit.Options)}
+			server.states[name] = state
+		}
+
+		active, sub := "inactive", "dead"
+		if unit.DesiredState == fleet.Launched {
+			active, sub = "active", "running"
+		}
+		if !scheduled || unit.CurrentState != unit.DesiredState {
+			state.SystemdLoadState = "loaded"
+			state.SystemdActiveState = active
+			state.SystemdSubState = sub
+			changed = append(changed, name)
+		}
+		unit.CurrentState = unit.DesiredState
+	}
+	return changed
+}
+
+// placeLocked returns the machine a unit should run on, or "" if there is none
+func (server *Server) placeLocked(unit fleet.Unit) string {
+	for _, option := range unit.Options {
+		if option.Section == "X-Fleet" && option.Name == "MachineID" {
+			if _, ok := server.machines[option.Value]; ok {
+				return option.Value
+			}
+			return ""
+		}
+	}
+
+	counts := map[string]int{}
+	for _, state := range server.states {
+		counts[state.MachineID]++
+	}
+	best := ""
+	for _, id := range sortedKeys(server.machines) {
+		if best == "" || counts[id] < counts[best] {
+			best = id
+		}
+	}
+	return best
+}
+
+// notify calls the OnChange function for the named units
+func (server *Server) notify(names []string) {
+	server.mutex.Lock()
+	onChange := server.onChange
+	type change struct {
+		unit  fleet.Unit
+		state *fleet.UnitState
+	}
+	changes := []change{}
+	for _, name := range names {
+		unit, ok := server.units[name]
+		if !ok {
+			continue
+		}
+		var state *fleet.UnitState
+		if current, ok := server.states[name]; ok {
+			copied := *current
+			state = &copied
+		}
+		changes = append(changes, change{unit: *unit, state: state})
+	}
+	server.mutex.Unlock()
+
+	if onChange == nil {
+		return
+	}
+	for _, change := range changes {
+		onChange(change.unit, change.state)
+	}
+}
+
+func (server *Server) unitsLocked() []fleet.Unit {
+	units := []fleet.Unit{}
+	for _, name := range sortedKeys(server.units) {
+		units = append(units, *server.units[name])
+	}
+	return units
+}
+
+func (server *Server) statesLocked(machineID, unitName string) []fleet.UnitState {
+	states := []fleet.UnitState{}
+	for _, name := range sortedKeys(server.states) {
+		state := server.states[name]
+		if (machineID == "" || state.MachineID == machineID) && (unitName == "" || state.Name == unitName) {
+			states = append(states, *state)
+		}
+	}
+	return states
+}
+
+// paginate returns the bounds of the page of a listing of count items the request asks for and
+// the token of the next page
+func paginate(count int, query url.Values) ([2]int, string) {
+	start, _ := strconv.Atoi(query.Get("nextPageToken"))
+	if start > count {
+		start = count
+	}
+	end := start + PageSize
+	if end >= count {
+		return [2]int{start, count}, ""
+	}
+	return [2]int{start, end}, strconv.Itoa(end)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, fleet.ErrorResponse{Error: fleet.Error{Code: status, Message: message}})
+}
+
+func sortedKeys(values interface{}) []string {
+	keys := []string{}
+	for _, key := range reflect.ValueOf(values).MapKeys() {
+		keys = append(keys, key.String())
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,54 @@
+package fleet
+
+import (
+	"io"
+	"sort"
+
+	"github.com/rarmstrong73/go-utils/internal/marshal"
+)
+
+// Snapshot is the state of a cluster, as returned by GetSnapshot
+type Snapshot struct {
+	Units    []Unit      `json:"units"`
+	States   []UnitState `json:"states"`
+	Machines []Machine   `json:"machines"`
+}
+
+// GetSnapshot returns the units, states and machines of the host's cluster, sorted so snapshots
+// of the same state are written the same way
+func GetSnapshot(host string) (Snapshot, error) {
+	return packageClient(host).GetSnapshot()
+}
+
+// GetSnapshot returns the units, states and machines of the cluster, sorted like the package's
+// GetSnapshot
+func (client *Client) GetSnapshot() (Snapshot, error) {
+	units, states, machines, err := client.GetStateOfFleet()
+	if err != nil {
+		return Snapshot{}, err
+	}
+	snapshot := Snapshot{Units: []Unit{}, States: []UnitState{}, Machines: []Machine{}}
+	snapshot.Units = append(snapshot.Units, units...)
+	snapshot.States = append(snapshot.States, states...)
+	snapshot.Machines = append(snapshot.Machines, machines...)
+
+	sort.Slice(snapshot.Units, func(i, j int) bool { return snapshot.Units[i].Name < snapshot.Units[j].Name })
+	sort.Slice(snapshot.States, func(i, j int) bool {
+		if snapshot.States[i].Name != snapshot.States[j].Name {
+			return snapshot.States[i].Name < snapshot.States[j].Name
+		}
+		return snapshot.States[i].MachineID < snapshot.States[j].MachineID
+	})
+	sort.Slice(snapshot.Machines, func(i, j int) bool { return snapshot.Machines[i].ID < snapshot.Machines[j].ID })
+	return snapshot, nil
+}
+
+// WriteJSON writes the snapshot to w as JSON
+func (snapshot Snapshot) WriteJSON(w io.Writer) error {
+	return marshal.WriteJSON(w, snapshot)
+}
+
+// WriteYAML writes the snapshot to w as YAML
+func (snapshot Snapshot) WriteYAML(w io.Writer) error {
+	return marshal.WriteYAML(w, snapshot)
+}
@@ -0,0 +1,257 @@
+// Package gc finds and removes what fleet no longer runs from the cluster's docker daemons:
+// containers no unit scheduled on their machine backs, and images no unit refers to and no
+// remaining container uses. Images pulled by hand or by other tools can't be told apart from
+// those units left behind, so orphan images are only reported unless RemoveImages is set.
+//
+//	report, err := gc.Collect(ctx, gc.Config{FleetHost: host, DryRun: true})
+//	for _, orphan := range report.Orphans {
+//		fmt.Println(orphan.Machine, orphan.Kind, orphan.Name, orphan.Reason)
+//	}
+//
+// Containers and images with the exclude label are never removed.
+package gc
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/rarmstrong73/go-utils/docker"
+	"github.com/rarmstrong73/go-utils/fanout"
+	"github.com/rarmstrong73/go-utils/fleet"
+	"github.com/rarmstrong73/go-utils/logging"
+)
+
+// DefaultExcludeLabel is the label that keeps containers and images from being collected
+const DefaultExcludeLabel = "gc.exclude"
+
+// Kinds of orphans
+const (
+	Container = "container"
+	Image     = "image"
+)
+
+// Config describes the cluster and what is collected
+type Config struct {
+	// FleetHost is the fleet API units and machines are read from
+	FleetHost string
+	// DockerHost returns the host to pass to the docker functions for a machine, defaulting to
+	// its PrimaryIP
+	DockerHost func(machine fleet.Machine) string
+	// ContainerUnit returns the name of the unit a container belongs to, defaulting to the
+	// container's name with .service added if it has no suffix
+	ContainerUnit func(container docker.Container) string
+	// DryRun finds orphans without removing them
+	DryRun bool
+	// RemoveRunning removes running orphan containers too, which are otherwise left running
+	RemoveRunning bool
+	// RemoveImages removes orphan images too, which are otherwise only reported
+	RemoveImages bool
+	// ExcludeLabel is the label that keeps containers and images, DefaultExcludeLabel when empty
+	ExcludeLabel string
+	// Concurrency is how many machines are collected at once, all of them when 0
+	Concurrency int
+	// Logger is where the collection logs, nowhere when nil
+	Logger logging.Logger
+}
+
+// Orphan is a container or image nothing in fleet needs
+type Orphan struct {
+	// Machine is the ID of the machine the orphan is on
+	Machine string
+	// Kind is Container or Image
+	Kind string
+	ID   string
+	// Name is the container's name or the image's first tag
+	Name string
+	// Reason is why the orphan was found
+	Reason string
+	// Removed reports whether the orphan was removed, false for dry runs, running containers,
+	// images unless RemoveImages is set and failed removals
+	Removed bool
+	// Err is the error removing the orphan
+	Err error
+}
+
+// Report is the outcome of a collection
+type Report struct {
+	Orphans []Orphan
+}
+
+// Removed returns how many orphans were removed
+func (report Report) Removed() int {
+	removed := 0
+	for _, orphan := range report.Orphans {
+		if orphan.Removed {
+			removed++
+		}
+	}
+	return removed
+}
+
+// Collect finds the orphans on every machine in the cluster and removes them unless the
+// collection is a dry run, refusing to when fleet has no unit states for a machine. It returns
+// the orphans of the machines it could collect along with a *fanout.Error listing the machines
+// it couldn't.
+func Collect(ctx context.Context, config Config) (Report, error) {
+	config = withDefaults(config)
+
+	units, states, machines, err := fleet.GetStateOfFleet(config.FleetHost)
+	if err != nil {
+		return Report{}, err
+	}
+	scheduled := map[string]map[string]bool{}
+	for _, state := range states {
+		if scheduled[state.MachineID] == nil {
+			scheduled[state.MachineID] = map[string]bool{}
+		}
+		scheduled[state.MachineID][state.Name] = true
+	}
+	// A machine fleet reports no units for looks the same as one whose states went missing, and
+	// collecting it would remove everything on it
+	if !config.DryRun {
+		for _, machine := range machines {
+			if len(scheduled[machine.ID]) == 0 {
+				return Report{}, fmt.Errorf("Fleet has no unit states for machine %s, refusing to remove anything", machine.ID)
+			}
+		}
+	}
+
+	hosts := []string{}
+	byHost := map[string]fleet.Machine{}
+	for _, machine := range machines {
+		host := config.DockerHost(machine)
+		hosts = append(hosts, host)
+		byHost[host] = machine
+	}
+
+	results, err := fanout.Run(ctx, hosts, fanout.Options{Concurrency: config.Concurrency}, func(ctx context.Context, host string) (interface{}, error) {
+		machine := byHost[host]
+		return collectMachine(config, host, machine.ID, scheduled[machine.ID], units)
+	})
+	report := Report{Orphans: []Orphan{}}
+	for _, result := range results {
+		if orphans, ok := result.Value.([]Orphan); ok {
+			report.Orphans = append(report.Orphans, orphans...)
+		}
+	}
+	return report, err
+}
+
+// collectMachine collects the orphans on a machine, whose scheduled units are the names of the
+// units fleet has scheduled on it
+func collectMachine(config Config, host, machineID string, scheduled map[string]bool, units []fleet.Unit) ([]Orphan, error) {
+	logger := logging.OrNop(config.Logger)
+	containers, err := docker.ListContainers(host, true)
+	if err != nil {
+		return nil, err
+	}
+	images, err := docker.ListImages(host, false)
+	if err != nil {
+		return nil, err
+	}
+
+	orphans := []Orphan{}
+	usedImages := map[string]bool{}
+	for _, container := range containers {
+		if _, excluded := container.Labels[config.ExcludeLabel]; excluded || scheduled[config.ContainerUnit(container)] {
+			usedImages[container.ImageID] = true
+			continue
+		}
+		orphan := Orphan{Machine: machineID, Kind: Container, ID: container.ID, Name: containerName(container), Reason: "No unit scheduled on the machine"}
+		running := strings.HasPrefix(container.Status, "Up")
+		switch {
+		case running && !config.RemoveRunning:
+			orphan.Reason += ", left running"
+			usedImages[container.ImageID] = true
+		case !config.DryRun:
+			orphan.Err = docker.RemoveContainer(host, container.ID, true, running)
+			orphan.Removed = orphan.Err == nil
+		}
+		orphans = append(orphans, orphan)
+	}
+
+	for _, image := range images {
+		if _, excluded := image.Labels[config.ExcludeLabel]; excluded || usedImages[image.ID] || referenced(image, units) {
+			continue
+		}
+		orphan := Orphan{Machine: machineID, Kind: Image, ID: image.ID, Name: imageName(image), Reason: "No unit refers to the image"}
+		switch {
+		case !config.RemoveImages:
+			orphan.Reason += ", left in place"
+		case !config.DryRun:
+			orphan.Err = docker.RemoveImage(host, image.ID, false, false)
+			orphan.Removed = orphan.Err == nil
+		}
+		orphans = append(orphans, orphan)
+	}
+
+	logger.Info("Collected machine", "machine", machineID, "orphans", len(orphans), "dryRun", config.DryRun)
+	return orphans, nil
+}
+
+// referenced reports whether any of the image's tags appears in the options of any unit, such as
+// in an ExecStart running it. A tag of latest also matches its repository without a tag.
+func referenced(image docker.Image, units []fleet.Unit) bool {
+	names := map[string]bool{}
+	for _, tag := range image.RepoTags {
+		if tag == "<none>:<none>" {
+			continue
+		}
+		names[tag] = true
+		if strings.HasSuffix(tag, ":latest") {
+			names[strings.TrimSuffix(tag, ":latest")] = true
+		}
+	}
+	if len(names) == 0 {
+		return false
+	}
+	for _, unit := range units {
+		for _, option := range unit.Options {
+			for _, field := range strings.Fields(option.Value) {
+				if names[strings.Trim(field, `"'`)] {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func containerName(container docker.Container) string {
+	if len(container.Names) == 0 {
+		return container.ID
+	}
+	return strings.TrimPrefix(container.Names[0], "/")
+}
+
+func imageName(image docker.Image) string {
+	tags := append([]string{}, image.RepoTags...)
+	sort.Strings(tags)
+	for _, tag := range tags {
+		if tag != "<none>:<none>" {
+			return tag
+		}
+	}
+	return image.ID
+}
+
+func withDefaults(config Config) Config {
+	if config.DockerHost == nil {
+		config.DockerHost = func(machine fleet.Machine) string { return machine.PrimaryIP }
+	}
+	if config.ContainerUnit == nil {
+		config.ContainerUnit = func(container docker.Container) string {
+			name := containerName(container)
+			if !strings.Contains(name, ".") {
+				name += ".service"
+			}
+			return name
+		}
+	}
+	if config.ExcludeLabel == "" {
+		config.ExcludeLabel = DefaultExcludeLabel
+	}
+	return config
+}
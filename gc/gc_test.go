@@ -0,0 +1,107 @@
+package gc_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/rarmstrong73/go-utils/docker"
+	"github.com/rarmstrong73/go-utils/docker/dockertest"
+	"github.com/rarmstrong73/go-utils/fleet"
+	"github.com/rarmstrong73/go-utils/fleet/fleettest"
+	"github.com/rarmstrong73/go-utils/gc"
+)
+
+func TestCollectRemovesImagesOnlyWhenAsked(t *testing.T) {
+	fleetServer := fleettest.NewServer()
+	defer fleetServer.Close()
+	fleetServer.AddMachine(fleet.Machine{ID: "m1", PrimaryIP: "10.0.0.1"})
+	launch(t, fleetServer, "web.service")
+	dockerServer := dockertest.NewServer()
+	defer dockerServer.Close()
+	if err := docker.CreateImage(dockerServer.Host(), "busybox:1.36", "", "", ""); err != nil {
+		t.Fatalf("CreateImage: %v", err)
+	}
+	config := gc.Config{
+		FleetHost:  fleetServer.Host(),
+		DockerHost: func(fleet.Machine) string { return dockerServer.Host() },
+	}
+
+	report, err := gc.Collect(context.Background(), config)
+	if err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	if len(report.Orphans) != 1 || report.Orphans[0].Kind != gc.Image || report.Removed() != 0 {
+		t.Errorf("orphans = %+v, want the image reported but not removed", report.Orphans)
+	}
+	if images := dockerServer.Images(); len(images) != 1 {
+		t.Fatalf("images = %+v, want busybox kept", images)
+	}
+
+	config.RemoveImages = true
+	report, err = gc.Collect(context.Background(), config)
+	if err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	if report.Removed() != 1 {
+		t.Errorf("orphans = %+v, want the image removed", report.Orphans)
+	}
+	if images := dockerServer.Images(); len(images) != 0 {
+		t.Errorf("images = %+v, want none", images)
+	}
+}
+
+func TestCollectRemovesNothingWithoutUnitStates(t *testing.T) {
+	fleetServer := fleettest.NewServer()
+	defer fleetServer.Close()
+	fleetServer.AddMachine(fleet.Machine{ID: "m1", PrimaryIP: "10.0.0.1"})
+	fleetServer.AddMachine(fleet.Machine{ID: "m2", PrimaryIP: "10.0.0.2"})
+	launch(t, fleetServer, "web.service")
+	target, _ := url.Parse(fleetServer.URL)
+	forward := httputil.NewSingleHostReverseProxy(target)
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fleet/v1/state" {
+			http.Error(w, `{"error":{"code":500,"message":"etcd unavailable"}}`, http.StatusInternalServerError)
+			return
+		}
+		forward.ServeHTTP(w, r)
+	}))
+	defer failing.Close()
+	dockerServer := dockertest.NewServer()
+	defer dockerServer.Close()
+	dockerServer.RunContainer("web", "nginx:1.25")
+	dockerServer.StopContainer("web")
+
+	tests := []struct {
+		name      string
+		fleetHost string
+	}{
+		{"failing state listing", strings.TrimPrefix(failing.URL, "http://")},
+		{"machine without units", fleetServer.Host()},
+	}
+	for _, test := range tests {
+		config := gc.Config{
+			FleetHost:    test.fleetHost,
+			DockerHost:   func(fleet.Machine) string { return dockerServer.Host() },
+			RemoveImages: true,
+		}
+		if report, err := gc.Collect(context.Background(), config); err == nil {
+			t.Errorf("%s: Collect = %+v, want an error", test.name, report.Orphans)
+		}
+		if containers := dockerServer.Containers(); len(containers) != 1 {
+			t.Errorf("%s: containers = %+v, want web kept", test.name, containers)
+		}
+	}
+}
+
+// launch launches a unit, which the fake schedules on the machine with the fewest units
+func launch(t *testing.T, server *fleettest.Server, name string) {
+	options := []fleet.Option{{Section: "Service", Name: "ExecStart", Value: "/bin/true"}}
+	if err := fleet.CreateUnit(server.Host(), name, fleet.Launched, options); err != nil {
+		t.Fatalf("CreateUnit(%s): %v", name, err)
+	}
+}
@@ -0,0 +1,71 @@
+package healthz
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	consul "github.com/rarmstrong73/go-utils/consul/health"
+	"github.com/rarmstrong73/go-utils/docker"
+	"github.com/rarmstrong73/go-utils/etcd"
+	"github.com/rarmstrong73/go-utils/fleet"
+)
+
+// Etcd checks the health of each of the client's members, the cluster is healthy while most of
+// them are
+func Etcd(client *etcd.Client) Check {
+	return func(ctx context.Context) error {
+		hosts := client.Hosts()
+		if len(hosts) == 0 {
+			return fmt.Errorf("No etcd members")
+		}
+		unhealthy := []string{}
+		for _, host := range hosts {
+			if err := client.Health(host); err != nil {
+				unhealthy = append(unhealthy, fmt.Sprintf("%s: %v", host, err))
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+		}
+		if len(unhealthy) >= (len(hosts)+1)/2 {
+			return fmt.Errorf("%d of %d members are unhealthy: %s", len(unhealthy), len(hosts), strings.Join(unhealthy, "; "))
+		}
+		return nil
+	}
+}
+
+// Consul checks that the client's datacenter has a leader
+func Consul(client *consul.Client) Check {
+	return func(ctx context.Context) error {
+		leader, err := client.StatusLeaderContext(ctx)
+		if err != nil {
+			return err
+		}
+		if leader == "" {
+			return fmt.Errorf("No cluster leader")
+		}
+		return nil
+	}
+}
+
+// Docker pings the docker daemon on host
+func Docker(host string) Check {
+	return func(ctx context.Context) error {
+		return docker.Ping(host)
+	}
+}
+
+// Fleet checks that the fleet API on host answers and has machines in its cluster
+func Fleet(host string) Check {
+	return func(ctx context.Context) error {
+		machines, err := fleet.ListMachines(host)
+		if err != nil {
+			return err
+		}
+		if len(machines) == 0 {
+			return fmt.Errorf("No machines in the cluster")
+		}
+		return nil
+	}
+}
@@ -0,0 +1,225 @@
+// Package healthz probes the backends a service depends on in the background and serves their
+// health over HTTP, for readiness checks:
+//
+//	checker := healthz.NewChecker(10 * time.Second)
+//	checker.Add("etcd", healthz.Etcd(etcdClient))
+//	checker.Add("consul", healthz.Consul(consulClient))
+//	checker.Add("docker", healthz.Docker(host))
+//	checker.Add("fleet", healthz.Fleet(fleetHost))
+//	go checker.Run(ctx)
+//	http.Handle("/healthz", checker)
+//
+// The endpoint answers 200 while every dependency is healthy and 503 otherwise, with the status
+// and latency of each dependency in the body.
+package healthz
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rarmstrong73/go-utils/logging"
+)
+
+// Check probes a dependency, returning why it is unhealthy. It should give up when ctx is done.
+type Check func(ctx context.Context) error
+
+// Status is the result of a dependency's last probe
+type Status struct {
+	Name    string `json:"name"`
+	Healthy bool   `json:"healthy"`
+	// Error is why the dependency is unhealthy
+	Error string `json:"error,omitempty"`
+	// Latency is how long the probe took
+	Latency time.Duration `json:"-"`
+	// Checked is when the probe finished, zero until the dependency was first probed
+	Checked time.Time `json:"checked"`
+}
+
+// errNotChecked is the error of dependencies that haven't been probed yet
+var errNotChecked = errors.New("Not checked yet")
+
+type dependency struct {
+	name  string
+	check Check
+}
+
+// Checker probes dependencies every interval, it is an http.Handler serving their health
+type Checker struct {
+	interval time.Duration
+
+	mutex        sync.Mutex
+	timeout      time.Duration
+	dependencies []dependency
+	statuses     map[string]Status
+	logger       logging.Logger
+}
+
+// NewChecker returns a checker without dependencies that probes every interval, giving probes
+// until the next one to finish
+func NewChecker(interval time.Duration) *Checker {
+	return &Checker{interval: interval, timeout: interval, statuses: map[string]Status{}, logger: logging.Nop}
+}
+
+// SetTimeout sets how long a probe may take before its dependency is unhealthy
+func (checker *Checker) SetTimeout(timeout time.Duration) {
+	checker.mutex.Lock()
+	defer checker.mutex.Unlock()
+	checker.timeout = timeout
+}
+
+// SetLogger sets where the checker logs dependencies becoming unhealthy and recovering, nil turns
+// logging off
+func (checker *Checker) SetLogger(logger logging.Logger) {
+	checker.mutex.Lock()
+	defer checker.mutex.Unlock()
+	checker.logger = logging.OrNop(logger)
+}
+
+// Add adds a dependency probed by check, which is unhealthy until it is first probed. Adding a
+// name again replaces its check.
+func (checker *Checker) Add(name string, check Check) {
+	checker.mutex.Lock()
+	defer checker.mutex.Unlock()
+	for i, existing := range checker.dependencies {
+		if existing.name == name {
+			checker.dependencies[i].check = check
+			return
+		}
+	}
+	checker.dependencies = append(checker.dependencies, dependency{name: name, check: check})
+	checker.statuses[name] = Status{Name: name, Error: errNotChecked.Error()}
+}
+
+// Run probes the dependencies right away and then every interval until ctx is done, returning
+// ctx's error
+func (checker *Checker) Run(ctx context.Context) error {
+	ticker := time.NewTicker(checker.interval)
+	defer ticker.Stop()
+	for {
+		checker.CheckNow(ctx)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// CheckNow probes every dependency at once and returns when they are all probed
+func (checker *Checker) CheckNow(ctx context.Context) {
+	checker.mutex.Lock()
+	dependencies := append([]dependency{}, checker.dependencies...)
+	timeout := checker.timeout
+	checker.mutex.Unlock()
+
+	var wg sync.WaitGroup
+	for _, dep := range dependencies {
+		wg.Add(1)
+		go func(dep dependency) {
+			defer wg.Done()
+			checker.record(dep.name, probe(ctx, dep.check, timeout))
+		}(dep)
+	}
+	wg.Wait()
+}
+
+// probe runs check, giving up on it after timeout even if it ignores its context
+func probe(ctx context.Context, check Check, timeout time.Duration) Status {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	started := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- check(ctx)
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+		if err == context.DeadlineExceeded {
+			err = errors.New("Timed out after " + timeout.String())
+		}
+	}
+
+	status := Status{Healthy: err == nil, Latency: time.Since(started), Checked: time.Now()}
+	if err != nil {
+		status.Error = err.Error()
+	}
+	return status
+}
+
+func (checker *Checker) record(name string, status Status) {
+	checker.mutex.Lock()
+	defer checker.mutex.Unlock()
+	previous := checker.statuses[name]
+	status.Name = name
+	checker.statuses[name] = status
+
+	if previous.Healthy && !status.Healthy {
+		checker.logger.Error("Dependency is unhealthy", "dependency", name, "error", status.Error)
+	} else if !previous.Healthy && status.Healthy {
+		checker.logger.Info("Dependency is healthy", "dependency", name, "latency", status.Latency)
+	}
+}
+
+// Statuses returns the status of every dependency, in the order they were added
+func (checker *Checker) Statuses() []Status {
+	checker.mutex.Lock()
+	defer checker.mutex.Unlock()
+	statuses := make([]Status, 0, len(checker.dependencies))
+	for _, dep := range checker.dependencies {
+		statuses = append(statuses, checker.statuses[dep.name])
+	}
+	return statuses
+}
+
+// Healthy reports whether every dependency was healthy when last probed
+func (checker *Checker) Healthy() bool {
+	for _, status := range checker.Statuses() {
+		if !status.Healthy {
+			return false
+		}
+	}
+	return true
+}
+
+// report is the body the checker serves
+type report struct {
+	Status       string             `json:"status"`
+	Dependencies []dependencyReport `json:"dependencies"`
+}
+
+type dependencyReport struct {
+	Status
+	// LatencyMs is the latency in milliseconds
+	LatencyMs float64 `json:"latencyMs"`
+}
+
+// ServeHTTP serves the status of every dependency as JSON, answering 503 if any is unhealthy
+func (checker *Checker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body := report{Status: "ok", Dependencies: []dependencyReport{}}
+	code := http.StatusOK
+	for _, status := range checker.Statuses() {
+		if !status.Healthy {
+			body.Status = "unavailable"
+			code = http.StatusServiceUnavailable
+		}
+		body.Dependencies = append(body.Dependencies, dependencyReport{Status: status, LatencyMs: float64(status.Latency) / float64(time.Millisecond)})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(code)
+	if r.Method != http.MethodHead {
+		json.NewEncoder(w).Encode(body)
+	}
+}
@@ -0,0 +1,376 @@
+// Package httpclient is the HTTP plumbing shared by the fleet, docker, etcd and consul clients:
+// building requests, encoding queries and bodies, timeouts, proxies, TLS, default headers such as auth
+// tokens, retries with exponential backoff, turning error responses into errors and shutting clients
+// down.
+package httpclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rarmstrong73/go-utils/apierror"
+	"github.com/rarmstrong73/go-utils/breaker"
+	"github.com/rarmstrong73/go-utils/cache"
+	"github.com/rarmstrong73/go-utils/proxy"
+	"github.com/rarmstrong73/go-utils/ratelimit"
+	"github.com/rarmstrong73/go-utils/retry"
+	"github.com/rarmstrong73/go-utils/timeouts"
+	"github.com/rarmstrong73/go-utils/tracing"
+)
+
+// Options configure a Client, the zero value makes a client with the default timeouts and no
+// retries
+type Options struct {
+	// Timeout limits each attempt, including reading the response body. Clients that make
+	// long polling requests should leave it 0 and use contexts instead.
+	Timeout time.Duration
+	// Timeouts are the client's connect, read and operation timeouts, the defaults of the
+	// timeouts package when nil
+	Timeouts *timeouts.Timeouts
+	// Proxy is the proxy requests go through, the default of the proxy package when nil
+	Proxy proxy.Func
+	// TLS configures https connections, see LoadTLS
+	TLS *tls.Config
+	// Header is sent with every request unless the request sets the same header, such as an
+	// Authorization or X-Consul-Token header
+	Header http.Header
+	// Retry is how Do retries failed requests, the zero value never retries
+	Retry retry.Policy
+	// Service names the backend in Observations, such as etcd
+	Service string
+	// Endpoint names the API endpoint of a request path in Observations, it should drop keys,
+	// names and IDs so the result can be used as a metric label
+	Endpoint func(path string) string
+	// Limiter is waited on before sending each attempt, none when nil
+	Limiter ratelimit.Limiter
+	// Breaker is consulted around each attempt, none when nil
+	Breaker breaker.Breaker
+	// Cache is what requests are sent through, none when nil
+	Cache cache.Cache
+	// DecodeError turns the status code and body of a non 2xx response into an error for
+	// CheckResponse, defaulting to a *StatusError
+	DecodeError func(statusCode int, body []byte) error
+
+	// lifecycle is shared by the clients made from the same options, see Shutdown
+	lifecycle *lifecycle
+}
+
+// Client sends requests built from Requests. Its options can be changed with Configure while it
+// is in use.
+type Client struct {
+	// mutex serializes Configure, requests read settings without it
+	mutex     sync.Mutex
+	settings  atomic.Value
+	lifecycle *lifecycle
+}
+
+// settings are what a client sends requests with, replaced as a whole by Configure
+type settings struct {
+	options     Options
+	httpClient  *http.Client
+	decodeError func(statusCode int, body []byte) error
+}
+
+// New returns a client configured by options. Clients made from the options of another client,
+// such as to change its timeouts, are shut down along with it.
+func New(options Options) *Client {
+	if options.lifecycle == nil {
+		options.lifecycle = newLifecycle()
+	}
+	client := &Client{lifecycle: options.lifecycle}
+	client.settings.Store(newSettings(options, NewHTTPClient(options.Timeout, options.TLS)))
+	return client
+}
+
+func newSettings(options Options, httpClient *http.Client) *settings {
+	decodeError := options.DecodeError
+	if decodeError == nil {
+		decodeError = func(statusCode int, body []byte) error {
+			return &StatusError{StatusCode: statusCode, Body: strings.TrimSpace(string(body))}
+		}
+	}
+	return &settings{options: options, httpClient: httpClient, decodeError: decodeError}
+}
+
+func (client *Client) current() *settings {
+	return client.settings.Load().(*settings)
+}
+
+// Options returns the options the client was made with, to make a client differing in some of
+// them
+func (client *Client) Options() Options {
+	return client.current().options
+}
+
+// Configure changes the client's options with change, for setters such as SetRateLimiter. It is
+// safe to call while the client sends requests. Connections are kept unless the timeout or TLS
+// configuration changes.
+func (client *Client) Configure(change func(options *Options)) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	previous := client.current()
+	options := previous.options
+	change(&options)
+	options.lifecycle = client.lifecycle
+	httpClient := previous.httpClient
+	if options.Timeout != previous.options.Timeout || options.TLS != previous.options.TLS {
+		httpClient = NewHTTPClient(options.Timeout, options.TLS)
+	}
+	client.settings.Store(newSettings(options, httpClient))
+}
+
+// NewHTTPClient returns an *http.Client with the given timeout, connecting over https with
+// tlsConfig when it isn't nil. Its connections are dialed within the default connect timeout.
+func NewHTTPClient(timeout time.Duration, tlsConfig *tls.Config) *http.Client {
+	httpClient := &http.Client{Timeout: timeout, Transport: sharedTransport}
+	if tlsConfig != nil {
+		httpClient.Transport = newTransport(tlsConfig)
+	}
+	return httpClient
+}
+
+// Request describes a request to send, see Client.NewRequest
+type Request struct {
+	Method string
+	// URL is the scheme and host, such as http://127.0.0.1:2379, with an optional base path
+	URL string
+	// Path is appended to URL, and may carry a query of its own
+	Path  string
+	Query url.Values
+	// Header is sent along with the client's headers, replacing those of the same name
+	Header      http.Header
+	ContentType string
+	// Body is sent as the request body, see JSONBody and FormBody
+	Body []byte
+	// BodyReader streams the body instead of Body, requests with one can't be retried
+	BodyReader io.Reader
+}
+
+// JSONBody returns a request with value encoded as JSON as its body
+func (request Request) JSONBody(value interface{}) (Request, error) {
+	body, err := json.Marshal(value)
+	if err != nil {
+		return request, err
+	}
+	request.Body = body
+	request.ContentType = "application/json"
+	return request, nil
+}
+
+// FormBody returns a request with values form encoded as its body
+func (request Request) FormBody(values url.Values) Request {
+	request.Body = []byte(values.Encode())
+	request.ContentType = "application/x-www-form-urlencoded"
+	return request
+}
+
+// Operation returns the request's method and path, without the scheme, host or query
+func (request Request) Operation() string {
+	parsed, err := url.Parse(request.url())
+	if err != nil {
+		return request.Method + " " + request.Path
+	}
+	return request.Method + " " + parsed.Path
+}
+
+func (request Request) url() string {
+	requestURL := strings.TrimSuffix(request.URL, "/") + request.Path
+	if encoded := request.Query.Encode(); encoded != "" {
+		separator := "?"
+		if strings.Contains(requestURL, "?") {
+			separator = "&"
+		}
+		requestURL += separator + encoded
+	}
+	return requestURL
+}
+
+// NewRequest builds the *http.Request for request, made with ctx
+func (client *Client) NewRequest(ctx context.Context, request Request) (*http.Request, error) {
+	body := request.BodyReader
+	if body == nil {
+		body = bytes.NewReader(request.Body)
+	}
+
+	httpRequest, err := http.NewRequest(request.Method, request.url(), body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range client.current().options.Header {
+		httpRequest.Header[name] = append([]string{}, values...)
+	}
+	for name, values := range request.Header {
+		httpRequest.Header[name] = append([]string{}, values...)
+	}
+	if request.ContentType != "" {
+		httpRequest.Header.Set("Content-Type", request.ContentType)
+	}
+	return httpRequest.WithContext(ctx), nil
+}
+
+// Send sends a single attempt of an already built request, unless the cache has its response
+func (client *Client) Send(request *http.Request) (*http.Response, error) {
+	if client.lifecycle.isClosed() {
+		return nil, apierror.ErrClosed
+	}
+	options := client.current().options
+	endpoint := request.URL.Path
+	if options.Endpoint != nil {
+		endpoint = options.Endpoint(request.URL.Path)
+	}
+	if options.Cache != nil {
+		return options.Cache.Do(request, endpoint, func(request *http.Request) (*http.Response, error) {
+			return client.send(request, endpoint)
+		})
+	}
+	return client.send(request, endpoint)
+}
+
+// send sends a request as one of the client's requests in flight, so shutting the client down
+// waits for it and cancels it
+func (client *Client) send(request *http.Request, endpoint string) (*http.Response, error) {
+	parent := request.Context()
+	ctx, done, err := client.lifecycle.begin(parent)
+	if err != nil {
+		return nil, err
+	}
+	request = request.WithContext(ctx)
+	response, err := client.observedSend(request, endpoint)
+	if response == nil {
+		// done cancels ctx, so whether the shutdown cancelled the request is decided first
+		err = closedError(parent, ctx, err)
+		done()
+		return nil, err
+	}
+	response.Body = &trackedBody{ReadCloser: response.Body, parent: parent, ctx: ctx, done: done}
+	return response, closedError(parent, ctx, err)
+}
+
+// observedSend sends a request in a span once the limiter and breaker allow it, reporting it to
+// the observer
+func (client *Client) observedSend(request *http.Request, endpoint string) (*http.Response, error) {
+	options := client.current().options
+	span := tracing.Start(request.Context(), tracing.SpanInfo{
+		Service:   options.Service,
+		Operation: endpoint,
+		Method:    request.Method,
+		Host:      request.URL.Host,
+	}, request.Header)
+
+	if options.Limiter != nil {
+		if err := options.Limiter.Wait(request.Context(), request.URL.Host, endpoint); err != nil {
+			span.End(0, err)
+			return nil, err
+		}
+	}
+	if options.Breaker != nil {
+		if err := options.Breaker.Allow(request.URL.Host, endpoint); err != nil {
+			span.End(0, err)
+			return nil, err
+		}
+	}
+
+	started := time.Now()
+	response, err := client.roundTrip(request)
+	duration := time.Since(started)
+
+	statusCode := 0
+	if response != nil {
+		statusCode = response.StatusCode
+	}
+	span.End(statusCode, err)
+	if options.Breaker != nil {
+		options.Breaker.Done(request.URL.Host, endpoint, statusCode, err, duration)
+	}
+	if observe, ok := observer.Load().(func(Observation)); ok && observe != nil {
+		observe(Observation{
+			Service:    options.Service,
+			Endpoint:   endpoint,
+			Method:     request.Method,
+			StatusCode: statusCode,
+			Err:        err,
+			Duration:   duration,
+		})
+	}
+	return response, err
+}
+
+// Do sends the request, retrying it according to the client's retry policy within its operation
+// timeout. The caller must close the response's body.
+func (client *Client) Do(ctx context.Context, request Request) (*http.Response, error) {
+	policy := client.current().options.Retry
+	if request.BodyReader != nil {
+		policy.MaxAttempts = 1
+	}
+	ctx, release := client.Deadline(ctx)
+	response, err := Retry(ctx, policy, func(int) (*http.Response, error) {
+		httpRequest, err := client.NewRequest(ctx, request)
+		if err != nil {
+			return nil, err
+		}
+		return client.Send(httpRequest)
+	})
+	return ReleaseOnClose(response, release), err
+}
+
+// DoJSON sends the request and decodes the JSON response into result unless it is nil, returning
+// the error from CheckResponse for non 2xx responses
+func (client *Client) DoJSON(ctx context.Context, request Request, result interface{}) error {
+	response, err := client.Do(ctx, request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if err := client.CheckResponse(response); err != nil || result == nil {
+		return err
+	}
+	return DecodeJSON(response, result)
+}
+
+// CheckResponse returns nil for a 2xx response, and otherwise reads the body and returns the
+// error made by the client's DecodeError
+func (client *Client) CheckResponse(response *http.Response) error {
+	return CheckStatus(response, client.current().decodeError)
+}
+
+// CheckStatus returns nil for a 2xx response, and otherwise reads the body and returns the error
+// made by decodeError
+func CheckStatus(response *http.Response, decodeError func(statusCode int, body []byte) error) error {
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return nil
+	}
+	body, _ := ioutil.ReadAll(response.Body)
+	return decodeError(response.StatusCode, body)
+}
+
+// DecodeJSON reads the response's body into result
+func DecodeJSON(response *http.Response, result interface{}) error {
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, result)
+}
+
+// StatusError is the default error for a non 2xx response
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%d: %s", e.StatusCode, e.Body)
+}
@@ -0,0 +1,24 @@
+package httpclient
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Observation describes a single request attempt made by any client
+type Observation struct {
+	Service    string
+	Endpoint   string
+	Method     string
+	StatusCode int
+	Err        error
+	Duration   time.Duration
+}
+
+var observer atomic.Value
+
+// SetObserver sets the function every request attempt of every client is reported to, nil stops
+// reporting. It must not block.
+func SetObserver(observe func(Observation)) {
+	observer.Store(observe)
+}
@@ -0,0 +1,48 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/rarmstrong73/go-utils/apierror"
+	"github.com/rarmstrong73/go-utils/breaker"
+	"github.com/rarmstrong73/go-utils/retry"
+)
+
+// Retry calls attempt, numbering the attempts from 0, until it succeeds or fails in a way the
+// policy doesn't retry, the policy allows no more attempts or ctx is done. The response and
+// error of the last attempt are returned, the responses of retried attempts are closed. Attempts
+// refused by a circuit breaker are retried without backing off, attempts of a closed client
+// aren't retried.
+func Retry(ctx context.Context, policy retry.Policy, attempt func(attempt int) (*http.Response, error)) (*http.Response, error) {
+	started := time.Now()
+	retries := 0
+	for number := 0; ; number++ {
+		response, err := attempt(number)
+		if !policy.Retryable(response, err) || ctx.Err() != nil || errors.Is(err, apierror.ErrClosed) {
+			return response, err
+		}
+
+		// Nothing was sent when the breaker refused the attempt, move straight on to the next
+		// one, which may go to another host
+		backoff := time.Duration(0)
+		if !errors.Is(err, breaker.ErrOpen) {
+			backoff = policy.Backoff(retries)
+		}
+		if !policy.Allows(number+1, started, backoff) {
+			return response, err
+		}
+		if response != nil {
+			response.Body.Close()
+		}
+		if backoff == 0 {
+			continue
+		}
+		if err := retry.Sleep(ctx, backoff); err != nil {
+			return nil, err
+		}
+		retries++
+	}
+}
@@ -0,0 +1,162 @@
+package httpclient
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/rarmstrong73/go-utils/apierror"
+)
+
+// lifecycle tracks the requests in flight of a client and of the clients made from its options,
+// so they are shut down together
+type lifecycle struct {
+	mutex   sync.Mutex
+	closed  bool
+	active  int
+	drained chan struct{}
+
+	// streams is cancelled as soon as shutdown starts, requests once it gives up waiting
+	streams     context.Context
+	stopStreams context.CancelFunc
+	requests    context.Context
+	abort       context.CancelFunc
+}
+
+func newLifecycle() *lifecycle {
+	requests, abort := context.WithCancel(context.Background())
+	streams, stopStreams := context.WithCancel(requests)
+	return &lifecycle{
+		drained:     make(chan struct{}),
+		streams:     streams,
+		stopStreams: stopStreams,
+		requests:    requests,
+		abort:       abort,
+	}
+}
+
+func (l *lifecycle) isClosed() bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.closed
+}
+
+// begin registers a request about to be sent with ctx, returning ctx cancelled when the shutdown
+// cancels the request and the function to call once the request is done
+func (l *lifecycle) begin(ctx context.Context) (context.Context, func(), error) {
+	l.mutex.Lock()
+	if l.closed {
+		l.mutex.Unlock()
+		return nil, nil, apierror.ErrClosed
+	}
+	l.active++
+	l.mutex.Unlock()
+
+	stopped := l.requests
+	if isStreaming(ctx) {
+		stopped = l.streams
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-stopped.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	var once sync.Once
+	return ctx, func() {
+		once.Do(func() {
+			cancel()
+			l.mutex.Lock()
+			defer l.mutex.Unlock()
+			l.active--
+			l.checkDrainedLocked()
+		})
+	}, nil
+}
+
+// closedError returns apierror.ErrClosed for a request that failed because the shutdown
+// cancelled it, ctx being its context from begin and parent the one it was sent with
+func closedError(parent, ctx context.Context, err error) error {
+	if err != nil && parent.Err() == nil && ctx.Err() != nil {
+		return apierror.ErrClosed
+	}
+	return err
+}
+
+// trackedBody is the body of a request in flight, which is done once it is closed. Reads
+// failing because the shutdown cancelled the request fail with apierror.ErrClosed.
+type trackedBody struct {
+	io.ReadCloser
+	parent, ctx context.Context
+	done        func()
+}
+
+func (body *trackedBody) Read(p []byte) (int, error) {
+	n, err := body.ReadCloser.Read(p)
+	if err == io.EOF {
+		return n, err
+	}
+	return n, closedError(body.parent, body.ctx, err)
+}
+
+func (body *trackedBody) Close() error {
+	err := body.ReadCloser.Close()
+	body.done()
+	return err
+}
+
+func (l *lifecycle) checkDrainedLocked() {
+	if l.closed && l.active == 0 {
+		select {
+		case <-l.drained:
+		default:
+			close(l.drained)
+		}
+	}
+}
+
+// Shutdown stops the client and the clients made from its options: new requests fail with
+// apierror.ErrClosed, streams such as watches are cancelled straight away, and requests in flight
+// are given until ctx is done to finish before they are cancelled too. A request is in flight
+// until its response's body is closed. Idle connections are closed once it returns, which for
+// clients without TLS only costs the other clients sharing their transport a reconnect. It
+// returns ctx's error if requests had to be cancelled.
+func (client *Client) Shutdown(ctx context.Context) error {
+	l := client.lifecycle
+	l.mutex.Lock()
+	l.closed = true
+	l.checkDrainedLocked()
+	l.mutex.Unlock()
+	l.stopStreams()
+
+	var err error
+	select {
+	case <-l.drained:
+	case <-ctx.Done():
+		l.abort()
+		err = ctx.Err()
+	}
+	client.current().httpClient.CloseIdleConnections()
+	return err
+}
+
+// Closing returns a channel closed once the client starts shutting down, for loops waiting
+// between requests
+func (client *Client) Closing() <-chan struct{} {
+	return client.lifecycle.streams.Done()
+}
+
+// Close stops the client like Shutdown, cancelling every request in flight straight away
+func (client *Client) Close() error {
+	l := client.lifecycle
+	l.mutex.Lock()
+	l.closed = true
+	l.checkDrainedLocked()
+	l.mutex.Unlock()
+	l.abort()
+	client.current().httpClient.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,162 @@
+package httpclient
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/rarmstrong73/go-utils/proxy"
+	"github.com/rarmstrong73/go-utils/timeouts"
+)
+
+// Context keys of the limits and proxy a request is sent with
+type (
+	streamingKey struct{}
+	connectKey   struct{}
+	operationKey struct{}
+	proxyKey     struct{}
+)
+
+// sharedTransport is the transport of clients without TLS, so they share connections
+var sharedTransport = newTransport(nil)
+
+// newTransport returns a transport dialing within the connect timeout of each request, through
+// the proxy of each request
+func newTransport(tlsConfig *tls.Config) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	transport.DialContext = dial
+	transport.Proxy = proxyFor
+	return transport
+}
+
+// proxyFor returns the proxy of the client sending request, or the default one for requests
+// that carry none
+func proxyFor(request *http.Request) (*url.URL, error) {
+	proxyFunc, ok := request.Context().Value(proxyKey{}).(proxy.Func)
+	if !ok {
+		proxyFunc = proxy.Default()
+	}
+	return proxyFunc(request)
+}
+
+// Proxy returns the proxy the client sends requests through, its own or the default of the
+// proxy package
+func (client *Client) Proxy() proxy.Func {
+	if options := client.current().options; options.Proxy != nil {
+		return options.Proxy
+	}
+	return proxy.Default()
+}
+
+// dial connects to address within the connect timeout the request being sent carries, or the
+// default one for requests that carry none
+func dial(ctx context.Context, network, address string) (net.Conn, error) {
+	limit, ok := ctx.Value(connectKey{}).(time.Duration)
+	if !ok {
+		limit = timeouts.Defaults().Connect
+	}
+	dialer := net.Dialer{Timeout: limit, KeepAlive: 30 * time.Second}
+	return dialer.DialContext(ctx, network, address)
+}
+
+// Streaming returns ctx marking the requests made with it as long polls or streams, such as
+// watches, blocking queries, followed logs and snapshots, which only the connect timeout applies to
+func Streaming(ctx context.Context) context.Context {
+	return context.WithValue(ctx, streamingKey{}, true)
+}
+
+func isStreaming(ctx context.Context) bool {
+	streaming, _ := ctx.Value(streamingKey{}).(bool)
+	return streaming
+}
+
+// Timeouts returns the timeouts the client applies, its own or the defaults of the timeouts
+// package
+func (client *Client) Timeouts() timeouts.Timeouts {
+	if options := client.current().options; options.Timeouts != nil {
+		return *options.Timeouts
+	}
+	return timeouts.Defaults()
+}
+
+// Deadline returns ctx limited by the client's operation timeout, for clients that retry with
+// their own loop rather than Do, and the function releasing it once the operation is done. ctx
+// is returned unchanged for streams and when it ends sooner.
+func (client *Client) Deadline(ctx context.Context) (context.Context, context.CancelFunc) {
+	limit := client.Timeouts().Operation
+	if limit <= 0 || isStreaming(ctx) {
+		return ctx, func() {}
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= limit {
+		return ctx, func() {}
+	}
+	ctx, cancel := context.WithTimeout(ctx, limit)
+	return context.WithValue(ctx, operationKey{}, limit), cancel
+}
+
+// ReleaseOnClose returns response with a body calling release when it is closed, calling release
+// straight away when there is no response
+func ReleaseOnClose(response *http.Response, release func()) *http.Response {
+	if response == nil {
+		release()
+		return nil
+	}
+	response.Body = &releasingBody{ReadCloser: response.Body, release: release}
+	return response
+}
+
+// releasingBody calls release once it is closed
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (body *releasingBody) Close() error {
+	err := body.ReadCloser.Close()
+	body.release()
+	return err
+}
+
+// roundTrip sends a request through the client's proxy within its connect and read timeouts,
+// turning the request running out of time into a *timeouts.Error
+func (client *Client) roundTrip(request *http.Request) (*http.Response, error) {
+	httpClient := client.current().httpClient
+	limits := client.Timeouts()
+	ctx := context.WithValue(request.Context(), connectKey{}, limits.Connect)
+	ctx = context.WithValue(ctx, proxyKey{}, client.Proxy())
+	if limits.Read <= 0 || isStreaming(ctx) {
+		response, err := httpClient.Do(request.WithContext(ctx))
+		return response, operationError(ctx, err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	timer := time.AfterFunc(limits.Read, cancel)
+	response, err := httpClient.Do(request.WithContext(ctx))
+	if !timer.Stop() {
+		if response != nil {
+			response.Body.Close()
+		}
+		cancel()
+		if operationErr := operationError(ctx, err); operationErr != err {
+			return nil, operationErr
+		}
+		return nil, &timeouts.Error{Kind: timeouts.Read, Limit: limits.Read}
+	}
+	return ReleaseOnClose(response, cancel), operationError(ctx, err)
+}
+
+// operationError returns a *timeouts.Error for err when the operation's deadline set by Deadline
+// passed, and err otherwise
+func operationError(ctx context.Context, err error) error {
+	limit, ok := ctx.Value(operationKey{}).(time.Duration)
+	if err == nil || !ok || !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return err
+	}
+	return &timeouts.Error{Kind: timeouts.Operation, Limit: limit}
+}
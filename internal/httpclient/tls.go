@@ -0,0 +1,51 @@
+package httpclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+// TLSFiles names the PEM files and settings a *tls.Config is loaded from by LoadTLS
+type TLSFiles struct {
+	// CAFile is a PEM file of the certificate authorities to verify servers with, defaulting to
+	// the system's
+	CAFile string
+	// CertFile and KeyFile are a PEM client certificate and key, for servers that verify clients
+	CertFile string
+	KeyFile  string
+	// ServerName overrides the name verified against the server's certificate
+	ServerName string
+	// InsecureSkipVerify turns off verification of the server's certificate
+	InsecureSkipVerify bool
+}
+
+// LoadTLS reads the files into a *tls.Config
+func LoadTLS(files TLSFiles) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         files.ServerName,
+		InsecureSkipVerify: files.InsecureSkipVerify,
+	}
+
+	if files.CAFile != "" {
+		caBytes, err := ioutil.ReadFile(files.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caBytes) {
+			return nil, fmt.Errorf("No certificates found in %s", files.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if files.CertFile != "" || files.KeyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(files.CertFile, files.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+	return tlsConfig, nil
+}
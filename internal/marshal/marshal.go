@@ -0,0 +1,124 @@
+// Package marshal writes the exports and snapshots of the fleet, etcd and consul packages as
+// indented JSON or as YAML. YAML is written from the value's JSON encoding, so it has the same
+// field names as the JSON and is read back the same way by YAML tools.
+package marshal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// Formats
+const (
+	JSON = "json"
+	YAML = "yaml"
+)
+
+// Write writes v to w in format, JSON or YAML
+func Write(w io.Writer, format string, v interface{}) error {
+	switch format {
+	case JSON:
+		return WriteJSON(w, v)
+	case YAML:
+		return WriteYAML(w, v)
+	}
+	return fmt.Errorf("Unknown format %q", format)
+}
+
+// WriteJSON writes v to w as JSON indented by two spaces
+func WriteJSON(w io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// WriteYAML writes v to w as a YAML document. Mapping keys are sorted and strings are always
+// double quoted, so values such as "yes" or "010" stay strings.
+func WriteYAML(w io.Writer, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return err
+	}
+
+	var buffer bytes.Buffer
+	if isBlock(value) {
+		writeBlock(&buffer, value, "")
+	} else {
+		buffer.WriteString(scalar(value) + "\n")
+	}
+	_, err = w.Write(buffer.Bytes())
+	return err
+}
+
+// writeBlock writes a non empty mapping or sequence, every line starting with indent
+func writeBlock(buffer *bytes.Buffer, value interface{}, indent string) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			child := value[key]
+			if !isBlock(child) {
+				buffer.WriteString(indent + strconv.Quote(key) + ": " + scalar(child) + "\n")
+				continue
+			}
+			buffer.WriteString(indent + strconv.Quote(key) + ":\n")
+			writeBlock(buffer, child, indent+"  ")
+		}
+	case []interface{}:
+		for _, item := range value {
+			if !isBlock(item) {
+				buffer.WriteString(indent + "- " + scalar(item) + "\n")
+				continue
+			}
+			// the item's first line goes on the same line as its dash
+			var nested bytes.Buffer
+			writeBlock(&nested, item, indent+"  ")
+			buffer.WriteString(indent + "- ")
+			buffer.Write(nested.Bytes()[len(indent)+2:])
+		}
+	}
+}
+
+// isBlock reports whether a decoded value is written as an indented block rather than inline
+func isBlock(value interface{}) bool {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		return len(value) > 0
+	case []interface{}:
+		return len(value) > 0
+	}
+	return false
+}
+
+// scalar returns the inline YAML of a decoded value that isn't a block
+func scalar(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return strconv.FormatBool(value)
+	case json.Number:
+		return value.String()
+	case string:
+		return strconv.Quote(value)
+	case map[string]interface{}:
+		return "{}"
+	case []interface{}:
+		return "[]"
+	}
+	return strconv.Quote(fmt.Sprint(value))
+}
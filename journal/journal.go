@@ -0,0 +1,295 @@
+// Package journal reads the logs of cluster machines from systemd-journal-gatewayd, so the logs of
+// fleet units can be fetched with the same library that manages them:
+//
+//	entries, err := journal.GetEntries(machine.PrimaryIP, journal.Query{Unit: "web@1.service", Lines: 100})
+//
+// Follow streams new entries as they are written.
+package journal
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rarmstrong73/go-utils/apierror"
+	"github.com/rarmstrong73/go-utils/internal/httpclient"
+	"github.com/rarmstrong73/go-utils/logging"
+)
+
+var port = 19531
+
+var httpClient = httpclient.New(httpclient.Options{Service: apierror.Journal, Endpoint: endpointName})
+
+// logger is where the package logs, set with SetLogger
+var logger = &logging.Swappable{}
+
+// SetLogger sets where the package logs, nil turns logging off
+func SetLogger(l logging.Logger) {
+	logger.Set(l)
+}
+
+// Shutdown stops the package: new requests fail with apierror.ErrClosed, followed journals stop
+// straight away and requests in flight are given until ctx is done to finish before they are
+// cancelled. It returns ctx's error if requests had to be cancelled.
+func Shutdown(ctx context.Context) error {
+	return httpClient.Shutdown(ctx)
+}
+
+// Close stops the package like Shutdown, cancelling requests in flight straight away
+func Close() error {
+	return httpClient.Close()
+}
+
+// Syslog priorities, most severe first
+var priorities = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+// Entry is a journal entry
+type Entry struct {
+	Cursor   string
+	Time     time.Time
+	Hostname string
+	Unit     string
+	// Priority is the entry's syslog priority, 0 for emerg to 7 for debug
+	Priority int
+	Message  string
+	// Fields are all of the entry's fields, such as _PID or CONTAINER_NAME
+	Fields map[string]string
+}
+
+// Query selects journal entries
+type Query struct {
+	// Unit only selects the entries of a systemd unit, such as a fleet unit's name
+	Unit string
+	// Since only selects entries written at or after Since
+	Since time.Time
+	// Priority only selects entries this severe or more, one of emerg, alert, crit, err,
+	// warning, notice, info or debug
+	Priority string
+	// Lines only selects the last Lines entries
+	Lines int
+	// Cursor only selects the entries after the entry with this cursor, to resume reading
+	Cursor string
+	// Boot only selects the entries of the current boot
+	Boot bool
+	// Matches only selects entries whose fields have these values
+	Matches map[string]string
+}
+
+// GetEntries returns the entries of the host's journal selected by query, oldest first
+func GetEntries(host string, query Query) ([]Entry, error) {
+	request, err := newRequest(host, query, false)
+	if err != nil {
+		return nil, err
+	}
+	response, err := httpClient.Do(context.Background(), request)
+	if err != nil {
+		return nil, apierror.Transport(apierror.Journal, request.Operation(), err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, responseError(response)
+	}
+
+	entries := []Entry{}
+	scanner := newScanner(response)
+	for scanner.Scan() {
+		entry, ok, err := parseEntry(scanner.Bytes(), query)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// Follow calls handle with the entries of the host's journal selected by query, then with every
+// new entry as it is written, until ctx is done or the stream fails. It returns ctx's error once
+// ctx is done.
+func Follow(ctx context.Context, host string, query Query, handle func(Entry)) error {
+	request, err := newRequest(host, query, true)
+	if err != nil {
+		return err
+	}
+	httpRequest, err := httpClient.NewRequest(httpclient.Streaming(ctx), request)
+	if err != nil {
+		return err
+	}
+	response, err := httpClient.Send(httpRequest)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return apierror.Transport(apierror.Journal, request.Operation(), err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return responseError(response)
+	}
+	logger.Debug("Following journal", "host", host, "unit", query.Unit)
+
+	scanner := newScanner(response)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if !strings.HasPrefix(string(line), "data:") {
+			continue
+		}
+		entry, ok, err := parseEntry([]byte(strings.TrimSpace(strings.TrimPrefix(string(line), "data:"))), query)
+		if err != nil {
+			return err
+		}
+		if ok {
+			handle(entry)
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err := scanner.Err(); err != nil {
+		return apierror.Transport(apierror.Journal, request.Operation(), err)
+	}
+	return nil
+}
+
+// newRequest builds the request for the entries selected by query
+func newRequest(host string, query Query, follow bool) (httpclient.Request, error) {
+	values := url.Values{}
+	if query.Unit != "" {
+		values.Add("_SYSTEMD_UNIT", query.Unit)
+	}
+	for field, value := range query.Matches {
+		values.Add(field, value)
+	}
+	if query.Priority != "" {
+		max := -1
+		for i, name := range priorities {
+			if name == query.Priority {
+				max = i
+			}
+		}
+		if max < 0 {
+			return httpclient.Request{}, fmt.Errorf("Unknown priority %q", query.Priority)
+		}
+		for i := 0; i <= max; i++ {
+			values.Add("PRIORITY", strconv.Itoa(i))
+		}
+	}
+	if query.Boot {
+		values.Set("boot", "true")
+	}
+
+	header := http.Header{"Accept": {"application/json"}}
+	if follow {
+		values.Set("follow", "true")
+		header.Set("Accept", "text/event-stream")
+	}
+	switch {
+	case query.Cursor != "" || query.Lines > 0:
+		entries := "entries=" + query.Cursor
+		switch {
+		case query.Cursor != "" && query.Lines > 0:
+			entries += fmt.Sprintf(":1:%d", query.Lines)
+		case query.Cursor != "":
+			entries += ":1:"
+		default:
+			entries += fmt.Sprintf(":-%d:%d", query.Lines, query.Lines)
+		}
+		header.Set("Range", entries)
+	case !query.Since.IsZero():
+		header.Set("Range", fmt.Sprintf("realtime=%d:", query.Since.Unix()))
+	}
+
+	return httpclient.Request{
+		Method: http.MethodGet,
+		URL:    baseURL(host),
+		Path:   "/entries",
+		Query:  values,
+		Header: header,
+	}, nil
+}
+
+// parseEntry parses an entry in gatewayd's JSON format, reporting false for entries before the
+// query's Since
+func parseEntry(line []byte, query Query) (Entry, bool, error) {
+	if len(strings.TrimSpace(string(line))) == 0 {
+		return Entry{}, false, nil
+	}
+	var raw map[string]interface{}
+	if err := json.Unmarshal(line, &raw); err != nil {
+		return Entry{}, false, err
+	}
+
+	entry := Entry{Fields: map[string]string{}}
+	for field, value := range raw {
+		entry.Fields[field] = fieldValue(value)
+	}
+	entry.Cursor = entry.Fields["__CURSOR"]
+	entry.Hostname = entry.Fields["_HOSTNAME"]
+	entry.Unit = entry.Fields["_SYSTEMD_UNIT"]
+	entry.Message = entry.Fields["MESSAGE"]
+	entry.Priority, _ = strconv.Atoi(entry.Fields["PRIORITY"])
+	if micros, err := strconv.ParseInt(entry.Fields["__REALTIME_TIMESTAMP"], 10, 64); err == nil {
+		entry.Time = time.Unix(0, micros*int64(time.Microsecond))
+	}
+	return entry, query.Since.IsZero() || !entry.Time.Before(query.Since), nil
+}
+
+// fieldValue converts a field's JSON value to a string. Binary values are arrays of bytes and
+// fields with several values are arrays of their values, of which the first is kept.
+func fieldValue(value interface{}) string {
+	switch value := value.(type) {
+	case string:
+		return value
+	case []interface{}:
+		if len(value) == 0 {
+			return ""
+		}
+		if _, ok := value[0].(float64); !ok {
+			return fieldValue(value[0])
+		}
+		bytes := make([]byte, 0, len(value))
+		for _, b := range value {
+			number, _ := b.(float64)
+			bytes = append(bytes, byte(number))
+		}
+		return string(bytes)
+	case nil:
+		return ""
+	}
+	return fmt.Sprint(value)
+}
+
+// baseURL returns the URL of gatewayd on host, which listens on the default port unless host has
+// one
+func baseURL(host string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return "http://" + host
+	}
+	return fmt.Sprintf("http://%s:%d", host, port)
+}
+
+// endpointName names a request path for observations, such as entries
+func endpointName(path string) string {
+	return strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)[0]
+}
+
+// responseError returns an *apierror.Error for a failed response
+func responseError(response *http.Response) error {
+	body, _ := ioutil.ReadAll(response.Body)
+	return apierror.FromResponse(apierror.Journal, response, "", strings.TrimSpace(string(body)), nil)
+}
+
+func newScanner(response *http.Response) *bufio.Scanner {
+	scanner := bufio.NewScanner(response.Body)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	return scanner
+}
@@ -0,0 +1,263 @@
+// Package kubernetes is a minimal client of the Kubernetes API server for pods, services and
+// nodes, so tooling managing fleet units can manage the workloads moved to Kubernetes the same
+// way:
+//
+//	client, err := kubernetes.NewClient(kubernetes.Config{Server: "https://10.0.0.1:6443", TokenFile: path, CAFile: ca})
+//	pods, err := client.ListPods("default", "app=web")
+//
+// Inside a pod, InClusterConfig configures the client from the pod's service account.
+package kubernetes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/rarmstrong73/go-utils/apierror"
+	"github.com/rarmstrong73/go-utils/internal/httpclient"
+	"github.com/rarmstrong73/go-utils/logging"
+	"github.com/rarmstrong73/go-utils/proxy"
+)
+
+// serviceAccountDir is where the service account of a pod is mounted
+var serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// pageSize is how many objects a list asks for at a time
+var pageSize = 500
+
+// Config describes an API server and how to authenticate with it
+type Config struct {
+	// Server is the API server's URL, such as https://10.0.0.1:6443
+	Server string
+	// Token is a bearer token, such as a service account's token
+	Token string
+	// TokenFile is a file the bearer token is read from, used when Token is empty
+	TokenFile string
+	// CAFile is a PEM file of the certificate authorities to verify the API server with
+	CAFile string
+	// CertFile and KeyFile are a PEM client certificate and key to authenticate with
+	CertFile string
+	KeyFile  string
+	// InsecureSkipVerify turns off verification of the API server's certificate
+	InsecureSkipVerify bool
+	// Proxy is the proxy requests go through, the default of the proxy package when nil
+	Proxy proxy.Func
+}
+
+// InClusterConfig returns the config of a client running in a pod, authenticating as the pod's
+// service account
+func InClusterConfig() (Config, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return Config{}, fmt.Errorf("Not running in a Kubernetes pod, KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT are not set")
+	}
+	return Config{
+		Server:    "https://" + net.JoinHostPort(host, port),
+		TokenFile: serviceAccountDir + "/token",
+		CAFile:    serviceAccountDir + "/ca.crt",
+	}, nil
+}
+
+// Status is the object the API server returns describing a failed request
+type Status struct {
+	Kind    string `json:"kind"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+	Reason  string `json:"reason"`
+	Code    int    `json:"code"`
+}
+
+// Client is a connection to an API server
+type Client struct {
+	config     Config
+	httpClient *httpclient.Client
+	logger     logging.Logger
+}
+
+// NewClient returns a client for the API server described by config, failing if its token or
+// certificate files can't be read
+func NewClient(config Config) (*Client, error) {
+	token := config.Token
+	if token == "" && config.TokenFile != "" {
+		tokenBytes, err := ioutil.ReadFile(config.TokenFile)
+		if err != nil {
+			return nil, err
+		}
+		token = strings.TrimSpace(string(tokenBytes))
+	}
+
+	options := httpclient.Options{Service: apierror.Kubernetes, Endpoint: endpointName, Proxy: config.Proxy}
+	if strings.HasPrefix(config.Server, "https://") {
+		tlsConfig, err := httpclient.LoadTLS(httpclient.TLSFiles{
+			CAFile:             config.CAFile,
+			CertFile:           config.CertFile,
+			KeyFile:            config.KeyFile,
+			InsecureSkipVerify: config.InsecureSkipVerify,
+		})
+		if err != nil {
+			return nil, err
+		}
+		options.TLS = tlsConfig
+	}
+	if token != "" {
+		options.Header = http.Header{"Authorization": {"Bearer " + token}}
+	}
+
+	return &Client{
+		config:     config,
+		httpClient: httpclient.New(options),
+		logger:     logging.Nop,
+	}, nil
+}
+
+// SetLogger sets where the client logs, nil turns logging off
+func (client *Client) SetLogger(logger logging.Logger) {
+	client.logger = logging.OrNop(logger)
+}
+
+// Shutdown stops the client: new requests fail with apierror.ErrClosed and requests in flight
+// are given until ctx is done to finish before they are cancelled. It returns ctx's error if
+// requests had to be cancelled.
+func (client *Client) Shutdown(ctx context.Context) error {
+	return client.httpClient.Shutdown(ctx)
+}
+
+// Close stops the client like Shutdown, cancelling requests in flight straight away
+func (client *Client) Close() error {
+	return client.httpClient.Close()
+}
+
+// get gets the object at path into result
+func (client *Client) get(path string, result interface{}) error {
+	response, err := client.do(http.MethodGet, path, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return handleError(response)
+	}
+	return httpclient.DecodeJSON(response, result)
+}
+
+// list gets every object of a list at path matching selector, a page at a time, calling page
+// with the items of each page
+func (client *Client) list(path, selector string, page func(items json.RawMessage) error) error {
+	query := url.Values{"limit": {fmt.Sprint(pageSize)}}
+	if selector != "" {
+		query.Set("labelSelector", selector)
+	}
+	for {
+		response, err := client.do(http.MethodGet, path, query, nil)
+		if err != nil {
+			return err
+		}
+		var list struct {
+			Items    json.RawMessage `json:"items"`
+			Metadata struct {
+				Continue string `json:"continue"`
+			} `json:"metadata"`
+		}
+		if response.StatusCode != http.StatusOK {
+			err = handleError(response)
+		} else {
+			err = httpclient.DecodeJSON(response, &list)
+		}
+		response.Body.Close()
+		if err != nil {
+			return err
+		}
+		if err := page(list.Items); err != nil {
+			return err
+		}
+		if list.Metadata.Continue == "" {
+			return nil
+		}
+		query.Set("continue", list.Metadata.Continue)
+	}
+}
+
+// create posts object to path, decoding the created object into result
+func (client *Client) create(path string, object, result interface{}) error {
+	body, err := json.Marshal(object)
+	if err != nil {
+		return err
+	}
+	response, err := client.do(http.MethodPost, path, nil, body)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusCreated && response.StatusCode != http.StatusOK && response.StatusCode != http.StatusAccepted {
+		return handleError(response)
+	}
+	return httpclient.DecodeJSON(response, result)
+}
+
+// delete deletes the object at path
+func (client *Client) delete(path string) error {
+	response, err := client.do(http.MethodDelete, path, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK && response.StatusCode != http.StatusAccepted {
+		return handleError(response)
+	}
+	return nil
+}
+
+// endpointName names a request path for observations by its resource, such as pods
+func endpointName(path string) string {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	// api/v1/namespaces/<namespace>/<resource>/<name> or api/v1/<resource>/<name>
+	if len(segments) >= 5 && segments[2] == "namespaces" {
+		return segments[4]
+	}
+	if len(segments) >= 3 {
+		return segments[2]
+	}
+	return strings.Join(segments, "/")
+}
+
+// handleError returns an *apierror.Error with the Status in the body of a failed response
+func handleError(response *http.Response) error {
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	var status Status
+	if err := json.Unmarshal(body, &status); err != nil || status.Message == "" {
+		return apierror.FromResponse(apierror.Kubernetes, response, "", strings.TrimSpace(string(body)), nil)
+	}
+	return apierror.FromResponse(apierror.Kubernetes, response, status.Reason, status.Message, nil)
+}
+
+// ============================================================================
+// ============================= HTTP UTILS ===================================
+// ============================================================================
+
+func (client *Client) do(method, path string, query url.Values, body []byte) (*http.Response, error) {
+	request := httpclient.Request{
+		Method: method,
+		URL:    strings.TrimSuffix(client.config.Server, "/"),
+		Path:   path,
+		Query:  query,
+		Header: http.Header{"Accept": {"application/json"}},
+	}
+	if body != nil {
+		request.Body = body
+		request.ContentType = "application/json"
+	}
+	response, err := client.httpClient.Do(context.Background(), request)
+	if err != nil {
+		return nil, apierror.Transport(apierror.Kubernetes, request.Operation(), err)
+	}
+	return response, nil
+}
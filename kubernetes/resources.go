@@ -0,0 +1,122 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"net/url"
+)
+
+// ListPods returns the pods in a namespace, in every namespace when namespace is empty, matching
+// the label selector, such as app=web, unless it is empty
+func (client *Client) ListPods(namespace, selector string) ([]Pod, error) {
+	pods := []Pod{}
+	err := client.list(namespacedPath(namespace, "pods", ""), selector, func(items json.RawMessage) error {
+		var page []Pod
+		if err := json.Unmarshal(items, &page); err != nil {
+			return err
+		}
+		pods = append(pods, page...)
+		return nil
+	})
+	return pods, err
+}
+
+// GetPod returns a pod
+func (client *Client) GetPod(namespace, name string) (Pod, error) {
+	var pod Pod
+	err := client.get(namespacedPath(namespace, "pods", name), &pod)
+	return pod, err
+}
+
+// CreatePod creates a pod in a namespace, returning it as created
+func (client *Client) CreatePod(namespace string, pod Pod) (Pod, error) {
+	var created Pod
+	err := client.create(namespacedPath(namespace, "pods", ""), pod, &created)
+	if err == nil {
+		client.logger.Info("Created pod", "namespace", namespace, "pod", created.Metadata.Name)
+	}
+	return created, err
+}
+
+// DeletePod deletes a pod, which terminates gracefully
+func (client *Client) DeletePod(namespace, name string) error {
+	err := client.delete(namespacedPath(namespace, "pods", name))
+	if err == nil {
+		client.logger.Info("Deleted pod", "namespace", namespace, "pod", name)
+	}
+	return err
+}
+
+// ListServices returns the services in a namespace, in every namespace when namespace is empty,
+// matching the label selector unless it is empty
+func (client *Client) ListServices(namespace, selector string) ([]Service, error) {
+	services := []Service{}
+	err := client.list(namespacedPath(namespace, "services", ""), selector, func(items json.RawMessage) error {
+		var page []Service
+		if err := json.Unmarshal(items, &page); err != nil {
+			return err
+		}
+		services = append(services, page...)
+		return nil
+	})
+	return services, err
+}
+
+// GetService returns a service
+func (client *Client) GetService(namespace, name string) (Service, error) {
+	var service Service
+	err := client.get(namespacedPath(namespace, "services", name), &service)
+	return service, err
+}
+
+// CreateService creates a service in a namespace, returning it as created
+func (client *Client) CreateService(namespace string, service Service) (Service, error) {
+	var created Service
+	err := client.create(namespacedPath(namespace, "services", ""), service, &created)
+	if err == nil {
+		client.logger.Info("Created service", "namespace", namespace, "service", created.Metadata.Name)
+	}
+	return created, err
+}
+
+// DeleteService deletes a service
+func (client *Client) DeleteService(namespace, name string) error {
+	err := client.delete(namespacedPath(namespace, "services", name))
+	if err == nil {
+		client.logger.Info("Deleted service", "namespace", namespace, "service", name)
+	}
+	return err
+}
+
+// ListNodes returns the nodes matching the label selector unless it is empty
+func (client *Client) ListNodes(selector string) ([]Node, error) {
+	nodes := []Node{}
+	err := client.list("/api/v1/nodes", selector, func(items json.RawMessage) error {
+		var page []Node
+		if err := json.Unmarshal(items, &page); err != nil {
+			return err
+		}
+		nodes = append(nodes, page...)
+		return nil
+	})
+	return nodes, err
+}
+
+// GetNode returns a node
+func (client *Client) GetNode(name string) (Node, error) {
+	var node Node
+	err := client.get("/api/v1/nodes/"+url.PathEscape(name), &node)
+	return node, err
+}
+
+// namespacedPath returns the path of a resource in a namespace, of the resource in every
+// namespace when namespace is empty, or of a single object when name isn't empty
+func namespacedPath(namespace, resource, name string) string {
+	path := "/api/v1/" + resource
+	if namespace != "" {
+		path = "/api/v1/namespaces/" + url.PathEscape(namespace) + "/" + resource
+	}
+	if name != "" {
+		path += "/" + url.PathEscape(name)
+	}
+	return path
+}
@@ -0,0 +1,206 @@
+package kubernetes
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// ObjectMeta is the metadata every object has
+type ObjectMeta struct {
+	Name              string            `json:"name,omitempty"`
+	Namespace         string            `json:"namespace,omitempty"`
+	UID               string            `json:"uid,omitempty"`
+	ResourceVersion   string            `json:"resourceVersion,omitempty"`
+	CreationTimestamp string            `json:"creationTimestamp,omitempty"`
+	DeletionTimestamp string            `json:"deletionTimestamp,omitempty"`
+	Labels            map[string]string `json:"labels,omitempty"`
+	Annotations       map[string]string `json:"annotations,omitempty"`
+}
+
+// Pod is a group of containers scheduled together on a node
+type Pod struct {
+	APIVersion string     `json:"apiVersion,omitempty"`
+	Kind       string     `json:"kind,omitempty"`
+	Metadata   ObjectMeta `json:"metadata"`
+	Spec       PodSpec    `json:"spec"`
+	Status     PodStatus  `json:"status,omitempty"`
+}
+
+// PodSpec describes a pod's containers
+type PodSpec struct {
+	Containers    []Container       `json:"containers"`
+	NodeName      string            `json:"nodeName,omitempty"`
+	NodeSelector  map[string]string `json:"nodeSelector,omitempty"`
+	RestartPolicy string            `json:"restartPolicy,omitempty"`
+}
+
+// Container is a container in a pod
+type Container struct {
+	Name    string          `json:"name"`
+	Image   string          `json:"image"`
+	Command []string        `json:"command,omitempty"`
+	Args    []string        `json:"args,omitempty"`
+	Env     []EnvVar        `json:"env,omitempty"`
+	Ports   []ContainerPort `json:"ports,omitempty"`
+}
+
+// EnvVar is an environment variable of a container
+type EnvVar struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// ContainerPort is a port a container listens on
+type ContainerPort struct {
+	Name          string `json:"name,omitempty"`
+	ContainerPort int    `json:"containerPort"`
+	Protocol      string `json:"protocol,omitempty"`
+}
+
+// PodStatus is the observed state of a pod
+type PodStatus struct {
+	// Phase is Pending, Running, Succeeded, Failed or Unknown
+	Phase             string            `json:"phase,omitempty"`
+	Message           string            `json:"message,omitempty"`
+	Reason            string            `json:"reason,omitempty"`
+	HostIP            string            `json:"hostIP,omitempty"`
+	PodIP             string            `json:"podIP,omitempty"`
+	StartTime         string            `json:"startTime,omitempty"`
+	Conditions        []Condition       `json:"conditions,omitempty"`
+	ContainerStatuses []ContainerStatus `json:"containerStatuses,omitempty"`
+}
+
+// ContainerStatus is the observed state of a container in a pod
+type ContainerStatus struct {
+	Name         string `json:"name"`
+	Image        string `json:"image"`
+	ImageID      string `json:"imageID"`
+	ContainerID  string `json:"containerID,omitempty"`
+	Ready        bool   `json:"ready"`
+	RestartCount int    `json:"restartCount"`
+}
+
+// Condition is a condition of a pod or node, such as Ready
+type Condition struct {
+	Type    string `json:"type"`
+	Status  string `json:"status"`
+	Reason  string `json:"reason,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// Service exposes a set of pods
+type Service struct {
+	APIVersion string        `json:"apiVersion,omitempty"`
+	Kind       string        `json:"kind,omitempty"`
+	Metadata   ObjectMeta    `json:"metadata"`
+	Spec       ServiceSpec   `json:"spec"`
+	Status     ServiceStatus `json:"status,omitempty"`
+}
+
+// ServiceSpec describes a service
+type ServiceSpec struct {
+	// Type is ClusterIP, NodePort, LoadBalancer or ExternalName
+	Type      string            `json:"type,omitempty"`
+	ClusterIP string            `json:"clusterIP,omitempty"`
+	Selector  map[string]string `json:"selector,omitempty"`
+	Ports     []ServicePort     `json:"ports,omitempty"`
+}
+
+// ServicePort is a port of a service
+type ServicePort struct {
+	Name       string      `json:"name,omitempty"`
+	Protocol   string      `json:"protocol,omitempty"`
+	Port       int         `json:"port"`
+	TargetPort IntOrString `json:"targetPort,omitempty"`
+	NodePort   int         `json:"nodePort,omitempty"`
+}
+
+// ServiceStatus is the observed state of a service
+type ServiceStatus struct {
+	LoadBalancer struct {
+		Ingress []struct {
+			IP       string `json:"ip,omitempty"`
+			Hostname string `json:"hostname,omitempty"`
+		} `json:"ingress,omitempty"`
+	} `json:"loadBalancer,omitempty"`
+}
+
+// IntOrString is a port given by number or by name
+type IntOrString struct {
+	Int    int
+	String string
+}
+
+// MarshalJSON encodes the port as a number unless it has a name
+func (value IntOrString) MarshalJSON() ([]byte, error) {
+	if value.String != "" {
+		return json.Marshal(value.String)
+	}
+	return json.Marshal(value.Int)
+}
+
+// UnmarshalJSON decodes a port given by number or by name
+func (value *IntOrString) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		return json.Unmarshal(data, &value.String)
+	}
+	return json.Unmarshal(data, &value.Int)
+}
+
+// Text returns the port's name or number
+func (value IntOrString) Text() string {
+	if value.String != "" {
+		return value.String
+	}
+	return strconv.Itoa(value.Int)
+}
+
+// Node is a machine pods run on
+type Node struct {
+	APIVersion string     `json:"apiVersion,omitempty"`
+	Kind       string     `json:"kind,omitempty"`
+	Metadata   ObjectMeta `json:"metadata"`
+	Spec       NodeSpec   `json:"spec"`
+	Status     NodeStatus `json:"status,omitempty"`
+}
+
+// NodeSpec describes a node
+type NodeSpec struct {
+	PodCIDR       string `json:"podCIDR,omitempty"`
+	ProviderID    string `json:"providerID,omitempty"`
+	Unschedulable bool   `json:"unschedulable,omitempty"`
+}
+
+// NodeStatus is the observed state of a node
+type NodeStatus struct {
+	Addresses   []NodeAddress     `json:"addresses,omitempty"`
+	Conditions  []Condition       `json:"conditions,omitempty"`
+	Capacity    map[string]string `json:"capacity,omitempty"`
+	Allocatable map[string]string `json:"allocatable,omitempty"`
+	NodeInfo    NodeInfo          `json:"nodeInfo,omitempty"`
+}
+
+// NodeAddress is an address of a node, of type InternalIP, ExternalIP or Hostname
+type NodeAddress struct {
+	Type    string `json:"type"`
+	Address string `json:"address"`
+}
+
+// NodeInfo describes the software a node runs
+type NodeInfo struct {
+	KernelVersion           string `json:"kernelVersion"`
+	OSImage                 string `json:"osImage"`
+	ContainerRuntimeVersion string `json:"containerRuntimeVersion"`
+	KubeletVersion          string `json:"kubeletVersion"`
+	Architecture            string `json:"architecture"`
+}
+
+// Ready reports whether the node's Ready condition is True
+func (node Node) Ready() bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == "Ready" {
+			return condition.Status == "True"
+		}
+	}
+	return false
+}
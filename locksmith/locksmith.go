@@ -0,0 +1,184 @@
+// Package locksmith reads and changes the reboot lock CoreOS machines take from etcd before
+// rebooting into an update, so maintenance tooling can coordinate rolling reboots with fleet
+// drains:
+//
+//	lock := locksmith.NewLock(etcd.NewClient(host), "")
+//	err := lock.Acquire(machine.ID)
+//	... drain the machine's units and reboot it ...
+//	err = lock.Release(machine.ID)
+//
+// The lock is the same semaphore locksmithd uses, so a machine holding it keeps locksmithd on
+// other machines from rebooting until it is released. ParseStatus reads the update status
+// update_engine_client reports on a machine.
+package locksmith
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/rarmstrong73/go-utils/etcd"
+	"github.com/rarmstrong73/go-utils/logging"
+)
+
+// keyPrefix is where locksmithd keeps its reboot locks
+var keyPrefix = "coreos.com/updateengine/rebootlock"
+
+// Errors returned when the lock can't be acquired or released
+var (
+	ErrAlreadyHeld = errors.New("Reboot lock already held")
+	ErrNotHeld     = errors.New("Reboot lock not held")
+	ErrNoSlots     = errors.New("Reboot lock has no free slots")
+)
+
+// Semaphore is the state of a reboot lock
+type Semaphore struct {
+	// Index is the etcd index the state was read at
+	Index int64 `json:"-"`
+	// Semaphore is how many more machines may hold the lock
+	Semaphore int `json:"semaphore"`
+	// Max is how many machines may hold the lock at once
+	Max int `json:"max"`
+	// Holders are the IDs of the machines holding the lock
+	Holders []string `json:"holders"`
+}
+
+// Lock is the reboot lock of a group of machines
+type Lock struct {
+	client *etcd.Client
+	group  string
+	logger logging.Logger
+}
+
+// NewLock returns the reboot lock of a group of machines, the lock of machines without a group
+// when group is empty
+func NewLock(client *etcd.Client, group string) *Lock {
+	return &Lock{client: client, group: group, logger: logging.Nop}
+}
+
+// SetLogger sets where the lock logs, nil turns logging off
+func (lock *Lock) SetLogger(logger logging.Logger) {
+	lock.logger = logging.OrNop(logger)
+}
+
+// Get returns the state of the lock, the state locksmithd starts from, one slot and no holders,
+// if the lock hasn't been used yet
+func (lock *Lock) Get() (Semaphore, error) {
+	node, err := lock.client.GetKey(lock.key())
+	if errors.Is(err, etcd.ErrKeyNotFound) {
+		return Semaphore{Semaphore: 1, Max: 1, Holders: []string{}}, nil
+	}
+	if err != nil {
+		return Semaphore{}, err
+	}
+
+	var semaphore Semaphore
+	if err := json.Unmarshal([]byte(node.Value), &semaphore); err != nil {
+		return Semaphore{}, fmt.Errorf("Invalid reboot lock at %s: %v", node.Key, err)
+	}
+	if semaphore.Holders == nil {
+		semaphore.Holders = []string{}
+	}
+	semaphore.Index = node.ModifiedIndex
+	return semaphore, nil
+}
+
+// Holders returns the IDs of the machines holding the lock
+func (lock *Lock) Holders() ([]string, error) {
+	semaphore, err := lock.Get()
+	return semaphore.Holders, err
+}
+
+// Acquire takes a slot of the lock for a machine, failing with ErrAlreadyHeld if the machine
+// already holds it or ErrNoSlots if every slot is taken
+func (lock *Lock) Acquire(machineID string) error {
+	err := lock.update(func(semaphore *Semaphore) error {
+		for _, holder := range semaphore.Holders {
+			if holder == machineID {
+				return ErrAlreadyHeld
+			}
+		}
+		if semaphore.Semaphore <= 0 {
+			return ErrNoSlots
+		}
+		semaphore.Semaphore--
+		semaphore.Holders = append(semaphore.Holders, machineID)
+		return nil
+	})
+	if err == nil {
+		lock.logger.Info("Acquired reboot lock", "group", lock.group, "machine", machineID)
+	}
+	return err
+}
+
+// Release gives up a machine's slot of the lock, failing with ErrNotHeld if the machine doesn't
+// hold it
+func (lock *Lock) Release(machineID string) error {
+	err := lock.update(func(semaphore *Semaphore) error {
+		for i, holder := range semaphore.Holders {
+			if holder == machineID {
+				semaphore.Holders = append(semaphore.Holders[:i], semaphore.Holders[i+1:]...)
+				semaphore.Semaphore++
+				return nil
+			}
+		}
+		return ErrNotHeld
+	})
+	if err == nil {
+		lock.logger.Info("Released reboot lock", "group", lock.group, "machine", machineID)
+	}
+	return err
+}
+
+// SetMax sets how many machines may hold the lock at once. Lowering it below the number of
+// holders leaves them holding it, and no machine acquires it until enough release it.
+func (lock *Lock) SetMax(max int) error {
+	if max < 0 {
+		return fmt.Errorf("Invalid reboot lock max %d", max)
+	}
+	err := lock.update(func(semaphore *Semaphore) error {
+		semaphore.Semaphore += max - semaphore.Max
+		semaphore.Max = max
+		return nil
+	})
+	if err == nil {
+		lock.logger.Info("Set reboot lock max", "group", lock.group, "max", max)
+	}
+	return err
+}
+
+// update applies change to the lock's state with compare-and-swap, retrying when another
+// machine changed it first. Nothing is written if change fails.
+func (lock *Lock) update(change func(semaphore *Semaphore) error) error {
+	for {
+		semaphore, err := lock.Get()
+		if err != nil {
+			return err
+		}
+		if err := change(&semaphore); err != nil {
+			return err
+		}
+
+		value, err := json.Marshal(semaphore)
+		if err != nil {
+			return err
+		}
+		if semaphore.Index == 0 {
+			_, err = lock.client.Create(lock.key(), string(value), 0)
+		} else {
+			_, err = lock.client.CompareAndSwap(lock.key(), string(value), semaphore.Index)
+		}
+		if errors.Is(err, etcd.ErrTestFailed) || errors.Is(err, etcd.ErrNodeExist) {
+			continue
+		}
+		return err
+	}
+}
+
+// key returns the etcd key of the lock's state
+func (lock *Lock) key() string {
+	if lock.group == "" {
+		return keyPrefix + "/semaphore"
+	}
+	return keyPrefix + "/groups/" + lock.group + "/semaphore"
+}
@@ -0,0 +1,88 @@
+package locksmith
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StatusCommand is the command printing a machine's update status, whose output ParseStatus reads
+const StatusCommand = "update_engine_client -status"
+
+// Operations update_engine reports in Status.CurrentOperation
+const (
+	UpdateStatusIdle                = "UPDATE_STATUS_IDLE"
+	UpdateStatusCheckingForUpdate   = "UPDATE_STATUS_CHECKING_FOR_UPDATE"
+	UpdateStatusUpdateAvailable     = "UPDATE_STATUS_UPDATE_AVAILABLE"
+	UpdateStatusDownloading         = "UPDATE_STATUS_DOWNLOADING"
+	UpdateStatusVerifying           = "UPDATE_STATUS_VERIFYING"
+	UpdateStatusFinalizing          = "UPDATE_STATUS_FINALIZING"
+	UpdateStatusUpdatedNeedReboot   = "UPDATE_STATUS_UPDATED_NEED_REBOOT"
+	UpdateStatusReportingErrorEvent = "UPDATE_STATUS_REPORTING_ERROR_EVENT"
+)
+
+// Status is the update status of a machine
+type Status struct {
+	// LastChecked is when the machine last checked for an update
+	LastChecked time.Time
+	// Progress is how far through downloading an update the machine is, from 0 to 1
+	Progress float64
+	// CurrentOperation is what update_engine is doing, such as UpdateStatusIdle
+	CurrentOperation string
+	// NewVersion and NewSize describe the update being applied, if any
+	NewVersion string
+	NewSize    int64
+}
+
+// NeedsReboot reports whether the machine has applied an update it needs to reboot into
+func (status Status) NeedsReboot() bool {
+	return status.CurrentOperation == UpdateStatusUpdatedNeedReboot
+}
+
+// ParseStatus parses the output of StatusCommand, such as
+//
+//	LAST_CHECKED_TIME=1488824516
+//	PROGRESS=0.000000
+//	CURRENT_OP=UPDATE_STATUS_IDLE
+//	NEW_VERSION=0.0.0
+//	NEW_SIZE=0
+func ParseStatus(output string) (Status, error) {
+	var status Status
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		equals := strings.Index(line, "=")
+		if equals < 0 {
+			// update_engine_client logs to the same output before the status
+			continue
+		}
+		name, value := line[:equals], line[equals+1:]
+
+		var err error
+		switch name {
+		case "LAST_CHECKED_TIME":
+			var seconds int64
+			seconds, err = strconv.ParseInt(value, 10, 64)
+			if seconds > 0 {
+				status.LastChecked = time.Unix(seconds, 0)
+			}
+		case "PROGRESS":
+			status.Progress, err = strconv.ParseFloat(value, 64)
+		case "CURRENT_OP":
+			status.CurrentOperation = value
+		case "NEW_VERSION":
+			status.NewVersion = value
+		case "NEW_SIZE":
+			status.NewSize, err = strconv.ParseInt(value, 10, 64)
+		}
+		if err != nil {
+			return Status{}, fmt.Errorf("Invalid update status %s: %v", line, err)
+		}
+	}
+	if status.CurrentOperation == "" {
+		return Status{}, fmt.Errorf("No CURRENT_OP in update status")
+	}
+	return status, nil
+}
@@ -0,0 +1,110 @@
+// Package logging is the logger interface the packages in this repo log through. Nothing is
+// logged unless a logger is set, with each package's SetLogger or a client's SetLogger, so the
+// packages never write to stderr of their own accord.
+package logging
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+)
+
+// Logger receives log messages with alternating key value pairs describing them, such as
+// logger.Info("Removed container", "container", id, "host", host). Implementations must be safe
+// for concurrent use. Adapting a structured logger means mapping the three levels onto it.
+type Logger interface {
+	Debug(msg string, keyvals ...interface{})
+	Info(msg string, keyvals ...interface{})
+	Error(msg string, keyvals ...interface{})
+}
+
+// Nop discards everything, it is the default logger
+var Nop Logger = nop{}
+
+type nop struct{}
+
+func (nop) Debug(string, ...interface{}) {}
+func (nop) Info(string, ...interface{})  {}
+func (nop) Error(string, ...interface{}) {}
+
+// Std returns a logger writing lines such as `INFO Removed container container=web host=h1`
+// to logger, leaving out debug messages unless debug is set
+func Std(logger *log.Logger, debug bool) Logger {
+	return stdLogger{logger: logger, debug: debug}
+}
+
+type stdLogger struct {
+	logger *log.Logger
+	debug  bool
+}
+
+func (std stdLogger) Debug(msg string, keyvals ...interface{}) {
+	if std.debug {
+		std.logger.Print(format("DEBUG", msg, keyvals))
+	}
+}
+
+func (std stdLogger) Info(msg string, keyvals ...interface{}) {
+	std.logger.Print(format("INFO", msg, keyvals))
+}
+
+func (std stdLogger) Error(msg string, keyvals ...interface{}) {
+	std.logger.Print(format("ERROR", msg, keyvals))
+}
+
+// Swappable is a Logger passing messages on to a logger that can be replaced while it is in use,
+// for packages whose SetLogger can be called while they log. The zero value logs to Nop.
+type Swappable struct {
+	logger atomic.Value
+}
+
+// loggerHolder keeps the loggers stored in a Swappable of one concrete type
+type loggerHolder struct {
+	Logger
+}
+
+// Set replaces the logger messages are passed on to, nil turns logging off
+func (swappable *Swappable) Set(logger Logger) {
+	swappable.logger.Store(loggerHolder{OrNop(logger)})
+}
+
+func (swappable *Swappable) current() Logger {
+	if holder, ok := swappable.logger.Load().(loggerHolder); ok {
+		return holder.Logger
+	}
+	return Nop
+}
+
+func (swappable *Swappable) Debug(msg string, keyvals ...interface{}) {
+	swappable.current().Debug(msg, keyvals...)
+}
+
+func (swappable *Swappable) Info(msg string, keyvals ...interface{}) {
+	swappable.current().Info(msg, keyvals...)
+}
+
+func (swappable *Swappable) Error(msg string, keyvals ...interface{}) {
+	swappable.current().Error(msg, keyvals...)
+}
+
+// OrNop returns logger, or Nop when it is nil
+func OrNop(logger Logger) Logger {
+	if logger == nil {
+		return Nop
+	}
+	return logger
+}
+
+func format(level, msg string, keyvals []interface{}) string {
+	var line strings.Builder
+	line.WriteString(level + " " + msg)
+	for i := 0; i < len(keyvals); i += 2 {
+		var value interface{} = "MISSING"
+		if i+1 < len(keyvals) {
+			value = keyvals[i+1]
+		}
+		fmt.Fprintf(&line, " %v=%v", keyvals[i], value)
+	}
+	return line.String()
+}
@@ -0,0 +1,198 @@
+// Package metrics collects uniform request metrics from every fleet, docker, etcd and consul
+// client in the process and serves them in the Prometheus text format, for an agent's /metrics
+// endpoint:
+//
+//	registry := metrics.NewRegistry()
+//	metrics.Enable(registry)
+//	http.Handle("/metrics", registry)
+//
+// Requests are counted by backend, endpoint, method and status code, their latencies are
+// recorded in a histogram by backend and endpoint, and failures are counted by error class.
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rarmstrong73/go-utils/internal/httpclient"
+)
+
+// Error classes of the errors counter
+const (
+	ClassTransport   = "transport"
+	ClassCanceled    = "canceled"
+	ClassClientError = "client_error"
+	ClassServerError = "server_error"
+)
+
+// DefaultBuckets are the upper bounds in seconds of the latency histogram's buckets
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300}
+
+// Registry holds the collected metrics, it is an http.Handler serving them
+type Registry struct {
+	namespace string
+	buckets   []float64
+
+	mutex     sync.Mutex
+	requests  map[requestKey]uint64
+	errors    map[errorKey]uint64
+	latencies map[endpointKey]*histogram
+}
+
+type endpointKey struct {
+	backend  string
+	endpoint string
+}
+
+type requestKey struct {
+	endpointKey
+	method string
+	code   string
+}
+
+type errorKey struct {
+	endpointKey
+	class string
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewRegistry returns an empty registry whose metrics are named goutils_*
+func NewRegistry() *Registry {
+	return &Registry{
+		namespace: "goutils",
+		buckets:   DefaultBuckets,
+		requests:  map[requestKey]uint64{},
+		errors:    map[errorKey]uint64{},
+		latencies: map[endpointKey]*histogram{},
+	}
+}
+
+// Enable reports every request made by the clients of this repo to registry, nil turns reporting off
+func Enable(registry *Registry) {
+	if registry == nil {
+		httpclient.SetObserver(nil)
+		return
+	}
+	httpclient.SetObserver(func(observation httpclient.Observation) {
+		registry.Observe(observation.Service, observation.Endpoint, observation.Method, observation.StatusCode, observation.Err, observation.Duration)
+	})
+}
+
+// Observe records a request, for clients outside this repo that want the same metrics. A
+// statusCode of 0 means no response was received.
+func (registry *Registry) Observe(backend, endpoint, method string, statusCode int, err error, duration time.Duration) {
+	key := endpointKey{backend: backend, endpoint: endpoint}
+	code := "none"
+	if statusCode != 0 {
+		code = strconv.Itoa(statusCode)
+	}
+
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	registry.requests[requestKey{endpointKey: key, method: method, code: code}]++
+	if class := errorClass(statusCode, err); class != "" {
+		registry.errors[errorKey{endpointKey: key, class: class}]++
+	}
+
+	latency := registry.latencies[key]
+	if latency == nil {
+		latency = &histogram{counts: make([]uint64, len(registry.buckets))}
+		registry.latencies[key] = latency
+	}
+	seconds := duration.Seconds()
+	for i, bound := range registry.buckets {
+		if seconds <= bound {
+			latency.counts[i]++
+		}
+	}
+	latency.count++
+	latency.sum += seconds
+}
+
+func errorClass(statusCode int, err error) string {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return ClassCanceled
+	case err != nil:
+		return ClassTransport
+	case statusCode >= 500:
+		return ClassServerError
+	case statusCode >= 400:
+		return ClassClientError
+	}
+	return ""
+}
+
+// ServeHTTP serves the metrics in the Prometheus text format
+func (registry *Registry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	registry.WriteTo(w)
+}
+
+// WriteTo writes the metrics in the Prometheus text format
+func (registry *Registry) WriteTo(w io.Writer) (int64, error) {
+	registry.mutex.Lock()
+	defer registry.mutex.Unlock()
+
+	var out strings.Builder
+	name := registry.namespace + "_requests_total"
+	fmt.Fprintf(&out, "# HELP %s Requests made to backends, including retried attempts.\n# TYPE %s counter\n", name, name)
+	lines := []string{}
+	for key, count := range registry.requests {
+		lines = append(lines, fmt.Sprintf("%s{%s,method=%q,code=%q} %d", name, key.labels(), key.method, key.code, count))
+	}
+	writeSorted(&out, lines)
+
+	name = registry.namespace + "_request_errors_total"
+	fmt.Fprintf(&out, "# HELP %s Failed requests by error class.\n# TYPE %s counter\n", name, name)
+	lines = []string{}
+	for key, count := range registry.errors {
+		lines = append(lines, fmt.Sprintf("%s{%s,class=%q} %d", name, key.labels(), key.class, count))
+	}
+	writeSorted(&out, lines)
+
+	name = registry.namespace + "_request_duration_seconds"
+	fmt.Fprintf(&out, "# HELP %s Request latencies.\n# TYPE %s histogram\n", name, name)
+	keys := make([]endpointKey, 0, len(registry.latencies))
+	for key := range registry.latencies {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].labels() < keys[j].labels() })
+	for _, key := range keys {
+		latency := registry.latencies[key]
+		for i, bound := range registry.buckets {
+			fmt.Fprintf(&out, "%s_bucket{%s,le=%q} %d\n", name, key.labels(), strconv.FormatFloat(bound, 'g', -1, 64), latency.counts[i])
+		}
+		fmt.Fprintf(&out, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, key.labels(), latency.count)
+		fmt.Fprintf(&out, "%s_sum{%s} %g\n", name, key.labels(), latency.sum)
+		fmt.Fprintf(&out, "%s_count{%s} %d\n", name, key.labels(), latency.count)
+	}
+
+	written, err := io.WriteString(w, out.String())
+	return int64(written), err
+}
+
+func (key endpointKey) labels() string {
+	return fmt.Sprintf("backend=%q,endpoint=%q", key.backend, key.endpoint)
+}
+
+func writeSorted(out *strings.Builder, lines []string) {
+	sort.Strings(lines)
+	for _, line := range lines {
+		out.WriteString(line + "\n")
+	}
+}
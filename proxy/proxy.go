@@ -0,0 +1,125 @@
+// Package proxy routes the requests of the fleet, docker, etcd, consul and other clients through
+// HTTP or SOCKS5 proxies, for clusters only reachable through a jump host:
+//
+//	bastion, err := proxy.URL("socks5://bastion.example.com:1080", "localhost", ".internal")
+//	if err != nil {
+//		...
+//	}
+//	proxy.SetDefault(bastion)
+//	consulClient.SetProxy(proxy.Direct)
+//
+// Until SetDefault is called, requests go through the proxies named by the HTTP_PROXY,
+// HTTPS_PROXY and NO_PROXY environment variables as net/http's do.
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Func returns the proxy a request goes through, nil for none, like http.Transport's Proxy
+type Func func(request *http.Request) (*url.URL, error)
+
+// Environment sends requests through the proxies named by HTTP_PROXY, HTTPS_PROXY and NO_PROXY,
+// which are read once
+var Environment Func = http.ProxyFromEnvironment
+
+// Direct sends requests straight to their hosts
+var Direct Func = func(*http.Request) (*url.URL, error) { return nil, nil }
+
+var (
+	mutex        sync.RWMutex
+	defaultProxy = Environment
+)
+
+// SetDefault sets the proxy of every client that hasn't been given its own, including clients
+// made before. nil goes back to Environment.
+func SetDefault(proxy Func) {
+	if proxy == nil {
+		proxy = Environment
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	defaultProxy = proxy
+}
+
+// Default returns the proxy of clients that haven't been given their own
+func Default() Func {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	return defaultProxy
+}
+
+// URL sends requests through the proxy at proxyURL, an http, https, socks5 or socks5h URL with
+// optional user:password credentials, except requests to hosts matching noProxy. noProxy is
+// written like NO_PROXY: a host matches example.com when it is example.com or one of its
+// subdomains, .example.com when it is a subdomain, an IP address or CIDR block containing it,
+// and * always. An entry with a port only matches that port.
+func URL(proxyURL string, noProxy ...string) (Func, error) {
+	parsed, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid proxy URL %q: %v", proxyURL, err)
+	}
+	switch parsed.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("Unsupported proxy scheme %q", parsed.Scheme)
+	}
+	if parsed.Host == "" {
+		return nil, fmt.Errorf("Proxy URL %q has no host", proxyURL)
+	}
+
+	return func(request *http.Request) (*url.URL, error) {
+		if bypass(noProxy, request.URL) {
+			return nil, nil
+		}
+		return parsed, nil
+	}, nil
+}
+
+// bypass reports whether any of the noProxy entries matches the host of target
+func bypass(noProxy []string, target *url.URL) bool {
+	host := strings.ToLower(target.Hostname())
+	port := target.Port()
+	if port == "" {
+		port = map[string]string{"http": "80", "https": "443"}[target.Scheme]
+	}
+
+	for _, entry := range noProxy {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			return true
+		}
+		if _, block, err := net.ParseCIDR(entry); err == nil {
+			if ip := net.ParseIP(host); ip != nil && block.Contains(ip) {
+				return true
+			}
+			continue
+		}
+
+		entryHost, entryPort := entry, ""
+		if splitHost, splitPort, err := net.SplitHostPort(entry); err == nil {
+			entryHost, entryPort = splitHost, splitPort
+		}
+		if entryPort != "" && entryPort != port {
+			continue
+		}
+		if strings.HasPrefix(entryHost, ".") {
+			if strings.HasSuffix(host, entryHost) {
+				return true
+			}
+			continue
+		}
+		if host == entryHost || strings.HasSuffix(host, "."+entryHost) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,127 @@
+// Package ratelimit limits the rate at which the fleet, docker, etcd and consul clients send
+// requests, so a reconciler looping over a whole cluster can't overload a backend:
+//
+//	limiter := ratelimit.New(50, 10).SetEndpoint("kv/range", 200, 50)
+//	client.SetRateLimiter(limiter)
+//	docker.SetRateLimiter(ratelimit.PerHost(func() ratelimit.Limiter { return ratelimit.New(5, 5) }))
+//
+// Endpoints are named as in metrics and traces, such as kv/range for etcd or containers/json for
+// docker. Every attempt of a retried request waits for the limiter.
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Limiter is what the clients wait on before sending each request
+type Limiter interface {
+	// Wait blocks until a request to endpoint of host may be sent, or returns ctx's error
+	Wait(ctx context.Context, host, endpoint string) error
+}
+
+// Buckets is a Limiter with a token bucket for the whole client and one for each endpoint given
+// its own rate, safe for concurrent use
+type Buckets struct {
+	mutex     sync.Mutex
+	client    *bucket
+	endpoints map[string]*bucket
+}
+
+// bucket holds up to burst tokens and gains qps tokens a second
+type bucket struct {
+	qps    float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// New returns a limiter allowing qps requests a second with bursts of up to burst requests. A
+// qps of 0 or less doesn't limit requests.
+func New(qps float64, burst int) *Buckets {
+	return &Buckets{client: newBucket(qps, burst), endpoints: map[string]*bucket{}}
+}
+
+func newBucket(qps float64, burst int) *bucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &bucket{qps: qps, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// SetEndpoint limits requests to endpoint to qps a second with bursts of up to burst instead of
+// the client's rate, returning the limiter
+func (buckets *Buckets) SetEndpoint(endpoint string, qps float64, burst int) *Buckets {
+	buckets.mutex.Lock()
+	defer buckets.mutex.Unlock()
+	buckets.endpoints[endpoint] = newBucket(qps, burst)
+	return buckets
+}
+
+// Wait blocks until a request to endpoint may be sent, or returns ctx's error. Requests to every
+// host share the limits.
+func (buckets *Buckets) Wait(ctx context.Context, host, endpoint string) error {
+	for {
+		buckets.mutex.Lock()
+		limit, ok := buckets.endpoints[endpoint]
+		if !ok {
+			limit = buckets.client
+		}
+		delay := limit.take(time.Now())
+		buckets.mutex.Unlock()
+
+		if delay == 0 {
+			return nil
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// take takes a token, or returns how long until there is one
+func (limit *bucket) take(now time.Time) time.Duration {
+	if limit.qps <= 0 {
+		return 0
+	}
+	limit.tokens += now.Sub(limit.last).Seconds() * limit.qps
+	if limit.tokens > limit.burst {
+		limit.tokens = limit.burst
+	}
+	limit.last = now
+	if limit.tokens >= 1 {
+		limit.tokens--
+		return 0
+	}
+	return time.Duration((1 - limit.tokens) / limit.qps * float64(time.Second))
+}
+
+// hosts is a Limiter with a limiter of its own for each host
+type hosts struct {
+	mutex      sync.Mutex
+	newLimiter func() Limiter
+	limiters   map[string]Limiter
+}
+
+// PerHost returns a limiter giving each host a limiter of its own made by newLimiter, for the
+// docker and fleet packages which talk to many hosts
+func PerHost(newLimiter func() Limiter) Limiter {
+	return &hosts{newLimiter: newLimiter, limiters: map[string]Limiter{}}
+}
+
+// Wait blocks until host's limiter allows a request to endpoint, or returns ctx's error
+func (hosts *hosts) Wait(ctx context.Context, host, endpoint string) error {
+	hosts.mutex.Lock()
+	limiter, ok := hosts.limiters[host]
+	if !ok {
+		limiter = hosts.newLimiter()
+		hosts.limiters[host] = limiter
+	}
+	hosts.mutex.Unlock()
+	return limiter.Wait(ctx, host, endpoint)
+}
@@ -0,0 +1,475 @@
+// Package registry is a client of the Docker Registry HTTP API v2, for tools that clean up a
+// registry's old images the way the docker package cleans up hosts:
+//
+//	client := registry.NewClient(registry.Config{URL: "https://registry.example.com", Username: "ci", Password: password})
+//	tags, err := client.Tags("web")
+//	digest, err := client.Digest("web", "1.0")
+//	err = client.DeleteManifest("web", digest)
+//
+// Registries asking for bearer tokens, such as ones behind docker/distribution's token auth, get
+// tokens from their realm with the configured credentials, which are otherwise sent with basic
+// auth.
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/rarmstrong73/go-utils/apierror"
+	"github.com/rarmstrong73/go-utils/internal/httpclient"
+	"github.com/rarmstrong73/go-utils/logging"
+)
+
+// Manifest media types
+const (
+	MediaTypeManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeOCIManifest  = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeOCIIndex     = "application/vnd.oci.image.index.v1+json"
+)
+
+// manifestTypes are accepted when fetching manifests, so registries don't convert them to the
+// deprecated schema 1
+var manifestTypes = []string{MediaTypeManifest, MediaTypeManifestList, MediaTypeOCIManifest, MediaTypeOCIIndex}
+
+// pageSize is how many repositories or tags are asked for at a time
+var pageSize = 100
+
+// Config describes a registry
+type Config struct {
+	// URL is the registry's base URL, such as https://registry.example.com
+	URL string
+	// Username and Password authenticate with basic auth or, when the registry asks for
+	// bearer tokens, with its token service
+	Username string
+	Password string
+	// Token is a bearer token sent with every request instead of getting one from the registry
+	Token string
+}
+
+// Descriptor describes content referenced by a manifest
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Size        int64             `json:"size"`
+	Digest      string            `json:"digest"`
+	Platform    *Platform         `json:"platform,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Platform is the platform of an image in a manifest list
+type Platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// Manifest is an image manifest or a manifest list
+type Manifest struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	MediaType     string `json:"mediaType"`
+	// Config and Layers are set for image manifests
+	Config Descriptor   `json:"config"`
+	Layers []Descriptor `json:"layers"`
+	// Manifests are set for manifest lists, one for each platform
+	Manifests []Descriptor `json:"manifests"`
+
+	// Digest is the manifest's content digest, which DeleteManifest takes
+	Digest string `json:"-"`
+	// Raw is the manifest as the registry returned it
+	Raw []byte `json:"-"`
+}
+
+// Error is an error reported by the registry
+type Error struct {
+	Code    string          `json:"code"`
+	Message string          `json:"message"`
+	Detail  json.RawMessage `json:"detail,omitempty"`
+}
+
+// ErrorResponse is the body of a failed response
+type ErrorResponse struct {
+	Errors []Error `json:"errors"`
+}
+
+// Client is a connection to a registry
+type Client struct {
+	config     Config
+	httpClient *httpclient.Client
+	logger     logging.Logger
+
+	mutex  sync.Mutex
+	tokens map[string]string
+}
+
+// NewClient returns a client for the registry described by config
+func NewClient(config Config) *Client {
+	return &Client{
+		config:     config,
+		httpClient: httpclient.New(httpclient.Options{Service: apierror.Registry, Endpoint: endpointName}),
+		logger:     logging.Nop,
+		tokens:     map[string]string{},
+	}
+}
+
+// SetLogger sets where the client logs, nil turns logging off
+func (client *Client) SetLogger(logger logging.Logger) {
+	client.logger = logging.OrNop(logger)
+}
+
+// Shutdown stops the client: new requests fail with apierror.ErrClosed and requests in flight
+// are given until ctx is done to finish before they are cancelled. It returns ctx's error if
+// requests had to be cancelled.
+func (client *Client) Shutdown(ctx context.Context) error {
+	return client.httpClient.Shutdown(ctx)
+}
+
+// Close stops the client like Shutdown, cancelling requests in flight straight away
+func (client *Client) Close() error {
+	return client.httpClient.Close()
+}
+
+// Repositories returns the names of every repository in the registry
+func (client *Client) Repositories() ([]string, error) {
+	repositories := []string{}
+	err := client.paginate("/v2/_catalog", func(body []byte) error {
+		var page struct {
+			Repositories []string `json:"repositories"`
+		}
+		if err := json.Unmarshal(body, &page); err != nil {
+			return err
+		}
+		repositories = append(repositories, page.Repositories...)
+		return nil
+	})
+	return repositories, err
+}
+
+// Tags returns the tags of a repository
+func (client *Client) Tags(repository string) ([]string, error) {
+	tags := []string{}
+	err := client.paginate("/v2/"+repository+"/tags/list", func(body []byte) error {
+		var page struct {
+			Tags []string `json:"tags"`
+		}
+		if err := json.Unmarshal(body, &page); err != nil {
+			return err
+		}
+		tags = append(tags, page.Tags...)
+		return nil
+	})
+	return tags, err
+}
+
+// Manifest returns the manifest of a repository's tag or digest
+func (client *Client) Manifest(repository, reference string) (Manifest, error) {
+	request := httpclient.Request{
+		Method: http.MethodGet,
+		Path:   "/v2/" + repository + "/manifests/" + reference,
+		Header: http.Header{"Accept": manifestTypes},
+	}
+	response, err := client.do(request)
+	if err != nil {
+		return Manifest{}, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return Manifest{}, handleError(response)
+	}
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return Manifest{}, err
+	}
+	var manifest Manifest
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return Manifest{}, err
+	}
+	if manifest.MediaType == "" {
+		manifest.MediaType = response.Header.Get("Content-Type")
+	}
+	manifest.Digest = response.Header.Get("Docker-Content-Digest")
+	manifest.Raw = body
+	return manifest, nil
+}
+
+// Digest returns the content digest of the manifest a repository's tag points to, without
+// fetching the manifest
+func (client *Client) Digest(repository, reference string) (string, error) {
+	request := httpclient.Request{
+		Method: http.MethodHead,
+		Path:   "/v2/" + repository + "/manifests/" + reference,
+		Header: http.Header{"Accept": manifestTypes},
+	}
+	response, err := client.do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", handleError(response)
+	}
+
+	digest := response.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		return "", apierror.FromResponse(apierror.Registry, response, "", "No Docker-Content-Digest header in response", nil)
+	}
+	return digest, nil
+}
+
+// DeleteManifest deletes the manifest with the given digest from a repository, removing every tag
+// pointing to it. The registry must have deletes enabled, and its garbage collection frees the
+// layers no manifest references any more.
+func (client *Client) DeleteManifest(repository, digest string) error {
+	if !strings.Contains(digest, ":") {
+		return fmt.Errorf("%q is not a digest, manifests are deleted by digest", digest)
+	}
+	response, err := client.do(httpclient.Request{Method: http.MethodDelete, Path: "/v2/" + repository + "/manifests/" + digest})
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusAccepted {
+		return handleError(response)
+	}
+	client.logger.Info("Deleted manifest", "repository", repository, "digest", digest)
+	return nil
+}
+
+// paginate gets each page of a listing, following the Link headers
+func (client *Client) paginate(path string, page func(body []byte) error) error {
+	request := httpclient.Request{Method: http.MethodGet, Path: path, Query: url.Values{"n": {fmt.Sprint(pageSize)}}}
+	for {
+		response, err := client.do(request)
+		if err != nil {
+			return err
+		}
+		body, err := ioutil.ReadAll(response.Body)
+		response.Body.Close()
+		if err != nil {
+			return err
+		}
+		if response.StatusCode != http.StatusOK {
+			response.Body = ioutil.NopCloser(strings.NewReader(string(body)))
+			return handleError(response)
+		}
+		if err := page(body); err != nil {
+			return err
+		}
+
+		next := nextPage(response.Header.Get("Link"))
+		if next == "" {
+			return nil
+		}
+		nextURL, err := url.Parse(next)
+		if err != nil {
+			return err
+		}
+		request.Path = nextURL.Path
+		request.Query = nextURL.Query()
+	}
+}
+
+// nextPage returns the URL in a Link header such as </v2/_catalog?last=b&n=100>; rel="next"
+func nextPage(link string) string {
+	if !strings.Contains(link, `rel="next"`) {
+		return ""
+	}
+	start := strings.Index(link, "<")
+	end := strings.Index(link, ">")
+	if start < 0 || end < start {
+		return ""
+	}
+	return link[start+1 : end]
+}
+
+// endpointName names a request path for observations, such as manifests or tags/list
+func endpointName(path string) string {
+	segments := strings.Split(strings.TrimPrefix(path, "/v2/"), "/")
+	switch {
+	case len(segments) >= 2 && segments[len(segments)-2] == "tags":
+		return "tags/list"
+	case len(segments) >= 2 && (segments[len(segments)-2] == "manifests" || segments[len(segments)-2] == "blobs"):
+		return segments[len(segments)-2]
+	}
+	return segments[0]
+}
+
+// handleError returns an *apierror.Error with the registry's error from a failed response
+func handleError(response *http.Response) error {
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+
+	var errorResponse ErrorResponse
+	if err := json.Unmarshal(body, &errorResponse); err != nil || len(errorResponse.Errors) == 0 {
+		return apierror.FromResponse(apierror.Registry, response, "", strings.TrimSpace(string(body)), nil)
+	}
+	first := errorResponse.Errors[0]
+	return apierror.FromResponse(apierror.Registry, response, first.Code, first.Message, nil)
+}
+
+// ============================================================================
+// ============================= HTTP UTILS ===================================
+// ============================================================================
+
+// do sends a request authenticated as the registry asks, getting a bearer token and sending the
+// request again when the registry challenges it
+func (client *Client) do(request httpclient.Request) (*http.Response, error) {
+	request.URL = strings.TrimSuffix(client.config.URL, "/")
+	scope := scopeOf(request)
+
+	response, err := client.send(request, client.authorization(scope))
+	if err != nil || response.StatusCode != http.StatusUnauthorized {
+		return response, err
+	}
+
+	challenge := response.Header.Get("WWW-Authenticate")
+	if !strings.HasPrefix(strings.ToLower(challenge), "bearer ") || client.config.Token != "" {
+		return response, nil
+	}
+	response.Body.Close()
+	token, err := client.fetchToken(parseChallenge(challenge))
+	if err != nil {
+		return nil, err
+	}
+	client.mutex.Lock()
+	client.tokens[scope] = token
+	client.mutex.Unlock()
+	return client.send(request, "Bearer "+token)
+}
+
+func (client *Client) send(request httpclient.Request, authorization string) (*http.Response, error) {
+	if authorization != "" {
+		header := http.Header{}
+		for name, values := range request.Header {
+			header[name] = values
+		}
+		header.Set("Authorization", authorization)
+		request.Header = header
+	}
+	response, err := client.httpClient.Do(context.Background(), request)
+	if err != nil {
+		return nil, apierror.Transport(apierror.Registry, request.Operation(), err)
+	}
+	return response, nil
+}
+
+// authorization returns the Authorization header for requests in scope
+func (client *Client) authorization(scope string) string {
+	if client.config.Token != "" {
+		return "Bearer " + client.config.Token
+	}
+	client.mutex.Lock()
+	token, ok := client.tokens[scope]
+	client.mutex.Unlock()
+	if ok {
+		return "Bearer " + token
+	}
+	return client.basicAuth()
+}
+
+// basicAuth returns the Authorization header for the configured credentials, if any
+func (client *Client) basicAuth() string {
+	if client.config.Username == "" {
+		return ""
+	}
+	request, _ := http.NewRequest(http.MethodGet, "/", nil)
+	request.SetBasicAuth(client.config.Username, client.config.Password)
+	return request.Header.Get("Authorization")
+}
+
+// fetchToken gets a bearer token from the token service named in a challenge
+func (client *Client) fetchToken(challenge map[string]string) (string, error) {
+	realm := challenge["realm"]
+	if realm == "" {
+		return "", fmt.Errorf("No realm in the registry's bearer challenge")
+	}
+	query := url.Values{}
+	for _, name := range []string{"service", "scope"} {
+		if value := challenge[name]; value != "" {
+			query.Set(name, value)
+		}
+	}
+	request := httpclient.Request{Method: http.MethodGet, URL: realm, Query: query}
+	response, err := client.send(request, client.basicAuth())
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", handleError(response)
+	}
+
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	client.logger.Debug("Got registry token", "realm", realm, "scope", challenge["scope"])
+	return token.Token, nil
+}
+
+// scopeOf returns the token scope a request needs, such as repository:web:pull
+func scopeOf(request httpclient.Request) string {
+	path := strings.TrimPrefix(request.Path, "/v2/")
+	if path == "_catalog" {
+		return "registry:catalog:*"
+	}
+	for _, marker := range []string{"/tags/", "/manifests/", "/blobs/"} {
+		if index := strings.Index(path, marker); index >= 0 {
+			actions := "pull"
+			if request.Method == http.MethodDelete {
+				actions = "delete"
+			}
+			return "repository:" + path[:index] + ":" + actions
+		}
+	}
+	return ""
+}
+
+// parseChallenge parses the parameters of a WWW-Authenticate header such as
+// Bearer realm="https://auth.example.com/token",service="registry",scope="repository:web:pull"
+func parseChallenge(header string) map[string]string {
+	params := map[string]string{}
+	rest := strings.TrimSpace(header[strings.Index(header, " ")+1:])
+	for rest != "" {
+		equals := strings.Index(rest, "=")
+		if equals < 0 {
+			break
+		}
+		name := strings.ToLower(strings.TrimSpace(rest[:equals]))
+		rest = rest[equals+1:]
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			value = rest[1 : end+1]
+			rest = rest[end+1:]
+			rest = strings.TrimPrefix(rest, `"`)
+		} else {
+			end := strings.Index(rest, ",")
+			if end < 0 {
+				end = len(rest)
+			}
+			value = rest[:end]
+			rest = rest[end:]
+		}
+		params[name] = value
+		rest = strings.TrimLeft(rest, ", ")
+	}
+	return params
+}
@@ -0,0 +1,157 @@
+// Package retry is the retry policy the fleet, docker, etcd and consul clients share, so how
+// requests are retried is tuned the same way everywhere:
+//
+//	policy := retry.Policy{MaxAttempts: 5, MaxElapsed: 10 * time.Second, InitialBackoff: 100 * time.Millisecond,
+//		MaxBackoff: 2 * time.Second, Jitter: 0.5, RetryOn: retry.Any(retry.ServerErrors, retry.Throttled)}
+//	etcdClient.SetRetryPolicy(policy)
+//	docker.SetRetryPolicy(policy)
+//
+// Policies also pace loops that aren't HTTP requests, see Do and Backoff.
+package retry
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/rarmstrong73/go-utils/breaker"
+)
+
+// Classifier reports whether a failed attempt is worth retrying, from its response or from its
+// error when it got no response
+type Classifier func(response *http.Response, err error) bool
+
+// TransportErrors retries attempts that got no response, such as refused connections
+var TransportErrors Classifier = func(response *http.Response, err error) bool {
+	return err != nil
+}
+
+// Unsent retries attempts that never reached the server because the connection couldn't be made
+// or the circuit breaker refused them, the only failures after which retrying a request that isn't
+// idempotent is safe
+var Unsent Classifier = func(response *http.Response, err error) bool {
+	var opError *net.OpError
+	return errors.Is(err, breaker.ErrOpen) || (errors.As(err, &opError) && opError.Op == "dial")
+}
+
+// ServerErrors retries attempts that got no response or a 5xx
+var ServerErrors Classifier = func(response *http.Response, err error) bool {
+	return err != nil || response.StatusCode >= 500
+}
+
+// Throttled retries attempts rejected with a 429
+var Throttled Classifier = func(response *http.Response, err error) bool {
+	return err == nil && response.StatusCode == http.StatusTooManyRequests
+}
+
+// Any retries attempts any of classifiers retries
+func Any(classifiers ...Classifier) Classifier {
+	return func(response *http.Response, err error) bool {
+		for _, classifier := range classifiers {
+			if classifier(response, err) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Policy controls how failed attempts are retried. Attempts are retried until MaxAttempts were
+// made or retrying would go past MaxElapsed, waiting between them with an exponential backoff
+// starting at InitialBackoff, multiplied by Multiplier after every retry and capped at
+// MaxBackoff. The zero value never retries.
+type Policy struct {
+	// MaxAttempts is how many attempts are made at most, including the first, a MaxAttempts
+	// below 2 disables retries
+	MaxAttempts int
+	// MaxElapsed stops retrying once the next attempt would start this long after the first, no
+	// limit when 0
+	MaxElapsed     time.Duration
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Multiplier is how much the backoff grows after every retry, defaulting to 2
+	Multiplier float64
+	// Jitter is the fraction of each backoff that is randomly taken off it, between 0 and 1, so
+	// clients failing together don't retry together
+	Jitter float64
+	// RetryOn reports whether a failed attempt is retried, defaulting to ServerErrors
+	RetryOn Classifier
+}
+
+// Never is the policy that doesn't retry
+var Never = Policy{MaxAttempts: 1}
+
+// Retryable reports whether the policy retries a failed attempt
+func (policy Policy) Retryable(response *http.Response, err error) bool {
+	if policy.RetryOn == nil {
+		return ServerErrors(response, err)
+	}
+	return policy.RetryOn(response, err)
+}
+
+// Backoff returns how long to wait before a retry, numbered from 0 for the first retry
+func (policy Policy) Backoff(retry int) time.Duration {
+	multiplier := policy.Multiplier
+	if multiplier <= 0 {
+		multiplier = 2
+	}
+	backoff := float64(policy.InitialBackoff)
+	for i := 0; i < retry && (policy.MaxBackoff <= 0 || backoff < float64(policy.MaxBackoff)); i++ {
+		backoff *= multiplier
+	}
+	if policy.MaxBackoff > 0 && backoff > float64(policy.MaxBackoff) {
+		backoff = float64(policy.MaxBackoff)
+	}
+	if policy.Jitter > 0 {
+		jitter := policy.Jitter
+		if jitter > 1 {
+			jitter = 1
+		}
+		backoff -= backoff * jitter * rand.Float64()
+	}
+	return time.Duration(backoff)
+}
+
+// Allows reports whether the policy allows another attempt after the given number of attempts
+// made since started, waiting backoff before it
+func (policy Policy) Allows(attempts int, started time.Time, backoff time.Duration) bool {
+	if attempts >= policy.MaxAttempts {
+		return false
+	}
+	return policy.MaxElapsed <= 0 || time.Since(started)+backoff <= policy.MaxElapsed
+}
+
+// Do calls attempt, numbering the attempts from 0, until it succeeds, fails with an error the
+// policy doesn't retry, the policy allows no more attempts or ctx is done. It returns the error
+// of the last attempt, or ctx's error if ctx is done while waiting to retry.
+func (policy Policy) Do(ctx context.Context, attempt func(attempt int) error) error {
+	started := time.Now()
+	for number := 0; ; number++ {
+		err := attempt(number)
+		if err == nil || !policy.Retryable(nil, err) || ctx.Err() != nil {
+			return err
+		}
+		backoff := policy.Backoff(number)
+		if !policy.Allows(number+1, started, backoff) {
+			return err
+		}
+		if err := Sleep(ctx, backoff); err != nil {
+			return err
+		}
+	}
+}
+
+// Sleep waits for d, returning ctx's error if ctx is done first
+func Sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
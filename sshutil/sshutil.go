@@ -0,0 +1,224 @@
+// Package sshutil runs commands on cluster machines over SSH and copies files to and from them,
+// the fallback when a machine's fleet, docker or journal APIs are down:
+//
+//	client := sshutil.ForMachine(machine, sshutil.Config{KeyFile: "/etc/ops/id_ed25519"})
+//	result, err := client.Run(ctx, "docker ps -aq --filter status=exited | xargs -r docker rm")
+//
+// It runs the system's ssh and scp, so it authenticates the way they do: with the configured key
+// or with the keys of the agent at SSH_AUTH_SOCK, verifying hosts against known_hosts.
+package sshutil
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rarmstrong73/go-utils/fleet"
+	"github.com/rarmstrong73/go-utils/logging"
+)
+
+// sshFailed is the exit status of ssh and scp when they fail themselves rather than the remote
+// command failing
+const sshFailed = 255
+
+// Config describes how to connect to machines
+type Config struct {
+	// User is the user to log in as, defaulting to core
+	User string
+	// Port is the SSH port, defaulting to 22
+	Port int
+	// KeyFile is the private key to authenticate with. When empty the keys of the agent at
+	// SSH_AUTH_SOCK and the user's default keys are tried.
+	KeyFile string
+	// KnownHostsFile is the file host keys are verified against, defaulting to the user's
+	KnownHostsFile string
+	// InsecureIgnoreHostKey turns off host key verification, for machines whose keys change
+	// when they are reprovisioned
+	InsecureIgnoreHostKey bool
+	// ConnectTimeout limits connecting, defaulting to 10 seconds
+	ConnectTimeout time.Duration
+	// Options are extra ssh options, such as ProxyJump=bastion
+	Options []string
+	// SSHCommand and SCPCommand are the ssh and scp binaries, defaulting to ssh and scp
+	SSHCommand string
+	SCPCommand string
+}
+
+// Result is the output of a command
+type Result struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+}
+
+// ExitError is returned when a command exits with a non zero status
+type ExitError struct {
+	Host     string
+	Command  string
+	ExitCode int
+	Stderr   string
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("%s on %s exited with %d: %s", e.Command, e.Host, e.ExitCode, e.Stderr)
+}
+
+// ConnectionError is returned when ssh or scp couldn't connect or authenticate
+type ConnectionError struct {
+	Host    string
+	Message string
+}
+
+func (e *ConnectionError) Error() string {
+	return fmt.Sprintf("SSH to %s failed: %s", e.Host, e.Message)
+}
+
+// Client runs commands on a host
+type Client struct {
+	host   string
+	config Config
+	logger logging.Logger
+}
+
+// NewClient returns a client for host, a name or IP address
+func NewClient(host string, config Config) *Client {
+	if config.User == "" {
+		config.User = "core"
+	}
+	if config.Port == 0 {
+		config.Port = 22
+	}
+	if config.ConnectTimeout == 0 {
+		config.ConnectTimeout = 10 * time.Second
+	}
+	if config.SSHCommand == "" {
+		config.SSHCommand = "ssh"
+	}
+	if config.SCPCommand == "" {
+		config.SCPCommand = "scp"
+	}
+	return &Client{host: host, config: config, logger: logging.Nop}
+}
+
+// ForMachine returns a client for a fleet machine, connecting to its PrimaryIP
+func ForMachine(machine fleet.Machine, config Config) *Client {
+	return NewClient(machine.PrimaryIP, config)
+}
+
+// SetLogger sets where the client logs, nil turns logging off
+func (client *Client) SetLogger(logger logging.Logger) {
+	client.logger = logging.OrNop(logger)
+}
+
+// Host returns the host the client connects to
+func (client *Client) Host() string {
+	return client.host
+}
+
+// Run runs a shell command on the host, killing it when ctx is done. It fails with an
+// *ExitError if the command exits with a non zero status, returning its output as well.
+func (client *Client) Run(ctx context.Context, command string) (Result, error) {
+	var stdout, stderr bytes.Buffer
+	err := client.Stream(ctx, command, &stdout, &stderr)
+	result := Result{Stdout: stdout.String(), Stderr: stderr.String()}
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) {
+		result.ExitCode = exitErr.ExitCode
+	}
+	return result, err
+}
+
+// Stream runs a shell command on the host, writing its output to stdout and stderr as it is
+// produced, until it exits or ctx is done
+func (client *Client) Stream(ctx context.Context, command string, stdout, stderr io.Writer) error {
+	args := append(client.options("-p"), "--", client.config.User+"@"+client.host, command)
+	client.logger.Debug("Running command over ssh", "host", client.host, "command", command)
+	return client.exec(ctx, client.config.SSHCommand, args, command, stdout, stderr)
+}
+
+// CopyTo copies a local file to a path on the host
+func (client *Client) CopyTo(ctx context.Context, localPath, remotePath string) error {
+	args := append(client.options("-P"), "--", localPath, client.remote(remotePath))
+	err := client.exec(ctx, client.config.SCPCommand, args, "scp "+localPath, nil, nil)
+	if err == nil {
+		client.logger.Info("Copied file to host", "host", client.host, "from", localPath, "to", remotePath)
+	}
+	return err
+}
+
+// CopyFrom copies a file on the host to a local path
+func (client *Client) CopyFrom(ctx context.Context, remotePath, localPath string) error {
+	args := append(client.options("-P"), "--", client.remote(remotePath), localPath)
+	err := client.exec(ctx, client.config.SCPCommand, args, "scp "+remotePath, nil, nil)
+	if err == nil {
+		client.logger.Info("Copied file from host", "host", client.host, "from", remotePath, "to", localPath)
+	}
+	return err
+}
+
+// options returns the arguments ssh and scp share, portFlag is how they set the port
+func (client *Client) options(portFlag string) []string {
+	seconds := int(client.config.ConnectTimeout / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	args := []string{
+		portFlag, strconv.Itoa(client.config.Port),
+		"-o", "BatchMode=yes",
+		"-o", "ConnectTimeout=" + strconv.Itoa(seconds),
+	}
+	if client.config.KeyFile != "" {
+		args = append(args, "-i", client.config.KeyFile, "-o", "IdentitiesOnly=yes")
+	}
+	switch {
+	case client.config.InsecureIgnoreHostKey:
+		args = append(args, "-o", "StrictHostKeyChecking=no", "-o", "UserKnownHostsFile=/dev/null", "-o", "LogLevel=ERROR")
+	case client.config.KnownHostsFile != "":
+		args = append(args, "-o", "UserKnownHostsFile="+client.config.KnownHostsFile)
+	}
+	for _, option := range client.config.Options {
+		args = append(args, "-o", option)
+	}
+	return args
+}
+
+// remote returns the scp argument for a path on the host
+func (client *Client) remote(path string) string {
+	host := client.host
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	return client.config.User + "@" + host + ":" + path
+}
+
+// exec runs ssh or scp, turning its exit status into an *ExitError or a *ConnectionError with
+// what it wrote to stderr
+func (client *Client) exec(ctx context.Context, name string, args []string, command string, stdout, stderr io.Writer) error {
+	var errOutput bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout = stdout
+	cmd.Stderr = &errOutput
+	if stderr != nil {
+		cmd.Stderr = io.MultiWriter(stderr, &errOutput)
+	}
+
+	err := cmd.Run()
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return err
+	}
+	message := strings.TrimSpace(errOutput.String())
+	if exitErr.ExitCode() == sshFailed {
+		return &ConnectionError{Host: client.host, Message: message}
+	}
+	return &ExitError{Host: client.host, Command: command, ExitCode: exitErr.ExitCode(), Stderr: message}
+}
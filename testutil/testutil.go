@@ -0,0 +1,252 @@
+// Package testutil runs the fleet, docker, etcd and consul fakes together as one in-process
+// cluster described by a Scenario, so orchestration code can be tested end to end without
+// containers. The fakes are coordinated the way a real cluster behaves: when fleet starts a unit
+// of one of the scenario's apps on a machine, a container of the app's image runs on that
+// machine's docker daemon and the app's service is registered with consul, passing its TTL
+// check; when the unit stops, fails or moves the container and the service follow it.
+//
+//	cluster, err := testutil.Start(testutil.Scenario{
+//		Machines: []testutil.Machine{{ID: "m1", IP: "10.0.0.1"}},
+//		Apps:     map[string]testutil.App{"web": {Image: "web:1.0", Service: "web", Port: 8080}},
+//	})
+//	defer cluster.Close()
+//	fleet.CreateUnit(cluster.FleetHost(), "web@1.service", fleet.Launched, options)
+package testutil
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/rarmstrong73/go-utils/consul/consultest"
+	consul "github.com/rarmstrong73/go-utils/consul/health"
+	"github.com/rarmstrong73/go-utils/docker/dockertest"
+	"github.com/rarmstrong73/go-utils/etcd"
+	"github.com/rarmstrong73/go-utils/etcd/etcdtest"
+	"github.com/rarmstrong73/go-utils/fleet"
+	"github.com/rarmstrong73/go-utils/fleet/fleettest"
+)
+
+// Scenario describes the starting state of a fake cluster
+type Scenario struct {
+	// Machines are the fleet machines, each with its own docker daemon
+	Machines []Machine
+	// Apps are what the units run, keyed by unit name without any instance and the .service
+	// suffix, so web@1.service runs the web app
+	Apps map[string]App
+	// Units are created with their desired state once the machines are up
+	Units []fleet.Unit
+	// Keys are set in etcd
+	Keys map[string]string
+	// KV are put in consul's KV store
+	KV map[string]string
+}
+
+// Machine is a fleet machine and its docker daemon
+type Machine struct {
+	ID       string
+	IP       string
+	Metadata map[string]string
+	// Images are already pulled on the machine
+	Images []string
+}
+
+// App is what the units of an app run
+type App struct {
+	// Image is the image of the units' containers
+	Image string
+	// Service is registered with consul while a unit runs, none when empty
+	Service string
+	Port    int
+	Tags    []string
+}
+
+// Cluster is a running fake cluster
+type Cluster struct {
+	Fleet  *fleettest.Server
+	Etcd   *etcdtest.Server
+	Consul *consultest.Server
+	// Docker are the machines' docker daemons, keyed by machine ID
+	Docker map[string]*dockertest.Server
+
+	scenario Scenario
+	mutex    sync.Mutex
+	machines map[string]Machine
+	// running is the machine each app unit's container is on
+	running map[string]string
+}
+
+// Start starts a cluster in the state described by scenario
+func Start(scenario Scenario) (*Cluster, error) {
+	cluster := &Cluster{
+		Fleet:    fleettest.NewServer(),
+		Etcd:     etcdtest.NewServer(),
+		Consul:   consultest.NewServer(),
+		Docker:   map[string]*dockertest.Server{},
+		scenario: scenario,
+		machines: map[string]Machine{},
+		running:  map[string]string{},
+	}
+	cluster.Fleet.OnChange(cluster.unitChanged)
+
+	for _, machine := range scenario.Machines {
+		cluster.AddMachine(machine)
+	}
+	for key, value := range scenario.Keys {
+		if _, err := cluster.EtcdClient().Set(key, value); err != nil {
+			cluster.Close()
+			return nil, err
+		}
+	}
+	for key, value := range scenario.KV {
+		if err := cluster.ConsulClient().KVPut(consul.KVPair{Key: key, Value: []byte(value)}); err != nil {
+			cluster.Close()
+			return nil, err
+		}
+	}
+	for _, unit := range scenario.Units {
+		if err := fleet.CreateUnit(cluster.FleetHost(), unit.Name, unit.DesiredState, unit.Options); err != nil {
+			cluster.Close()
+			return nil, err
+		}
+	}
+	return cluster, nil
+}
+
+// Close shuts every fake down
+func (cluster *Cluster) Close() {
+	cluster.Fleet.Close()
+	cluster.Etcd.Close()
+	cluster.Consul.Close()
+	for _, daemon := range cluster.Docker {
+		daemon.Close()
+	}
+}
+
+// FleetHost returns the host to pass to the fleet functions
+func (cluster *Cluster) FleetHost() string {
+	return cluster.Fleet.Host()
+}
+
+// DockerHost returns the host to pass to the docker functions for a machine
+func (cluster *Cluster) DockerHost(machineID string) string {
+	cluster.mutex.Lock()
+	defer cluster.mutex.Unlock()
+	if daemon, ok := cluster.Docker[machineID]; ok {
+		return daemon.Host()
+	}
+	return ""
+}
+
+// EtcdClient returns a client of the etcd fake
+func (cluster *Cluster) EtcdClient() *etcd.Client {
+	return cluster.Etcd.Client()
+}
+
+// ConsulClient returns a client of the consul fake
+func (cluster *Cluster) ConsulClient() *consul.Client {
+	return cluster.Consul.Client()
+}
+
+// AddMachine adds a machine and its docker daemon, fleet schedules waiting units on it
+func (cluster *Cluster) AddMachine(machine Machine) {
+	daemon := dockertest.NewServer()
+	for _, image := range machine.Images {
+		daemon.AddImage(image)
+	}
+	cluster.mutex.Lock()
+	cluster.Docker[machine.ID] = daemon
+	cluster.machines[machine.ID] = machine
+	cluster.mutex.Unlock()
+
+	cluster.Fleet.AddMachine(fleet.Machine{ID: machine.ID, PrimaryIP: machine.IP, Metadata: machine.Metadata})
+}
+
+// FailMachine takes a machine out of the cluster as if it had died: fleet reschedules its units
+// and its docker daemon stops answering. It returns false if there is no such machine.
+func (cluster *Cluster) FailMachine(id string) bool {
+	if !cluster.Fleet.RemoveMachine(id) {
+		return false
+	}
+	cluster.mutex.Lock()
+	daemon := cluster.Docker[id]
+	delete(cluster.machines, id)
+	cluster.mutex.Unlock()
+	if daemon != nil {
+		daemon.Close()
+	}
+	return true
+}
+
+// FailUnit makes a running unit's process exit, as systemd would report it, returning false if
+// the unit isn't scheduled
+func (cluster *Cluster) FailUnit(name string) bool {
+	return cluster.Fleet.SetSystemdState(name, "failed", "failed")
+}
+
+// unitChanged makes the containers and services of a unit follow its state in fleet
+func (cluster *Cluster) unitChanged(unit fleet.Unit, state *fleet.UnitState) {
+	app, ok := cluster.appOf(unit.Name)
+	if !ok {
+		return
+	}
+
+	cluster.mutex.Lock()
+	previous, wasRunning := cluster.running[unit.Name]
+	moved := wasRunning && (state == nil || state.MachineID != previous)
+	if moved {
+		if daemon, ok := cluster.Docker[previous]; ok {
+			daemon.RemoveContainer(unit.Name)
+		}
+		delete(cluster.running, unit.Name)
+	}
+
+	active := state != nil && state.SystemdActiveState == "active"
+	var daemon *dockertest.Server
+	var machine Machine
+	if state != nil {
+		daemon = cluster.Docker[state.MachineID]
+		machine = cluster.machines[state.MachineID]
+	}
+	switch {
+	case active && daemon != nil && (moved || !wasRunning):
+		daemon.RunContainer(unit.Name, app.Image)
+		cluster.running[unit.Name] = state.MachineID
+	case active && daemon != nil:
+		daemon.StartContainer(unit.Name)
+	case !active && daemon != nil:
+		daemon.StopContainer(unit.Name)
+	}
+	cluster.mutex.Unlock()
+
+	if app.Service == "" {
+		return
+	}
+	client := cluster.ConsulClient()
+	switch {
+	case state == nil:
+		client.AgentDeregisterService(unit.Name)
+	case active:
+		client.AgentRegisterService(consul.AgentServiceRegistration{
+			ID:      unit.Name,
+			Name:    app.Service,
+			Tags:    app.Tags,
+			Address: machine.IP,
+			Port:    app.Port,
+			Check:   &consul.AgentServiceCheck{TTL: "1h"},
+		})
+		client.PassTTL("service:"+unit.Name, "")
+	default:
+		client.FailTTL("service:"+unit.Name, fmt.Sprintf("%s is %s", unit.Name, state.SystemdActiveState))
+	}
+}
+
+// appOf returns the app a unit runs
+func (cluster *Cluster) appOf(name string) (App, bool) {
+	name = strings.TrimSuffix(name, ".service")
+	if at := strings.Index(name, "@"); at >= 0 {
+		name = name[:at]
+	}
+	app, ok := cluster.scenario.Apps[name]
+	return app, ok
+}
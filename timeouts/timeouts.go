@@ -0,0 +1,75 @@
+// Package timeouts sets how long the fleet, docker, etcd, consul and other clients wait on their
+// backends, so a host that stops answering fails requests instead of hanging them. Every client
+// uses the package defaults unless it is given timeouts of its own:
+//
+//	timeouts.SetDefaults(timeouts.Timeouts{Connect: 5 * time.Second, Read: 30 * time.Second, Operation: time.Minute})
+//	docker.SetTimeouts(&timeouts.Timeouts{Connect: time.Second, Read: 2 * time.Minute})
+//
+// Watches, blocking queries, followed logs and snapshots wait as long as they need to, only the
+// connect timeout applies to them.
+package timeouts
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Timeouts limit how long requests take, a limit of 0 means no limit
+type Timeouts struct {
+	// Connect limits dialing a host
+	Connect time.Duration
+	// Read limits waiting for the response to each attempt once it is sent
+	Read time.Duration
+	// Operation limits a whole operation, including its retries and reading its response
+	Operation time.Duration
+}
+
+// Default is what the package defaults are until SetDefaults changes them
+var Default = Timeouts{Connect: 10 * time.Second, Read: time.Minute, Operation: 5 * time.Minute}
+
+var (
+	mutex    sync.RWMutex
+	defaults = Default
+)
+
+// SetDefaults sets the timeouts of every client that hasn't been given its own, including
+// clients made before
+func SetDefaults(t Timeouts) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	defaults = t
+}
+
+// Defaults returns the timeouts of clients that haven't been given their own
+func Defaults() Timeouts {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	return defaults
+}
+
+// Kinds of timeouts, as in Error
+const (
+	Read      = "read"
+	Operation = "operation"
+)
+
+// Error is returned when a request runs out of time waiting for its response or its operation
+// runs out of time
+type Error struct {
+	// Kind is the timeout that ran out, Read or Operation
+	Kind  string
+	Limit time.Duration
+}
+
+func (e *Error) Error() string {
+	if e.Kind == Read {
+		return fmt.Sprintf("No response within %s", e.Limit)
+	}
+	return fmt.Sprintf("Operation didn't finish within %s", e.Limit)
+}
+
+// Timeout reports that the error is a timeout, as net.Error does
+func (e *Error) Timeout() bool {
+	return true
+}
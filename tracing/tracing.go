@@ -0,0 +1,59 @@
+// Package tracing wraps every request the fleet, docker, etcd and consul clients send in a span,
+// so a trace of a deploy shows how long it spent in each backend. Spans are made by a Tracer set
+// with SetTracer, which can adapt OpenTelemetry or any other tracing library:
+//
+//	tracing.SetTracer(tracing.NewTracer(func(record tracing.Record) { ... }))
+//
+// The built in Tracer propagates W3C trace context: a request continues the trace in its
+// context, see Extract and StartSpan, and sends a traceparent header to the backend.
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+)
+
+// SpanInfo describes the request a span is started for
+type SpanInfo struct {
+	// Service is the backend, such as etcd
+	Service string
+	// Operation is the API endpoint, such as kv/range
+	Operation string
+	Method    string
+	// Host is the host:port the request is sent to
+	Host string
+}
+
+// Span is a started span
+type Span interface {
+	// End ends the span with the response's status code, 0 when none was received, and the
+	// transport error if any
+	End(statusCode int, err error)
+}
+
+// Tracer starts spans
+type Tracer interface {
+	// Start starts a span for a request made in ctx, and may add headers propagating the
+	// trace to the backend to header
+	Start(ctx context.Context, info SpanInfo, header http.Header) Span
+}
+
+type noopSpan struct{}
+
+func (noopSpan) End(statusCode int, err error) {}
+
+var tracer atomic.Value
+
+// SetTracer sets the tracer of every client, nil turns tracing off
+func SetTracer(t Tracer) {
+	tracer.Store(&t)
+}
+
+// Start starts a span with the tracer set by SetTracer, or returns a span that does nothing
+func Start(ctx context.Context, info SpanInfo, header http.Header) Span {
+	if t, ok := tracer.Load().(*Tracer); ok && *t != nil {
+		return (*t).Start(ctx, info, header)
+	}
+	return noopSpan{}
+}
@@ -0,0 +1,119 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Record is a finished span of the built in tracer
+type Record struct {
+	TraceID  string
+	SpanID   string
+	ParentID string
+	SpanInfo
+	StatusCode int
+	Err        error
+	Start      time.Time
+	Duration   time.Duration
+}
+
+// SpanContext identifies a span in a trace
+type SpanContext struct {
+	TraceID string
+	SpanID  string
+	Sampled bool
+}
+
+type spanContextKey struct{}
+
+// ContextWithSpan returns a copy of ctx in which spans are children of span
+func ContextWithSpan(ctx context.Context, span SpanContext) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, span)
+}
+
+// SpanFromContext returns the span ctx's spans are children of
+func SpanFromContext(ctx context.Context) (SpanContext, bool) {
+	span, ok := ctx.Value(spanContextKey{}).(SpanContext)
+	return span, ok
+}
+
+// Extract continues the trace of an incoming request's traceparent header in a copy of ctx
+func Extract(ctx context.Context, header http.Header) context.Context {
+	parts := strings.Split(header.Get("traceparent"), "-")
+	if len(parts) != 4 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return ctx
+	}
+	return ContextWithSpan(ctx, SpanContext{TraceID: parts[1], SpanID: parts[2], Sampled: parts[3] == "01"})
+}
+
+// Inject sets the traceparent header of an outgoing request to span
+func Inject(header http.Header, span SpanContext) {
+	flags := "00"
+	if span.Sampled {
+		flags = "01"
+	}
+	header.Set("traceparent", fmt.Sprintf("00-%s-%s-%s", span.TraceID, span.SpanID, flags))
+}
+
+// W3CTracer is a Tracer passing finished spans to a function, see NewTracer
+type W3CTracer struct {
+	export func(Record)
+}
+
+// NewTracer returns a tracer propagating W3C trace context that passes finished spans to export,
+// which must not block
+func NewTracer(export func(Record)) *W3CTracer {
+	return &W3CTracer{export: export}
+}
+
+// w3cSpan is a span started by a W3CTracer
+type w3cSpan struct {
+	tracer *W3CTracer
+	record Record
+}
+
+// Start starts a span for a request
+func (tracer *W3CTracer) Start(ctx context.Context, info SpanInfo, header http.Header) Span {
+	ctx, span := tracer.StartSpan(ctx, info)
+	if header != nil {
+		spanContext, _ := SpanFromContext(ctx)
+		Inject(header, spanContext)
+	}
+	return span
+}
+
+// StartSpan starts a span for work of the application such as a deploy, requests made with the
+// returned context are its children
+func (tracer *W3CTracer) StartSpan(ctx context.Context, info SpanInfo) (context.Context, Span) {
+	span := &w3cSpan{tracer: tracer, record: Record{SpanID: randomID(8), SpanInfo: info, Start: time.Now()}}
+	parent, ok := SpanFromContext(ctx)
+	if ok {
+		span.record.TraceID = parent.TraceID
+		span.record.ParentID = parent.SpanID
+	} else {
+		span.record.TraceID = randomID(16)
+	}
+	ctx = ContextWithSpan(ctx, SpanContext{TraceID: span.record.TraceID, SpanID: span.record.SpanID, Sampled: true})
+	return ctx, span
+}
+
+// End ends the span and exports it
+func (span *w3cSpan) End(statusCode int, err error) {
+	span.record.StatusCode = statusCode
+	span.record.Err = err
+	span.record.Duration = time.Since(span.record.Start)
+	if span.tracer.export != nil {
+		span.tracer.export(span.record)
+	}
+}
+
+func randomID(bytes int) string {
+	id := make([]byte, bytes)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
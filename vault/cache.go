@@ -0,0 +1,182 @@
+package vault
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rarmstrong73/go-utils/apierror"
+)
+
+// checkInterval is how often Run looks for secrets due a refresh
+var checkInterval = time.Second
+
+// Cache keeps the secrets read through it, refreshing each before its lease expires: renewable
+// leases are renewed, and other secrets, or ones whose lease can't be renewed for long enough any
+// more, are read again. Secrets without leases are kept until forgotten.
+//
+//	cache := vault.NewCache(client)
+//	cache.OnRefresh(func(path string, secret *vault.Secret) { db.Reconnect(secret.String("username"), secret.String("password")) })
+//	go cache.Run(ctx)
+//	secret, err := cache.Get("database/creds/web")
+type Cache struct {
+	client    *Client
+	refreshAt float64
+	onRefresh func(path string, secret *Secret)
+
+	mutex   sync.Mutex
+	entries map[string]*cacheEntry
+}
+
+type cacheEntry struct {
+	mutex  sync.Mutex
+	secret *Secret
+	// ttl is the lease duration the secret was read with, what renewals ask for
+	ttl time.Duration
+	// refresh and expires are when the secret is due a refresh and when its lease ends, zero
+	// for secrets without leases
+	refresh time.Time
+	expires time.Time
+}
+
+// NewCache returns a cache of secrets read with client, refreshing them two thirds of the way
+// through their leases
+func NewCache(client *Client) *Cache {
+	return &Cache{
+		client:    client,
+		refreshAt: 2.0 / 3,
+		entries:   map[string]*cacheEntry{},
+	}
+}
+
+// SetRefreshAt sets how far through its lease, from 0 to 1, a secret is refreshed
+func (cache *Cache) SetRefreshAt(fraction float64) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.refreshAt = fraction
+}
+
+// OnRefresh sets a function called with every secret refreshed, to pick up credentials that
+// changed. It isn't called for renewals, whose secret is unchanged.
+func (cache *Cache) OnRefresh(onRefresh func(path string, secret *Secret)) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.onRefresh = onRefresh
+}
+
+// Get returns the secret at path, reading it unless it is cached and refreshing it first if it
+// is due a refresh. It returns nil if there is no secret at path.
+func (cache *Cache) Get(path string) (*Secret, error) {
+	cache.mutex.Lock()
+	entry, ok := cache.entries[path]
+	if !ok {
+		entry = &cacheEntry{}
+		cache.entries[path] = entry
+	}
+	cache.mutex.Unlock()
+
+	entry.mutex.Lock()
+	defer entry.mutex.Unlock()
+	if entry.secret == nil {
+		return cache.read(path, entry, false)
+	}
+	if !entry.refresh.IsZero() && !time.Now().Before(entry.refresh) {
+		if err := cache.refresh(path, entry); err != nil && !time.Now().Before(entry.expires) {
+			return nil, err
+		}
+	}
+	return entry.secret, nil
+}
+
+// Forget drops the secret at path from the cache
+func (cache *Cache) Forget(path string) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	delete(cache.entries, path)
+}
+
+// Run refreshes the cached secrets as they come due until ctx is done or the client is closed,
+// so they are refreshed even between calls to Get. Failed refreshes are logged and tried again
+// until the lease ends.
+func (cache *Cache) Run(ctx context.Context) error {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-cache.client.httpClient.Closing():
+			return apierror.ErrClosed
+		case <-ticker.C:
+		}
+
+		cache.mutex.Lock()
+		entries := make(map[string]*cacheEntry, len(cache.entries))
+		for path, entry := range cache.entries {
+			entries[path] = entry
+		}
+		cache.mutex.Unlock()
+
+		for path, entry := range entries {
+			entry.mutex.Lock()
+			if entry.secret != nil && !entry.refresh.IsZero() && !time.Now().Before(entry.refresh) {
+				if err := cache.refresh(path, entry); err != nil {
+					cache.client.logger.Error("Failed to refresh secret", "path", path, "expires", entry.expires, "error", err)
+				}
+			}
+			entry.mutex.Unlock()
+		}
+	}
+}
+
+// refresh renews the lease of an entry's secret if it can be renewed for long enough, reading the
+// secret again otherwise. The entry's mutex is held.
+func (cache *Cache) refresh(path string, entry *cacheEntry) error {
+	if entry.secret.Renewable && entry.secret.LeaseID != "" {
+		renewed, err := cache.client.RenewLease(entry.secret.LeaseID, entry.ttl)
+		// A lease renewed for less than half its TTL is close to its maximum TTL
+		if err == nil && renewed != nil && renewed.TTL() >= entry.ttl/2 {
+			cache.setLease(entry, renewed.TTL())
+			cache.client.logger.Debug("Renewed secret lease", "path", path, "ttl", renewed.TTL())
+			return nil
+		}
+		if err != nil {
+			cache.client.logger.Info("Failed to renew secret lease, reading it again", "path", path, "error", err)
+		}
+	}
+	_, err := cache.read(path, entry, true)
+	return err
+}
+
+// read reads an entry's secret. The entry's mutex is held.
+func (cache *Cache) read(path string, entry *cacheEntry, refreshed bool) (*Secret, error) {
+	secret, err := cache.client.Read(path)
+	if err != nil || secret == nil {
+		return nil, err
+	}
+	entry.secret = secret
+	entry.ttl = secret.TTL()
+	cache.setLease(entry, secret.TTL())
+
+	cache.mutex.Lock()
+	onRefresh := cache.onRefresh
+	cache.mutex.Unlock()
+	if refreshed && onRefresh != nil {
+		onRefresh(path, secret)
+	}
+	return secret, nil
+}
+
+// setLease sets when an entry is due a refresh and expires from a lease of ttl starting now
+func (cache *Cache) setLease(entry *cacheEntry, ttl time.Duration) {
+	if ttl <= 0 {
+		entry.refresh, entry.expires = time.Time{}, time.Time{}
+		return
+	}
+	cache.mutex.Lock()
+	refreshAt := cache.refreshAt
+	cache.mutex.Unlock()
+	now := time.Now()
+	entry.refresh = now.Add(time.Duration(float64(ttl) * refreshAt))
+	entry.expires = now.Add(ttl)
+}
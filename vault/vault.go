@@ -0,0 +1,340 @@
+// Package vault is a client of HashiCorp Vault for reading secrets, so services can pull database
+// credentials and other secrets with the same library they use for the rest of the cluster:
+//
+//	client, err := vault.NewClient(vault.Config{Address: "https://vault:8200", RoleID: roleID, SecretID: secretID})
+//	secret, err := client.ReadKV("secret", "web/config")
+//
+// Clients authenticate with a token or with AppRole, logging in again when their token expires.
+// A Cache keeps leased secrets such as database credentials, refreshing them before they expire.
+package vault
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rarmstrong73/go-utils/apierror"
+	"github.com/rarmstrong73/go-utils/internal/httpclient"
+	"github.com/rarmstrong73/go-utils/logging"
+	"github.com/rarmstrong73/go-utils/proxy"
+)
+
+// Config describes a Vault server and how to authenticate with it
+type Config struct {
+	// Address is Vault's URL, such as https://vault:8200
+	Address string
+	// Token is the token sent with requests, used unless RoleID is set
+	Token string
+	// RoleID and SecretID log in with AppRole, mounted at AppRoleMount, defaulting to approle
+	RoleID       string
+	SecretID     string
+	AppRoleMount string
+	// Namespace is the Vault Enterprise namespace requests are made in
+	Namespace string
+	// CAFile, CertFile and KeyFile configure https, see httpclient.TLSFiles
+	CAFile             string
+	CertFile           string
+	KeyFile            string
+	InsecureSkipVerify bool
+	// Proxy is the proxy requests go through, the default of the proxy package when nil
+	Proxy proxy.Func
+}
+
+// Secret is a secret read from Vault
+type Secret struct {
+	RequestID string `json:"request_id"`
+	// LeaseID identifies the secret's lease for RenewLease and RevokeLease, dynamic secrets
+	// such as database credentials have one
+	LeaseID string `json:"lease_id"`
+	// LeaseDuration is how many seconds the secret is valid for, 0 for secrets without leases
+	LeaseDuration int                    `json:"lease_duration"`
+	Renewable     bool                   `json:"renewable"`
+	Data          map[string]interface{} `json:"data"`
+	Warnings      []string               `json:"warnings"`
+	// Auth is set by logins and token operations
+	Auth *Auth `json:"auth"`
+}
+
+// Auth is the token a login or token operation returned
+type Auth struct {
+	ClientToken   string            `json:"client_token"`
+	Accessor      string            `json:"accessor"`
+	Policies      []string          `json:"policies"`
+	Metadata      map[string]string `json:"metadata"`
+	LeaseDuration int               `json:"lease_duration"`
+	Renewable     bool              `json:"renewable"`
+}
+
+// TTL returns how long the secret is valid for
+func (secret *Secret) TTL() time.Duration {
+	return time.Duration(secret.LeaseDuration) * time.Second
+}
+
+// String returns the string value of a key of the secret's data, "" if it isn't set or isn't a
+// string
+func (secret *Secret) String(key string) string {
+	value, _ := secret.Data[key].(string)
+	return value
+}
+
+// ErrorResponse is the body of a failed response
+type ErrorResponse struct {
+	Errors []string `json:"errors"`
+}
+
+// Client is a connection to Vault
+type Client struct {
+	config     Config
+	httpClient *httpclient.Client
+	logger     logging.Logger
+
+	mutex sync.Mutex
+	token string
+}
+
+// NewClient returns a client for the Vault server described by config, failing if its
+// certificate files can't be read. AppRole clients log in with their first request.
+func NewClient(config Config) (*Client, error) {
+	if config.AppRoleMount == "" {
+		config.AppRoleMount = "approle"
+	}
+	options := httpclient.Options{Service: apierror.Vault, Endpoint: endpointName, Proxy: config.Proxy}
+	if strings.HasPrefix(config.Address, "https://") {
+		tlsConfig, err := httpclient.LoadTLS(httpclient.TLSFiles{
+			CAFile:             config.CAFile,
+			CertFile:           config.CertFile,
+			KeyFile:            config.KeyFile,
+			InsecureSkipVerify: config.InsecureSkipVerify,
+		})
+		if err != nil {
+			return nil, err
+		}
+		options.TLS = tlsConfig
+	}
+	if config.Namespace != "" {
+		options.Header = http.Header{"X-Vault-Namespace": {config.Namespace}}
+	}
+
+	return &Client{
+		config:     config,
+		httpClient: httpclient.New(options),
+		logger:     logging.Nop,
+		token:      config.Token,
+	}, nil
+}
+
+// SetLogger sets where the client logs, nil turns logging off
+func (client *Client) SetLogger(logger logging.Logger) {
+	client.logger = logging.OrNop(logger)
+}
+
+// Shutdown stops the client: new requests fail with apierror.ErrClosed and requests in flight
+// are given until ctx is done to finish before they are cancelled. It returns ctx's error if
+// requests had to be cancelled.
+func (client *Client) Shutdown(ctx context.Context) error {
+	return client.httpClient.Shutdown(ctx)
+}
+
+// Close stops the client like Shutdown, cancelling requests in flight straight away
+func (client *Client) Close() error {
+	return client.httpClient.Close()
+}
+
+// Token returns the client's current token, logging in first if the client uses AppRole and
+// hasn't yet
+func (client *Client) Token() (string, error) {
+	client.mutex.Lock()
+	token := client.token
+	client.mutex.Unlock()
+	if token != "" || client.config.RoleID == "" {
+		return token, nil
+	}
+	return client.Login()
+}
+
+// Login logs in with the configured AppRole credentials, replacing the client's token
+func (client *Client) Login() (string, error) {
+	if client.config.RoleID == "" {
+		return "", fmt.Errorf("No AppRole role ID configured to log in with")
+	}
+	body := map[string]string{"role_id": client.config.RoleID, "secret_id": client.config.SecretID}
+	secret, err := client.send(http.MethodPost, "/v1/auth/"+client.config.AppRoleMount+"/login", "", body)
+	if err != nil {
+		return "", err
+	}
+	if secret == nil || secret.Auth == nil || secret.Auth.ClientToken == "" {
+		return "", fmt.Errorf("No token in the AppRole login response")
+	}
+
+	client.mutex.Lock()
+	client.token = secret.Auth.ClientToken
+	client.mutex.Unlock()
+	client.logger.Info("Logged in to vault", "mount", client.config.AppRoleMount, "ttl", secret.Auth.LeaseDuration)
+	return secret.Auth.ClientToken, nil
+}
+
+// Read reads the secret at path, such as database/creds/web, returning nil if there is none
+func (client *Client) Read(path string) (*Secret, error) {
+	secret, err := client.do(http.MethodGet, path, nil)
+	if isNotFound(err) {
+		return nil, nil
+	}
+	return secret, err
+}
+
+// Write writes data to path, returning the secret Vault responds with if any
+func (client *Client) Write(path string, data map[string]interface{}) (*Secret, error) {
+	return client.do(http.MethodPut, path, data)
+}
+
+// Delete deletes the secret at path
+func (client *Client) Delete(path string) error {
+	_, err := client.do(http.MethodDelete, path, nil)
+	return err
+}
+
+// ReadKV reads the latest version of a secret from the version 2 KV engine mounted at mount,
+// returning nil if there is none. Its Data is the secret's data, without the version's metadata.
+func (client *Client) ReadKV(mount, path string) (*Secret, error) {
+	secret, err := client.Read(mount + "/data/" + path)
+	if err != nil || secret == nil {
+		return secret, err
+	}
+	data, _ := secret.Data["data"].(map[string]interface{})
+	if data == nil {
+		// Deleted versions have no data
+		return nil, nil
+	}
+	secret.Data = data
+	return secret, nil
+}
+
+// WriteKV writes a new version of a secret to the version 2 KV engine mounted at mount
+func (client *Client) WriteKV(mount, path string, data map[string]interface{}) error {
+	_, err := client.Write(mount+"/data/"+path, map[string]interface{}{"data": data})
+	if err == nil {
+		client.logger.Info("Wrote secret", "mount", mount, "path", path)
+	}
+	return err
+}
+
+// DeleteKV deletes the latest version of a secret from the version 2 KV engine mounted at mount
+func (client *Client) DeleteKV(mount, path string) error {
+	return client.Delete(mount + "/data/" + path)
+}
+
+// RenewLease extends the lease of a secret by increment, returning the renewed secret whose
+// LeaseDuration may be shorter than asked for when the lease reaches its maximum TTL
+func (client *Client) RenewLease(leaseID string, increment time.Duration) (*Secret, error) {
+	return client.do(http.MethodPut, "sys/leases/renew", map[string]interface{}{
+		"lease_id":  leaseID,
+		"increment": int(increment / time.Second),
+	})
+}
+
+// RevokeLease revokes the lease of a secret, such as database credentials no longer needed
+func (client *Client) RevokeLease(leaseID string) error {
+	_, err := client.do(http.MethodPut, "sys/leases/revoke", map[string]interface{}{"lease_id": leaseID})
+	return err
+}
+
+// RenewToken extends the client's token by increment, returning the token's new lease
+func (client *Client) RenewToken(increment time.Duration) (*Auth, error) {
+	secret, err := client.do(http.MethodPost, "auth/token/renew-self", map[string]interface{}{
+		"increment": int(increment / time.Second),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Auth == nil {
+		return nil, fmt.Errorf("No auth in the token renewal response")
+	}
+	return secret.Auth, nil
+}
+
+// endpointName names a request path for observations by its first segments, such as sys/leases
+func endpointName(path string) string {
+	segments := strings.Split(strings.TrimPrefix(path, "/v1/"), "/")
+	if len(segments) > 2 {
+		segments = segments[:2]
+	}
+	return strings.Join(segments, "/")
+}
+
+// handleError returns an *apierror.Error with Vault's errors from a failed response
+func handleError(response *http.Response) error {
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	var errorResponse ErrorResponse
+	if err := json.Unmarshal(body, &errorResponse); err != nil || len(errorResponse.Errors) == 0 {
+		return apierror.FromResponse(apierror.Vault, response, "", strings.TrimSpace(string(body)), nil)
+	}
+	return apierror.FromResponse(apierror.Vault, response, "", strings.Join(errorResponse.Errors, ", "), nil)
+}
+
+// isNotFound reports whether err is Vault's response to reading a path without a secret
+func isNotFound(err error) bool {
+	var apiErr *apierror.Error
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// ============================================================================
+// ============================= HTTP UTILS ===================================
+// ============================================================================
+
+// do sends an authenticated request to a path under /v1/, logging in again and sending the
+// request once more when an AppRole client's token has expired
+func (client *Client) do(method, path string, body interface{}) (*Secret, error) {
+	token, err := client.Token()
+	if err != nil {
+		return nil, err
+	}
+	secret, err := client.send(method, "/v1/"+strings.TrimPrefix(path, "/"), token, body)
+	var apiErr *apierror.Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusForbidden || client.config.RoleID == "" {
+		return secret, err
+	}
+
+	client.logger.Debug("Vault token rejected, logging in again", "path", path)
+	if token, err = client.Login(); err != nil {
+		return nil, err
+	}
+	return client.send(method, "/v1/"+strings.TrimPrefix(path, "/"), token, body)
+}
+
+func (client *Client) send(method, path, token string, body interface{}) (*Secret, error) {
+	request := httpclient.Request{Method: method, URL: strings.TrimSuffix(client.config.Address, "/"), Path: path}
+	if token != "" {
+		request.Header = http.Header{"X-Vault-Token": {token}}
+	}
+	if body != nil {
+		var err error
+		if request, err = request.JSONBody(body); err != nil {
+			return nil, err
+		}
+	}
+	response, err := client.httpClient.Do(context.Background(), request)
+	if err != nil {
+		return nil, apierror.Transport(apierror.Vault, request.Operation(), err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNoContent {
+		return nil, nil
+	}
+	if response.StatusCode != http.StatusOK {
+		return nil, handleError(response)
+	}
+	var secret Secret
+	if err := httpclient.DecodeJSON(response, &secret); err != nil {
+		return nil, err
+	}
+	return &secret, nil
+}